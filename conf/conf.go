@@ -3,16 +3,112 @@ package conf
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/linuxerwang/confish"
 )
 
+// DefaultGenDir is the folder for generated files when gen-dirs is not set.
+const DefaultGenDir = "bazel-genfiles"
+
+// Values of require-clean-tree.
+const (
+	CleanTreeWarn = "warn"
+	CleanTreeFail = "fail"
+)
+
+// DefaultTimeout is the kernel entry and attribute timeout for a path class
+// without configured timeouts.
+const DefaultTimeout = time.Second
+
 // GobazelConf represents the bazel build config.
 type BuildConf struct {
 	Rules   []string `cfg-attr:"rules"`
 	Ignores []string `cfg-attr:"ignore-dirs"`
 }
 
+// TimeoutConf represents the entry and attribute timeouts of a path class,
+// e.g., "1s" or "5m".
+type TimeoutConf struct {
+	Attr  string `cfg-attr:"attr-timeout"`
+	Entry string `cfg-attr:"entry-timeout"`
+
+	AttrTimeout  time.Duration
+	EntryTimeout time.Duration
+}
+
+// TimeoutsConf represents the timeouts of each path class.
+type TimeoutsConf struct {
+	FirstParty *TimeoutConf `cfg-attr:"first-party"`
+	Vendor     *TimeoutConf `cfg-attr:"vendor"`
+	GoRoot     *TimeoutConf `cfg-attr:"goroot"`
+}
+
+// Shortest returns the shortest attribute and entry timeouts of all path
+// classes, which are the ones the kernel can use for the whole mount.
+func (tc *TimeoutsConf) Shortest() (attr, entry time.Duration) {
+	attr, entry = tc.FirstParty.AttrTimeout, tc.FirstParty.EntryTimeout
+	for _, t := range []*TimeoutConf{tc.Vendor, tc.GoRoot} {
+		if t.AttrTimeout < attr {
+			attr = t.AttrTimeout
+		}
+		if t.EntryTimeout < entry {
+			entry = t.EntryTimeout
+		}
+	}
+	return attr, entry
+}
+
+// CreatePolicyConf restricts the files created through the mount.
+type CreatePolicyConf struct {
+	// Deny are patterns of file names which can't be created, e.g.,
+	// "*.so".
+	Deny []string `cfg-attr:"deny"`
+
+	// MaxSize is the size in bytes files written through the mount can't
+	// grow beyond.
+	MaxSize  string `cfg-attr:"max-size"`
+	MaxBytes int64
+}
+
+// WriteThroughRule maps generated files to the source files they are
+// generated from, by replacing GenSuffix with SrcSuffix.
+type WriteThroughRule struct {
+	GenSuffix string
+	SrcSuffix string
+}
+
+// GenRoot is an extra folder of generated files, mirroring the workspace
+// layout, consulted only for import paths under ImportPrefix.
+type GenRoot struct {
+	ImportPrefix string
+	Dir          string
+}
+
+// OverlayDir is a local folder served as the first-party package
+// ImportPrefix, e.g., a scratch folder of experimental packages.
+type OverlayDir struct {
+	ImportPrefix string
+	Dir          string
+}
+
+// PathPin serves the mount paths under Prefix from Dir only, bypassing the
+// usual resolution.
+type PathPin struct {
+	Prefix string
+	Dir    string
+}
+
+// DirOverride holds the settings of a per-directory .gobazel file, which
+// override the global config for the directory's subtree.
+type DirOverride struct {
+	ReadOnly bool     `cfg-attr:"read-only"`
+	GenDirs  []string `cfg-attr:"gen-dirs"`
+}
+
 // GobazelConf represents the gobazel global config.
 type GobazelConf struct {
 	GoPath      string     `cfg-attr:"go-path"`
@@ -21,8 +117,258 @@ type GobazelConf struct {
 	Ignores     []string   `cfg-attr:"ignore-dirs"`
 	Vendors     []string   `cfg-attr:"vendor-dirs"`
 	FallThrough []string   `cfg-attr:"fall-through-dirs"`
+	GenDirs     []string   `cfg-attr:"gen-dirs"`
 	Build       *BuildConf `cfg-attr:"build"`
 
+	// FsyncOnClose makes files written through the mount durable before
+	// the writer's close returns.
+	FsyncOnClose bool `cfg-attr:"fsync-on-close"`
+
+	// CreateMountpoint creates the go-path folders if they don't exist, and
+	// removes the mount point on unmount if it was created empty.
+	CreateMountpoint bool `cfg-attr:"create-mountpoint"`
+
+	// ForceUnmountStale unmounts a mount left behind at the mount point by
+	// a process which is gone instead of failing. Live mounts are never
+	// unmounted.
+	ForceUnmountStale bool `cfg-attr:"force-unmount-stale"`
+
+	// Timeouts sets how long attributes and entries are cached for each
+	// path class.
+	Timeouts *TimeoutsConf `cfg-attr:"timeouts"`
+
+	// GoSDKAutoDetect uses the GOROOT of the go command on PATH when the
+	// Go SDK can't be found in bazel's external folder.
+	GoSDKAutoDetect bool `cfg-attr:"go-sdk-auto-detect"`
+
+	// BinDir is where "go install" puts binaries, $GOPATH/bin is linked to
+	// it.
+	BinDir string `cfg-attr:"bin-dir"`
+
+	// WatchConfig reloads the config file when it changes, without
+	// remounting.
+	WatchConfig bool `cfg-attr:"watch-config"`
+
+	// MissingDirGrace is how long a first-party directory which vanished is
+	// still served as an empty directory, e.g., "5s".
+	MissingDirGrace        string `cfg-attr:"missing-dir-grace"`
+	MissingDirGraceTimeout time.Duration
+
+	// GoRootSubtrees restricts the virtual GOROOT to the given paths
+	// relative to the Go SDK, e.g., "src".
+	GoRootSubtrees []string `cfg-attr:"goroot-subtrees"`
+
+	// WriteThrough redirects writes to generated files to their source
+	// files, with rules like ".pb.go=.proto".
+	WriteThrough      []string `cfg-attr:"write-through"`
+	WriteThroughRules []WriteThroughRule
+
+	// CaseCollisionScan warns on startup about names which differ only in
+	// case, and would collide on macOS.
+	CaseCollisionScan bool `cfg-attr:"case-collision-scan"`
+
+	// SymlinkLoopScan warns on startup about symbolic links in the served
+	// trees leading back into a directory they're in.
+	SymlinkLoopScan bool `cfg-attr:"symlink-loop-scan"`
+
+	// GitWorktree serves the given git worktree of the workspace instead of
+	// the workspace itself, named by its path or its folder under
+	// .git/worktrees.
+	GitWorktree string `cfg-attr:"git-worktree"`
+
+	// SkipVendorSymlinks skips vendor directories which are symbolic
+	// links, instead of serving their targets.
+	SkipVendorSymlinks bool `cfg-attr:"skip-vendor-symlinks"`
+
+	// GenRoots are extra folders of generated files for parts of the
+	// first-party tree, e.g., "mycompany.com/protos=bazel-bin".
+	GenRoots      []string `cfg-attr:"gen-roots"`
+	GenRootScopes []GenRoot
+
+	// StatsInterval is how often a summary of the mount's activity is
+	// printed, e.g., "1m". Unset means never.
+	StatsInterval         string `cfg-attr:"stats-interval"`
+	StatsIntervalDuration time.Duration
+
+	// GitRevision serves the first-party tree read-only as it was at the
+	// given git revision, instead of the working tree.
+	GitRevision string `cfg-attr:"git-revision"`
+
+	// PreferNewer serves the most recently modified of a first-party file
+	// and its generated counterparts, instead of the first one found.
+	PreferNewer bool `cfg-attr:"prefer-newer"`
+
+	// DisableGenfiles serves source files only, without looking up
+	// generated files in gen-dirs, gen-roots or a content source.
+	DisableGenfiles bool `cfg-attr:"disable-genfiles"`
+
+	// FallThroughReadOnly rejects changes to fall-through-dirs through the
+	// mount.
+	FallThroughReadOnly bool `cfg-attr:"fall-through-read-only"`
+
+	// Overlays are local folders outside of the workspace served as
+	// first-party packages, e.g., "mycompany.com/exp=/home/me/exp".
+	Overlays    []string `cfg-attr:"overlay-dirs"`
+	OverlayDirs []OverlayDir
+
+	// Readahead sets the readahead hint for files of a resolution kind
+	// when opened, e.g., "genfiles=sequential". The hint is one of "normal",
+	// "sequential" and "random". Unset kinds use the kernel's default.
+	Readahead      []string `cfg-attr:"readahead"`
+	ReadaheadHints map[string]string
+
+	// MaxOpenFiles is the number of files open through the mount above
+	// which a warning is printed, and new opens fail with ENFILE if
+	// MaxOpenFilesReject is set. Unset means no limit.
+	MaxOpenFiles       string `cfg-attr:"max-open-files"`
+	MaxOpenFilesLimit  int64
+	MaxOpenFilesReject bool `cfg-attr:"max-open-files-reject"`
+
+	// NormalizeCRLF are patterns of file names, e.g., "*.go", whose CRLF
+	// line endings are read as LF. Writes are unchanged.
+	NormalizeCRLF []string `cfg-attr:"normalize-crlf"`
+
+	// BazelConfigs are bazel output configurations, e.g., "k8-fastbuild",
+	// whose bin and genfiles folders under bazel-out are searched for
+	// generated files, in this order and before gen-dirs.
+	BazelConfigs []string `cfg-attr:"bazel-configs"`
+
+	// HashXAttrMaxSize is the size in bytes above which files get no
+	// content hash extended attribute, and HashXAttrTimeout how long hashing
+	// a file may take, e.g., "5s".
+	HashXAttrMaxSize         string `cfg-attr:"hash-xattr-max-size"`
+	HashXAttrMaxBytes        int64
+	HashXAttrTimeout         string `cfg-attr:"hash-xattr-timeout"`
+	HashXAttrTimeoutDuration time.Duration
+
+	// WarnUnbuiltFiles warns when a Go file created in the first-party tree
+	// likely isn't referenced by a BUILD file.
+	WarnUnbuiltFiles bool `cfg-attr:"warn-unbuilt-files"`
+
+	// ScanConcurrency is the number of directories background walks (e.g.,
+	// listing packages) read at once, together. It defaults to GOMAXPROCS.
+	ScanConcurrency      string `cfg-attr:"scan-concurrency"`
+	ScanConcurrencyLimit int
+
+	// DecompressGz are patterns of file names, e.g., "*.go", which are
+	// served decompressed from a gzip compressed file of the same name plus
+	// ".gz" if they don't exist themselves.
+	DecompressGz []string `cfg-attr:"decompress-gz"`
+
+	// Pins serve packages from a fixed absolute directory, ahead of all
+	// other lookups, e.g., "mycompany.com/foo=/home/me/foo".
+	Pins     []string `cfg-attr:"path-pins"`
+	PathPins []PathPin
+
+	// SynthesizeModulesTxt serves a modules.txt in the vendor root of the
+	// first-party module listing the vendored modules and the packages
+	// served of each.
+	SynthesizeModulesTxt bool `cfg-attr:"synthesize-modules-txt"`
+
+	CreatePolicy *CreatePolicyConf `cfg-attr:"create-policy"`
+
+	// Quarantine is how long a path rejected by the create-policy is
+	// rejected right away, and its rejections not logged, e.g., "10s".
+	Quarantine         string `cfg-attr:"quarantine"`
+	QuarantineDuration time.Duration
+
+	// TemplateFiles are patterns of file names, e.g., "*.tmpl.json", which
+	// are read expanded as Go templates with the TemplateVars, e.g.,
+	// "version=1.2.3" for {{.version}}.
+	TemplateFiles  []string `cfg-attr:"template-files"`
+	TemplateVars   []string `cfg-attr:"template-vars"`
+	TemplateVarMap map[string]string
+
+	// RequireCleanTree checks on startup that the workspace has no
+	// uncommitted changes, and either warns ("warn") or exits ("fail") if
+	// it has.
+	RequireCleanTree string `cfg-attr:"require-clean-tree"`
+
+	// NFSWorkspace defaults the timeouts for a workspace on NFS, which
+	// caches attributes on its own: first-party paths aren't cached at all,
+	// vendor and GOROOT ones for long. NFSRevalidate further opens
+	// first-party files on lookup, so that the NFS client revalidates its
+	// cached attributes.
+	NFSWorkspace  bool `cfg-attr:"nfs-workspace"`
+	NFSRevalidate bool `cfg-attr:"nfs-revalidate"`
+
+	// SynthesizeDocGo serves a doc.go with a package clause only in
+	// first-party directories with subpackages but no Go files.
+	SynthesizeDocGo bool `cfg-attr:"synthesize-doc-go"`
+
+	// HideSpecialFiles hides FIFOs, sockets and device nodes in the served
+	// trees, which are otherwise served with their own type.
+	HideSpecialFiles bool `cfg-attr:"hide-special-files"`
+
+	// TargetArch is the architecture, e.g., "arm64", whose bazel output
+	// roots bazel-out/<arch>-* are searched for first-party generated
+	// files before GenDirs, then those of the TargetArchFallbacks.
+	TargetArch          string   `cfg-attr:"target-arch"`
+	TargetArchFallbacks []string `cfg-attr:"target-arch-fallbacks"`
+
+	// MaxDepth is how many levels of directories below go-pkg-prefix are
+	// listed; deeper ones are listed as empty. It defaults to unlimited.
+	MaxDepth      string `cfg-attr:"max-depth"`
+	MaxDepthLimit int
+
+	// GopathMode hides first-party go.mod and go.sum files, for tools
+	// running in GOPATH mode (GO111MODULE=off), which they would otherwise
+	// switch into module mode.
+	GopathMode bool `cfg-attr:"gopath-mode"`
+
+	// MaxVendors caps the number of vendor-dirs served; the existing ones
+	// beyond it are skipped with a warning.
+	MaxVendors      string `cfg-attr:"max-vendors"`
+	MaxVendorsLimit int
+
+	// UIDMap and GIDMap map backing user and group IDs to the ones
+	// reported through the mount, e.g., "1000=0".
+	UIDMap     []string `cfg-attr:"uid-map"`
+	GIDMap     []string `cfg-attr:"gid-map"`
+	UIDMapping map[uint32]uint32
+	GIDMapping map[uint32]uint32
+
+	// ServeBazelMetadata serves the WORKSPACE, WORKSPACE.bazel and
+	// MODULE.bazel files of the workspace root read-only in the virtual
+	// .bazel directory of the mount root.
+	ServeBazelMetadata bool `cfg-attr:"serve-bazel-metadata"`
+
+	// MaxRead is the largest read and write request size, in bytes, the
+	// kernel sends, between 4096 and 1048576. It defaults to go-fuse's.
+	MaxRead      string `cfg-attr:"max-read"`
+	MaxReadBytes int
+
+	// ConsistencyCheck is how often a sample of the cached attributes is
+	// compared with the backing files, e.g., "1m". Unset means never.
+	// ConsistencyCheckSamples is how many cached paths each check compares,
+	// and ConsistencyCheckHeal drops the stale ones from the caches.
+	ConsistencyCheck         string `cfg-attr:"consistency-check"`
+	ConsistencyCheckDuration time.Duration
+	ConsistencyCheckSamples  string `cfg-attr:"consistency-check-samples"`
+	ConsistencyCheckCount    int
+	ConsistencyCheckHeal     bool `cfg-attr:"consistency-check-heal"`
+
+	// BuildGOOS, BuildGOARCH and BuildTags filter the listings of
+	// first-party Go files to those the compiler would build for the given
+	// target. Unset GOOS and GOARCH default to the host's.
+	BuildGOOS   string   `cfg-attr:"build-goos"`
+	BuildGOARCH string   `cfg-attr:"build-goarch"`
+	BuildTags   []string `cfg-attr:"build-tags"`
+
+	// FirstPartyOrder and VendorOrder set the order in which the kinds of
+	// backing directories are searched for first-party and vendor paths,
+	// e.g., ["vendor"] to never serve vendored packages from genfiles.
+	// Kinds left out aren't searched. Unset means the built-in order.
+	FirstPartyOrder []string `cfg-attr:"first-party-order"`
+	VendorOrder     []string `cfg-attr:"vendor-order"`
+
+	// ContentCacheDir caches the files of the genfiles content source on
+	// local disk, evicting the least recently used ones beyond
+	// ContentCacheMaxSize bytes, if set.
+	ContentCacheDir      string `cfg-attr:"content-cache-dir"`
+	ContentCacheMaxSize  string `cfg-attr:"content-cache-max-size"`
+	ContentCacheMaxBytes int64
+
 	IgnoreSet      map[string]struct{}
 	VendorSet      map[string]struct{}
 	FallThroughSet map[string]struct{}
@@ -32,17 +378,399 @@ type confWrapper struct {
 	Conf *GobazelConf `cfg-attr:"gobazel"`
 }
 
-// LoadConfig loads gobazel config from the given file.
+// expandGenDirs returns the folders searched for generated files: the bin
+// and genfiles folders of the given bazel-configs, in order, then the given
+// gen-dirs, which default to DefaultGenDir without bazel-configs.
+func expandGenDirs(genDirs, bazelConfigs []string) ([]string, error) {
+	if len(bazelConfigs) == 0 {
+		if len(genDirs) == 0 {
+			return []string{DefaultGenDir}, nil
+		}
+		return genDirs, nil
+	}
+
+	dirs := []string{}
+	for _, c := range bazelConfigs {
+		if c == "" || strings.Contains(c, "/") {
+			return nil, fmt.Errorf("invalid bazel-configs entry \"%s\"", c)
+		}
+		dirs = append(dirs, filepath.Join("bazel-out", c, "bin"), filepath.Join("bazel-out", c, "genfiles"))
+	}
+	return append(dirs, genDirs...), nil
+}
+
+// LoadConfig loads gobazel config from the given file. It exits if the
+// config is invalid.
 func LoadConfig(cfgPath string) *GobazelConf {
-	cfg := confWrapper{}
-	if err := confish.ParseFile(cfgPath, &cfg); err != nil {
+	cfg, err := ParseConfig(cfgPath)
+	if err != nil {
 		fmt.Printf("Failed to parse gobazel config file %s, %+v.\n", cfgPath, err)
 		os.Exit(2)
 	}
+	return cfg
+}
+
+// ParseConfig parses gobazel config from the given file.
+func ParseConfig(cfgPath string) (*GobazelConf, error) {
+	cfg := confWrapper{}
+	if err := confish.ParseFile(cfgPath, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Conf == nil {
+		return nil, fmt.Errorf("no gobazel section found")
+	}
+
 	cfg.Conf.IgnoreSet = toSet(cfg.Conf.Ignores)
 	cfg.Conf.VendorSet = toSet(cfg.Conf.Vendors)
 	cfg.Conf.FallThroughSet = toSet(cfg.Conf.FallThrough)
-	return cfg.Conf
+	var err error
+	if cfg.Conf.GenDirs, err = expandGenDirs(cfg.Conf.GenDirs, cfg.Conf.BazelConfigs); err != nil {
+		return nil, err
+	}
+	if cfg.Conf.NFSWorkspace {
+		if cfg.Conf.Timeouts == nil {
+			cfg.Conf.Timeouts = &TimeoutsConf{}
+		}
+		for _, d := range []struct {
+			t       **TimeoutConf
+			timeout string
+		}{
+			{&cfg.Conf.Timeouts.FirstParty, "0s"},
+			{&cfg.Conf.Timeouts.Vendor, "1m"},
+			{&cfg.Conf.Timeouts.GoRoot, "10m"},
+		} {
+			if *d.t == nil {
+				*d.t = &TimeoutConf{}
+			}
+			if (*d.t).Attr == "" {
+				(*d.t).Attr = d.timeout
+			}
+			if (*d.t).Entry == "" {
+				(*d.t).Entry = d.timeout
+			}
+		}
+	}
+	if cfg.Conf.Timeouts != nil {
+		for _, t := range []**TimeoutConf{&cfg.Conf.Timeouts.FirstParty, &cfg.Conf.Timeouts.Vendor, &cfg.Conf.Timeouts.GoRoot} {
+			if *t == nil {
+				*t = &TimeoutConf{}
+			}
+			var err error
+			if (*t).AttrTimeout, err = parseTimeout((*t).Attr); err != nil {
+				return nil, err
+			}
+			if (*t).EntryTimeout, err = parseTimeout((*t).Entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.Conf.MissingDirGrace != "" {
+		d, err := time.ParseDuration(cfg.Conf.MissingDirGrace)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid missing-dir-grace \"%s\"", cfg.Conf.MissingDirGrace)
+		}
+		cfg.Conf.MissingDirGraceTimeout = d
+	}
+	for _, r := range cfg.Conf.WriteThrough {
+		parts := strings.Split(r, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid write-through rule \"%s\"", r)
+		}
+		cfg.Conf.WriteThroughRules = append(cfg.Conf.WriteThroughRules, WriteThroughRule{
+			GenSuffix: parts[0],
+			SrcSuffix: parts[1],
+		})
+	}
+	if cfg.Conf.StatsInterval != "" {
+		d, err := time.ParseDuration(cfg.Conf.StatsInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid stats-interval \"%s\"", cfg.Conf.StatsInterval)
+		}
+		cfg.Conf.StatsIntervalDuration = d
+	}
+	for _, r := range cfg.Conf.GenRoots {
+		parts := strings.Split(r, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid gen-roots entry \"%s\"", r)
+		}
+		cfg.Conf.GenRootScopes = append(cfg.Conf.GenRootScopes, GenRoot{
+			ImportPrefix: strings.Trim(parts[0], "/"),
+			Dir:          parts[1],
+		})
+	}
+	for _, o := range cfg.Conf.Overlays {
+		parts := strings.Split(o, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid overlay-dirs entry \"%s\"", o)
+		}
+		prefix := strings.Trim(parts[0], "/")
+		if !strings.HasPrefix(prefix, cfg.Conf.GoPkgPrefix+"/") {
+			return nil, fmt.Errorf("overlay-dirs entry \"%s\" is not under go-pkg-prefix", o)
+		}
+		cfg.Conf.OverlayDirs = append(cfg.Conf.OverlayDirs, OverlayDir{
+			ImportPrefix: prefix,
+			Dir:          parts[1],
+		})
+	}
+	if cfg.Conf.MaxOpenFiles != "" {
+		n, err := strconv.ParseInt(cfg.Conf.MaxOpenFiles, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid max-open-files \"%s\"", cfg.Conf.MaxOpenFiles)
+		}
+		cfg.Conf.MaxOpenFilesLimit = n
+	}
+	if cfg.Conf.UIDMapping, err = parseIDMap("uid-map", cfg.Conf.UIDMap); err != nil {
+		return nil, err
+	}
+	if cfg.Conf.GIDMapping, err = parseIDMap("gid-map", cfg.Conf.GIDMap); err != nil {
+		return nil, err
+	}
+	if err := checkOrder("first-party-order", cfg.Conf.FirstPartyOrder, "first-party", "overlay", "genfiles"); err != nil {
+		return nil, err
+	}
+	if err := checkOrder("vendor-order", cfg.Conf.VendorOrder, "vendor", "vendor-genfiles"); err != nil {
+		return nil, err
+	}
+	if cfg.Conf.MaxRead != "" {
+		n, err := strconv.Atoi(cfg.Conf.MaxRead)
+		if err != nil || n < 4096 || n > 1<<20 {
+			return nil, fmt.Errorf("invalid max-read \"%s\"", cfg.Conf.MaxRead)
+		}
+		cfg.Conf.MaxReadBytes = n
+	}
+	if cfg.Conf.ConsistencyCheck != "" {
+		d, err := time.ParseDuration(cfg.Conf.ConsistencyCheck)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid consistency-check \"%s\"", cfg.Conf.ConsistencyCheck)
+		}
+		cfg.Conf.ConsistencyCheckDuration = d
+	}
+	cfg.Conf.ConsistencyCheckCount = 16
+	if cfg.Conf.ConsistencyCheckSamples != "" {
+		n, err := strconv.Atoi(cfg.Conf.ConsistencyCheckSamples)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid consistency-check-samples \"%s\"", cfg.Conf.ConsistencyCheckSamples)
+		}
+		cfg.Conf.ConsistencyCheckCount = n
+	}
+	if cfg.Conf.MaxVendors != "" {
+		n, err := strconv.Atoi(cfg.Conf.MaxVendors)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid max-vendors \"%s\"", cfg.Conf.MaxVendors)
+		}
+		cfg.Conf.MaxVendorsLimit = n
+	}
+	if cfg.Conf.MaxDepth != "" {
+		n, err := strconv.Atoi(cfg.Conf.MaxDepth)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid max-depth \"%s\"", cfg.Conf.MaxDepth)
+		}
+		cfg.Conf.MaxDepthLimit = n
+	}
+	if cfg.Conf.ScanConcurrency != "" {
+		n, err := strconv.Atoi(cfg.Conf.ScanConcurrency)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid scan-concurrency \"%s\"", cfg.Conf.ScanConcurrency)
+		}
+		cfg.Conf.ScanConcurrencyLimit = n
+	}
+	if cfg.Conf.HashXAttrMaxSize != "" {
+		n, err := strconv.ParseInt(cfg.Conf.HashXAttrMaxSize, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid hash-xattr-max-size \"%s\"", cfg.Conf.HashXAttrMaxSize)
+		}
+		cfg.Conf.HashXAttrMaxBytes = n
+	}
+	if cfg.Conf.ContentCacheMaxSize != "" {
+		n, err := strconv.ParseInt(cfg.Conf.ContentCacheMaxSize, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid content-cache-max-size \"%s\"", cfg.Conf.ContentCacheMaxSize)
+		}
+		cfg.Conf.ContentCacheMaxBytes = n
+	}
+	if cfg.Conf.HashXAttrTimeout != "" {
+		d, err := time.ParseDuration(cfg.Conf.HashXAttrTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid hash-xattr-timeout \"%s\"", cfg.Conf.HashXAttrTimeout)
+		}
+		cfg.Conf.HashXAttrTimeoutDuration = d
+	}
+	for _, p := range cfg.Conf.NormalizeCRLF {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid normalize-crlf pattern \"%s\"", p)
+		}
+	}
+	if cfg.Conf.Quarantine != "" {
+		d, err := time.ParseDuration(cfg.Conf.Quarantine)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid quarantine \"%s\"", cfg.Conf.Quarantine)
+		}
+		cfg.Conf.QuarantineDuration = d
+	}
+	if cp := cfg.Conf.CreatePolicy; cp != nil {
+		for _, p := range cp.Deny {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid create-policy deny pattern \"%s\"", p)
+			}
+		}
+		if cp.MaxSize != "" {
+			n, err := strconv.ParseInt(cp.MaxSize, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid create-policy max-size \"%s\"", cp.MaxSize)
+			}
+			cp.MaxBytes = n
+		}
+	}
+	for _, p := range cfg.Conf.Pins {
+		parts := strings.Split(p, "=")
+		if len(parts) != 2 || strings.Trim(parts[0], "/") == "" || !filepath.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("invalid path-pins entry \"%s\"", p)
+		}
+		cfg.Conf.PathPins = append(cfg.Conf.PathPins, PathPin{
+			Prefix: strings.Trim(parts[0], "/"),
+			Dir:    parts[1],
+		})
+	}
+	switch cfg.Conf.RequireCleanTree {
+	case "", CleanTreeWarn, CleanTreeFail:
+	default:
+		return nil, fmt.Errorf("invalid require-clean-tree \"%s\"", cfg.Conf.RequireCleanTree)
+	}
+	if cfg.Conf.TargetArch == "" && len(cfg.Conf.TargetArchFallbacks) > 0 {
+		return nil, fmt.Errorf("target-arch-fallbacks set without target-arch")
+	}
+	if cfg.Conf.TargetArch != "" {
+		for _, a := range append([]string{cfg.Conf.TargetArch}, cfg.Conf.TargetArchFallbacks...) {
+			if _, err := filepath.Match(a, ""); a == "" || strings.Contains(a, "/") || err != nil {
+				return nil, fmt.Errorf("invalid target architecture \"%s\"", a)
+			}
+		}
+	}
+	for _, p := range cfg.Conf.TemplateFiles {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid template-files pattern \"%s\"", p)
+		}
+	}
+	for _, v := range cfg.Conf.TemplateVars {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid template-vars entry \"%s\"", v)
+		}
+		if cfg.Conf.TemplateVarMap == nil {
+			cfg.Conf.TemplateVarMap = map[string]string{}
+		}
+		cfg.Conf.TemplateVarMap[v[:i]] = v[i+1:]
+	}
+	for _, p := range cfg.Conf.DecompressGz {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid decompress-gz pattern \"%s\"", p)
+		}
+	}
+	for _, r := range cfg.Conf.Readahead {
+		parts := strings.Split(r, "=")
+		if len(parts) != 2 || !readaheadKinds[parts[0]] || !readaheadHints[parts[1]] {
+			return nil, fmt.Errorf("invalid readahead rule \"%s\"", r)
+		}
+		if cfg.Conf.ReadaheadHints == nil {
+			cfg.Conf.ReadaheadHints = map[string]string{}
+		}
+		cfg.Conf.ReadaheadHints[parts[0]] = parts[1]
+	}
+	return cfg.Conf, nil
+}
+
+// The resolution kinds of regular files, and the readahead hints for them.
+var (
+	readaheadKinds = map[string]bool{
+		"first-party":     true,
+		"overlay":         true,
+		"genfiles":        true,
+		"goroot":          true,
+		"fall-through":    true,
+		"vendor":          true,
+		"vendor-genfiles": true,
+	}
+	readaheadHints = map[string]bool{
+		"normal":     true,
+		"sequential": true,
+		"random":     true,
+	}
+)
+
+type dirOverrideWrapper struct {
+	Override *DirOverride `cfg-attr:"gobazel"`
+}
+
+// ParseDirOverride parses a per-directory .gobazel file.
+func ParseDirOverride(path string) (*DirOverride, error) {
+	ow := dirOverrideWrapper{}
+	if err := confish.ParseFile(path, &ow); err != nil {
+		return nil, err
+	}
+	if ow.Override == nil {
+		return nil, fmt.Errorf("no gobazel section found")
+	}
+	return ow.Override, nil
+}
+
+// parseIDMap parses the "backing=reported" rules of the given ID map.
+func parseIDMap(attr string, rules []string) (map[uint32]uint32, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	mapping := map[uint32]uint32{}
+	reported := map[uint32]bool{}
+	for _, r := range rules {
+		parts := strings.Split(r, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s rule \"%s\"", attr, r)
+		}
+		from, err1 := strconv.ParseUint(parts[0], 10, 32)
+		to, err2 := strconv.ParseUint(parts[1], 10, 32)
+		if err1 != nil || err2 != nil || reported[uint32(to)] {
+			return nil, fmt.Errorf("invalid %s rule \"%s\"", attr, r)
+		}
+		mapping[uint32(from)] = uint32(to)
+		reported[uint32(to)] = true
+	}
+	return mapping, nil
+}
+
+// checkOrder checks that the given search order lists each kind at most
+// once, and only the given ones. Unless empty, it has to list the first
+// kind, the directories files created through the mount go to, or they
+// couldn't be found afterwards.
+func checkOrder(attr string, order []string, kinds ...string) error {
+	seen := map[string]bool{}
+	for _, k := range order {
+		valid := false
+		for _, v := range kinds {
+			if k == v {
+				valid = true
+				break
+			}
+		}
+		if !valid || seen[k] {
+			return fmt.Errorf("invalid %s entry \"%s\"", attr, k)
+		}
+		seen[k] = true
+	}
+	if len(order) > 0 && !seen[kinds[0]] {
+		return fmt.Errorf("invalid %s, \"%s\" missing", attr, kinds[0])
+	}
+	return nil
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout \"%s\"", s)
+	}
+	return d, nil
 }
 
 func toSet(slice []string) map[string]struct{} {
@@ -0,0 +1,57 @@
+package conf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckOrder(t *testing.T) {
+	kinds := []string{"first-party", "overlay", "genfiles"}
+	for _, tc := range []struct {
+		order []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"genfiles", "first-party"}, true},
+		{[]string{"first-party", "overlay", "genfiles"}, true},
+		{[]string{"genfiles"}, false},
+		{[]string{"overlay", "genfiles"}, false},
+		{[]string{"first-party", "first-party"}, false},
+		{[]string{"first-party", "vendor"}, false},
+	} {
+		err := checkOrder("first-party-order", tc.order, kinds...)
+		if (err == nil) != tc.ok {
+			t.Errorf("checkOrder(%q) = %v, want ok %v", tc.order, err, tc.ok)
+		}
+	}
+}
+
+func TestExpandGenDirs(t *testing.T) {
+	for _, tc := range []struct {
+		genDirs, bazelConfigs, want []string
+	}{
+		{nil, nil, []string{DefaultGenDir}},
+		{[]string{"out"}, nil, []string{"out"}},
+		{nil, []string{"k8-fastbuild", "k8-dbg"}, []string{
+			"bazel-out/k8-fastbuild/bin", "bazel-out/k8-fastbuild/genfiles",
+			"bazel-out/k8-dbg/bin", "bazel-out/k8-dbg/genfiles",
+		}},
+		// Reordered, and followed by gen-dirs.
+		{[]string{DefaultGenDir}, []string{"k8-dbg", "k8-fastbuild"}, []string{
+			"bazel-out/k8-dbg/bin", "bazel-out/k8-dbg/genfiles",
+			"bazel-out/k8-fastbuild/bin", "bazel-out/k8-fastbuild/genfiles",
+			DefaultGenDir,
+		}},
+	} {
+		got, err := expandGenDirs(tc.genDirs, tc.bazelConfigs)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("expandGenDirs(%q, %q) = %q, %v, want %q", tc.genDirs, tc.bazelConfigs, got, err, tc.want)
+		}
+	}
+
+	for _, c := range []string{"", "k8-dbg/bin"} {
+		if _, err := expandGenDirs(nil, []string{"k8-dbg", c}); err == nil {
+			t.Errorf("expandGenDirs accepted bazel-configs entry %q", c)
+		}
+	}
+}
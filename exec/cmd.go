@@ -83,11 +83,18 @@ func RunCommand(cfg *conf.GobazelConf, command string) error {
 
 func replaceGoPath(cfg *conf.GobazelConf) []string {
 	environ := []string{fmt.Sprintf("GOPATH=%s", cfg.GoPath)}
+	if cfg.BinDir != "" {
+		environ = append(environ, fmt.Sprintf("GOBIN=%s", cfg.BinDir))
+	}
+
 	env := os.Environ()
 	for _, e := range env {
 		if strings.HasPrefix(e, "GOPATH=") {
 			continue
 		}
+		if cfg.BinDir != "" && strings.HasPrefix(e, "GOBIN=") {
+			continue
+		}
 		environ = append(environ, e)
 	}
 	return environ
@@ -0,0 +1,40 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+func TestRunGoInstallIntoBinDir(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	// The GOPATH is mounted in GOPATH mode.
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOFLAGS", "")
+
+	cfg := &conf.GobazelConf{
+		GoPath: t.TempDir(),
+		BinDir: t.TempDir(),
+	}
+	main := filepath.Join(cfg.GoPath, "src", "example.com", "x", "hello", "main.go")
+	if err := os.MkdirAll(filepath.Dir(main), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(main, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	RunGoInstall(cfg, "example.com/x/hello")
+	if _, err := os.Stat(filepath.Join(cfg.BinDir, "hello")); err != nil {
+		t.Errorf("hello not installed into bin-dir, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.GoPath, "bin", "hello")); !os.IsNotExist(err) {
+		t.Errorf("hello installed into $GOPATH/bin, %v", err)
+	}
+}
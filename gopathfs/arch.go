@@ -0,0 +1,106 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long the output roots found for the target architecture are used
+// before bazel-out is searched again, e.g., for a newly built configuration.
+const archGenDirsTTL = 5 * time.Second
+
+// archGenDirCache caches the genfiles directories of the target
+// architectures.
+type archGenDirCache struct {
+	mu      sync.Mutex
+	arches  []string // The architectures the dirs were found for.
+	dirs    []string
+	expires time.Time
+}
+
+func (c *archGenDirCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.arches, c.dirs = nil, nil
+}
+
+// targetArches returns the target-arch followed by its fallbacks.
+func (gpf *GoPathFs) targetArches() []string {
+	if gpf.config().TargetArch == "" {
+		return nil
+	}
+	return append([]string{gpf.config().TargetArch}, gpf.config().TargetArchFallbacks...)
+}
+
+// archGenDirs returns the genfiles directories, relative to the workspace,
+// of the bazel output roots of the target architecture and then those of
+// its fallbacks. The output roots of an architecture, e.g.,
+// bazel-out/arm64-fastbuild and bazel-out/arm64-opt for "arm64", come most
+// recently built first.
+func (gpf *GoPathFs) archGenDirs() []string {
+	arches := gpf.targetArches()
+	if len(arches) == 0 {
+		return nil
+	}
+
+	c := &gpf.archDirs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expires) && equalStrings(c.arches, arches) {
+		return c.dirs
+	}
+
+	dirs := []string{}
+	for _, arch := range arches {
+		for _, root := range gpf.archOutputRoots(arch) {
+			dirs = append(dirs, filepath.Join(root, "bin"), filepath.Join(root, "genfiles"))
+		}
+	}
+	c.arches, c.dirs, c.expires = arches, dirs, now.Add(archGenDirsTTL)
+	return dirs
+}
+
+// archOutputRoots returns the output roots of the given architecture,
+// relative to the workspace, most recently modified first.
+func (gpf *GoPathFs) archOutputRoots(arch string) []string {
+	matches, _ := filepath.Glob(filepath.Join(gpf.workspace(), "bazel-out", arch+"-*"))
+
+	type root struct {
+		rel     string
+		modTime time.Time
+	}
+	roots := []root{}
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		roots = append(roots, root{filepath.Join("bazel-out", filepath.Base(m)), fi.ModTime()})
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].modTime.After(roots[j].modTime)
+	})
+
+	rels := make([]string, 0, len(roots))
+	for _, r := range roots {
+		rels = append(rels, r.rel)
+	}
+	return rels
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
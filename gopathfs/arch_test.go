@@ -0,0 +1,51 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestTargetArchGenfiles(t *testing.T) {
+	cfg := testConfig()
+	cfg.TargetArch = "arm64"
+	cfg.TargetArchFallbacks = []string{"k8"}
+	gpf, ws := newTestFs(t, cfg)
+	arm64 := filepath.Join(ws, "bazel-out", "arm64-fastbuild", "bin")
+	k8 := filepath.Join(ws, "bazel-out", "k8-fastbuild", "bin")
+	writeFile(t, filepath.Join(arm64, "foo", "a.pb.go"), "package arm64\n")
+	writeFile(t, filepath.Join(k8, "foo", "a.pb.go"), "package k8\n")
+	writeFile(t, filepath.Join(k8, "foo", "b.pb.go"), "package k8\n")
+	writeFile(t, filepath.Join(ws, "bazel-genfiles", "foo", "c.pb.go"), "package genfiles\n")
+
+	// The most recently built output root of an architecture comes first.
+	older := filepath.Join(ws, "bazel-out", "arm64-opt", "bin")
+	writeFile(t, filepath.Join(older, "foo", "a.pb.go"), "package arm64opt\n")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(ws, "bazel-out", "arm64-opt"), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"a.pb.go": "package arm64\n",    // From the target architecture.
+		"b.pb.go": "package k8\n",       // From its fallback.
+		"c.pb.go": "package genfiles\n", // From gen-dirs.
+	} {
+		if got, status := readMountFile(t, gpf, testPrefix+"/foo/"+name); status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+		}
+	}
+
+	// Switching the architecture takes effect on the next lookup.
+	k8Cfg := *cfg
+	k8Cfg.TargetArch, k8Cfg.TargetArchFallbacks = "k8", nil
+	if err := gpf.Reload(&k8Cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.pb.go"); status != fuse.OK || got != "package k8\n" {
+		t.Errorf("reading a.pb.go for k8 = %q, %v, want %q", got, status, "package k8\n")
+	}
+}
@@ -1,57 +1,162 @@
 package gopathfs
 
 import (
-	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"golang.org/x/sys/unix"
 )
 
 // GetAttr overwrites the parent's GetAttr method.
-func (gpf *GoPathFs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+func (gpf *GoPathFs) GetAttr(name string, context *fuse.Context) (attr *fuse.Attr, code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("getattr", name, time.Now(), &code, &backingPath)
+
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
+	}
+	attr, backingPath, code = gpf.lookupAttr(name)
+	return attr, code
+}
+
+// lookupAttr returns the attributes of the given mount path from the
+// attribute cache, or fresh ones which it caches, with the backing path it
+// resolved, if it did.
+func (gpf *GoPathFs) lookupAttr(name string) (*fuse.Attr, string, fuse.Status) {
+	revalidate := gpf.revalidates(name)
+	if attr, ok := gpf.attrCache.get(name); ok && !revalidate {
+		if attr == nil {
+			return nil, "", fuse.ENOENT
+		}
+		return attr, "", fuse.OK
+	}
+
+	backingPath := ""
+	attr, status := gpf.resolveAttr(name, &backingPath)
+	attrTTL, entryTTL := gpf.cacheTTLs(name)
+	if revalidate {
+		attrTTL, entryTTL = 0, 0
+	}
+	switch status {
+	case fuse.OK:
+		gpf.attrCache.put(name, attr, attrTTL)
+	case fuse.ENOENT:
+		gpf.attrCache.put(name, nil, entryTTL)
+	}
+	return attr, backingPath, status
+}
+
+func (gpf *GoPathFs) getAttr(name string) (*fuse.Attr, fuse.Status) {
+	return gpf.resolveAttr(name, nil)
+}
+
+// resolveAttr returns the attributes of the given mount path, and sets the
+// given backing path, unless nil, to the one they are from, if any.
+func (gpf *GoPathFs) resolveAttr(name string, backingPath *string) (*fuse.Attr, fuse.Status) {
+	return gpf.findAttr(name, backingPath, true)
+}
+
+// peekAttr returns the attributes of the given mount path like getAttr,
+// without recording which candidate they are from, e.g., for the
+// consistency check, which mustn't warm the caches it checks.
+func (gpf *GoPathFs) peekAttr(name string) (*fuse.Attr, fuse.Status) {
+	return gpf.findAttr(name, nil, false)
+}
+
+// findAttr is resolveAttr, which also records the candidate resolved in
+// the root cache, the directories seen and the stats if record is true.
+func (gpf *GoPathFs) findAttr(name string, backingPath *string, record bool) (*fuse.Attr, fuse.Status) {
 	if name == "" {
 		return gpf.getTopDirAttr()
 	}
+	if name == ctlFileName {
+		return gpf.getCtlFileAttr()
+	}
+	if gpf.servesModulesTxt(name) {
+		return gpf.getModulesTxtAttr()
+	}
+	if isIntrospectPath(name) {
+		return gpf.getIntrospectAttr(name)
+	}
+	if gpf.servesBazelMeta(name) {
+		return gpf.getBazelMetaAttr(name)
+	}
+	if data, ok := gpf.metadata(name); ok {
+		return gpf.getMetadataAttr(data)
+	}
+	if gpf.servesDocGo(name) {
+		return gpf.getDocGoAttr(name)
+	}
 
 	// Handle the virtual Golang prefix package.
-	if name == gpf.cfg.GoPkgPrefix {
+	if name == gpf.config().GoPkgPrefix {
 		return gpf.getFirstPartyDirAttr()
 	}
-
-	// Handle the children of the virtual Golang prefix package.
-	prefix := gpf.cfg.GoPkgPrefix + pathSeparator
-	if strings.HasPrefix(name, prefix) {
-		name = name[len(prefix):]
-		attr, status := gpf.getFirstPartyChildDirAttr(name)
-		if status == fuse.OK {
-			return attr, fuse.OK
-		}
+	if gpf.hidesModFile(name) {
+		return nil, fuse.ENOENT
 	}
 
-	// Search in fall-through directories.
-	for _, v := range gpf.cfg.FallThrough {
-		if name == v || strings.HasPrefix(name, v) {
-			return gpf.getRealDirAttr(filepath.Join(gpf.dirs.Workspace, name))
+	// Search in first-party, fall-through and vendor directories.
+	cands := gpf.candidates(name)
+	for i, c := range cands {
+		if c.src != nil {
+			if attr, status := gpf.getContentSourceAttr(c.src, c.rel); status == fuse.OK {
+				if record {
+					gpf.stats.recordCandidates(i + 1)
+				}
+				return attr, fuse.OK
+			}
+			continue
 		}
-	}
 
-	// Search in vendor directories.
-	for _, v := range gpf.cfg.Vendors {
-		fname := filepath.Join(gpf.dirs.Workspace, v, name)
-		attr, status := gpf.getRealDirAttr(fname)
-		if status == fuse.OK {
-			return attr, fuse.OK
+		getAttr := gpf.getRealDirAttr
+		if gpf.revalidates(name) {
+			getAttr = gpf.getFreshAttr
+		}
+		attr, status := getAttr(c.path)
+		if status == fuse.OK && isSpecialAttr(attr.Mode) && gpf.config().HideSpecialFiles {
+			continue
 		}
-
-		// Also search in bezel-genfiles.
-		fname = filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", v, name)
-		attr, status = gpf.getRealDirAttr(fname)
 		if status == fuse.OK {
+			if record {
+				if gpf.debug {
+					gpf.logShadowed(c, cands[i+1:])
+				}
+				gpf.rememberRoot(name, cands, i)
+				gpf.stats.recordCandidates(i + 1)
+				if attr.Mode&fuse.S_IFDIR != 0 {
+					gpf.rememberDir(name)
+				}
+			}
+			gpf.expandAttr(name, c.path, attr)
+			gpf.normalizeAttr(name, c.path, attr)
+			gpf.mapOwner(attr)
+			if backingPath != nil {
+				*backingPath = c.path
+			}
 			return attr, fuse.OK
 		}
 	}
 
+	// Parents of overlay directories exist even if not in the workspace.
+	if len(gpf.overlayChildren(name)) > 0 {
+		return gpf.getFirstPartyDirAttr()
+	}
+	if gpf.servesModulesTxtDir(name) {
+		return gpf.getFirstPartyDirAttr()
+	}
+
+	// The directory may be recreated by a concurrent build.
+	if gpf.inGrace(name) {
+		return gpf.getGraceDirAttr()
+	}
+
 	return nil, fuse.ENOENT
 }
 
@@ -67,31 +172,91 @@ func (gpf *GoPathFs) getFirstPartyDirAttr() (*fuse.Attr, fuse.Status) {
 	}, fuse.OK
 }
 
-func (gpf *GoPathFs) getFirstPartyChildDirAttr(name string) (*fuse.Attr, fuse.Status) {
-	// Search in GOROOT (for debugger).
-	if name == "GOROOT" || strings.HasPrefix(name, "GOROOT"+pathSeparator) {
-		return gpf.getRealDirAttr(filepath.Join(gpf.dirs.GoSDKDir, name[len("GOROOT"):]))
-	}
+// stat is unix.Stat, which tests replace to inject faults of the backing
+// file system, e.g., the stale attributes an NFS client caches.
+var stat = unix.Stat
 
-	nm := filepath.Join(gpf.dirs.Workspace, name)
-	attr, status := gpf.getRealDirAttr(name)
-	if status == fuse.OK {
-		return attr, fuse.OK
+func (gpf *GoPathFs) getRealDirAttr(name string) (*fuse.Attr, fuse.Status) {
+	t := unix.Stat_t{}
+	err := stat(name, &t)
+	if err != nil {
+		return nil, fuse.ENOENT
 	}
 
-	// Search in bazel-genfiles directories.
-	nm = filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", name)
-	return gpf.getRealDirAttr(nm)
+	attr := gpf.statAttr(t)
+
+	return &attr, fuse.OK
 }
 
-func (gpf *GoPathFs) getRealDirAttr(name string) (*fuse.Attr, fuse.Status) {
-	t := unix.Stat_t{}
-	err := unix.Stat(name, &t)
+// revalidates returns true if the attributes of the given mount path are
+// read afresh from an NFS-backed workspace on every lookup.
+func (gpf *GoPathFs) revalidates(name string) bool {
+	return gpf.config().NFSRevalidate && gpf.pathClass(name) == KindFirstParty
+}
+
+// getFreshAttr is getRealDirAttr for NFS-backed workspaces. It opens the
+// backing path, which by close-to-open consistency makes the NFS client
+// revalidate the attributes it cached, and stats the open file.
+func (gpf *GoPathFs) getFreshAttr(name string) (*fuse.Attr, fuse.Status) {
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
 	if err != nil {
+		// E.g., unreadable files, whose attributes may be stale.
+		return gpf.getRealDirAttr(name)
+	}
+	defer unix.Close(fd)
+
+	t := unix.Stat_t{}
+	if err := unix.Fstat(fd, &t); err != nil {
 		return nil, fuse.ENOENT
 	}
 
-	attr := unixAttrToFuseAttr(t)
+	attr := gpf.statAttr(t)
 
 	return &attr, fuse.OK
 }
+
+// Inode numbers of hard links get the index of their backing device in the
+// top bits, so that files of different backing roots (e.g., the workspace
+// and a vendor directory on another disk) don't collide.
+const devIndexShift = 48
+
+// devIndexes numbers the backing devices, see devIndexShift.
+type devIndexes struct {
+	mu      sync.Mutex
+	indexes map[uint64]uint64
+}
+
+func (di *devIndexes) index(dev uint64) uint64 {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if di.indexes == nil {
+		di.indexes = map[uint64]uint64{}
+	}
+	idx, ok := di.indexes[dev]
+	if !ok {
+		idx = uint64(len(di.indexes)) + 1
+		di.indexes[dev] = idx
+	}
+	return idx
+}
+
+func (di *devIndexes) clear() {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	di.indexes = nil
+}
+
+// statAttr returns the attributes of a backing file. Only hard links, i.e.,
+// files other than directories with more than one link, get their backing
+// inode number, which the mount shares between their mount paths. Others
+// get none, so that the mount numbers them, as several mount paths may be
+// backed by the same file, e.g., in fall-through or vendor directories, but
+// can't share an inode.
+func (gpf *GoPathFs) statAttr(st unix.Stat_t) fuse.Attr {
+	attr := unixAttrToFuseAttr(st)
+	if attr.Mode&unix.S_IFMT != unix.S_IFDIR && attr.Nlink > 1 {
+		idx := gpf.devIndexes.index(uint64(st.Dev))
+		attr.Ino = idx<<devIndexShift | uint64(st.Ino)&(1<<devIndexShift-1)
+	}
+	return attr
+}
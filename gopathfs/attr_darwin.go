@@ -6,10 +6,13 @@ import (
 )
 
 func unixAttrToFuseAttr(from unix.Stat_t) (result fuse.Attr) {
-	result.Ino = from.Ino
 	result.Size = uint64(from.Size)
 	result.Blocks = uint64(from.Blocks)
+	result.Blksize = uint32(from.Blksize)
 	result.Mode = uint32(from.Mode)
+	result.Nlink = uint32(from.Nlink)
+	result.Uid = from.Uid
+	result.Gid = from.Gid
 
 	sec, nsec := from.Atim.Unix()
 	result.Atime = uint64(sec)
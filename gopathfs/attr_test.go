@@ -0,0 +1,149 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestHardLinkInodes(t *testing.T) {
+	cfg := testConfig()
+	cfg.FallThrough = []string{"tools"}
+	cfg.FallThroughSet = map[string]struct{}{"tools": {}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	if err := os.Link(filepath.Join(ws, "foo", "a.go"), filepath.Join(ws, "foo", "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(ws, "tools", "x.go"), "package tools\n")
+
+	getAttr := func(name string) *fuse.Attr {
+		t.Helper()
+		attr, status := gpf.GetAttr(name, nil)
+		if status != fuse.OK {
+			t.Fatalf("GetAttr(%s) = %v", name, status)
+		}
+		return attr
+	}
+
+	// Hard links share their inode number, also through open files.
+	a, b := getAttr(testPrefix+"/foo/a.go"), getAttr(testPrefix+"/foo/b.go")
+	if a.Ino == 0 || a.Ino != b.Ino || a.Nlink != 2 {
+		t.Errorf("hard links have inode numbers %d and %d, %d links", a.Ino, b.Ino, a.Nlink)
+	}
+	f, status := gpf.Open(testPrefix+"/foo/b.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	out := fuse.Attr{}
+	if status := f.GetAttr(&out); status != fuse.OK || out.Ino != a.Ino {
+		t.Errorf("File.GetAttr = %d, %v, want inode number %d", out.Ino, status, a.Ino)
+	}
+
+	// Mount paths which merely share a backing file or directory don't,
+	// the mount numbers them.
+	for _, name := range []string{"tools", "tools/x.go", testPrefix + "/tools", testPrefix + "/tools/x.go"} {
+		if attr := getAttr(name); attr.Ino != 0 {
+			t.Errorf("GetAttr(%s) has inode number %d, want 0", name, attr.Ino)
+		}
+	}
+}
+
+// staleStat replaces stat with one serving the attributes the given backing
+// paths had when called, like an NFS client caching attributes, until the
+// returned function restores it.
+func staleStat(t *testing.T, paths ...string) func() {
+	t.Helper()
+	cached := map[string]unix.Stat_t{}
+	for _, p := range paths {
+		st := unix.Stat_t{}
+		if err := unix.Stat(p, &st); err != nil {
+			t.Fatal(err)
+		}
+		cached[p] = st
+	}
+	stat = func(path string, st *unix.Stat_t) error {
+		if c, ok := cached[path]; ok {
+			*st = c
+			return nil
+		}
+		return unix.Stat(path, st)
+	}
+	return func() { stat = unix.Stat }
+}
+
+func TestNFSRevalidateBypassesStaleAttrs(t *testing.T) {
+	for _, revalidate := range []bool{false, true} {
+		cfg := cachingConfig()
+		cfg.NFSRevalidate = revalidate
+		gpf, ws := newTestFs(t, cfg)
+		first := filepath.Join(ws, "foo", "a.go")
+		vendor := filepath.Join(ws, "vendor", "github.com", "y", "b.go")
+		writeFile(t, first, "package foo\n")
+		writeFile(t, vendor, "package y\n")
+
+		for _, name := range []string{testPrefix + "/foo/a.go", "github.com/y/b.go"} {
+			if _, status := gpf.GetAttr(name, nil); status != fuse.OK {
+				t.Fatalf("GetAttr(%s) = %v", name, status)
+			}
+		}
+		// Changed on another host, while the NFS client serves the
+		// attributes it cached.
+		restore := staleStat(t, first, vendor)
+		writeFile(t, first, "package foo\n\n// Changed.\n")
+		writeFile(t, vendor, "package y\n\n// Changed.\n")
+
+		// Neither gobazel's attribute cache nor, once that's flushed, the
+		// NFS client's is used with nfs-revalidate.
+		want := map[bool]uint64{false: 12, true: 25}[revalidate]
+		for _, flush := range []bool{false, true} {
+			if flush {
+				gpf.attrCache.clear()
+			}
+			attr, status := gpf.GetAttr(testPrefix+"/foo/a.go", nil)
+			if status != fuse.OK || attr.Size != want {
+				t.Errorf("nfs-revalidate %v, flushed %v: first-party GetAttr = %+v, %v, want size %d", revalidate, flush, attr, status, want)
+			}
+		}
+		// Vendor paths are cached either way.
+		if attr, status := gpf.GetAttr("github.com/y/b.go", nil); status != fuse.OK || attr.Size != 10 {
+			t.Errorf("nfs-revalidate %v: vendor GetAttr = %+v, %v, want the cached size 10", revalidate, attr, status)
+		}
+		restore()
+	}
+}
+
+func TestBlksizeOfBackingFile(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	st := unix.Stat_t{}
+	if err := unix.Stat(backing, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Blksize == 0 {
+		t.Skip("the workspace filesystem reports no block size")
+	}
+
+	attr, status := gpf.GetAttr(testPrefix+"/foo/a.go", nil)
+	if status != fuse.OK {
+		t.Fatalf("GetAttr = %v", status)
+	}
+	if attr.Blksize != uint32(st.Blksize) {
+		t.Errorf("Blksize = %d, want %d", attr.Blksize, st.Blksize)
+	}
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	fattr := fuse.Attr{}
+	if status := f.GetAttr(&fattr); status != fuse.OK || fattr.Blksize != uint32(st.Blksize) {
+		t.Errorf("File.GetAttr = %v, Blksize %d, want %d", status, fattr.Blksize, st.Blksize)
+	}
+}
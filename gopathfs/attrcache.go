@@ -0,0 +1,154 @@
+package gopathfs
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// attrCache caches attributes, and the absence of entries, by mount path.
+// It lets stable path classes (e.g., vendor) be cached longer than the
+// kernel timeouts, which apply to the whole mount.
+type attrCache struct {
+	mu      sync.Mutex
+	entries map[string]attrCacheEntry
+
+	hits   int64
+	misses int64
+}
+
+type attrCacheEntry struct {
+	attr    *fuse.Attr // nil if the entry doesn't exist.
+	added   time.Time
+	expires time.Time
+}
+
+func newAttrCache() *attrCache {
+	return &attrCache{
+		entries: map[string]attrCacheEntry{},
+	}
+}
+
+// get returns a copy of the cached attributes for the given mount path, or
+// nil if the path is cached as nonexistent.
+func (ac *attrCache) get(name string) (attr *fuse.Attr, ok bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	e, ok := ac.entries[name]
+	if !ok {
+		atomic.AddInt64(&ac.misses, 1)
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(ac.entries, name)
+		atomic.AddInt64(&ac.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&ac.hits, 1)
+	if e.attr == nil {
+		return nil, true
+	}
+	a := *e.attr
+	return &a, true
+}
+
+func (ac *attrCache) put(name string, attr *fuse.Attr, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	e := attrCacheEntry{added: now, expires: now.Add(ttl)}
+	if attr != nil {
+		a := *attr
+		e.attr = &a
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.entries[name] = e
+}
+
+// invalidate drops the given mount paths and everything below them.
+func (ac *attrCache) invalidate(names ...string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for _, name := range names {
+		delete(ac.entries, name)
+		for n := range ac.entries {
+			if strings.HasPrefix(n, name+pathSeparator) {
+				delete(ac.entries, n)
+			}
+		}
+	}
+}
+
+// invalidateFile drops the given mount path of a file, which has nothing
+// below it. Unlike invalidate, it doesn't go over the whole cache, so it's
+// cheap enough for every write.
+func (ac *attrCache) invalidateFile(name string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	delete(ac.entries, name)
+}
+
+// sample returns copies of up to n unexpired entries cached before the
+// given time, picked at random by the map iteration order.
+func (ac *attrCache) sample(n int, before time.Time) map[string]*fuse.Attr {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	sampled := map[string]*fuse.Attr{}
+	for name, e := range ac.entries {
+		if len(sampled) >= n {
+			break
+		}
+		if now.After(e.expires) || !e.added.Before(before) {
+			continue
+		}
+		if e.attr == nil {
+			sampled[name] = nil
+			continue
+		}
+		a := *e.attr
+		sampled[name] = &a
+	}
+	return sampled
+}
+
+func (ac *attrCache) len() int {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return len(ac.entries)
+}
+
+func (ac *attrCache) clear() {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.entries = map[string]attrCacheEntry{}
+}
+
+// cacheTTLs returns how long gobazel caches attributes and nonexistent
+// entries of the given mount path, on top of the kernel timeouts.
+func (gpf *GoPathFs) cacheTTLs(name string) (attr, entry time.Duration) {
+	if gpf.config().Timeouts == nil {
+		return 0, 0
+	}
+
+	t := gpf.config().Timeouts.FirstParty
+	switch gpf.pathClass(name) {
+	case KindVendor:
+		t = gpf.config().Timeouts.Vendor
+	case KindGoRoot:
+		t = gpf.config().Timeouts.GoRoot
+	}
+
+	kernelAttr, kernelEntry := gpf.config().Timeouts.Shortest()
+	return t.AttrTimeout - kernelAttr, t.EntryTimeout - kernelEntry
+}
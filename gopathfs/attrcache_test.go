@@ -0,0 +1,57 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+func TestTimeoutsPerPathClass(t *testing.T) {
+	cfg := testConfig()
+	cfg.FallThrough = []string{"tools"}
+	cfg.FallThroughSet = map[string]struct{}{"tools": {}}
+	cfg.Timeouts = &conf.TimeoutsConf{
+		FirstParty: &conf.TimeoutConf{AttrTimeout: time.Second, EntryTimeout: 2 * time.Second},
+		Vendor:     &conf.TimeoutConf{AttrTimeout: time.Minute, EntryTimeout: 2 * time.Minute},
+		GoRoot:     &conf.TimeoutConf{AttrTimeout: time.Hour, EntryTimeout: 2 * time.Hour},
+	}
+	gpf, ws := newTestFs(t, cfg)
+
+	// The kernel applies the shortest timeouts to the whole mount, and
+	// gobazel caches for the rest of the path class's timeouts.
+	if attr, entry := cfg.Timeouts.Shortest(); attr != time.Second || entry != 2*time.Second {
+		t.Errorf("Shortest() = %v, %v, want 1s, 2s", attr, entry)
+	}
+	for name, want := range map[string]time.Duration{
+		testPrefix + "/foo/a.go":       0,
+		testPrefix:                     0,
+		"tools/x.go":                   0,
+		"github.com/y/b.go":            time.Minute - time.Second,
+		testPrefix + "/GOROOT/src/fmt": time.Hour - time.Second,
+	} {
+		attr, entry := gpf.cacheTTLs(name)
+		if attr != want || entry != 2*want {
+			t.Errorf("cacheTTLs(%s) = %v, %v, want %v, %v", name, attr, entry, want, 2*want)
+		}
+	}
+
+	// Vendor attributes are cached past a change, first-party ones aren't.
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "b.go"), "package y\n")
+	for _, name := range []string{testPrefix + "/foo/a.go", "github.com/y/b.go"} {
+		if _, status := gpf.GetAttr(name, nil); status != fuse.OK {
+			t.Fatalf("GetAttr(%s) = %v", name, status)
+		}
+	}
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo // changed\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "b.go"), "package y // changed\n")
+	if attr, _ := gpf.GetAttr(testPrefix+"/foo/a.go", nil); attr.Size != 23 {
+		t.Errorf("first-party size after a change = %d, want 23", attr.Size)
+	}
+	if attr, _ := gpf.GetAttr("github.com/y/b.go", nil); attr.Size != 10 {
+		t.Errorf("vendor size after a change = %d, want the cached 10", attr.Size)
+	}
+}
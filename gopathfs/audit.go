@@ -0,0 +1,79 @@
+package gopathfs
+
+import (
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// AuditRecord describes a mutating operation served by a GoPathFs.
+type AuditRecord struct {
+	Time        time.Time
+	Op          string // One of create, unlink, rename, mkdir, rmdir, chmod, chown and truncate.
+	Name        string // The mount path.
+	NewName     string // The new mount path, for rename.
+	BackingPath string // The backing path Name resolved to, if any.
+	Mode        uint32 // For create, mkdir and chmod.
+	Size        uint64 // For truncate.
+	Uid         uint32 // Of the caller, or of the opener for operations
+	Gid         uint32 // through an open file.
+	Pid         uint32
+	Status      fuse.Status
+}
+
+// AuditLogger receives a record of every mutating operation, failed or not,
+// by path or through an open file, separately from the debug output.
+type AuditLogger interface {
+	Audit(rec AuditRecord)
+}
+
+// SetAuditLogger sets the logger of mutating operations. It must be called
+// before mounting.
+func (gpf *GoPathFs) SetAuditLogger(l AuditLogger) {
+	gpf.auditLogger = l
+}
+
+// auditedPath returns the backing path the given mount path resolves to, or
+// an empty string if it doesn't or no audit logger is set.
+func (gpf *GoPathFs) auditedPath(name string) string {
+	if gpf.auditLogger == nil {
+		return ""
+	}
+	if c, _, ok := gpf.resolve(name); ok && c.src == nil {
+		return c.path
+	}
+	return ""
+}
+
+// audit logs a mutating operation, given a pointer to its result status so
+// that it can be deferred. The backing path of created entries, which is
+// unknown beforehand, is looked up afterwards.
+func (gpf *GoPathFs) audit(rec AuditRecord, context *fuse.Context, code *fuse.Status) {
+	if gpf.auditLogger == nil {
+		return
+	}
+
+	rec.Time = time.Now()
+	rec.Status = *code
+	if context != nil {
+		rec.Uid, rec.Gid, rec.Pid = context.Owner.Uid, context.Owner.Gid, context.Pid
+	}
+	if rec.BackingPath == "" && *code == fuse.OK && rec.Op != "rename" {
+		rec.BackingPath = gpf.auditedPath(rec.Name)
+	}
+	gpf.auditLogger.Audit(rec)
+}
+
+// audit logs a mutating operation through the file, see GoPathFs.audit,
+// with the file's mount and backing paths and the process which opened it.
+func (lf *loopbackFile) audit(rec AuditRecord, code *fuse.Status) {
+	if lf.gpf.auditLogger == nil {
+		return
+	}
+
+	lf.gpf.openFilesMu.Lock()
+	rec.Name, rec.BackingPath = lf.name, lf.path
+	rec.Uid, rec.Gid, rec.Pid = lf.owner.Uid, lf.owner.Gid, lf.pid
+	lf.gpf.openFilesMu.Unlock()
+	lf.gpf.audit(rec, nil, code)
+}
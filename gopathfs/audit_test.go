@@ -0,0 +1,133 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// auditRecorder is an AuditLogger keeping the records.
+type auditRecorder struct {
+	mu   sync.Mutex
+	recs []AuditRecord
+}
+
+func (ar *auditRecorder) Audit(rec AuditRecord) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.recs = append(ar.recs, rec)
+}
+
+// take returns the records logged since the last call.
+func (ar *auditRecorder) take() []AuditRecord {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	recs := ar.recs
+	ar.recs = nil
+	return recs
+}
+
+func TestAuditMutatingOps(t *testing.T) {
+	ar := &auditRecorder{}
+	gpf, ws := newTestFs(t, nil, WithLogger(ar))
+	dir, file := testPrefix+"/foo", testPrefix+"/foo/a.go"
+	ctx := &fuse.Context{}
+	ctx.Owner, ctx.Pid = fuse.Owner{Uid: 1000, Gid: 1001}, 42
+
+	var f *loopbackFile
+	for _, tc := range []struct {
+		op   string
+		run  func() fuse.Status
+		want AuditRecord
+	}{
+		{"mkdir", func() fuse.Status { return gpf.Mkdir(dir, 0755, ctx) },
+			AuditRecord{Op: "mkdir", Name: dir, Mode: 0755, BackingPath: filepath.Join(ws, "foo")}},
+		{"create", func() fuse.Status {
+			file, status := gpf.Create(file, uint32(os.O_RDWR), 0644, ctx)
+			if status == fuse.OK {
+				f = file.(*loopbackFile)
+			}
+			return status
+		}, AuditRecord{Op: "create", Name: file, Mode: 0644, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"chmod", func() fuse.Status { return gpf.Chmod(file, 0600, ctx) },
+			AuditRecord{Op: "chmod", Name: file, Mode: 0600, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"chown", func() fuse.Status { return gpf.Chown(file, ^uint32(0), ^uint32(0), ctx) },
+			AuditRecord{Op: "chown", Name: file, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"truncate", func() fuse.Status { return gpf.Truncate(file, 3, ctx) },
+			AuditRecord{Op: "truncate", Name: file, Size: 3, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		// Through the open file, with the opener's owner and process.
+		{"ftruncate", func() fuse.Status { return f.Truncate(2) },
+			AuditRecord{Op: "truncate", Name: file, Size: 2, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"fchmod", func() fuse.Status { return f.Chmod(0640) },
+			AuditRecord{Op: "chmod", Name: file, Mode: 0640, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"fchown", func() fuse.Status { return f.Chown(^uint32(0), ^uint32(0)) },
+			AuditRecord{Op: "chown", Name: file, BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"rename", func() fuse.Status { return gpf.Rename(file, dir+"/b.go", ctx) },
+			AuditRecord{Op: "rename", Name: file, NewName: dir + "/b.go", BackingPath: filepath.Join(ws, "foo", "a.go")}},
+		{"unlink", func() fuse.Status { return gpf.Unlink(dir+"/b.go", ctx) },
+			AuditRecord{Op: "unlink", Name: dir + "/b.go", BackingPath: filepath.Join(ws, "foo", "b.go")}},
+		{"rmdir", func() fuse.Status { return gpf.Rmdir(dir, ctx) },
+			AuditRecord{Op: "rmdir", Name: dir, BackingPath: filepath.Join(ws, "foo")}},
+	} {
+		if status := tc.run(); status != fuse.OK {
+			t.Fatalf("%s = %v", tc.op, status)
+		}
+		recs := ar.take()
+		if len(recs) != 1 {
+			t.Errorf("%s logged %d records, want 1", tc.op, len(recs))
+			continue
+		}
+		got := recs[0]
+		if got.Time.IsZero() {
+			t.Errorf("%s logged no time", tc.op)
+		}
+		got.Time = tc.want.Time
+		tc.want.Uid, tc.want.Gid, tc.want.Pid = 1000, 1001, 42
+		if got != tc.want {
+			t.Errorf("%s logged %+v, want %+v", tc.op, got, tc.want)
+		}
+	}
+	f.Release()
+
+	// Reads and the control file aren't audited.
+	writeFile(t, filepath.Join(ws, "foo", "c.go"), "package foo\n")
+	readMountFile(t, gpf, dir+"/c.go")
+	gpf.Truncate(ctlFileName, 0, ctx)
+	if recs := ar.take(); len(recs) != 0 {
+		t.Errorf("logged %+v, want nothing", recs)
+	}
+}
+
+func TestReadOnlyOpenFile(t *testing.T) {
+	ar := &auditRecorder{}
+	gpf, ws := newTestFs(t, nil, WithReadOnly(), WithLogger(ar))
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+
+	for op, status := range map[string]fuse.Status{
+		"ftruncate": f.Truncate(0),
+		"fchmod":    f.Chmod(0600),
+		"fchown":    f.Chown(^uint32(0), ^uint32(0)),
+	} {
+		if status != fuse.EROFS {
+			t.Errorf("%s = %v, want EROFS", op, status)
+		}
+	}
+	if fi, err := os.Stat(backing); err != nil || fi.Size() != 12 || fi.Mode().Perm() != 0644 {
+		t.Errorf("backing file changed, %v", err)
+	}
+	for _, rec := range ar.take() {
+		if rec.Status != fuse.EROFS {
+			t.Errorf("logged %+v, want EROFS", rec)
+		}
+	}
+}
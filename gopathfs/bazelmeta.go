@@ -0,0 +1,91 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// bazelMetaDirName is the virtual directory in the mount root serving the
+// bazel metadata files of the workspace root read-only, with
+// serve-bazel-metadata, so that IDE integrations find them in the mount.
+const bazelMetaDirName = ".bazel"
+
+var bazelMetaFiles = []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"}
+
+// servesBazelMeta returns true if the given mount path is in the virtual
+// bazel metadata directory.
+func (gpf *GoPathFs) servesBazelMeta(name string) bool {
+	if !gpf.config().ServeBazelMetadata {
+		return false
+	}
+	return name == bazelMetaDirName || strings.HasPrefix(name, bazelMetaDirName+pathSeparator)
+}
+
+// bazelMetaPath returns the backing path of the given file in the virtual
+// bazel metadata directory, if it's one of the metadata files.
+func (gpf *GoPathFs) bazelMetaPath(name string) (string, bool) {
+	base := name[len(bazelMetaDirName):]
+	for _, f := range bazelMetaFiles {
+		if base == pathSeparator+f {
+			return filepath.Join(gpf.workspace(), f), true
+		}
+	}
+	return "", false
+}
+
+func (gpf *GoPathFs) getBazelMetaAttr(name string) (*fuse.Attr, fuse.Status) {
+	if name == bazelMetaDirName {
+		return &fuse.Attr{
+			Mode: fuse.S_IFDIR | 0555,
+		}, fuse.OK
+	}
+
+	path, ok := gpf.bazelMetaPath(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	attr, status := gpf.getRealDirAttr(path)
+	if status != fuse.OK || attr.Mode&fuse.S_IFREG == 0 {
+		return nil, fuse.ENOENT
+	}
+	attr.Mode &^= 0222
+	gpf.mapOwner(attr)
+	return attr, fuse.OK
+}
+
+func (gpf *GoPathFs) openBazelMetaDir(name string) ([]fuse.DirEntry, fuse.Status) {
+	if name != bazelMetaDirName {
+		return nil, fuse.ENOENT
+	}
+
+	entries := []fuse.DirEntry{}
+	for _, f := range bazelMetaFiles {
+		if _, status := gpf.getBazelMetaAttr(filepath.Join(bazelMetaDirName, f)); status == fuse.OK {
+			entries = append(entries, fuse.DirEntry{Name: f, Mode: fuse.S_IFREG})
+		}
+	}
+	return entries, fuse.OK
+}
+
+func (gpf *GoPathFs) openBazelMetaFile(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	if _, status := gpf.getBazelMetaAttr(name); status != fuse.OK {
+		return nil, status
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+	if status := gpf.checkOpenFiles(); status != fuse.OK {
+		return nil, status
+	}
+	defer gpf.releaseOpenFileSlot(&file)
+
+	path, _ := gpf.bazelMetaPath(name)
+	file, status := gpf.openUnderlyingFile(path, flags, context)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return gpf.trackFile(name, file, flags, context), fuse.OK
+}
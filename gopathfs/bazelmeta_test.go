@@ -0,0 +1,46 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestBazelMetadata(t *testing.T) {
+	cfg := testConfig()
+	cfg.ServeBazelMetadata = true
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "WORKSPACE"), "workspace(name = \"x\")\n")
+	writeFile(t, filepath.Join(ws, "MODULE.bazel"), "module(name = \"x\")\n")
+
+	if names := listNames(t, gpf, ""); !names[".bazel"] {
+		t.Errorf("mount root lists %v, want .bazel", names)
+	}
+	names := listNames(t, gpf, ".bazel")
+	if len(names) != 2 || !names["WORKSPACE"] || !names["MODULE.bazel"] {
+		t.Errorf(".bazel lists %v, want the existing metadata files", names)
+	}
+
+	name := ".bazel/WORKSPACE"
+	if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != "workspace(name = \"x\")\n" {
+		t.Errorf("reading %s = %q, %v", name, got, status)
+	}
+	attr, status := gpf.GetAttr(name, nil)
+	if status != fuse.OK || attr.Mode&0222 != 0 {
+		t.Errorf("GetAttr(%s) = %v, %v, want no write bits", name, attr, status)
+	}
+	if _, status := gpf.Open(name, uint32(os.O_WRONLY), nil); status != fuse.EROFS {
+		t.Errorf("opening %s for writing = %v, want EROFS", name, status)
+	}
+	if _, status := gpf.GetAttr(".bazel/WORKSPACE.bazel", nil); status != fuse.ENOENT {
+		t.Errorf("GetAttr of a missing metadata file = %v, want ENOENT", status)
+	}
+
+	// Not served unless configured.
+	gpf, _ = newTestFs(t, nil)
+	if names := listNames(t, gpf, ""); names[".bazel"] {
+		t.Errorf("mount root lists .bazel without serve-bazel-metadata")
+	}
+}
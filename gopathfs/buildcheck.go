@@ -0,0 +1,38 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of bazel package files, in the order bazel looks for them.
+var buildFileNames = []string{"BUILD.bazel", "BUILD"}
+
+// warnIfUnbuilt warns if the given first-party Go file, relative to the
+// workspace, likely isn't built by bazel, i.e., if its directory has no
+// BUILD file, or one which neither mentions the file nor uses glob. It's a
+// heuristic to explain why a new file doesn't get compiled.
+func (gpf *GoPathFs) warnIfUnbuilt(rel string) {
+	base := filepath.Base(rel)
+	if !strings.HasSuffix(base, ".go") || strings.HasPrefix(base, ".") {
+		return
+	}
+
+	dir := filepath.Join(gpf.workspace(), filepath.Dir(rel))
+	for _, n := range buildFileNames {
+		data, err := os.ReadFile(filepath.Join(dir, n))
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(data, []byte(`"`+base+`"`)) || bytes.Contains(data, []byte("glob(")) {
+			return
+		}
+		fmt.Printf("Warning, %s isn't listed in %s, bazel won't build it until it's added to a target.\n",
+			rel, filepath.Join(filepath.Dir(rel), n))
+		return
+	}
+	fmt.Printf("Warning, %s has no BUILD file, bazel won't build %s.\n", filepath.Dir(rel), rel)
+}
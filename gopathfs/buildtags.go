@@ -0,0 +1,146 @@
+package gopathfs
+
+import (
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// buildContext returns the go/build context the listings of Go files are
+// filtered for, or nil if build-goos, build-goarch and build-tags are unset.
+// Files are read through the mount's resolution, so that the //go:build
+// lines of generated files count too.
+func (gpf *GoPathFs) buildContext() *build.Context {
+	cfg := gpf.config()
+	if cfg.BuildGOOS == "" && cfg.BuildGOARCH == "" && len(cfg.BuildTags) == 0 {
+		return nil
+	}
+
+	ctxt := build.Default
+	if cfg.BuildGOOS != "" {
+		ctxt.GOOS = cfg.BuildGOOS
+	}
+	if cfg.BuildGOARCH != "" {
+		ctxt.GOARCH = cfg.BuildGOARCH
+	}
+	ctxt.BuildTags = cfg.BuildTags
+	ctxt.OpenFile = gpf.openForBuild
+	return &ctxt
+}
+
+func (gpf *GoPathFs) openForBuild(name string) (io.ReadCloser, error) {
+	c, _, ok := gpf.resolve(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if c.src == nil {
+		return os.Open(c.path)
+	}
+	r, size, err := c.src.Open(c.rel)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(io.NewSectionReader(r, 0, size)), nil
+}
+
+// withoutExcludedFiles drops the Go files the compiler wouldn't build for
+// the configured target from the listing of the given mount directory, by
+// their filename suffixes (e.g., _windows.go) and build constraints. Only
+// listings are filtered, the files can still be opened by name.
+func (gpf *GoPathFs) withoutExcludedFiles(dir string, entries []fuse.DirEntry) []fuse.DirEntry {
+	ctxt := gpf.buildContext()
+	if ctxt == nil || !strings.HasPrefix(dir+pathSeparator, gpf.config().GoPkgPrefix+pathSeparator) {
+		return entries
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR == 0 && strings.HasSuffix(e.Name, ".go") && !gpf.matchFile(ctxt, dir, e.Name) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// Entries beyond which the buildMatches starts over.
+const maxBuildMatchesCached = 10000
+
+// buildMatches keeps whether Go files are built for the configured target,
+// by mount path, until they change, so that listings don't read every file
+// again.
+type buildMatches struct {
+	mu      sync.Mutex
+	entries map[string]buildMatch
+}
+
+type buildMatch struct {
+	stamp fileStamp
+	match bool
+}
+
+func (bm *buildMatches) clear() {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.entries = nil
+}
+
+// matchFile returns false if the given Go file isn't built for the target
+// of the given context, or true if it is or can't be read.
+func (gpf *GoPathFs) matchFile(ctxt *build.Context, dir, name string) bool {
+	path := filepath.Join(dir, name)
+	stamp, ok := gpf.buildStamp(path)
+
+	bm := &gpf.buildMatches
+	if ok {
+		bm.mu.Lock()
+		e, found := bm.entries[path]
+		bm.mu.Unlock()
+		if found && e.stamp.size == stamp.size && e.stamp.modTime.Equal(stamp.modTime) {
+			return e.match
+		}
+	}
+
+	match, err := ctxt.MatchFile(dir, name)
+	if err != nil {
+		return true
+	}
+	if ok {
+		bm.mu.Lock()
+		if bm.entries == nil || len(bm.entries) >= maxBuildMatchesCached {
+			bm.entries = map[string]buildMatch{}
+		}
+		bm.entries[path] = buildMatch{stamp: stamp, match: match}
+		bm.mu.Unlock()
+	}
+	return match
+}
+
+// buildStamp returns the modification time and size of the file at the
+// given mount path, or false if they are unknown, e.g., for content sources
+// which don't tell.
+func (gpf *GoPathFs) buildStamp(name string) (fileStamp, bool) {
+	c, _, ok := gpf.resolve(name)
+	if !ok {
+		return fileStamp{}, false
+	}
+	if c.src == nil {
+		fi, err := os.Stat(c.path)
+		if err != nil {
+			return fileStamp{}, false
+		}
+		return fileStamp{size: fi.Size(), modTime: fi.ModTime()}, true
+	}
+	if st, ok := c.src.(stater); ok {
+		if fi, err := st.Stat(c.rel); err == nil {
+			return fileStamp{size: fi.Size(), modTime: fi.ModTime()}, true
+		}
+	}
+	return fileStamp{}, false
+}
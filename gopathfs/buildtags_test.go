@@ -0,0 +1,55 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListingFilteredByBuildConstraints(t *testing.T) {
+	cfg := testConfig()
+	cfg.BuildGOOS = "linux"
+	cfg.BuildGOARCH = "amd64"
+	gpf, ws := newTestFs(t, cfg)
+	dir := filepath.Join(ws, "foo")
+	writeFile(t, filepath.Join(dir, "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(dir, "a_linux.go"), "package foo\n")
+	writeFile(t, filepath.Join(dir, "a_windows.go"), "package foo\n")
+	writeFile(t, filepath.Join(dir, "a_windows.txt"), "not go\n")
+	b := filepath.Join(dir, "b.go")
+	writeFile(t, b, "//go:build windows\n\npackage foo\n")
+	mtime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(b, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listNames(t, gpf, testPrefix+"/foo")
+	for name, want := range map[string]bool{
+		"a.go":          true,
+		"a_linux.go":    true,
+		"a_windows.go":  false,
+		"a_windows.txt": true,
+		"b.go":          false,
+	} {
+		if names[name] != want {
+			t.Errorf("%s listed = %v, want %v", name, names[name], want)
+		}
+	}
+
+	// Unchanged mtime and size: the cached result holds, the file isn't
+	// read again.
+	writeFile(t, b, "//go:build linux!!\n\npackage foo\n")
+	if err := os.Chtimes(b, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if listNames(t, gpf, testPrefix+"/foo")["b.go"] {
+		t.Error("b.go listed though unchanged")
+	}
+
+	// A changed file is matched again.
+	writeFile(t, b, "//go:build linux\n\npackage foo\n")
+	if !listNames(t, gpf, testPrefix+"/foo")["b.go"] {
+		t.Error("b.go not listed after it changed")
+	}
+}
@@ -0,0 +1,357 @@
+package gopathfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Digester is implemented by content sources which know the content hash of
+// a file without fetching it, e.g., a content-addressed store. Digests are
+// sha256 hashes in hex, optionally prefixed with "sha256:", and are checked
+// against the content fetched.
+type Digester interface {
+	Digest(name string) (string, error)
+}
+
+// stater is implemented by content sources which know the size and
+// modification time of a file without opening it. CachingContentSource uses
+// them, or the size from a sizer, to notice changed files. Files of other
+// sources are taken as never changing.
+type stater interface {
+	Stat(name string) (os.FileInfo, error)
+}
+
+// fileStamp identifies a version of a file of a content source, as far as
+// the source tells.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// CacheStats holds the counters of a CachingContentSource.
+type CacheStats struct {
+	Hits         int64
+	Misses       int64
+	BytesFetched int64
+	BytesCached  int64
+}
+
+// CachingContentSource materializes files fetched from another ContentSource
+// into a local directory on first access, and serves them from disk
+// thereafter. Cached files are keyed by content hash and evicted in LRU order
+// once their total size exceeds the configured cap. Files cached by an
+// earlier run are kept, and evicted first.
+//
+// The optional methods of the wrapped source, e.g. ReadDir of a DirSource,
+// are passed on to it. Those it doesn't implement return errNotSupported.
+type CachingContentSource struct {
+	src      ContentSource
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List               // Of *cacheEntry, most recently used first.
+	entries map[string]*list.Element // Keyed by content hash.
+	digests map[string]digestEntry   // Maps file names to content hashes.
+	size    int64
+
+	hits         int64
+	misses       int64
+	bytesFetched int64
+}
+
+type digestEntry struct {
+	digest string
+	stamp  fileStamp // The version of the file hashed.
+}
+
+// fetchPrefix prefixes the temporary files of fetches in the cache directory.
+const fetchPrefix = ".fetch-"
+
+type cacheEntry struct {
+	digest string
+	size   int64
+}
+
+// NewCachingContentSource returns a CachingContentSource fetching from src
+// and caching in dir, which is created if it doesn't exist. A maxBytes of 0
+// means no size cap.
+func NewCachingContentSource(src ContentSource, dir string, maxBytes int64) (*CachingContentSource, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ccs := &CachingContentSource{
+		src:      src,
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		digests:  map[string]digestEntry{},
+	}
+	if err := ccs.loadCached(); err != nil {
+		return nil, err
+	}
+	return ccs, nil
+}
+
+// loadCached indexes the files cached by an earlier run, least recently
+// fetched last, removes the temporary files of fetches which didn't finish,
+// and evicts files over the size cap.
+func (ccs *CachingContentSource) loadCached() error {
+	fis, err := ioutil.ReadDir(ccs.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().After(fis[j].ModTime())
+	})
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), fetchPrefix) {
+			os.Remove(filepath.Join(ccs.dir, fi.Name()))
+			continue
+		}
+		if !fi.Mode().IsRegular() || !isSHA256(fi.Name()) {
+			continue
+		}
+		ccs.entries[fi.Name()] = ccs.lru.PushBack(&cacheEntry{digest: fi.Name(), size: fi.Size()})
+		ccs.size += fi.Size()
+	}
+	for ccs.maxBytes > 0 && ccs.size > ccs.maxBytes && ccs.lru.Len() > 0 {
+		ccs.removeLocked(ccs.lru.Back())
+	}
+	return nil
+}
+
+// Open implements ContentSource.
+func (ccs *CachingContentSource) Open(name string) (io.ReaderAt, int64, error) {
+	stamp, err := ccs.stamp(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	digest, err := ccs.digest(name, stamp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if digest != "" {
+		if f, size, ok := ccs.openCached(digest); ok {
+			atomic.AddInt64(&ccs.hits, 1)
+			return f, size, nil
+		}
+	}
+
+	atomic.AddInt64(&ccs.misses, 1)
+	digest, err = ccs.fetch(name, digest, stamp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, size, ok := ccs.openCached(digest)
+	if !ok {
+		return nil, 0, fmt.Errorf("cached file for %s disappeared", name)
+	}
+	return f, size, nil
+}
+
+// Stats returns the current cache counters.
+func (ccs *CachingContentSource) Stats() CacheStats {
+	ccs.mu.Lock()
+	size := ccs.size
+	ccs.mu.Unlock()
+
+	return CacheStats{
+		Hits:         atomic.LoadInt64(&ccs.hits),
+		Misses:       atomic.LoadInt64(&ccs.misses),
+		BytesFetched: atomic.LoadInt64(&ccs.bytesFetched),
+		BytesCached:  size,
+	}
+}
+
+// ReadDir implements DirSource if the wrapped source does.
+func (ccs *CachingContentSource) ReadDir(name string) ([]fuse.DirEntry, error) {
+	if ds, ok := ccs.src.(DirSource); ok {
+		return ds.ReadDir(name)
+	}
+	return nil, errNotSupported
+}
+
+// Size implements sizer if the wrapped source does.
+func (ccs *CachingContentSource) Size(name string) (int64, error) {
+	if s, ok := ccs.src.(sizer); ok {
+		return s.Size(name)
+	}
+	return 0, errNotSupported
+}
+
+// Mode implements moder if the wrapped source does.
+func (ccs *CachingContentSource) Mode(name string) (uint32, error) {
+	if m, ok := ccs.src.(moder); ok {
+		return m.Mode(name)
+	}
+	return 0, errNotSupported
+}
+
+// Stat implements stater if the wrapped source does.
+func (ccs *CachingContentSource) Stat(name string) (os.FileInfo, error) {
+	if st, ok := ccs.src.(stater); ok {
+		return st.Stat(name)
+	}
+	return nil, errNotSupported
+}
+
+// stamp returns the current version of the given file, as far as the
+// source tells.
+func (ccs *CachingContentSource) stamp(name string) (fileStamp, error) {
+	if fi, err := ccs.Stat(name); err != errNotSupported {
+		if err != nil {
+			return fileStamp{}, err
+		}
+		return fileStamp{size: fi.Size(), modTime: fi.ModTime()}, nil
+	}
+	if size, err := ccs.Size(name); err != errNotSupported {
+		return fileStamp{size: size}, err
+	}
+	return fileStamp{}, nil
+}
+
+// digest returns the known content hash of the given version of a file, or
+// an empty string if it's unknown until the file is fetched. The hash of an
+// earlier version is forgotten.
+func (ccs *CachingContentSource) digest(name string, stamp fileStamp) (string, error) {
+	if d, ok := ccs.src.(Digester); ok {
+		digest, err := d.Digest(name)
+		if err != nil {
+			return "", err
+		}
+		hash := strings.TrimPrefix(digest, "sha256:")
+		if !isSHA256(hash) {
+			return "", fmt.Errorf("invalid digest %q of %s", digest, name)
+		}
+		return hash, nil
+	}
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+	e, ok := ccs.digests[name]
+	if !ok {
+		return "", nil
+	}
+	if e.stamp != stamp {
+		delete(ccs.digests, name)
+		return "", nil
+	}
+	return e.digest, nil
+}
+
+// isSHA256 returns true if the given string is a sha256 hash in lower case
+// hex, which is how cached files are named.
+func isSHA256(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	for _, r := range hash {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (ccs *CachingContentSource) openCached(digest string) (*os.File, int64, bool) {
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	elem, ok := ccs.entries[digest]
+	if !ok {
+		return nil, 0, false
+	}
+
+	// Files still open remain readable after eviction, so callers can use
+	// the returned file without holding the lock.
+	f, err := os.Open(filepath.Join(ccs.dir, digest))
+	if err != nil {
+		ccs.removeLocked(elem)
+		return nil, 0, false
+	}
+
+	ccs.lru.MoveToFront(elem)
+	return f, elem.Value.(*cacheEntry).size, true
+}
+
+// fetch copies the given version of a file from the backing source into the
+// cache directory and returns its content hash. If the source told the
+// hash, the content fetched must match it.
+func (ccs *CachingContentSource) fetch(name, digest string, stamp fileStamp) (string, error) {
+	r, size, err := ccs.src.Open(name)
+	if err != nil {
+		return "", err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	tmp, err := ioutil.TempFile(ccs.dir, fetchPrefix)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.NewSectionReader(r, 0, size))
+	tmp.Close()
+	atomic.AddInt64(&ccs.bytesFetched, n)
+	if err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if digest != "" && digest != hash {
+		return "", fmt.Errorf("content of %s fetched has sha256 %s, want %s", name, hash, digest)
+	}
+	digest = hash
+
+	if err := os.Rename(tmp.Name(), filepath.Join(ccs.dir, digest)); err != nil {
+		return "", err
+	}
+
+	ccs.mu.Lock()
+	defer ccs.mu.Unlock()
+
+	ccs.digests[name] = digestEntry{digest: digest, stamp: stamp}
+	if elem, ok := ccs.entries[digest]; ok {
+		ccs.lru.MoveToFront(elem)
+		return digest, nil
+	}
+	ccs.entries[digest] = ccs.lru.PushFront(&cacheEntry{digest: digest, size: n})
+	ccs.size += n
+
+	// Evict the least recently used files, but never the one just fetched.
+	for ccs.maxBytes > 0 && ccs.size > ccs.maxBytes && ccs.lru.Len() > 1 {
+		ccs.removeLocked(ccs.lru.Back())
+	}
+	return digest, nil
+}
+
+func (ccs *CachingContentSource) removeLocked(elem *list.Element) {
+	e := elem.Value.(*cacheEntry)
+	ccs.lru.Remove(elem)
+	delete(ccs.entries, e.digest)
+	ccs.size -= e.size
+	os.Remove(filepath.Join(ccs.dir, e.digest))
+}
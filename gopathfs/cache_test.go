@@ -0,0 +1,246 @@
+package gopathfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func readAll(t *testing.T, src ContentSource, name string) string {
+	t.Helper()
+	r, size, err := src.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%s) = %v", name, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCachingContentSource(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.pb.go"), "package a\n")
+	ccs, err := NewCachingContentSource(NewLocalContentSource(root), t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := readAll(t, ccs, "a.pb.go"); got != "package a\n" {
+			t.Errorf("reading a.pb.go = %q", got)
+		}
+	}
+	if st := ccs.Stats(); st.Hits != 1 || st.Misses != 1 || st.BytesFetched != 10 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss, 10 bytes fetched", st)
+	}
+
+	// A changed file is fetched again, even of the same size.
+	writeFile(t, filepath.Join(root, "a.pb.go"), "package b\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "a.pb.go"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, ccs, "a.pb.go"); got != "package b\n" {
+		t.Errorf("reading a.pb.go after a change = %q", got)
+	}
+	if st := ccs.Stats(); st.Misses != 2 {
+		t.Errorf("stats = %+v, want 2 misses", st)
+	}
+}
+
+func TestCachingContentSourceEviction(t *testing.T) {
+	src := sizedMemSource{&memSource{files: map[string]string{
+		"a": strings.Repeat("a", 10),
+		"b": strings.Repeat("b", 10),
+	}}}
+	ccs, err := NewCachingContentSource(src, t.TempDir(), 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, ccs, "a")
+	readAll(t, ccs, "b")
+	if st := ccs.Stats(); st.BytesCached != 10 {
+		t.Errorf("%d bytes cached, want 10", st.BytesCached)
+	}
+	if got := readAll(t, ccs, "a"); got != strings.Repeat("a", 10) {
+		t.Errorf("reading an evicted file = %q", got)
+	}
+	if st := ccs.Stats(); st.Misses != 3 {
+		t.Errorf("stats = %+v, want 3 misses", st)
+	}
+}
+
+// digestSource is a memSource with the given digests.
+type digestSource struct {
+	*memSource
+	digests map[string]string
+}
+
+func (ds digestSource) Digest(name string) (string, error) {
+	return ds.digests[name], nil
+}
+
+func TestCachingContentSourceInvalidDigest(t *testing.T) {
+	dir := t.TempDir()
+	src := digestSource{
+		memSource: &memSource{files: map[string]string{"a": "a", "b": "b"}},
+		digests:   map[string]string{"a": "../escaped", "b": "sha256:" + sha256Hex("b")},
+	}
+	ccs, err := NewCachingContentSource(src, filepath.Join(dir, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ccs.Open("a"); err == nil {
+		t.Error("Open with digest ../escaped succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of the cache directory, %v", err)
+	}
+	if got := readAll(t, ccs, "b"); got != "b" {
+		t.Errorf("reading b = %q", got)
+	}
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCachingContentSourceDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := digestSource{
+		memSource: &memSource{files: map[string]string{"a": "truncat"}},
+		digests:   map[string]string{"a": sha256Hex("truncated")},
+	}
+	ccs, err := NewCachingContentSource(src, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ccs.Open("a"); err == nil {
+		t.Error("Open of content not matching its digest succeeded")
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 || ccs.Stats().BytesCached != 0 {
+		t.Errorf("content not matching its digest cached, %d files, %+v", len(fis), ccs.Stats())
+	}
+
+	// Once the source serves the content of the digest, it's cached.
+	src.files["a"] = "truncated"
+	if got := readAll(t, ccs, "a"); got != "truncated" {
+		t.Errorf("reading a = %q", got)
+	}
+}
+
+func TestCachingContentSourceRestart(t *testing.T) {
+	dir := t.TempDir()
+	src := digestSource{
+		memSource: &memSource{files: map[string]string{
+			"a": strings.Repeat("a", 10),
+			"b": strings.Repeat("b", 10),
+		}},
+		digests: map[string]string{
+			"a": sha256Hex(strings.Repeat("a", 10)),
+			"b": sha256Hex(strings.Repeat("b", 10)),
+		},
+	}
+	ccs, err := NewCachingContentSource(src, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, ccs, "a")
+	readAll(t, ccs, "b")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, src.digests["a"]), past, past); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, fetchPrefix+"123"), "partial")
+
+	// A restart with a smaller cap evicts the least recently fetched file,
+	// and serves the other one without fetching it.
+	ccs, err = NewCachingContentSource(src, dir, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := ccs.Stats(); st.BytesCached != 10 {
+		t.Errorf("%d bytes cached after a restart, want 10", st.BytesCached)
+	}
+	if _, err := os.Stat(filepath.Join(dir, src.digests["a"])); !os.IsNotExist(err) {
+		t.Errorf("least recently fetched file not evicted, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fetchPrefix+"123")); !os.IsNotExist(err) {
+		t.Errorf("temporary file of a fetch not removed, %v", err)
+	}
+	opens := src.openCount()
+	if got := readAll(t, ccs, "b"); got != strings.Repeat("b", 10) {
+		t.Errorf("reading b = %q", got)
+	}
+	if st := ccs.Stats(); st.Hits != 1 || src.openCount() != opens {
+		t.Errorf("stats = %+v, %d fetches, want b served from the cache", st, src.openCount()-opens)
+	}
+}
+
+func TestCachingContentSourceDirectories(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{"pb/sub/a.pb.go": "package sub\n"}, nil, nil)
+	gs, err := NewGitSnapshot(repo, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.ContentCacheDir = filepath.Join(t.TempDir(), "cache")
+	gpf, _ := newTestFs(t, cfg)
+	gpf.SetGenfilesSource(gs)
+	ccs := gpf.genSource.(*CachingContentSource)
+
+	for _, dir := range []string{testPrefix + "/pb", testPrefix + "/pb/sub"} {
+		if attr, status := gpf.GetAttr(dir, nil); status != fuse.OK || !attr.IsDir() {
+			t.Errorf("GetAttr(%s) = %v, %v, want a directory", dir, attr, status)
+		}
+	}
+	if names := listNames(t, gpf, testPrefix+"/pb/sub"); !names["a.pb.go"] {
+		t.Errorf("pb/sub lists %v, want a.pb.go", names)
+	}
+	name := testPrefix + "/pb/sub/a.pb.go"
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != 12 {
+		t.Errorf("GetAttr(%s) = %v, %v, want size 12", name, attr, status)
+	}
+	if st := ccs.Stats(); st.Misses != 0 {
+		t.Errorf("stats = %+v, want no fetches for lookups", st)
+	}
+	if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != "package sub\n" {
+		t.Errorf("reading %s = %q, %v", name, got, status)
+	}
+}
+
+func TestContentCacheConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.ContentCacheDir = filepath.Join(t.TempDir(), "cache")
+	cfg.ContentCacheMaxBytes = 1 << 20
+	gpf, _ := newTestFs(t, cfg)
+	src := &memSource{files: map[string]string{"foo/gen.pb.go": "package foo\n"}}
+	gpf.SetGenfilesSource(src)
+
+	ccs, ok := gpf.genSource.(*CachingContentSource)
+	if !ok || ccs.dir != cfg.ContentCacheDir || ccs.maxBytes != 1<<20 {
+		t.Fatalf("genfiles source = %#v, want caching in %s up to 1MiB", gpf.genSource, cfg.ContentCacheDir)
+	}
+	for i := 0; i < 2; i++ {
+		if got, _ := readMountFile(t, gpf, testPrefix+"/foo/gen.pb.go"); got != "package foo\n" {
+			t.Errorf("reading gen.pb.go = %q", got)
+		}
+	}
+	if st := ccs.Stats(); st.Hits == 0 {
+		t.Errorf("stats = %+v, want hits", st)
+	}
+}
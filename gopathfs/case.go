@@ -0,0 +1,72 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// CaseCollision is a set of entries of a served directory whose names differ
+// only in case, and which would collide on a case-insensitive filesystem.
+type CaseCollision struct {
+	Dir   string // Mount path of the directory.
+	Names []string
+}
+
+// ScanCaseCollisions walks the served tree (except GOROOT), warns about names
+// which differ only in case, and returns them. The findings are kept for
+// CaseCollisions.
+func (gpf *GoPathFs) ScanCaseCollisions() []CaseCollision {
+	collisions := []CaseCollision{}
+	gpf.scanCaseCollisions("", &walkVisits{}, &collisions)
+
+	for _, c := range collisions {
+		fmt.Printf("Warning: names differ only in case in %s: %s.\n",
+			filepath.Join(gpf.dirs.SrcDir, c.Dir), strings.Join(c.Names, ", "))
+	}
+
+	gpf.caseMu.Lock()
+	gpf.caseCollisions = collisions
+	gpf.caseMu.Unlock()
+	return collisions
+}
+
+// CaseCollisions returns the findings of the last ScanCaseCollisions, e.g.,
+// for a CI job to fail on them.
+func (gpf *GoPathFs) CaseCollisions() []CaseCollision {
+	gpf.caseMu.Lock()
+	defer gpf.caseMu.Unlock()
+	return gpf.caseCollisions
+}
+
+func (gpf *GoPathFs) scanCaseCollisions(dir string, visits *walkVisits, collisions *[]CaseCollision) {
+	if gpf.isGoRoot(dir) || !visits.enter(gpf, dir) {
+		return
+	}
+
+	entries, status := gpf.scanDir(dir)
+	if status != fuse.OK {
+		return
+	}
+
+	byLower := map[string][]string{}
+	for _, e := range entries {
+		lower := strings.ToLower(e.Name)
+		byLower[lower] = append(byLower[lower], e.Name)
+	}
+	for _, names := range byLower {
+		if len(names) > 1 {
+			sort.Strings(names)
+			*collisions = append(*collisions, CaseCollision{Dir: dir, Names: names})
+		}
+	}
+
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR != 0 {
+			gpf.scanCaseCollisions(filepath.Join(dir, e.Name), visits, collisions)
+		}
+	}
+}
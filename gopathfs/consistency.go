@@ -0,0 +1,117 @@
+package gopathfs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Entries cached more recently are left out of the consistency checks, as
+// the notification of a change may still be on its way.
+var consistencySettleTime = 2 * time.Second
+
+// startConsistencyCheck starts the consistency check on mount, if
+// configured, see reloadConsistencyCheck.
+func (gpf *GoPathFs) startConsistencyCheck() {
+	gpf.consistencyMu.Lock()
+	defer gpf.consistencyMu.Unlock()
+	gpf.consistencyStarted = true
+	gpf.runConsistencyCheck()
+}
+
+// reloadConsistencyCheck restarts the consistency check, if started, with
+// the interval of the current config.
+func (gpf *GoPathFs) reloadConsistencyCheck() {
+	gpf.consistencyMu.Lock()
+	defer gpf.consistencyMu.Unlock()
+	if gpf.consistencyStarted {
+		gpf.runConsistencyCheck()
+	}
+}
+
+// endConsistencyCheck stops the consistency check on unmount.
+func (gpf *GoPathFs) endConsistencyCheck() {
+	gpf.consistencyMu.Lock()
+	defer gpf.consistencyMu.Unlock()
+	gpf.consistencyStarted = false
+	gpf.runConsistencyCheck()
+}
+
+// runConsistencyCheck stops the consistency check running, if any, and
+// starts one with the interval of the current config if started. It's
+// called with consistencyMu held.
+func (gpf *GoPathFs) runConsistencyCheck() {
+	if gpf.stopConsistencyCheck != nil {
+		close(gpf.stopConsistencyCheck)
+		gpf.stopConsistencyCheck = nil
+	}
+	interval := gpf.config().ConsistencyCheckDuration
+	if gpf.consistencyStarted && interval > 0 {
+		gpf.stopConsistencyCheck = make(chan struct{})
+		go gpf.checkConsistency(interval, consistencySettleTime, gpf.stopConsistencyCheck)
+	}
+}
+
+// checkConsistency compares a sample of the cached attributes older than
+// settle with the backing files every interval, until stop is closed. Stale
+// entries are logged, counted and, with consistency-check-heal,
+// invalidated.
+func (gpf *GoPathFs) checkConsistency(interval, settle time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		cfg := gpf.config()
+		sampled := gpf.attrCache.sample(cfg.ConsistencyCheckCount, time.Now().Add(-settle))
+		for name, cached := range sampled {
+			atomic.AddInt64(&gpf.stats.consistencyChecked, 1)
+
+			fresh, status := gpf.peekAttr(name)
+			if status != fuse.OK && status != fuse.ENOENT {
+				continue
+			}
+			if status != fuse.OK {
+				fresh = nil
+			}
+			if sameAttr(cached, fresh) {
+				continue
+			}
+
+			atomic.AddInt64(&gpf.stats.consistencyMismatches, 1)
+			fmt.Printf("Warning, cached attributes of %s are stale: %s, but %s.\n",
+				name, describeAttr(cached), describeAttr(fresh))
+			if cfg.ConsistencyCheckHeal {
+				gpf.attrCache.invalidate(name)
+				if gpf.nodeFs != nil {
+					gpf.nodeFs.Notify(name)
+				}
+			}
+		}
+	}
+}
+
+// sameAttr returns true if the given attributes, nil for nonexistent
+// entries, agree on what's visible to the build.
+func sameAttr(a, b *fuse.Attr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Ino == b.Ino && a.Mode == b.Mode && a.Size == b.Size &&
+		a.Mtime == b.Mtime && a.Mtimensec == b.Mtimensec
+}
+
+func describeAttr(a *fuse.Attr) string {
+	if a == nil {
+		return "nonexistent"
+	}
+	mtime := time.Unix(int64(a.Mtime), int64(a.Mtimensec))
+	return fmt.Sprintf("mode %o, size %d, modified %s", a.Mode, a.Size, mtime.Format(time.RFC3339Nano))
+}
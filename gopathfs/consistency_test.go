@@ -0,0 +1,72 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// candidatesTried returns the number of resolutions counted in the stats.
+func candidatesTried(gpf *GoPathFs) (n int64) {
+	for _, c := range gpf.Stats().CandidatesTried {
+		n += c
+	}
+	return n
+}
+
+func TestConsistencyCheckHeals(t *testing.T) {
+	defer func(settle time.Duration) { consistencySettleTime = settle }(consistencySettleTime)
+	consistencySettleTime = 0
+
+	cfg := cachingConfig()
+	cfg.ConsistencyCheckDuration = 10 * time.Millisecond
+	cfg.ConsistencyCheckHeal = true
+	gpf, ws := newTestFs(t, cfg)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != 12 {
+		t.Fatalf("GetAttr = %+v, %v", attr, status)
+	}
+	// Changed behind the mount's back, e.g., with the notification lost.
+	writeFile(t, backing, "package foo\n\n// More.\n")
+	tried := candidatesTried(gpf)
+
+	gpf.startConsistencyCheck()
+	defer gpf.endConsistencyCheck()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, cached := gpf.attrCache.get(name); cached; _, cached = gpf.attrCache.get(name) {
+		if time.Now().After(deadline) {
+			t.Fatal("stale entry not dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := gpf.Stats().ConsistencyMismatches; n != 1 {
+		t.Errorf("%d mismatches counted, want 1", n)
+	}
+	// The check doesn't resolve paths as lookups do.
+	if n := candidatesTried(gpf); n != tried {
+		t.Errorf("the consistency check counted %d resolutions", n-tried)
+	}
+
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != 22 {
+		t.Errorf("GetAttr after the check = %+v, %v, want size 22", attr, status)
+	}
+
+	// Turned off by a reload.
+	off := *cfg
+	off.ConsistencyCheckDuration = 0
+	if err := gpf.Reload(&off); err != nil {
+		t.Fatal(err)
+	}
+	gpf.GetAttr(name, nil)
+	time.Sleep(20 * time.Millisecond)
+	checked := gpf.Stats().ConsistencyChecked
+	time.Sleep(50 * time.Millisecond)
+	if n := gpf.Stats().ConsistencyChecked; n != checked {
+		t.Errorf("%d entries checked after the check was turned off", n-checked)
+	}
+}
@@ -0,0 +1,291 @@
+package gopathfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// ContentSource serves the content of first-party generated files. By
+// default they are read from the local gen dirs; embedders can plug in a
+// client for a remote content-addressed store instead.
+type ContentSource interface {
+	// Open returns a reader for the given path (relative to the genfiles
+	// root) and its size. It returns an error satisfying os.IsNotExist if
+	// the file doesn't exist.
+	Open(name string) (io.ReaderAt, int64, error)
+}
+
+// DirSource is implemented by content sources which also serve directories.
+type DirSource interface {
+	ContentSource
+
+	// ReadDir lists the given directory. It returns an error satisfying
+	// os.IsNotExist if it isn't a directory.
+	ReadDir(name string) ([]fuse.DirEntry, error)
+}
+
+// errNotSupported is returned by the optional methods of a content source
+// wrapping another one, e.g. ReadDir of a CachingContentSource, if the
+// wrapped source doesn't implement them. Callers then go on as if the method
+// didn't exist.
+var errNotSupported = errors.New("not supported by the content source")
+
+// LocalContentSource is a ContentSource backed by a local directory.
+type LocalContentSource struct {
+	Root string
+}
+
+// NewLocalContentSource returns a ContentSource reading from root.
+func NewLocalContentSource(root string) *LocalContentSource {
+	return &LocalContentSource{Root: root}
+}
+
+// Open implements ContentSource.
+func (lcs *LocalContentSource) Open(name string) (io.ReaderAt, int64, error) {
+	f, err := os.Open(filepath.Join(lcs.Root, name))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// Stat returns the file info of the given file.
+func (lcs *LocalContentSource) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(lcs.Root, name))
+}
+
+// Mode returns the mode of the given file, read-only like the files of other
+// content sources.
+func (lcs *LocalContentSource) Mode(name string) (uint32, error) {
+	fi, err := lcs.Stat(name)
+	switch {
+	case err != nil:
+		return 0, err
+	case fi.IsDir():
+		return fuse.S_IFDIR | 0555, nil
+	case fi.Mode()&0111 != 0:
+		return fuse.S_IFREG | 0555, nil
+	}
+	return fuse.S_IFREG | 0444, nil
+}
+
+// ReadDir implements DirSource. Symbolic links are listed as what they lead
+// to, and skipped if they lead nowhere.
+func (lcs *LocalContentSource) ReadDir(name string) ([]fuse.DirEntry, error) {
+	dir := filepath.Join(lcs.Root, name)
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTDIR) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(des))
+	for _, de := range des {
+		isDir := de.IsDir()
+		if de.Type()&os.ModeSymlink != 0 {
+			fi, err := os.Stat(filepath.Join(dir, de.Name()))
+			if err != nil {
+				continue
+			}
+			isDir = fi.IsDir()
+		}
+		entry := fuse.DirEntry{
+			Name: de.Name(),
+			Mode: fuse.S_IFREG,
+		}
+		if isDir {
+			entry.Mode = fuse.S_IFDIR
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SetGenfilesSource replaces the source of first-party generated files.
+// With content-cache-dir, its files are cached there, see
+// CachingContentSource.
+func (gpf *GoPathFs) SetGenfilesSource(src ContentSource) {
+	if dir := gpf.config().ContentCacheDir; dir != "" && src != nil {
+		ccs, err := NewCachingContentSource(src, dir, gpf.config().ContentCacheMaxBytes)
+		if err != nil {
+			fmt.Printf("Warning, failed to create content-cache-dir %s, not caching, %v.\n", dir, err)
+		} else {
+			src = ccs
+		}
+	}
+	gpf.genSource = src
+}
+
+// isLocalGenfiles returns true if generated files are served from the local
+// gen dirs, in which case they are opened like any other file.
+func (gpf *GoPathFs) isLocalGenfiles() bool {
+	return gpf.genSource == nil
+}
+
+func (gpf *GoPathFs) openContentSourceFile(src ContentSource, name string, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+
+	r, size, err := src.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fuse.ENOENT
+		}
+		fmt.Printf("Failed to open file %s from content source, %v.\n", name, err)
+		if errors.Is(err, syscall.EFBIG) {
+			return nil, fuse.Status(syscall.EFBIG)
+		}
+		return nil, fuse.EIO
+	}
+
+	if gpf.debug {
+		fmt.Printf("Succeeded to open file %s from content source.\n", name)
+	}
+	return &contentSourceFile{
+		File: nodefs.NewDefaultFile(),
+		r:    r,
+		size: size,
+		mode: contentSourceMode(src, name),
+	}, fuse.OK
+}
+
+// sizer is implemented by content sources which know the size of a file
+// without opening it.
+type sizer interface {
+	Size(name string) (int64, error)
+}
+
+// moder is implemented by content sources which know the type and
+// permissions of a file, e.g., its exec bit, as fuse.Attr.Mode. Files of
+// other sources are served read-only with mode 0444.
+type moder interface {
+	Mode(name string) (uint32, error)
+}
+
+// contentSourceMode returns the mode of the given file of a content source,
+// known to exist.
+func contentSourceMode(src ContentSource, name string) uint32 {
+	if m, ok := src.(moder); ok {
+		if mode, err := m.Mode(name); err == nil {
+			return mode
+		}
+	}
+	return fuse.S_IFREG | 0444
+}
+
+func (gpf *GoPathFs) getContentSourceAttr(src ContentSource, name string) (*fuse.Attr, fuse.Status) {
+	knowsFile := false
+	if m, ok := src.(moder); ok {
+		mode, err := m.Mode(name)
+		switch {
+		case err == errNotSupported:
+		case err != nil:
+			return nil, fuse.ENOENT
+		case mode&fuse.S_IFDIR != 0:
+			return &fuse.Attr{Mode: mode}, fuse.OK
+		default:
+			knowsFile = true
+		}
+	}
+	if ds, ok := src.(DirSource); ok && !knowsFile {
+		if _, err := ds.ReadDir(name); err == nil {
+			return &fuse.Attr{
+				Mode: fuse.S_IFDIR | 0555,
+			}, fuse.OK
+		}
+	}
+	if s, ok := src.(sizer); ok {
+		size, err := s.Size(name)
+		if err == nil {
+			return &fuse.Attr{
+				Mode: contentSourceMode(src, name),
+				Size: uint64(size),
+			}, fuse.OK
+		}
+		if err != errNotSupported {
+			return nil, fuse.ENOENT
+		}
+	}
+
+	r, size, err := src.Open(name)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+
+	return &fuse.Attr{
+		Mode: contentSourceMode(src, name),
+		Size: uint64(size),
+	}, fuse.OK
+}
+
+// contentSourceFile is a read-only file served from a ContentSource.
+type contentSourceFile struct {
+	nodefs.File
+	r    io.ReaderAt
+	size int64
+	mode uint32 // Defaults to fuse.S_IFREG | 0444.
+
+	// If set, the attributes reported, but for the size, e.g., those of the
+	// backing file of normalized content, as GetAttr reports them.
+	attr *fuse.Attr
+}
+
+func (f *contentSourceFile) String() string {
+	return fmt.Sprintf("contentSourceFile(%d)", f.size)
+}
+
+// Read fills dest up to the end of the file, since the kernel takes a short
+// read for the end of the file. Content sources may return short reads,
+// e.g., of one chunk of a large remote file at a time.
+func (f *contentSourceFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	n := 0
+	for n < len(dest) {
+		m, err := f.r.ReadAt(dest[n:], off+int64(n))
+		n += m
+		if err == io.EOF || err == nil && m == 0 {
+			break
+		}
+		if err != nil {
+			return nil, fuse.EIO
+		}
+	}
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+func (f *contentSourceFile) GetAttr(out *fuse.Attr) fuse.Status {
+	if f.attr != nil {
+		*out = *f.attr
+		out.Size = uint64(f.size)
+		return fuse.OK
+	}
+	out.Mode = f.mode
+	if out.Mode == 0 {
+		out.Mode = fuse.S_IFREG | 0444
+	}
+	out.Size = uint64(f.size)
+	return fuse.OK
+}
+
+func (f *contentSourceFile) Release() {
+	if c, ok := f.r.(io.Closer); ok {
+		c.Close()
+	}
+}
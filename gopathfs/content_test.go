@@ -0,0 +1,105 @@
+package gopathfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// chunkedSource is a ContentSource whose readers return at most chunk bytes
+// per ReadAt, without an error, like a network-backed reader may.
+type chunkedSource struct {
+	*memSource
+	chunk int
+}
+
+func (cs chunkedSource) Open(name string) (io.ReaderAt, int64, error) {
+	r, size, err := cs.memSource.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunkedReader{r, cs.chunk}, size, nil
+}
+
+type chunkedReader struct {
+	r     io.ReaderAt
+	chunk int
+}
+
+func (cr chunkedReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > cr.chunk {
+		p = p[:cr.chunk]
+	}
+	n, err := cr.r.ReadAt(p, off)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// TestReadsLargerThanMaxRead reads files larger than the default max read
+// size of 128KiB in one request each.
+func TestReadsLargerThanMaxRead(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	data := strings.Repeat("0123456789abcdef", 1<<16) // 1MiB.
+	writeFile(t, filepath.Join(ws, "foo", "large.go"), data)
+	gpf.SetGenfilesSource(chunkedSource{&memSource{files: map[string]string{"foo/large.pb.go": data}}, 4096})
+
+	for _, name := range []string{testPrefix + "/foo/large.go", testPrefix + "/foo/large.pb.go"} {
+		f, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+		if status != fuse.OK {
+			t.Fatalf("Open(%s) = %v", name, status)
+		}
+		buf := make([]byte, len(data)+1)
+		res, status := f.Read(buf, 0)
+		if status != fuse.OK {
+			t.Fatalf("Read(%s) = %v", name, status)
+		}
+		got, _ := res.Bytes(buf)
+		if !bytes.Equal(got, []byte(data)) {
+			t.Errorf("reading %s in one request returned %d bytes, want all %d", name, len(got), len(data))
+		}
+		f.Release()
+	}
+}
+
+func TestLocalContentSourceNestedPackage(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "pb", "a.pb.go"), "package pb\n")
+	writeFile(t, filepath.Join(root, "pb", "sub", "b.pb.go"), "package sub\n")
+	writeFile(t, filepath.Join(root, "pb", "sub", "gen.sh"), "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(root, "pb", "sub", "gen.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gpf.SetGenfilesSource(NewLocalContentSource(root))
+
+	for name, want := range map[string]uint32{
+		testPrefix + "/pb":             fuse.S_IFDIR | 0555,
+		testPrefix + "/pb/sub":         fuse.S_IFDIR | 0555,
+		testPrefix + "/pb/a.pb.go":     fuse.S_IFREG | 0444,
+		testPrefix + "/pb/sub/gen.sh":  fuse.S_IFREG | 0555,
+		testPrefix + "/pb/sub/b.pb.go": fuse.S_IFREG | 0444,
+	} {
+		if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Mode != want {
+			t.Errorf("GetAttr(%s) = %v, %v, want mode %o", name, attr, status, want)
+		}
+	}
+	if names := listNames(t, gpf, testPrefix+"/pb"); !names["a.pb.go"] || !names["sub"] {
+		t.Errorf("pb lists %v, want a.pb.go and sub", names)
+	}
+	if names := listNames(t, gpf, testPrefix+"/pb/sub"); len(names) != 2 || !names["b.pb.go"] || !names["gen.sh"] {
+		t.Errorf("pb/sub lists %v, want b.pb.go and gen.sh", names)
+	}
+	if got, status := readMountFile(t, gpf, testPrefix+"/pb/sub/b.pb.go"); status != fuse.OK || got != "package sub\n" {
+		t.Errorf("reading pb/sub/b.pb.go = %q, %v", got, status)
+	}
+	if _, status := gpf.OpenDir(testPrefix+"/pb/a.pb.go", nil); status == fuse.OK {
+		t.Errorf("OpenDir of a file succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+package gopathfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkSupport caches, by device, whether the backing filesystem supports
+// reflinks (e.g., Btrfs or XFS).
+var reflinkSupport sync.Map // Of uint64 to bool.
+
+// moveFile moves the regular file src to dst across filesystems, which
+// os.Rename can't, by copying it to a new temporary file next to dst,
+// renaming the copy onto dst and removing src. The copy is a copy-on-write
+// clone when the backing filesystem supports it.
+func moveFile(dst, src string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return unix.EXDEV
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".gobazel-move-*")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	err = out.Chmod(fi.Mode().Perm())
+	if err == nil {
+		err = cloneOrCopy(out, in)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func cloneOrCopy(out, in *os.File) error {
+	st := unix.Stat_t{}
+	if err := unix.Fstat(int(out.Fd()), &st); err != nil {
+		return err
+	}
+	dev := uint64(st.Dev)
+
+	if ok, known := reflinkSupport.Load(dev); !known || ok.(bool) {
+		err := cloneFile(out, in)
+		if err == nil {
+			reflinkSupport.Store(dev, true)
+			return nil
+		}
+		// EXDEV only tells about this pair of files.
+		if err != unix.EXDEV {
+			reflinkSupport.Store(dev, false)
+		}
+	}
+
+	_, err := io.Copy(out, in)
+	return err
+}
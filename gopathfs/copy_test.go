@@ -0,0 +1,124 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.go"), filepath.Join(dir, "dst.go")
+	writeFile(t, src, "package foo\n")
+	if err := os.Chmod(src, 0751); err != nil {
+		t.Fatal(err)
+	}
+	// A leftover of a move by an earlier process with the same pid.
+	writeFile(t, dst+".gobazel-move-"+fmt.Sprint(os.Getpid()), "stale")
+
+	if err := moveFile(dst, src); err != nil {
+		t.Fatalf("moveFile = %v", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "package foo\n" {
+		t.Errorf("moved file = %q, %v", data, err)
+	}
+	if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0751 {
+		t.Errorf("moved file mode = %v, %v, want 0751", fi.Mode(), err)
+	}
+	if _, err := os.Lstat(src); !os.IsNotExist(err) {
+		t.Errorf("source left behind, %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d files after the move, want dst.go and the leftover", len(entries))
+	}
+}
+
+func TestRenameAcrossFilesystemsOfOpenFile(t *testing.T) {
+	shm, err := os.MkdirTemp("/dev/shm", "gobazel-test-")
+	if err != nil {
+		t.Skip("no /dev/shm")
+	}
+	defer os.RemoveAll(shm)
+
+	gpf, ws := newTestFs(t, nil)
+	st1, st2 := unix.Stat_t{}, unix.Stat_t{}
+	if unix.Stat(ws, &st1) != nil || unix.Stat(shm, &st2) != nil || st1.Dev == st2.Dev {
+		t.Skip("/dev/shm is on the same filesystem as the workspace")
+	}
+	if err := os.Remove(filepath.Join(ws, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(shm, filepath.Join(ws, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+	gpf.FlushCaches()
+	writeFile(t, filepath.Join(shm, "github.com", "y", "a.go"), "package y\n")
+	if err := os.Mkdir(filepath.Join(ws, "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, status := gpf.Open("github.com/y/a.go", uint32(os.O_WRONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	if status := gpf.Rename("github.com/y/a.go", testPrefix+"/foo/a.go", nil); status != fuse.Status(syscall.EBUSY) {
+		t.Errorf("Rename of a file open for writing = %v, want EBUSY", status)
+	}
+	f.Release()
+
+	if status := gpf.Rename("github.com/y/a.go", testPrefix+"/foo/a.go", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	if data, err := os.ReadFile(filepath.Join(ws, "foo", "a.go")); err != nil || string(data) != "package y\n" {
+		t.Errorf("moved file = %q, %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(shm, "github.com", "y", "a.go")); !os.IsNotExist(err) {
+		t.Errorf("source left behind, %v", err)
+	}
+}
+
+// BenchmarkCopyLargeFile compares a copy-on-write clone of a large generated
+// file with a plain copy. Clones are skipped where the temporary directory's
+// filesystem doesn't support reflinks.
+func BenchmarkCopyLargeFile(b *testing.B) {
+	dir := b.TempDir()
+	src := filepath.Join(dir, "large.pb.go")
+	writeFile(b, src, strings.Repeat("x", 64<<20))
+
+	copyWith := func(b *testing.B, copyFile func(out, in *os.File) error) {
+		b.SetBytes(64 << 20)
+		for i := 0; i < b.N; i++ {
+			in, err := os.Open(src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			out, err := os.Create(filepath.Join(dir, "copy.pb.go"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = copyFile(out, in)
+			in.Close()
+			out.Close()
+			if err != nil {
+				b.Skip("can't copy with it here,", err)
+			}
+		}
+	}
+	b.Run("reflink", func(b *testing.B) {
+		copyWith(b, cloneFile)
+	})
+	b.Run("plain", func(b *testing.B) {
+		copyWith(b, func(out, in *os.File) error {
+			_, err := io.Copy(out, in)
+			return err
+		})
+	})
+}
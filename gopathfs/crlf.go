@@ -0,0 +1,126 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// normalizesCRLF returns true if files at the given mount path are read with
+// CRLF line endings turned into LF, i.e., if its base name matches one of
+// the normalize-crlf patterns.
+func (gpf *GoPathFs) normalizesCRLF(name string) bool {
+	base := filepath.Base(name)
+	for _, p := range gpf.config().NormalizeCRLF {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries beyond which the crlfCache starts over.
+const maxCRLFCached = 10000
+
+// crlfCache keeps the normalized content of files served with normalized
+// line endings by backing path, until they change, so that neither GetAttr
+// nor Open reads them again.
+type crlfCache struct {
+	mu      sync.Mutex
+	entries map[string]crlfCacheEntry
+}
+
+type crlfCacheEntry struct {
+	ino   uint64
+	mtime time.Time
+	size  int64
+	data  []byte // nil if served as is.
+}
+
+func (cc *crlfCache) clear() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.entries = nil
+}
+
+// readNormalized returns the content of the given backing file with CRLF
+// turned into LF. It returns false if the file can't be read, looks binary
+// (i.e., has a NUL byte), or has no CRLF, in which case it's served as is.
+func (gpf *GoPathFs) readNormalized(path string) ([]byte, bool) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, false
+	}
+	var ino uint64
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		ino = uint64(st.Ino)
+	}
+
+	cc := &gpf.crlfs
+	cc.mu.Lock()
+	e, ok := cc.entries[path]
+	cc.mu.Unlock()
+	if ok && e.ino == ino && e.mtime.Equal(fi.ModTime()) && e.size == fi.Size() {
+		return e.data, e.data != nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !bytes.Contains(data, []byte("\r\n")) {
+		data = nil
+	} else {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.entries == nil || len(cc.entries) >= maxCRLFCached {
+		cc.entries = map[string]crlfCacheEntry{}
+	}
+	cc.entries[path] = crlfCacheEntry{ino: ino, mtime: fi.ModTime(), size: fi.Size(), data: data}
+	return data, data != nil
+}
+
+// openNormalizedFile opens the given backing file read-only with normalized
+// line endings, see readNormalized. It reports the same attributes as
+// GetAttr does for its mount path.
+func (gpf *GoPathFs) openNormalizedFile(path string) (nodefs.File, bool) {
+	data, ok := gpf.readNormalized(path)
+	if !ok {
+		return nil, false
+	}
+	attr, status := gpf.getRealDirAttr(path)
+	if status != fuse.OK {
+		return nil, false
+	}
+	gpf.mapOwner(attr)
+	if gpf.debug {
+		fmt.Printf("Serving file %s with normalized line endings.\n", path)
+	}
+	return &contentSourceFile{
+		File: nodefs.NewDefaultFile(),
+		r:    bytes.NewReader(data),
+		size: int64(len(data)),
+		attr: attr,
+	}, true
+}
+
+// normalizeAttr sets the size of the given attributes of a backing file to
+// the size of its normalized content, if it's served normalized.
+func (gpf *GoPathFs) normalizeAttr(name, path string, attr *fuse.Attr) {
+	if attr.Mode&fuse.S_IFREG == 0 || !gpf.normalizesCRLF(name) {
+		return
+	}
+	if data, ok := gpf.readNormalized(path); ok {
+		attr.Size = uint64(len(data))
+	}
+}
@@ -0,0 +1,89 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestNormalizedCRLFAttrs(t *testing.T) {
+	cfg := testConfig()
+	cfg.NormalizeCRLF = []string{"*.go"}
+	gpf, ws := newTestFs(t, cfg)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\r\n\r\nvar x = 1\r\n")
+	if err := os.Chmod(backing, 0640); err != nil {
+		t.Fatal(err)
+	}
+	name := testPrefix + "/foo/a.go"
+	want := "package foo\n\nvar x = 1\n"
+
+	attr, status := gpf.GetAttr(name, nil)
+	if status != fuse.OK || attr.Size != uint64(len(want)) {
+		t.Fatalf("GetAttr = %+v, %v, want size %d", attr, status, len(want))
+	}
+	if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != want {
+		t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+	}
+
+	// An open file reports what GetAttr reports for its path.
+	f, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	out := fuse.Attr{}
+	if status := f.GetAttr(&out); status != fuse.OK {
+		t.Fatalf("File.GetAttr = %v", status)
+	}
+	if out.Mode != attr.Mode || out.Size != attr.Size || out.Mtime != attr.Mtime || out.Uid != attr.Uid {
+		t.Errorf("File.GetAttr = %+v, want %+v", out, *attr)
+	}
+}
+
+func TestNormalizedCRLFCached(t *testing.T) {
+	cfg := testConfig()
+	cfg.NormalizeCRLF = []string{"*.go"}
+	gpf, ws := newTestFs(t, cfg)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\r\n")
+	name := testPrefix + "/foo/a.go"
+	mtime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(backing, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := readMountFile(t, gpf, name); got != "package foo\n" {
+		t.Fatalf("reading %s = %q", name, got)
+	}
+
+	// Unchanged inode, mtime and size: served from the cache, not reread.
+	writeFile(t, backing, "package bar\r\n")
+	if err := os.Chtimes(backing, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readMountFile(t, gpf, name); got != "package foo\n" {
+		t.Errorf("reading an unchanged file = %q, want the cached content", got)
+	}
+
+	// A changed mtime is picked up.
+	mtime = mtime.Add(time.Minute)
+	if err := os.Chtimes(backing, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readMountFile(t, gpf, name); got != "package bar\n" {
+		t.Errorf("reading a changed file = %q, want %q", got, "package bar\n")
+	}
+
+	// Files without CRLF are served as is, and cached as such.
+	writeFile(t, backing, "package baz\n")
+	if got, _ := readMountFile(t, gpf, name); got != "package baz\n" {
+		t.Errorf("reading a file without CRLF = %q", got)
+	}
+	if e := gpf.crlfs.entries[backing]; e.data != nil {
+		t.Errorf("cached %q for a file without CRLF, want nil", e.data)
+	}
+}
@@ -0,0 +1,114 @@
+package gopathfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// ctlFileName is the control file in the mount root. Commands written to it
+// are answered with a JSON document read back from the same file handle.
+// FUSE ioctls would be the natural fit, but the path filesystem API answers
+// them with ENOSYS.
+//
+// Commands:
+//
+//	flush     drops all caches, like SIGUSR1. Answers {"ok": true}.
+//	stats     answers the Stats counters and the number of cached attributes.
+//	ops       answers the most recent operations, oldest first.
+//	config    answers the config in use.
+//	files     answers the workspace files open through the mount.
+//	validate  starts validating the served trees in the background, unless
+//	          it runs, and answers the ValidationStatus.
+//	report    answers the ValidationStatus, with the last ValidationReport.
+//
+// Unknown commands are answered with {"error": "..."}.
+const ctlFileName = ".gobazel-ctl"
+
+func (gpf *GoPathFs) getCtlFileAttr() (*fuse.Attr, fuse.Status) {
+	return &fuse.Attr{
+		Mode: fuse.S_IFREG | 0600,
+	}, fuse.OK
+}
+
+func (gpf *GoPathFs) openCtlFile() (nodefs.File, fuse.Status) {
+	// Results aren't known to the kernel's page cache, bypass it.
+	return &nodefs.WithFlags{
+		File:      &ctlFile{File: nodefs.NewDefaultFile(), gpf: gpf},
+		FuseFlags: fuse.FOPEN_DIRECT_IO | fuse.FOPEN_NONSEEKABLE,
+	}, fuse.OK
+}
+
+func (gpf *GoPathFs) runCtlCommand(cmd string) interface{} {
+	switch cmd {
+	case "flush":
+		gpf.FlushCaches()
+		return map[string]bool{"ok": true}
+	case "stats":
+		return struct {
+			Stats
+			CachedAttrs int
+		}{gpf.Stats(), gpf.attrCache.len()}
+	case "ops":
+		return gpf.RecentOps()
+	case "config":
+		return gpf.config()
+	case "files":
+		return gpf.OpenFiles()
+	case "validate":
+		return gpf.startValidation()
+	case "report":
+		return gpf.validationStatus()
+	}
+	return map[string]string{"error": fmt.Sprintf("unknown command \"%s\"", cmd)}
+}
+
+// ctlFile is an open handle of the control file. Reads consume the answer
+// to the last command written, like a pipe.
+type ctlFile struct {
+	nodefs.File
+	gpf *GoPathFs
+
+	mu  sync.Mutex
+	out []byte
+}
+
+func (cf *ctlFile) String() string {
+	return "ctlFile"
+}
+
+func (cf *ctlFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	cmd := strings.TrimSpace(string(data))
+	out, err := json.MarshalIndent(cf.gpf.runCtlCommand(cmd), "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode the result of control command %s, %v.\n", cmd, err)
+		return 0, fuse.EIO
+	}
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	cf.out = append(out, '\n')
+	return uint32(len(data)), fuse.OK
+}
+
+func (cf *ctlFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	n := copy(dest, cf.out)
+	cf.out = cf.out[n:]
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+func (cf *ctlFile) Truncate(size uint64) fuse.Status {
+	return fuse.OK
+}
+
+func (cf *ctlFile) GetAttr(out *fuse.Attr) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0600
+	return fuse.OK
+}
@@ -0,0 +1,27 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxDepth(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxDepthLimit = 2
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "a", "b", "c", "d.go"), "package c\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "z", "e.go"), "package z\n")
+
+	for dir, want := range map[string]string{
+		testPrefix:            "a",
+		testPrefix + "/a":     "b",
+		testPrefix + "/a/b":   "c",
+		testPrefix + "/a/b/c": "", // Beyond the limit.
+		"github.com/y/z":      "e.go",
+	} {
+		names := listNames(t, gpf, dir)
+		if want == "" && len(names) != 0 || want != "" && (len(names) != 1 || !names[want]) {
+			t.Errorf("listing %s = %v, want %q", dir, names, want)
+		}
+	}
+}
@@ -5,67 +5,230 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
 )
 
-// OpenDir overwrites the parent's OpenDir method.
-func (gpf *GoPathFs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+// OpenDir overwrites the parent's OpenDir method. go-fuse calls it when a
+// directory is opened, and again on rewinddir, and keeps the listing as the
+// snapshot the handle's paged reads are served from, so that continuation
+// offsets stay consistent while the directory changes. The listing must
+// therefore be a fresh slice, not shared with a cache or another handle.
+func (gpf *GoPathFs) OpenDir(name string, context *fuse.Context) (entries []fuse.DirEntry, code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("opendir", name, time.Now(), &code, nil)
+
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
+	}
+	return gpf.listDir(name)
+}
+
+// listDir lists the given mount directory as OpenDir serves it, without
+// accounting for an operation, e.g., for background walks.
+func (gpf *GoPathFs) listDir(name string) (entries []fuse.DirEntry, code fuse.Status) {
+	if gpf.beyondMaxDepth(name) {
+		return []fuse.DirEntry{}, fuse.OK
+	}
+
+	entries, code = gpf.openDir(name)
+	if gpf.servesModulesTxtDir(name) {
+		if code == fuse.ENOENT {
+			entries, code = []fuse.DirEntry{}, fuse.OK
+		}
+		if code == fuse.OK {
+			l := newListing(entries)
+			gpf.mergeEntry(l, fuse.DirEntry{Name: modulesTxtName, Mode: fuse.S_IFREG}, filepath.Join(name, modulesTxtName))
+			entries = l.entries
+		}
+	}
+	if code != fuse.OK {
+		return entries, code
+	}
+	entries = gpf.withoutModFiles(name, entries)
+	entries = gpf.withoutExcludedFiles(name, entries)
+	if extra := gpf.metadataEntries(name); len(extra) > 0 {
+		l := newListing(entries)
+		for _, e := range extra {
+			gpf.mergeEntry(l, e, filepath.Join(name, e.Name))
+		}
+		entries = l.entries
+	}
+	if gpf.synthesizesDocGo(name, entries) {
+		entries = append(entries, fuse.DirEntry{Name: docGoName, Mode: fuse.S_IFREG})
+	}
+	return entries, code
+}
+
+// beyondMaxDepth returns true if the given mount directory is nested deeper
+// below the prefix than max-depth, so that it's listed as empty.
+func (gpf *GoPathFs) beyondMaxDepth(name string) bool {
+	limit := gpf.config().MaxDepthLimit
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if limit <= 0 || !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	return strings.Count(name[len(prefix):], pathSeparator)+1 > limit
+}
+
+// openDir lists the given mount directory.
+func (gpf *GoPathFs) openDir(name string) (entries []fuse.DirEntry, code fuse.Status) {
 	if name == "" {
 		return gpf.openTopDir()
 	}
 
-	if name == gpf.cfg.GoPkgPrefix {
+	if name == gpf.config().GoPkgPrefix {
 		return gpf.openFirstPartyDir()
 	}
 
-	if strings.HasPrefix(name, gpf.cfg.GoPkgPrefix+pathSeparator) {
-		return gpf.openFirstPartyChildDir(name)
+	if isIntrospectPath(name) {
+		return gpf.openIntrospectDir(name)
 	}
 
-	entries := []fuse.DirEntry{}
-	var status fuse.Status
-
-	// Search in fall-through directories.
-	for _, dir := range gpf.cfg.FallThrough {
-		if dir == name || strings.HasPrefix(name, dir) {
-			fname := filepath.Join(gpf.dirs.Workspace, name)
-			entries, status = gpf.openUnderlyingDir(fname, nil /* excludes */, entries)
-			if status == fuse.OK {
-				return entries, fuse.OK
+	if gpf.servesBazelMeta(name) {
+		return gpf.openBazelMetaDir(name)
+	}
+
+	// Merge the listings of first-party, fall-through and vendor
+	// directories, in the resolution order.
+	l := newListing(nil)
+	found := false
+	for _, c := range gpf.candidates(name) {
+		if c.src != nil {
+			// Most content sources serve files only.
+			if ds, ok := c.src.(DirSource); ok {
+				if list, err := ds.ReadDir(c.rel); err == nil {
+					for _, e := range list {
+						gpf.mergeEntry(l, e, filepath.Join(c.rel, e.Name))
+					}
+					found = true
+				}
 			}
-			fmt.Printf("failed to open entry %s\n", fname)
-			return nil, fuse.ENOENT
+			continue
+		}
+
+		excludes := gpf.config().FallThroughSet
+		if c.kind == KindFallThrough || c.kind == KindGoRoot {
+			excludes = nil
+		}
+
+		if gpf.mergeUnderlyingDir(c.path, c.kind, excludes, l) == fuse.OK {
+			found = true
+		}
+
+		if c.kind == KindGoRoot {
+			l = newListing(gpf.filterGoRootEntries(c.rel, l.entries))
 		}
 	}
+	if len(gpf.config().DecompressGz) > 0 {
+		l = newListing(gpf.renameGzipped(l.entries))
+	}
+	for _, child := range gpf.overlayChildren(name) {
+		gpf.mergeEntry(l, fuse.DirEntry{Name: child, Mode: fuse.S_IFDIR}, filepath.Join(name, child))
+		found = true
+	}
 
-	// Search in vendor directories.
-	for _, vendor := range gpf.cfg.Vendors {
-		entries, status = gpf.openVendorChildDir(vendor, name, entries)
-		if status == fuse.OK {
-			return entries, fuse.OK
+	if !found {
+		// The directory may be recreated by a concurrent build.
+		if gpf.inGrace(name) {
+			return l.entries, fuse.OK
 		}
+
+		if gpf.debug {
+			fmt.Printf("failed to open entry %s\n", name)
+		}
+		return nil, fuse.ENOENT
 	}
 
-	return nil, fuse.ENOENT
+	gpf.rememberDir(name)
+	return l.entries, fuse.OK
 }
 
 // Mkdir overwrites the parent's Mkdir method.
-func (gpf *GoPathFs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
-	prefix := gpf.cfg.GoPkgPrefix + pathSeparator
+func (gpf *GoPathFs) Mkdir(name string, mode uint32, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	gpf.ops.record("mkdir", name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("mkdir", name, time.Now(), &code, nil)
+	defer gpf.audit(AuditRecord{Op: "mkdir", Name: name, Mode: mode}, context, &code)
+
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
+	}
+
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	defer gpf.attrCache.invalidate(name)
+	defer gpf.docGos.forget(name)
+	// Parent directories may be created too.
+	defer gpf.roots.clear()
+
+	if path, _, ok := gpf.customResolve(name); ok {
+		return mkUnderlyingDir(path, mode)
+	}
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
 	if strings.HasPrefix(name, prefix) {
 		return gpf.mkFirstPartyChildDir(name[len(prefix):], mode, context)
 	}
+	if gpf.isFallThrough(name) {
+		return gpf.mkFirstPartyChildDir(name, mode, context)
+	}
 
 	return gpf.mkThirdPartyChildDir(name, mode, context)
 }
 
 // Rmdir overwrites the parent's Rmdir method.
-func (gpf *GoPathFs) Rmdir(name string, context *fuse.Context) fuse.Status {
-	prefix := gpf.cfg.GoPkgPrefix + pathSeparator
+func (gpf *GoPathFs) Rmdir(name string, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	gpf.ops.record("rmdir", name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("rmdir", name, time.Now(), &code, nil)
+	defer gpf.audit(AuditRecord{Op: "rmdir", Name: name, BackingPath: gpf.auditedPath(name)}, context, &code)
+
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
+	}
+
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	defer gpf.attrCache.invalidate(name)
+	defer gpf.docGos.forget(name)
+	defer func() {
+		if code == fuse.OK {
+			gpf.forgetDir(name)
+		}
+	}()
+
+	if path, _, ok := gpf.customResolve(name); ok {
+		return rmUnderlyingDir(path)
+	}
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
 	if strings.HasPrefix(name, prefix) {
 		return gpf.rmFirstPartyChildDir(name[len(prefix):], context)
 	}
+	if gpf.isFallThrough(name) {
+		return gpf.rmFirstPartyChildDir(name, context)
+	}
 
 	return gpf.rmThirdPartyChildDir(name, context)
 }
@@ -73,19 +236,22 @@ func (gpf *GoPathFs) Rmdir(name string, context *fuse.Context) fuse.Status {
 func (gpf *GoPathFs) openTopDir() ([]fuse.DirEntry, fuse.Status) {
 	entries := []fuse.DirEntry{
 		{
-			Name: gpf.cfg.GoPkgPrefix,
+			Name: gpf.config().GoPkgPrefix,
 			Mode: fuse.S_IFDIR,
 		},
 	}
-
-	// Vendor directories.
-	for _, vendor := range gpf.cfg.Vendors {
-		entries, _ = gpf.openUnderlyingDir(filepath.Join(gpf.dirs.Workspace, vendor), gpf.cfg.FallThroughSet /* excludes */, entries)
+	if gpf.debug {
+		entries = append(entries, fuse.DirEntry{Name: introspectDirName, Mode: fuse.S_IFDIR})
+	}
+	if gpf.config().ServeBazelMetadata {
+		entries = append(entries, fuse.DirEntry{Name: bazelMetaDirName, Mode: fuse.S_IFDIR})
 	}
 
-	// Fall-through directories.
-	for _, dir := range gpf.cfg.FallThrough {
-		dir = filepath.Join(gpf.dirs.Workspace, dir)
+	// Fall-through directories, which take precedence over vendor
+	// directories in the resolution order.
+	l := newListing(entries)
+	for _, dir := range gpf.config().FallThrough {
+		dir = filepath.Join(gpf.workspace(), dir)
 		fi, err := os.Stat(dir)
 		if err != nil {
 			fmt.Printf("Failed to access %s, %v", dir, err)
@@ -99,141 +265,253 @@ func (gpf *GoPathFs) openTopDir() ([]fuse.DirEntry, fuse.Status) {
 		if fi.IsDir() {
 			entry.Mode = fuse.S_IFDIR
 		}
-		entries = append(entries, entry)
+		gpf.mergeEntry(l, entry, dir)
 	}
 
-	return entries, fuse.OK
+	for _, vendor := range gpf.vendors() {
+		gpf.mergeUnderlyingDir(vendor.root, KindVendor, gpf.config().FallThroughSet /* excludes */, l)
+	}
+
+	return l.entries, fuse.OK
 }
 
 func (gpf *GoPathFs) openFirstPartyDir() ([]fuse.DirEntry, fuse.Status) {
-	h, err := os.Open(gpf.dirs.Workspace)
-	if err != nil {
-		return nil, fuse.ENOENT
-	}
-	defer h.Close()
-
-	fis, err := h.Readdir(-1)
+	list, err := gpf.readFirstPartyRoot()
 	if err != nil {
 		return nil, fuse.ENOENT
 	}
 
 	entries := []fuse.DirEntry{}
-	for _, fi := range fis {
-		if gpf.isIgnored(fi.Name()) {
+	for _, e := range list {
+		if gpf.isIgnored(e.Name) {
 			continue
 		}
 
-		if gpf.isVendorDir(fi.Name()) {
+		if gpf.isVendorDir(e.Name) {
 			continue
 		}
 
-		if fi.IsDir() {
-			entry := fuse.DirEntry{
-				Name: fi.Name(),
-				Mode: fuse.S_IFREG,
-			}
-			entry.Mode = fuse.S_IFDIR
-			entries = append(entries, entry)
+		if e.Mode&fuse.S_IFDIR != 0 {
+			entries = append(entries, e)
+			continue
+		}
+
+		// Expose the module definition so that Go tools in module mode
+		// find the module root.
+		if _, ok := moduleFiles[e.Name]; ok {
+			entries = append(entries, e)
 		}
 	}
 
-	return entries, fuse.OK
+	l := newListing(entries)
+	for _, child := range gpf.overlayChildren(gpf.config().GoPkgPrefix) {
+		gpf.mergeEntry(l, fuse.DirEntry{Name: child, Mode: fuse.S_IFDIR}, child)
+	}
+	return l.entries, fuse.OK
 }
 
-func (gpf *GoPathFs) openFirstPartyChildDir(name string) ([]fuse.DirEntry, fuse.Status) {
-	name = name[len(gpf.cfg.GoPkgPrefix+pathSeparator):]
-	entries := []fuse.DirEntry{}
+// readFirstPartyRoot lists the workspace, or the git revision it's served
+// from.
+func (gpf *GoPathFs) readFirstPartyRoot() ([]fuse.DirEntry, error) {
+	if gpf.snapshot != nil {
+		return gpf.snapshot.ReadDir("")
+	}
+
+	h, err := os.Open(gpf.workspace())
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
 
-	// Search in GOROOT (for debugger).
-	if name == "GOROOT" || strings.HasPrefix(name, "GOROOT"+pathSeparator) {
-		fname := filepath.Join(gpf.dirs.GoSDKDir, name[len("GOROOT"):])
-		entries, status := gpf.openUnderlyingDir(fname, nil /* excludes */, entries)
-		if status == fuse.OK {
-			return entries, fuse.OK
+	fis, err := h.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]fuse.DirEntry, 0, len(fis))
+	for _, fi := range fis {
+		if entry, ok := gpf.direntOf(gpf.workspace(), fi); ok {
+			list = append(list, entry)
 		}
-		fmt.Printf("failed to open entry %s\n", fname)
-		return nil, fuse.ENOENT
 	}
+	return list, nil
+}
 
-	entries, _ = gpf.openUnderlyingDir(filepath.Join(gpf.dirs.Workspace, name), gpf.cfg.FallThroughSet /* excludes */, entries)
-	// Also search in bazel-genfiles.
-	entries, _ = gpf.openUnderlyingDir(filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", name), gpf.cfg.FallThroughSet /* excludes */, entries)
+// filterGoRootEntries drops the entries of the given GOROOT directory which
+// are not in the configured goroot-subtrees.
+func (gpf *GoPathFs) filterGoRootEntries(dir string, entries []fuse.DirEntry) []fuse.DirEntry {
+	filtered := entries[:0]
+	for _, e := range entries {
+		if gpf.goRootAllowed(filepath.Join(dir, e.Name)) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
 
-	return entries, fuse.OK
+// listedNames returns the set of names in the given listing.
+func listedNames(entries []fuse.DirEntry) map[string]struct{} {
+	listed := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		listed[e.Name] = struct{}{}
+	}
+	return listed
 }
 
-func (gpf *GoPathFs) openVendorChildDir(vendor, name string, entries []fuse.DirEntry) ([]fuse.DirEntry, fuse.Status) {
-	entries, _ = gpf.openUnderlyingDir(filepath.Join(gpf.dirs.Workspace, vendor, name), gpf.cfg.FallThroughSet /* excludes */, entries)
-	// Also search in bazel-genfiles.
-	entries, _ = gpf.openUnderlyingDir(filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", vendor, name), gpf.cfg.FallThroughSet /* excludes */, entries)
+// listing is a directory listing merged from several directories, in the
+// resolution order, with the names listed so far.
+type listing struct {
+	entries []fuse.DirEntry
+	index   map[string]int       // Of each name in entries.
+	origins map[string]candidate // Where each name in entries was found.
+}
 
-	return entries, fuse.OK
+// newListing returns a listing to merge into the given entries, which
+// must have distinct names.
+func newListing(entries []fuse.DirEntry) *listing {
+	l := &listing{
+		entries: entries,
+		index:   make(map[string]int, len(entries)),
+		origins: make(map[string]candidate, len(entries)),
+	}
+	if l.entries == nil {
+		l.entries = []fuse.DirEntry{}
+	}
+	for i, e := range entries {
+		l.index[e.Name] = i
+	}
+	return l
 }
 
-func (gpf *GoPathFs) openUnderlyingDir(dir string, excludes map[string]struct{}, entries []fuse.DirEntry) ([]fuse.DirEntry, fuse.Status) {
+// mergeUnderlyingDir merges the entries of the given backing directory, of
+// the given kind, into the listing, except the directories named in
+// excludes.
+func (gpf *GoPathFs) mergeUnderlyingDir(dir string, kind PathKind, excludes map[string]struct{}, l *listing) fuse.Status {
 	h, err := os.Open(dir)
 	if err != nil {
-		return entries, fuse.ENOENT
+		return fuse.ENOENT
 	}
 	defer h.Close()
 
-	fis, err := h.Readdir(-1)
-	if err != nil {
-		return entries, fuse.ENOENT
+	// ReadDir takes the entry type from the directory itself (d_type), so
+	// unlike Readdir it doesn't lstat every entry. If the directory changes
+	// while it's read, the entries read so far are still listed.
+	fis, err := h.ReadDir(-1)
+	if err != nil && len(fis) == 0 {
+		return fuse.ENOENT
+	}
+	if err != nil && gpf.debug {
+		fmt.Printf("Listed %s partially, %v.\n", dir, err)
 	}
 
-outterLoop:
+	// A directory changing while it's read, e.g., by a concurrent build,
+	// may return a name twice. The last one read wins over the ones read
+	// from the same directory, not over earlier directories.
+	merged := make(map[string]struct{}, len(fis))
 	for _, fi := range fis {
-		if fi.IsDir() {
-			for _, e := range entries {
-				if fi.Name() == e.Name {
-					// The generated folder has the same name as the original
-					// one.
-					continue outterLoop
-				}
-				if _, ok := excludes[fi.Name()]; ok {
-					// The folder should be excluded, e.g., when it has the same
-					// name as a fall-through folder.
-					continue outterLoop
-				}
-			}
+		entry, ok := gpf.direntOf(dir, fi)
+		if !ok {
+			continue
 		}
 
-		entry := fuse.DirEntry{
-			Name: fi.Name(),
-			Mode: fuse.S_IFREG,
+		if _, ok := excludes[fi.Name()]; ok && entry.Mode&fuse.S_IFDIR != 0 {
+			// The folder should be excluded, e.g., when it has the same
+			// name as a fall-through folder.
+			continue
 		}
-		if fi.IsDir() {
-			entry.Mode = fuse.S_IFDIR
+
+		if _, ok := merged[fi.Name()]; ok {
+			l.entries[l.index[fi.Name()]] = entry
+			continue
+		}
+		if gpf.mergeFound(l, entry, candidate{path: filepath.Join(dir, fi.Name()), kind: kind}) {
+			merged[fi.Name()] = struct{}{}
 		}
-		entries = append(entries, entry)
 	}
 
-	return entries, fuse.OK
+	return fuse.OK
 }
 
-func (gpf *GoPathFs) mkFirstPartyChildDir(name string, mode uint32, context *fuse.Context) fuse.Status {
-	name = filepath.Join(gpf.dirs.Workspace, name)
-	if err := os.MkdirAll(name, os.FileMode(mode)); err != nil {
-		return fuse.ENOENT
+// direntOf returns the listing entry of the given entry of dir. Symbolic
+// links (e.g., package directories linked elsewhere) are listed as their
+// targets, like GetAttr reports them, and dangling ones are skipped. Special
+// files are listed with their type, or skipped with hide-special-files.
+func (gpf *GoPathFs) direntOf(dir string, fi os.DirEntry) (fuse.DirEntry, bool) {
+	entry := fuse.DirEntry{
+		Name: fi.Name(),
 	}
-	return fuse.OK
+
+	typ := fi.Type()
+	if typ&os.ModeSymlink != 0 {
+		target, err := os.Stat(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			if gpf.debug {
+				fmt.Printf("Skipped dangling symbolic link %s.\n", filepath.Join(dir, fi.Name()))
+			}
+			return entry, false
+		}
+		typ = target.Mode().Type()
+	}
+
+	if isSpecialFile(typ) && gpf.config().HideSpecialFiles {
+		return entry, false
+	}
+	entry.Mode = direntMode(typ)
+	return entry, true
+}
+
+// mergeEntry appends the given entry, found at path, to the listing, unless
+// an entry with the same name is already listed, which takes precedence.
+// It returns true if the entry was appended.
+func (gpf *GoPathFs) mergeEntry(l *listing, entry fuse.DirEntry, path string) bool {
+	return gpf.mergeFound(l, entry, candidate{path: path})
+}
+
+// mergeFound is mergeEntry for an entry found at the given candidate, and
+// logs shadowed generated files like resolve does.
+func (gpf *GoPathFs) mergeFound(l *listing, entry fuse.DirEntry, c candidate) bool {
+	if _, ok := l.index[entry.Name]; ok {
+		if gpf.debug {
+			if earlier := l.origins[entry.Name]; isShadowing(earlier, c) {
+				logShadowing(earlier, c)
+			} else {
+				fmt.Printf("Entry %s is shadowed by an earlier directory.\n", c.path)
+			}
+		}
+		return false
+	}
+	l.index[entry.Name] = len(l.entries)
+	l.origins[entry.Name] = c
+	l.entries = append(l.entries, entry)
+	return true
+}
+
+func (gpf *GoPathFs) mkFirstPartyChildDir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return mkUnderlyingDir(filepath.Join(gpf.workspace(), name), mode)
 }
 
 func (gpf *GoPathFs) mkThirdPartyChildDir(name string, mode uint32, context *fuse.Context) fuse.Status {
-	if len(gpf.cfg.Vendors) == 0 {
+	root, ok := gpf.vendorRootFor(name)
+	if !ok {
 		return fuse.ENOENT
 	}
+	return mkUnderlyingDir(filepath.Join(root, name), mode)
+}
 
-	name = filepath.Join(gpf.dirs.Workspace, gpf.cfg.Vendors[0], name)
-	if err := os.MkdirAll(name, os.FileMode(mode)); err != nil {
+// mkUnderlyingDir creates the given backing directory and its parents.
+func mkUnderlyingDir(name string, mode uint32) fuse.Status {
+	if err := os.MkdirAll(name, os.FileMode(mode&0777)); err != nil {
 		return fuse.ENOENT
 	}
-	return fuse.OK
+	return setSpecialBits(name, mode)
 }
 
 func (gpf *GoPathFs) rmFirstPartyChildDir(name string, context *fuse.Context) fuse.Status {
-	name = filepath.Join(gpf.dirs.Workspace, name)
+	return rmUnderlyingDir(filepath.Join(gpf.workspace(), name))
+}
+
+// rmUnderlyingDir removes the given backing directory and its contents.
+func rmUnderlyingDir(name string) fuse.Status {
 	if err := os.RemoveAll(name); err != nil {
 		return fuse.ENOENT
 	}
@@ -241,13 +519,31 @@ func (gpf *GoPathFs) rmFirstPartyChildDir(name string, context *fuse.Context) fu
 }
 
 func (gpf *GoPathFs) rmThirdPartyChildDir(name string, context *fuse.Context) fuse.Status {
-	if len(gpf.cfg.Vendors) == 0 {
-		return fuse.ENOENT
+	// Removes the first existing one, as lookups serve it.
+	for _, v := range gpf.vendors() {
+		path := filepath.Join(v.root, name)
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fuse.ENOENT
+		}
+		return fuse.OK
 	}
+	return fuse.ENOENT
+}
 
-	name = filepath.Join(gpf.dirs.Workspace, gpf.cfg.Vendors[0], name)
-	if err := os.RemoveAll(name); err != nil {
-		return fuse.ENOENT
+// setSpecialBits applies the setuid, setgid and sticky bits of the given raw
+// mode, which os.MkdirAll drops, e.g., for setgid directories passing their
+// group on to new files.
+func setSpecialBits(name string, mode uint32) fuse.Status {
+	if mode&(unix.S_ISUID|unix.S_ISGID|unix.S_ISVTX) == 0 {
+		return fuse.OK
 	}
-	return fuse.OK
+
+	st := unix.Stat_t{}
+	if err := unix.Stat(name, &st); err != nil {
+		return fuse.ToStatus(err)
+	}
+	return fuse.ToStatus(unix.Chmod(name, uint32(st.Mode)&0777|mode&(unix.S_ISUID|unix.S_ISGID|unix.S_ISVTX)))
 }
@@ -0,0 +1,298 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestListingWhileDirectoryChanges(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	dir := filepath.Join(ws, "foo")
+	for i := 0; i < 200; i++ {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("a%d.go", i)), "package foo\n")
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Renames within the directory move entries around while it's
+			// read.
+			name := filepath.Join(dir, fmt.Sprintf("b%d.go", i%50))
+			os.WriteFile(name, nil, 0644)
+			os.Rename(name, filepath.Join(dir, fmt.Sprintf("c%d.go", i%50)))
+			os.Remove(filepath.Join(dir, fmt.Sprintf("c%d.go", (i+25)%50)))
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	for i := 0; i < 200; i++ {
+		entries, status := gpf.OpenDir(testPrefix+"/foo", nil)
+		if status != fuse.OK {
+			t.Fatalf("OpenDir = %v", status)
+		}
+		seen := map[string]bool{}
+		for _, e := range entries {
+			if seen[e.Name] {
+				t.Fatalf("%s listed twice", e.Name)
+			}
+			seen[e.Name] = true
+		}
+		for _, name := range []string{"a0.go", "a199.go"} {
+			if !seen[name] {
+				t.Fatalf("%s not listed", name)
+			}
+		}
+	}
+}
+
+func TestListingMergesVendors(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vendors = []string{"vendor", "vendor2"}
+	cfg.VendorSet = map[string]struct{}{"vendor": {}, "vendor2": {}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "a.go"), "package y\n")
+	writeFile(t, filepath.Join(ws, "vendor2", "github.com", "z", "b.go"), "package z\n")
+	writeFile(t, filepath.Join(ws, "vendor2", "golang.org", "x", "c.go"), "package x\n")
+	gpf.FlushCaches()
+
+	entries, status := gpf.OpenDir("", nil)
+	if status != fuse.OK {
+		t.Fatalf("OpenDir = %v", status)
+	}
+	count := map[string]int{}
+	for _, e := range entries {
+		count[e.Name]++
+	}
+	for _, name := range []string{testPrefix, "github.com", "golang.org"} {
+		if count[name] != 1 {
+			t.Errorf("%s listed %d times, want once", name, count[name])
+		}
+	}
+}
+
+func TestListingFallThroughOverVendor(t *testing.T) {
+	cfg := testConfig()
+	cfg.FallThrough = []string{"tools", "github.com"}
+	cfg.FallThroughSet = map[string]struct{}{"tools": {}, "github.com": {}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "tools", "lint.go"), "package tools\n")
+	writeFile(t, filepath.Join(ws, "github.com", "y", "a.go"), "package y\n")
+	// A vendor file and a vendor directory named like the fall-through
+	// directories.
+	writeFile(t, filepath.Join(ws, "vendor", "tools"), "")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "z", "b.go"), "package z\n")
+
+	entries, status := gpf.OpenDir("", nil)
+	if status != fuse.OK {
+		t.Fatalf("OpenDir = %v", status)
+	}
+	count := map[string]int{}
+	for _, e := range entries {
+		count[e.Name]++
+		if (e.Name == "tools" || e.Name == "github.com") && e.Mode&fuse.S_IFDIR == 0 {
+			t.Errorf("%s listed with mode %o, want the fall-through directory", e.Name, e.Mode)
+		}
+	}
+	for _, name := range []string{"tools", "github.com"} {
+		if count[name] != 1 {
+			t.Errorf("%s listed %d times, want once", name, count[name])
+		}
+	}
+	if names := listNames(t, gpf, "github.com"); !names["y"] || names["z"] {
+		t.Errorf("github.com lists %v, want the fall-through directory's", names)
+	}
+}
+
+func TestModuleFilesAtPrefix(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "go.mod"), "module "+testPrefix+"\n")
+	writeFile(t, filepath.Join(ws, "go.sum"), "")
+	writeFile(t, filepath.Join(ws, "WORKSPACE"), "")
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	names := listNames(t, gpf, testPrefix)
+	for _, name := range []string{"go.mod", "go.sum", "foo"} {
+		if !names[name] {
+			t.Errorf("%s not listed in %s", name, testPrefix)
+		}
+	}
+	if names["WORKSPACE"] {
+		t.Errorf("WORKSPACE listed in %s", testPrefix)
+	}
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/go.mod"); status != fuse.OK || got != "module "+testPrefix+"\n" {
+		t.Errorf("reading go.mod = %q, %v", got, status)
+	}
+	if attr, status := gpf.GetAttr(testPrefix+"/go.sum", nil); status != fuse.OK || attr.Mode&fuse.S_IFREG == 0 {
+		t.Errorf("GetAttr(go.sum) = %v, %v, want a regular file", attr, status)
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestShadowedGenfilesAreLogged(t *testing.T) {
+	cfg := testConfig()
+	cfg.GenDirs = []string{"bazel-genfiles", "bazel-bin"}
+	gpf, ws := newTestFs(t, cfg, WithDebug())
+	first := filepath.Join(ws, "bazel-genfiles", "foo", "a.pb.go")
+	second := filepath.Join(ws, "bazel-bin", "foo", "a.pb.go")
+	writeFile(t, first, "package first\n")
+	writeFile(t, second, "package second\n")
+	want := fmt.Sprintf("Generated file %s shadows %s.\n", first, second)
+
+	out := captureStdout(t, func() {
+		if _, status := gpf.GetAttr(testPrefix+"/foo/a.pb.go", nil); status != fuse.OK {
+			t.Errorf("GetAttr = %v", status)
+		}
+	})
+	if !strings.Contains(out, want) {
+		t.Errorf("GetAttr printed %q, want %q", out, want)
+	}
+
+	// Listings log it the same way, and list the file once.
+	var entries []fuse.DirEntry
+	var status fuse.Status
+	out = captureStdout(t, func() {
+		entries, status = gpf.OpenDir(testPrefix+"/foo", nil)
+	})
+	if status != fuse.OK {
+		t.Fatalf("OpenDir = %v", status)
+	}
+	if len(entries) != 1 || entries[0].Name != "a.pb.go" {
+		t.Errorf("OpenDir = %v, want a.pb.go once", entries)
+	}
+	if !strings.Contains(out, want) {
+		t.Errorf("OpenDir printed %q, want %q", out, want)
+	}
+
+	out = captureStdout(t, func() {
+		if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.pb.go"); status != fuse.OK || got != "package first\n" {
+			t.Errorf("reading the generated file = %q, %v, want the first genfiles directory's", got, status)
+		}
+	})
+	if !strings.Contains(out, want) {
+		t.Errorf("Open printed %q, want %q", out, want)
+	}
+}
+
+// BenchmarkListLargeDirectory compares listing a large directory as OpenDir
+// does, with the entry types read along with the names, with reading the
+// entries with Readdir, which lstats each one.
+func BenchmarkListLargeDirectory(b *testing.B) {
+	gpf, ws := newTestFs(b, nil)
+	dir := filepath.Join(ws, "foo")
+	for i := 0; i < 5000; i++ {
+		writeFile(b, filepath.Join(dir, fmt.Sprintf("%d.go", i)), "package foo\n")
+	}
+
+	b.Run("d_type", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if status := gpf.mergeUnderlyingDir(dir, KindFirstParty, nil, newListing(nil)); status != fuse.OK {
+				b.Fatal(status)
+			}
+		}
+	})
+	b.Run("lstat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			h, err := os.Open(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			fis, err := h.Readdir(-1)
+			h.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+			l := newListing(nil)
+			for _, fi := range fis {
+				entry := fuse.DirEntry{Name: fi.Name(), Mode: fuse.S_IFREG}
+				if fi.IsDir() {
+					entry.Mode = fuse.S_IFDIR
+				}
+				gpf.mergeEntry(l, entry, filepath.Join(dir, fi.Name()))
+			}
+		}
+	})
+}
+
+// TestOpenDirSnapshots checks that OpenDir returns a fresh listing on every
+// call, which go-fuse keeps as the snapshot paged reads of a directory
+// handle are served from, so that paging stays consistent while the
+// directory changes.
+func TestOpenDirSnapshots(t *testing.T) {
+	cfg := testConfig()
+	cfg.ServeBazelMetadata = true
+	gpf, ws := newTestFs(t, cfg)
+	for i := 0; i < 1000; i++ {
+		writeFile(t, filepath.Join(ws, "foo", fmt.Sprintf("%04d.go", i)), "package foo\n")
+	}
+	writeFile(t, filepath.Join(ws, "WORKSPACE"), "")
+
+	for _, dir := range []string{"", testPrefix, testPrefix + "/foo", ".bazel"} {
+		first, status := gpf.OpenDir(dir, nil)
+		if status != fuse.OK || len(first) == 0 {
+			t.Fatalf("OpenDir(%q) = %v, %v", dir, first, status)
+		}
+		snapshot := append([]fuse.DirEntry(nil), first...)
+
+		// Page through the first listing while the directory changes and
+		// other handles list it.
+		var paged []fuse.DirEntry
+		for off := 0; off < len(first); off += 100 {
+			if dir == testPrefix+"/foo" {
+				os.Remove(filepath.Join(ws, "foo", fmt.Sprintf("%04d.go", off)))
+				writeFile(t, filepath.Join(ws, "foo", fmt.Sprintf("new%04d.go", off)), "package foo\n")
+			}
+			second, _ := gpf.OpenDir(dir, nil)
+			for i := range second {
+				second[i].Name = "clobbered"
+			}
+			end := off + 100
+			if end > len(first) {
+				end = len(first)
+			}
+			paged = append(paged, first[off:end]...)
+		}
+		if !reflect.DeepEqual(paged, snapshot) {
+			t.Errorf("paging through the listing of %q while it changed didn't return the listing opened", dir)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// docGoName is the file synthesized, with synthesize-doc-go, in first-party
+// directories which have no Go files but subpackages, which some tools
+// (e.g., go list) otherwise fail on. It holds a package clause only.
+const docGoName = "doc.go"
+
+// servesDocGo returns true if the given mount path is a synthesized doc.go.
+func (gpf *GoPathFs) servesDocGo(name string) bool {
+	if !gpf.config().SynthesizeDocGo || filepath.Base(name) != docGoName {
+		return false
+	}
+	if _, _, ok := gpf.resolve(name); ok {
+		return false
+	}
+
+	dir := filepath.Dir(name)
+	entries, status := gpf.openDir(dir)
+	return status == fuse.OK && gpf.synthesizesDocGo(dir, entries)
+}
+
+// synthesizesDocGo returns true if a doc.go is synthesized in the given
+// mount directory, whose entries are given, i.e., it's a first-party
+// directory with no Go files of its own but a subdirectory with some.
+func (gpf *GoPathFs) synthesizesDocGo(dir string, entries []fuse.DirEntry) bool {
+	if !gpf.config().SynthesizeDocGo {
+		return false
+	}
+	prefix := gpf.config().GoPkgPrefix
+	if dir != prefix && !strings.HasPrefix(dir, prefix+pathSeparator) || gpf.isGoRoot(dir) {
+		return false
+	}
+	if synth, ok := gpf.docGos.load(dir); ok {
+		return synth
+	}
+
+	synth := false
+	if !hasGoFiles(entries) {
+		for _, e := range entries {
+			if e.Mode&fuse.S_IFDIR == 0 {
+				continue
+			}
+			if sub, status := gpf.openDir(filepath.Join(dir, e.Name)); status == fuse.OK && hasGoFiles(sub) {
+				synth = true
+				break
+			}
+		}
+	}
+	gpf.docGos.store(dir, synth)
+	return synth
+}
+
+// Directories beyond which the docGoDirs cache starts over.
+const maxDocGoDirs = 100000
+
+// docGoDirs caches whether a doc.go is synthesized in first-party mount
+// directories, which lists their subdirectories, until an entry in them or
+// their subdirectories changes.
+type docGoDirs struct {
+	mu      sync.Mutex
+	entries map[string]bool
+}
+
+func (dg *docGoDirs) load(dir string) (bool, bool) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	synth, ok := dg.entries[dir]
+	return synth, ok
+}
+
+func (dg *docGoDirs) store(dir string, synth bool) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	if dg.entries == nil || len(dg.entries) >= maxDocGoDirs {
+		dg.entries = map[string]bool{}
+	}
+	dg.entries[dir] = synth
+}
+
+// forget drops the decisions the given changed mount paths may affect, i.e.,
+// those of the paths themselves and below, of their directories and of the
+// parents of those.
+func (dg *docGoDirs) forget(names ...string) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	for _, name := range names {
+		dir := filepath.Dir(name)
+		delete(dg.entries, dir)
+		delete(dg.entries, filepath.Dir(dir))
+		for d := range dg.entries {
+			if d == name || strings.HasPrefix(d, name+pathSeparator) {
+				delete(dg.entries, d)
+			}
+		}
+	}
+}
+
+func (dg *docGoDirs) clear() {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	dg.entries = nil
+}
+
+func hasGoFiles(entries []fuse.DirEntry) bool {
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR == 0 && strings.HasSuffix(e.Name, ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+// docGo returns the content of the doc.go synthesized at the given mount
+// path.
+func docGo(name string) []byte {
+	return []byte(fmt.Sprintf("package %s\n", packageIdent(filepath.Base(filepath.Dir(name)))))
+}
+
+// packageIdent returns the given directory name made a valid package name,
+// e.g., "go_client" for "go-client".
+func packageIdent(dir string) string {
+	ident := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, dir)
+	if ident == "" || unicode.IsDigit(rune(ident[0])) {
+		ident = "_" + ident
+	}
+	return ident
+}
+
+func (gpf *GoPathFs) getDocGoAttr(name string) (*fuse.Attr, fuse.Status) {
+	return &fuse.Attr{
+		Mode: fuse.S_IFREG | 0444,
+		Size: uint64(len(docGo(name))),
+	}, fuse.OK
+}
+
+func (gpf *GoPathFs) openDocGo(name string, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+	data := docGo(name)
+	return &contentSourceFile{
+		File: nodefs.NewDefaultFile(),
+		r:    bytes.NewReader(data),
+		size: int64(len(data)),
+	}, fuse.OK
+}
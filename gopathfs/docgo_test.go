@@ -0,0 +1,103 @@
+package gopathfs
+
+import (
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// mountBuildContext returns a go/build context which, like go list run in
+// the mount, reads the GOPATH through the given GoPathFs.
+func mountBuildContext(t *testing.T, gpf *GoPathFs) *build.Context {
+	ctxt := build.Default
+	ctxt.GOPATH = "/gopath"
+	ctxt.CgoEnabled = false
+	mountName := func(path string) string {
+		return strings.TrimPrefix(path, "/gopath/src/")
+	}
+	ctxt.IsDir = func(path string) bool {
+		attr, status := gpf.GetAttr(mountName(path), nil)
+		return status == fuse.OK && attr.Mode&fuse.S_IFDIR != 0
+	}
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		entries, status := gpf.OpenDir(mountName(dir), nil)
+		if status != fuse.OK {
+			return nil, os.ErrNotExist
+		}
+		fis := []os.FileInfo{}
+		for _, e := range entries {
+			attr, status := gpf.GetAttr(filepath.Join(mountName(dir), e.Name), nil)
+			if status != fuse.OK {
+				continue
+			}
+			fis = append(fis, attrFileInfo{name: e.Name, attr: attr})
+		}
+		return fis, nil
+	}
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		data, status := readMountFile(t, gpf, mountName(path))
+		if status != fuse.OK {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+	return &ctxt
+}
+
+type attrFileInfo struct {
+	os.FileInfo
+	name string
+	attr *fuse.Attr
+}
+
+func (fi attrFileInfo) Name() string { return fi.name }
+func (fi attrFileInfo) Size() int64  { return int64(fi.attr.Size) }
+func (fi attrFileInfo) IsDir() bool  { return fi.attr.Mode&fuse.S_IFDIR != 0 }
+func (fi attrFileInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func TestDocGoLetsGoListLoadParents(t *testing.T) {
+	cfg := testConfig()
+	cfg.SynthesizeDocGo = true
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "go-client", "sub", "a.go"), "package sub\n")
+	ctxt := mountBuildContext(t, gpf)
+
+	pkg, err := ctxt.Import(testPrefix+"/go-client", "", 0)
+	if err != nil {
+		t.Fatalf("Import = %v", err)
+	}
+	if pkg.Name != "go_client" || len(pkg.GoFiles) != 1 || pkg.GoFiles[0] != docGoName {
+		t.Errorf("Import = package %s of %v, want go_client of doc.go", pkg.Name, pkg.GoFiles)
+	}
+
+	if synth, ok := gpf.docGos.load(testPrefix + "/go-client"); !ok || !synth {
+		t.Errorf("cached decision = %v, %v, want true", synth, ok)
+	}
+
+	// Decided again once the files below change.
+	if status := gpf.Unlink(testPrefix+"/go-client/sub/a.go", nil); status != fuse.OK {
+		t.Fatalf("Unlink = %v", status)
+	}
+	if _, err := ctxt.Import(testPrefix+"/go-client", "", 0); err == nil {
+		t.Error("Import of a directory without Go files below succeeded")
+	}
+	f, status := gpf.Create(testPrefix+"/go-client/b.go", uint32(os.O_WRONLY), 0644, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create = %v", status)
+	}
+	f.Write([]byte("package client\n"), 0)
+	f.Release()
+	if pkg, err := ctxt.Import(testPrefix+"/go-client", "", 0); err != nil || pkg.Name != "client" {
+		t.Errorf("Import = %v, %v, want package client", pkg.Name, err)
+	}
+}
@@ -0,0 +1,61 @@
+package gopathfs
+
+import (
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Operations taking longer than this are reported as slow.
+const slowOpThreshold = time.Second
+
+// ErrorEvent is a non-fatal error, or a slow operation, observed by a
+// GoPathFs.
+type ErrorEvent struct {
+	Time     time.Time
+	Op       string        // E.g., "open", or "check-vendors" outside of operations.
+	Name     string        // The mount path, or the config entry at fault.
+	Status   fuse.Status   // OK for slow operations which succeeded.
+	Duration time.Duration // How long the operation took, if any.
+}
+
+// SetErrorEvents sets the channel error events are sent to. It must be
+// called before mounting; see WithErrorEvents to get the events of New too.
+// Events are dropped while the channel is full, so that serving never blocks
+// on the receiver; it should be buffered.
+func (gpf *GoPathFs) SetErrorEvents(ch chan<- ErrorEvent) {
+	gpf.errorEvents = ch
+}
+
+func (gpf *GoPathFs) reportError(ev ErrorEvent) {
+	if gpf.errorEvents == nil {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case gpf.errorEvents <- ev:
+	default:
+	}
+}
+
+// watchOp traces the given operation, and reports it if it failed for
+// another reason than a missing entry, or was slow. It takes pointers to the
+// result status and to the backing path the operation resolves, which may
+// be nil, so that it can be deferred.
+func (gpf *GoPathFs) watchOp(op, name string, start time.Time, code *fuse.Status, backingPath *string) {
+	if gpf.tracer != nil {
+		path := ""
+		if backingPath != nil {
+			path = *backingPath
+		}
+		gpf.trace(op, name, path, start, *code)
+	}
+	if gpf.errorEvents == nil {
+		return
+	}
+	d := time.Since(start)
+	if (*code == fuse.OK || *code == fuse.ENOENT) && d < slowOpThreshold {
+		return
+	}
+	gpf.reportError(ErrorEvent{Op: op, Name: name, Status: *code, Duration: d})
+}
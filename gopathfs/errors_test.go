@@ -0,0 +1,24 @@
+package gopathfs
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestErrorEventsOfNew(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vendors = append(cfg.Vendors, "missing")
+	cfg.VendorSet["missing"] = struct{}{}
+	ch := make(chan ErrorEvent, 4)
+	newTestFs(t, cfg, WithErrorEvents(ch))
+
+	select {
+	case ev := <-ch:
+		if ev.Op != "check-vendors" || ev.Name != "missing" || ev.Status != fuse.ENOENT {
+			t.Errorf("event = %+v, want check-vendors of missing, ENOENT", ev)
+		}
+	default:
+		t.Error("no event for the missing vendor directory")
+	}
+}
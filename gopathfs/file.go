@@ -1,10 +1,12 @@
 package gopathfs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -13,68 +15,228 @@ import (
 
 // Open overwrites the parent's Open method.
 func (gpf *GoPathFs) Open(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	name = normalizeName(name)
+
 	if gpf.debug {
 		fmt.Printf("\nReqeusted to open file %s.\n", name)
 	}
+	gpf.ops.record("open", name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("open", name, time.Now(), &code, &backingPath)
 
-	if strings.HasPrefix(name, gpf.cfg.GoPkgPrefix+pathSeparator) {
-		return gpf.openFirstPartyChildFile(name, flags, context)
+	if gpf.isVirtualDir(name) {
+		return nil, fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
 	}
 
-	// Search in fall-through directories.
-	for _, path := range gpf.cfg.FallThrough {
-		if path == name || strings.HasPrefix(name, path) {
-			f, status := gpf.openUnderlyingFile(filepath.Join(gpf.dirs.Workspace, name), flags, context)
-			if status == fuse.OK {
-				return f, status
+	if name == ctlFileName {
+		return gpf.openCtlFile()
+	}
+	if gpf.servesModulesTxt(name) {
+		return gpf.openModulesTxt(flags)
+	}
+	if isIntrospectPath(name) {
+		return gpf.openIntrospectFile(name, flags)
+	}
+	if gpf.servesBazelMeta(name) {
+		return gpf.openBazelMetaFile(name, flags, context)
+	}
+	if data, ok := gpf.metadata(name); ok {
+		return gpf.openMetadataFile(data, flags)
+	}
+	if gpf.servesDocGo(name) {
+		return gpf.openDocGo(name, flags)
+	}
+	if gpf.hidesModFile(name) {
+		return nil, fuse.ENOENT
+	}
+	if status := gpf.checkOpenFiles(); status != fuse.OK {
+		return nil, status
+	}
+	defer gpf.releaseOpenFileSlot(&file)
+
+	if flags&fuse.O_ANYWRITE != 0 && gpf.isReadOnly(name) {
+		return nil, fuse.EROFS
+	}
+
+	if flags&fuse.O_ANYWRITE != 0 {
+		if src, ok := gpf.writeThroughPath(name); ok {
+			fmt.Printf("Warning: writing %s to its source file %s, not to the generated file.\n", name, src)
+			file, status := gpf.openUnderlyingFile(src, flags, context)
+			if status != fuse.OK {
+				return nil, status
 			}
-			return nil, fuse.ENOENT
+			return gpf.trackFile(name, file, flags, context), fuse.OK
 		}
 	}
 
-	// Search in vendor directories.
-	for _, vendor := range gpf.cfg.Vendors {
-		f, status := gpf.openVendorChildFile(vendor, name, flags, context)
+	// Search in first-party, fall-through and vendor directories, in the
+	// resolution order.
+	code = fuse.ENOENT
+	cands := gpf.candidates(name)
+	for i, c := range cands {
+		if c.src != nil {
+			file, status := gpf.openContentSourceFile(c.src, c.rel, flags)
+			if status == fuse.OK {
+				return file, status
+			}
+			if status != fuse.ENOENT && code == fuse.ENOENT {
+				code = status
+			}
+			continue
+		}
+
+		if flags&fuse.O_ANYWRITE != 0 && gpf.expandsTemplate(name) {
+			// GetAttr reports the expanded size, which the kernel would
+			// cut reads of the raw content through a write handle to.
+			if _, err := os.Stat(c.path); err == nil {
+				return nil, fuse.EROFS
+			}
+		}
+		if flags&fuse.O_ANYWRITE == 0 && gpf.expandsTemplate(name) {
+			if file, ok := gpf.openExpandedFile(c.path); ok {
+				return file, fuse.OK
+			}
+		}
+		if flags&fuse.O_ANYWRITE == 0 && gpf.normalizesCRLF(name) {
+			if file, ok := gpf.openNormalizedFile(c.path); ok {
+				return file, fuse.OK
+			}
+		}
+
+		file, status := gpf.openUnderlyingFile(c.path, flags, context)
 		if status == fuse.OK {
-			return f, status
+			if gpf.debug {
+				gpf.logShadowed(c, cands[i+1:])
+			}
+			backingPath = c.path
+			gpf.adviseReadahead(file, c.kind)
+			return gpf.trackFile(name, file, flags, context), status
+		}
+		if status != fuse.ENOENT && code == fuse.ENOENT {
+			// Report why the first existing file can't be opened.
+			code = status
 		}
 	}
 
-	return nil, fuse.ENOENT
+	return nil, code
 }
 
 // Create overwrites the parent's Create method.
 func (gpf *GoPathFs) Create(name string, flags uint32, mode uint32,
 	context *fuse.Context) (file nodefs.File, code fuse.Status) {
 
+	name = normalizeName(name)
+
 	if gpf.debug {
 		fmt.Printf("\nReqeusted to create file %s.\n", name)
 	}
+	gpf.ops.record("create", name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("create", name, time.Now(), &code, &backingPath)
+	defer gpf.audit(AuditRecord{Op: "create", Name: name, Mode: mode}, context, &code)
 
-	prefix := gpf.cfg.GoPkgPrefix + pathSeparator
-	if strings.HasPrefix(name, prefix) {
-		return gpf.createFirstPartyChildFile(name[len(prefix):], flags, mode, context)
+	if gpf.isVirtualDir(name) {
+		return nil, fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
 	}
 
-	return gpf.createThirdPartyChildFile(name, flags, mode, context)
+	if gpf.isReadOnly(name) {
+		return nil, fuse.EROFS
+	}
+	if status := gpf.checkCreatePolicy(name); status != fuse.OK {
+		return nil, status
+	}
+	if status := gpf.checkOpenFiles(); status != fuse.OK {
+		return nil, status
+	}
+	defer gpf.releaseOpenFileSlot(&file)
+	defer gpf.attrCache.invalidate(name)
+	defer gpf.docGos.forget(name)
+
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if path, _, ok := gpf.customResolve(name); ok {
+		file, code = gpf.createUnderlyingFile(path, flags, mode)
+	} else if strings.HasPrefix(name, prefix) {
+		file, code = gpf.createFirstPartyChildFile(name[len(prefix):], flags, mode, context)
+		if code == fuse.OK && gpf.config().WarnUnbuiltFiles {
+			gpf.warnIfUnbuilt(name[len(prefix):])
+		}
+	} else if gpf.isFallThrough(name) {
+		// Fall-through paths are relative to the workspace.
+		file, code = gpf.createFirstPartyChildFile(name, flags, mode, context)
+	} else {
+		file, code = gpf.createThirdPartyChildFile(name, flags, mode, context)
+	}
+	if code != fuse.OK {
+		return nil, code
+	}
+	if lf, ok := file.(*loopbackFile); ok {
+		backingPath = lf.f.Name()
+		gpf.chownCreated(lf.f, context)
+	}
+	return gpf.trackFile(name, file, flags, context), fuse.OK
 }
 
 // Unlink overwrites the parent's Unlink method.
 func (gpf *GoPathFs) Unlink(name string, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+
 	if gpf.debug {
 		fmt.Printf("\nReqeusted to unlink file %s.\n", name)
 	}
+	gpf.ops.record("unlink", name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("unlink", name, time.Now(), &code, &backingPath)
+	defer gpf.audit(AuditRecord{Op: "unlink", Name: name, BackingPath: gpf.auditedPath(name)}, context, &code)
+
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
+	}
 
-	prefix := gpf.cfg.GoPkgPrefix + pathSeparator
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	defer gpf.attrCache.invalidate(name)
+	defer gpf.docGos.forget(name)
+
+	if path, _, ok := gpf.customResolve(name); ok {
+		backingPath = path
+		return gpf.unlinkUnderlyingFile(backingPath, context)
+	}
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
 	if strings.HasPrefix(name, prefix) {
-		name = filepath.Join(gpf.dirs.Workspace, name[len(prefix):])
-		return gpf.unlinkUnderlyingFile(name, context)
+		backingPath = filepath.Join(gpf.workspace(), name[len(prefix):])
+		return gpf.unlinkUnderlyingFile(backingPath, context)
+	}
+	if gpf.isFallThrough(name) {
+		backingPath = filepath.Join(gpf.workspace(), name)
+		return gpf.unlinkUnderlyingFile(backingPath, context)
 	}
 
 	// Vendor directories.
-	for _, vendor := range gpf.cfg.Vendors {
-		name = filepath.Join(gpf.dirs.Workspace, vendor, name)
-		if status := gpf.unlinkUnderlyingFile(name, context); status == fuse.OK {
+	for _, vendor := range gpf.vendors() {
+		if status := gpf.unlinkUnderlyingFile(filepath.Join(vendor.root, name), context); status == fuse.OK {
+			backingPath = filepath.Join(vendor.root, name)
 			return status
 		}
 	}
@@ -84,75 +246,232 @@ func (gpf *GoPathFs) Unlink(name string, context *fuse.Context) (code fuse.Statu
 
 // Rename overwrites the parent's Rename method.
 func (gpf *GoPathFs) Rename(oldName string, newName string, context *fuse.Context) (code fuse.Status) {
+	oldName, newName = normalizeName(oldName), normalizeName(newName)
+
 	if gpf.debug {
 		fmt.Printf("\nReqeusted to rename from %s to %s.\n", oldName, newName)
 	}
+	gpf.ops.record("rename", oldName+" -> "+newName)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("rename", oldName+" -> "+newName, time.Now(), &code, &backingPath)
+	defer gpf.audit(AuditRecord{Op: "rename", Name: oldName, NewName: newName, BackingPath: gpf.auditedPath(oldName)}, context, &code)
 
-	if strings.HasPrefix(oldName, gpf.cfg.GoPkgPrefix+pathSeparator) {
-		oldName = filepath.Join(gpf.dirs.Workspace, oldName[len(gpf.cfg.GoPkgPrefix):])
-		newName = filepath.Join(gpf.dirs.Workspace, newName[len(gpf.cfg.GoPkgPrefix):])
-	} else {
-		// Vendor directories.
-		for _, vendor := range gpf.cfg.Vendors {
-			oldName = filepath.Join(vendor, oldName)
-			if _, err := os.Stat(oldName); err == nil {
-				newName = filepath.Join(vendor, newName)
-				break
-			}
+	for _, name := range []string{oldName, newName} {
+		if gpf.isVirtualDir(name) {
+			return fuse.EPERM
 		}
-		if newName == "" || oldName == "" {
-			return fuse.ENOSYS
+		if status := gpf.checkName(name); status != fuse.OK {
+			return status
+		}
+	}
+
+	if gpf.isReadOnly(oldName) || gpf.isReadOnly(newName) {
+		return fuse.EROFS
+	}
+	if status := gpf.checkCreatePolicy(newName); status != fuse.OK {
+		return status
+	}
+	defer gpf.attrCache.invalidate(oldName, newName)
+	defer gpf.docGos.forget(oldName, newName)
+	defer gpf.roots.clear()
+
+	// Both sides are resolved independently, e.g., a file may be moved
+	// out of a vendor directory into a first-party package.
+	oldPath, vendorRoot := gpf.renamePath(oldName, "")
+	newPath, _ := gpf.renamePath(newName, vendorRoot)
+	if newPath == "" || oldPath == "" {
+		return fuse.ENOSYS
+	}
+	backingPath = oldPath
+
+	// Some atomic-save libraries rename files onto themselves, which does
+	// nothing if the file exists.
+	if oldPath == newPath {
+		if _, err := os.Lstat(oldPath); err != nil {
+			return fuse.ToStatus(err)
 		}
+		return fuse.OK
 	}
 
 	if gpf.debug {
-		fmt.Printf("Actual rename from %s to %s ... ", oldName, newName)
+		fmt.Printf("Actual rename from %s to %s ... ", oldPath, newPath)
+	}
+	// The backing path of the files open below oldPath after the rename.
+	openPath := newPath
+	err := os.Rename(oldPath, newPath)
+	if errors.Is(err, unix.EXDEV) {
+		// The backing roots are on different filesystems. Files open for
+		// writing would keep writing to the file left behind.
+		if gpf.openForWriting(oldPath) {
+			return fuse.Status(unix.EBUSY)
+		}
+		if err = moveFile(newPath, oldPath); err != nil {
+			fmt.Printf("Failed to move file %s to %s, %v.\n", oldPath, newPath, err)
+			return fuse.Status(unix.EXDEV)
+		}
+		// Files open for reading still read the file left behind.
+		openPath = oldPath
 	}
-	if err := os.Rename(oldName, newName); err != nil {
+	if err != nil {
 		if gpf.debug {
-			fmt.Println("failed to rename file %s,", oldName, err)
+			fmt.Printf("failed to rename file %s, %v.\n", oldPath, err)
 		}
 		return fuse.ENOSYS
 	}
+	gpf.renameOpenFiles(oldName, newName, oldPath, openPath)
+	gpf.forgetDir(oldName)
 	if gpf.debug {
-		fmt.Println("Succeeded to rename file %s.\n", oldName)
+		fmt.Printf("Succeeded to rename file %s.\n", oldPath)
 	}
 	return fuse.OK
 }
 
-func (gpf *GoPathFs) openFirstPartyChildFile(name string, flags uint32,
-	context *fuse.Context) (file nodefs.File, code fuse.Status) {
-
-	name = name[len(gpf.cfg.GoPkgPrefix+pathSeparator):]
+// renamePath returns the backing path a rename from or to the given mount
+// path applies to. Paths handled by custom resolvers are where they resolve
+// to. First-party and fall-through paths are in the workspace. A vendor path
+// being renamed is in the vendor directory it exists in, which is also
+// returned; one being renamed to is in the given vendor directory, if any,
+// or where it would be created.
+func (gpf *GoPathFs) renamePath(name, vendorRoot string) (path, root string) {
+	if path, _, ok := gpf.customResolve(name); ok {
+		return path, ""
+	}
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if strings.HasPrefix(name, prefix) {
+		return filepath.Join(gpf.workspace(), name[len(prefix):]), ""
+	}
+	if gpf.isFallThrough(name) {
+		return filepath.Join(gpf.workspace(), name), ""
+	}
 
-	// Search in GOROOT (for debugger).
-	if name == "GOROOT" || strings.HasPrefix(name, "GOROOT"+pathSeparator) {
-		f, status := gpf.openUnderlyingFile(filepath.Join(gpf.dirs.GoSDKDir, name[len("GOROOT"):]), flags, context)
-		if status == fuse.OK {
-			return f, status
+	if vendorRoot != "" {
+		return filepath.Join(vendorRoot, name), vendorRoot
+	}
+	for _, vendor := range gpf.vendors() {
+		p := filepath.Join(vendor.root, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, vendor.root
 		}
-		return nil, fuse.ENOENT
 	}
+	if root, ok := gpf.vendorRootFor(name); ok {
+		return filepath.Join(root, name), root
+	}
+	return "", ""
+}
+
+// Truncate overwrites the parent's Truncate method.
+func (gpf *GoPathFs) Truncate(name string, size uint64, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("truncate", name, time.Now(), &code, nil)
 
-	f, status := gpf.openUnderlyingFile(filepath.Join(gpf.dirs.Workspace, name), flags, context)
-	if status == fuse.OK {
-		return f, status
+	// Commands written to the control file aren't audited.
+	if name == ctlFileName {
+		return fuse.OK
 	}
+	defer gpf.audit(AuditRecord{Op: "truncate", Name: name, Size: size, BackingPath: gpf.auditedPath(name)}, context, &code)
 
-	// Also search in bazel-genfiles.
-	return gpf.openUnderlyingFile(filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", name), flags, context)
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
+	}
+
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	if status := gpf.checkMaxSize(name, int64(size)); status != fuse.OK {
+		return status
+	}
+	path, status := gpf.writablePath(name)
+	if status != fuse.OK {
+		return status
+	}
+	defer gpf.attrCache.invalidate(name)
+	return fuse.ToStatus(os.Truncate(path, int64(size)))
 }
 
-func (gpf *GoPathFs) openVendorChildFile(vendor, name string, flags uint32,
-	context *fuse.Context) (file nodefs.File, code fuse.Status) {
+// Chmod overwrites the parent's Chmod method.
+func (gpf *GoPathFs) Chmod(name string, mode uint32, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("chmod", name, time.Now(), &code, nil)
+	defer gpf.audit(AuditRecord{Op: "chmod", Name: name, Mode: mode, BackingPath: gpf.auditedPath(name)}, context, &code)
 
-	f, status := gpf.openUnderlyingFile(filepath.Join(gpf.dirs.Workspace, vendor, name), flags, context)
-	if status == fuse.OK {
-		return f, status
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
 	}
 
-	// Also search in bazel-genfiles.
-	return gpf.openUnderlyingFile(filepath.Join(gpf.dirs.Workspace, "bazel-genfiles", vendor, name), flags, context)
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	path, status := gpf.writablePath(name)
+	if status != fuse.OK {
+		return status
+	}
+	defer gpf.attrCache.invalidate(name)
+	return fuse.ToStatus(unix.Chmod(path, mode&07777))
+}
+
+// Chown overwrites the parent's Chown method. The given owner is mapped
+// back to the backing one, see uid-map.
+func (gpf *GoPathFs) Chown(name string, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("chown", name, time.Now(), &code, &backingPath)
+	defer gpf.audit(AuditRecord{Op: "chown", Name: name, BackingPath: gpf.auditedPath(name)}, context, &code)
+
+	if gpf.isVirtualDir(name) {
+		return fuse.EPERM
+	}
+	if status := gpf.checkName(name); status != fuse.OK {
+		return status
+	}
+
+	if gpf.isReadOnly(name) {
+		return fuse.EROFS
+	}
+	path, status := gpf.writablePath(name)
+	if status != fuse.OK {
+		return status
+	}
+	backingPath = path
+	defer gpf.attrCache.invalidate(name)
+
+	uid, gid = gpf.backingOwner(uid, gid)
+	return fuse.ToStatus(os.Chown(path, chownID(uid), chownID(gid)))
+}
+
+// writablePath returns the backing file the given mount path resolves to,
+// for changes to the file itself. Files served by content sources are
+// read-only.
+func (gpf *GoPathFs) writablePath(name string) (string, fuse.Status) {
+	c, _, ok := gpf.resolve(name)
+	if !ok {
+		return "", fuse.ENOENT
+	}
+	if c.src != nil {
+		return "", fuse.EROFS
+	}
+	return c.path, fuse.OK
 }
 
 func (gpf *GoPathFs) openUnderlyingFile(name string, flags uint32,
@@ -162,10 +481,18 @@ func (gpf *GoPathFs) openUnderlyingFile(name string, flags uint32,
 		fmt.Printf("Actually opening file %s.\n", name)
 	}
 
-	if _, err := os.Stat(name); err != nil {
+	fi, err := os.Stat(name)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fuse.ENOENT
 		}
+	} else if isSpecialFile(fi.Mode()) {
+		// The kernel opens special files itself, given their type. Opening
+		// a FIFO here would block until it's opened for writing.
+		if gpf.config().HideSpecialFiles {
+			return nil, fuse.ENOENT
+		}
+		return nil, fuse.Status(unix.ENXIO)
 	}
 
 	if flags&fuse.O_ANYWRITE != 0 && unix.Access(name, unix.W_OK) != nil {
@@ -182,63 +509,65 @@ func (gpf *GoPathFs) openUnderlyingFile(name string, flags uint32,
 	if gpf.debug {
 		fmt.Printf("Succeeded to open file: %s.\n", name)
 	}
-	return nodefs.NewLoopbackFile(f), fuse.OK
+	return gpf.newLoopbackFile(f, flags&fuse.O_ANYWRITE != 0), fuse.OK
 }
 
 func (gpf *GoPathFs) createFirstPartyChildFile(name string, flags uint32, mode uint32,
 	context *fuse.Context) (file nodefs.File, code fuse.Status) {
 
-	name = filepath.Join(gpf.dirs.Workspace, name)
-
-	if gpf.debug {
-		fmt.Printf("Actually creating file %s.\n", name)
-	}
-
-	f, err := os.Create(name)
-	if err != nil {
-		if gpf.debug {
-			fmt.Printf("Failed to create file %s.\n", name)
-		}
-		return nil, fuse.EIO
-	}
-
-	if err = os.Chmod(name, os.FileMode(mode)); err != nil {
-		fmt.Printf("Fail to chmod. file: %s, mode: %s, err: %#v.", name, os.FileMode(mode).String(), err)
-	}
-
-	if gpf.debug {
-		fmt.Printf("Succeeded to create file %s.\n", name)
-	}
-	return nodefs.NewLoopbackFile(f), fuse.OK
+	return gpf.createUnderlyingFile(filepath.Join(gpf.workspace(), name), flags, mode)
 }
 
 func (gpf *GoPathFs) createThirdPartyChildFile(name string, flags uint32, mode uint32,
 	context *fuse.Context) (file nodefs.File, code fuse.Status) {
-	if len(gpf.cfg.Vendors) == 0 {
+	root, ok := gpf.vendorRootFor(name)
+	if !ok {
 		return nil, fuse.EIO
 	}
 
-	name = filepath.Join(gpf.dirs.Workspace, gpf.cfg.Vendors[0], name)
+	return gpf.createUnderlyingFile(filepath.Join(root, name), flags, mode)
+}
+
+// createUnderlyingFile creates the given backing file with the open flags of
+// the request, e.g., failing if it exists with O_EXCL. Concurrent creates of
+// the same file are serialized, so that only the one which actually creates
+// it sets its mode, and none truncates what another has written unless asked
+// to with O_TRUNC.
+func (gpf *GoPathFs) createUnderlyingFile(name string, flags uint32, mode uint32) (nodefs.File, fuse.Status) {
 	if gpf.debug {
 		fmt.Printf("Actually creating file %s.\n", name)
 	}
 
-	f, err := os.Create(name)
+	mu := gpf.pathLocks.get(name)
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, err := os.Lstat(name)
+	existed := err == nil
+
+	f, err := os.OpenFile(name, int(flags)|os.O_CREATE, os.FileMode(mode&0777))
 	if err != nil {
 		if gpf.debug {
-			fmt.Printf("Failed to create file %s.\n", name)
+			fmt.Printf("Failed to create file %s, %v.\n", name, err)
+		}
+		if os.IsExist(err) {
+			return nil, fuse.Status(unix.EEXIST)
 		}
 		return nil, fuse.EIO
 	}
 
-	if err = os.Chmod(name, os.FileMode(mode)); err != nil {
-		fmt.Printf("Fail to chmod. file: %s, mode: %s, err: %#v.", name, os.FileMode(mode).String(), err)
+	if !existed {
+		// The raw mode keeps the setuid, setgid and sticky bits, which
+		// os.FileMode has in different places, and isn't subject to umask.
+		if err = unix.Fchmod(int(f.Fd()), mode&07777); err != nil {
+			fmt.Printf("Fail to chmod. file: %s, mode: %o, err: %#v.\n", name, mode&07777, err)
+		}
 	}
 
 	if gpf.debug {
 		fmt.Printf("Succeeded to create file %s.\n", name)
 	}
-	return nodefs.NewLoopbackFile(f), fuse.OK
+	return gpf.newLoopbackFile(f, flags&fuse.O_ANYWRITE != 0), fuse.OK
 }
 
 func (gpf *GoPathFs) unlinkUnderlyingFile(name string, context *fuse.Context) (code fuse.Status) {
@@ -0,0 +1,118 @@
+package gopathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestRenameOntoItself(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	if status := gpf.Rename(name, name, nil); status != fuse.OK {
+		t.Fatalf("Rename onto itself = %v", status)
+	}
+	if data, err := ioutil.ReadFile(backing); err != nil || string(data) != "package foo\n" {
+		t.Errorf("content after renaming onto itself = %q, %v", data, err)
+	}
+	if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading after renaming onto itself = %q, %v", got, status)
+	}
+
+	missing := testPrefix + "/foo/nope.go"
+	if status := gpf.Rename(missing, missing, nil); status != fuse.ENOENT {
+		t.Errorf("Rename of a missing file onto itself = %v, want ENOENT", status)
+	}
+}
+
+func TestCreateKeepsSpecialBits(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	if status := gpf.Mkdir(testPrefix+"/shared", 0755|unix.S_ISGID, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	f, status := gpf.Create(testPrefix+"/shared/run.sh", uint32(os.O_WRONLY), 0755|unix.S_ISGID, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create = %v", status)
+	}
+	f.Release()
+	if status := gpf.Mkdir("github.com/tmp", 0755|unix.S_ISVTX, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+
+	// Relative to the workspace.
+	for name, want := range map[string]uint32{
+		"shared":                0755 | unix.S_ISGID,
+		"shared/run.sh":         0755 | unix.S_ISGID,
+		"vendor/github.com/tmp": 0755 | unix.S_ISVTX,
+	} {
+		st := unix.Stat_t{}
+		if err := unix.Stat(filepath.Join(ws, name), &st); err != nil {
+			t.Fatal(err)
+		}
+		if got := uint32(st.Mode) & 07777; got != want {
+			t.Errorf("mode of %s = %o, want %o", name, got, want)
+		}
+	}
+	if attr, status := gpf.GetAttr(testPrefix+"/shared/run.sh", nil); status != fuse.OK || attr.Mode&unix.S_ISGID == 0 {
+		t.Errorf("GetAttr(shared/run.sh) = %v, %v, want the setgid bit", attr, status)
+	}
+}
+
+func TestChangesInFallThroughDir(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.FallThrough = []string{"tools"}
+		cfg.FallThroughSet = map[string]struct{}{"tools": {}}
+		cfg.FallThroughReadOnly = readOnly
+		gpf, ws := newTestFs(t, cfg)
+		writeFile(t, filepath.Join(ws, "tools", "lint.go"), "package tools\n")
+
+		if readOnly {
+			if _, status := gpf.Create("tools/new.go", uint32(os.O_WRONLY), 0644, nil); status != fuse.EROFS {
+				t.Errorf("Create with fall-through-read-only = %v, want EROFS", status)
+			}
+			if status := gpf.Unlink("tools/lint.go", nil); status != fuse.EROFS {
+				t.Errorf("Unlink with fall-through-read-only = %v, want EROFS", status)
+			}
+			continue
+		}
+
+		if status := gpf.Mkdir("tools/sub", 0755, nil); status != fuse.OK {
+			t.Fatalf("Mkdir = %v", status)
+		}
+		f, status := gpf.Create("tools/sub/new.go", uint32(os.O_WRONLY), 0644, nil)
+		if status != fuse.OK {
+			t.Fatalf("Create = %v", status)
+		}
+		f.Release()
+		if status := gpf.Rename("tools/sub/new.go", "tools/moved.go", nil); status != fuse.OK {
+			t.Fatalf("Rename = %v", status)
+		}
+		if status := gpf.Rmdir("tools/sub", nil); status != fuse.OK {
+			t.Fatalf("Rmdir = %v", status)
+		}
+		if status := gpf.Unlink("tools/lint.go", nil); status != fuse.OK {
+			t.Fatalf("Unlink = %v", status)
+		}
+
+		// The changes went to the workspace, not to a vendor directory.
+		if _, err := os.Stat(filepath.Join(ws, "tools", "moved.go")); err != nil {
+			t.Errorf("tools/moved.go not in the workspace, %v", err)
+		}
+		for _, name := range []string{"tools/lint.go", "tools/sub", "vendor/tools"} {
+			if _, err := os.Lstat(filepath.Join(ws, name)); !os.IsNotExist(err) {
+				t.Errorf("%s exists, %v", name, err)
+			}
+		}
+		if names := listNames(t, gpf, "tools"); len(names) != 1 || !names["moved.go"] {
+			t.Errorf("tools lists %v, want moved.go only", names)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package gopathfs
+
+import (
+	"fmt"
+)
+
+// FlushCaches drops everything cached about the backing tree, both in
+// gobazel and (once mounted) in the kernel, so that changes made out-of-band
+// are picked up immediately. It also releases the quarantined paths.
+func (gpf *GoPathFs) FlushCaches() {
+	gpf.attrCache.clear()
+	gpf.overrides.clear()
+	gpf.roots.clear()
+	gpf.templates.clear()
+	gpf.crlfs.clear()
+	gpf.gzipSizes.clear()
+	gpf.buildMatches.clear()
+	gpf.docGos.clear()
+	gpf.archDirs.clear()
+	gpf.rebuildModulesTxt()
+	gpf.quarantine.clear()
+	gpf.checkVendors()
+	gpf.checkGenDirs()
+
+	if gpf.nodeFs == nil {
+		return
+	}
+
+	// Invalidate the top level entries; the kernel has to look up their
+	// children again once their parents are gone.
+	entries, _ := gpf.openTopDir()
+	for _, e := range entries {
+		gpf.nodeFs.Notify(e.Name)
+	}
+	gpf.nodeFs.FileNotify("", 0, 0)
+	// The devices of the backing roots may have changed too.
+	gpf.devIndexes.clear()
+	gpf.nodeFs.ForgetClientInodes()
+
+	if gpf.debug {
+		fmt.Println("Flushed all caches.")
+	}
+}
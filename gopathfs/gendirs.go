@@ -0,0 +1,39 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// checkGenDirs warns once about gen dirs which are dangling symbolic links,
+// i.e., bazel's convenience links after "bazel clean", since lookups would
+// otherwise silently serve source files only until the next build.
+func (gpf *GoPathFs) checkGenDirs() {
+	if gpf.config().DisableGenfiles {
+		return
+	}
+
+	for _, gen := range gpf.config().GenDirs {
+		path := filepath.Join(gpf.workspace(), gen)
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			gpf.danglingGenDirs.Delete(gen)
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			gpf.danglingGenDirs.Delete(gen)
+			continue
+		}
+
+		if _, warned := gpf.danglingGenDirs.LoadOrStore(gen, true); warned {
+			continue
+		}
+		target, _ := os.Readlink(path)
+		fmt.Printf("Warning, gen dir %s links to %s which doesn't exist (e.g. after \"bazel clean\"), "+
+			"generated files won't be served until the next build.\n", gen, target)
+		gpf.reportError(ErrorEvent{Op: "check-gen-dirs", Name: gen, Status: fuse.ENOENT})
+	}
+}
@@ -0,0 +1,61 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+func TestDanglingGenDir(t *testing.T) {
+	ch := make(chan ErrorEvent, 16)
+	gpf, ws := newTestFs(t, nil, WithErrorEvents(ch))
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	target := filepath.Join(t.TempDir(), "genfiles")
+	if err := os.Symlink(target, filepath.Join(ws, conf.DefaultGenDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		gpf.FlushCaches()
+		gpf.FlushCaches()
+	})
+	if n := strings.Count(out, "Warning, gen dir "+conf.DefaultGenDir); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, out)
+	}
+	if n := len(ch); n != 1 {
+		t.Fatalf("%d error events, want 1", n)
+	}
+	if ev := <-ch; ev.Name != conf.DefaultGenDir || ev.Status != fuse.ENOENT {
+		t.Errorf("error event = %+v", ev)
+	}
+
+	// Source files are still served.
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading foo/a.go = %q, %v", got, status)
+	}
+	if _, status := gpf.GetAttr(testPrefix+"/foo/a.pb.go", nil); status != fuse.ENOENT {
+		t.Errorf("GetAttr(foo/a.pb.go) = %v, want ENOENT", status)
+	}
+
+	// Once built again, generated files are served, and the next time the
+	// link dangles is reported again.
+	writeFile(t, filepath.Join(target, "foo", "a.pb.go"), "package foo\n")
+	out = captureStdout(t, gpf.FlushCaches)
+	if strings.Contains(out, "Warning, gen dir") {
+		t.Errorf("warned about an existing gen dir:\n%s", out)
+	}
+	if _, status := readMountFile(t, gpf, testPrefix+"/foo/a.pb.go"); status != fuse.OK {
+		t.Errorf("reading foo/a.pb.go = %v", status)
+	}
+	if err := os.RemoveAll(target); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, gpf.FlushCaches)
+	if n := strings.Count(out, "Warning, gen dir "+conf.DefaultGenDir); n != 1 {
+		t.Errorf("warned %d times after the gen dir went away again, want once:\n%s", n, out)
+	}
+}
@@ -0,0 +1,38 @@
+package gopathfs
+
+import (
+	"strings"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// DirtyFiles returns the files of the workspace (or of the configured git
+// worktree) which are modified, staged or untracked, as listed by "git
+// status", i.e., whose content served by the mount isn't the committed one.
+func DirtyFiles(cfg *conf.GobazelConf, workspace string) ([]string, error) {
+	st, err := newSettings(cfg, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runGit(st.workspace, "status", "--porcelain", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		// "XY <path>", renames and copies are followed by the original
+		// path as a separate entry.
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		files = append(files, e[3:])
+		if e[0] == 'R' || e[0] == 'C' {
+			i++
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,41 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDirtyFiles(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{
+		"foo/a.go": "package foo\n",
+		"foo/b.go": "package foo\n",
+		"foo/c.go": "package foo\n",
+	}, nil, nil)
+
+	files, err := DirtyFiles(testConfig(), repo)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("DirtyFiles of a clean tree = %v, %v, want none", files, err)
+	}
+
+	writeFile(t, filepath.Join(repo, "foo", "a.go"), "package foo // modified\n")
+	writeFile(t, filepath.Join(repo, "foo", "new.go"), "package foo\n")
+	if err := os.Remove(filepath.Join(repo, "foo", "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(repo, "mv", "foo/c.go", "foo/renamed.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err = DirtyFiles(testConfig(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	want := []string{"foo/a.go", "foo/b.go", "foo/new.go", "foo/renamed.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("DirtyFiles of a dirty tree = %v, want %v", files, want)
+	}
+}
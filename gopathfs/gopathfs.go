@@ -5,8 +5,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
@@ -17,6 +19,13 @@ import (
 
 var (
 	pathSeparator = string(os.PathSeparator)
+
+	// Files at the workspace root which are served under the prefix
+	// package.
+	moduleFiles = map[string]struct{}{
+		"go.mod": {},
+		"go.sum": {},
+	}
 )
 
 // Dirs contains directory paths for GoPathFs.
@@ -33,88 +42,215 @@ type Dirs struct {
 // GoPathFs implements a virtual tree for src folder of GOPATH.
 type GoPathFs struct {
 	pathfs.FileSystem
-	debug         bool
-	dirs          *Dirs
-	cfg           *conf.GobazelConf
-	ignoreRegexes []*regexp.Regexp
-	notifyCh      chan notify.EventInfo
+	debug       bool
+	opts        options // Those given to New, kept across reloads.
+	dirs        *Dirs
+	settings    atomic.Value // Of *settings, replaced on reload.
+	notifyCh    chan notify.EventInfo
+	cfgNotifyCh chan notify.EventInfo
+	genSource   ContentSource
+	snapshot    *GitSnapshot
+	nodeFs      *pathfs.PathNodeFs
+	attrCache   *attrCache
+	dirGrace    *dirGrace
+	stats       stats
+	ops         opLog
+	resolvers   []Resolver
+	overrides   dirOverrides
+	roots       rootCache
+	pathLocks   pathLocks
+	templates   templateCache
+	quarantine  quarantine
+	archDirs    archGenDirCache
+	pinsUsed    sync.Map // Prefixes of the path pins used so far.
+	errorEvents chan<- ErrorEvent
+	auditLogger AuditLogger
+
+	metadataProviders []MetadataProvider
+	tracer            Tracer
+
+	danglingGenDirs sync.Map // Gen dirs warned about being dangling links.
+
+	modulesTxtMu   sync.Mutex
+	modulesTxtData []byte
+
+	// Closed once modulesTxtData is built, guarded by modulesTxtMu like the
+	// build state.
+	modulesTxtBuilt    chan struct{}
+	modulesTxtBuilding bool
+	modulesTxtStale    bool // Changed while building.
+
+	stopStatsLog chan struct{}
+	noGoRootOnce sync.Once
+
+	consistencyMu        sync.Mutex
+	consistencyStarted   bool          // Between mount and unmount.
+	stopConsistencyCheck chan struct{} // Of the check running, if any.
+
+	shutdownMu   sync.RWMutex
+	shuttingDown bool
+	inFlight     int64         // Accessed atomically.
+	drained      chan struct{} // Closed once no operation is in flight.
+
+	openFilesMu sync.Mutex
+	openFiles   map[*loopbackFile]struct{}
+
+	vendorsMu       sync.RWMutex
+	existingVendors []vendorDir
+
+	caseMu         sync.Mutex
+	caseCollisions []CaseCollision
+
+	devIndexes devIndexes
+
+	crlfs crlfCache
+
+	gzipSizes gzipSizes
+
+	buildMatches buildMatches
+
+	validation validation
+
+	docGos docGoDirs
 }
 
 // Access overwrites the parent's Access method.
 func (gpf *GoPathFs) Access(name string, mode uint32, context *fuse.Context) (code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	defer gpf.watchOp("access", name, time.Now(), &code, nil)
 	return fuse.OK
 }
 
 // OnMount overwrites the parent's OnMount method.
 func (gpf *GoPathFs) OnMount(nodeFs *pathfs.PathNodeFs) {
-	if err := notify.Watch(filepath.Join(gpf.dirs.Workspace, "..."), gpf.notifyCh, notify.All); err != nil {
+	gpf.nodeFs = nodeFs
+
+	if interval := gpf.config().StatsIntervalDuration; interval > 0 {
+		gpf.stopStatsLog = make(chan struct{})
+		go gpf.logStats(interval, gpf.stopStatsLog)
+	}
+	gpf.startConsistencyCheck()
+	gpf.rebuildModulesTxt()
+
+	if err := notify.Watch(filepath.Join(gpf.workspace(), "..."), gpf.notifyCh, notify.All); err != nil {
 		log.Fatal(err)
 	}
 
 	go func() {
 		for ei := range gpf.notifyCh {
-			path := ei.Path()[len(gpf.dirs.Workspace+pathSeparator):]
-			gpf.notifyFileChange(nodeFs, path)
+			// The workspace may have switched to another worktree.
+			workspace := gpf.workspace() + pathSeparator
+			if !strings.HasPrefix(ei.Path(), workspace) {
+				continue
+			}
+			gpf.notifyFileChange(nodeFs, ei.Path()[len(workspace):])
 		}
 	}()
 }
 
 // OnUnmount overwrites the parent's OnUnmount method.
 func (gpf *GoPathFs) OnUnmount() {
+	if gpf.debug {
+		fmt.Println(gpf.Stats())
+	}
+
+	if gpf.stopStatsLog != nil {
+		close(gpf.stopStatsLog)
+	}
+	gpf.endConsistencyCheck()
+
 	notify.Stop(gpf.notifyCh)
+	if gpf.cfgNotifyCh != nil {
+		notify.Stop(gpf.cfgNotifyCh)
+	}
 }
 
-func (gpf *GoPathFs) notifyFileChange(nodeFs *pathfs.PathNodeFs, path string) {
+// changeNotifier is the part of pathfs.PathNodeFs changes are notified to
+// the kernel through.
+type changeNotifier interface {
+	Notify(path string) fuse.Status
+	FileNotify(path string, off int64, length int64) fuse.Status
+}
+
+func (gpf *GoPathFs) notifyFileChange(nodeFs changeNotifier, path string) {
+	if filepath.Base(path) == dirOverrideFileName {
+		gpf.overrides.clear()
+		gpf.attrCache.clear()
+	}
+
 	if gpf.isIgnored(path) {
 		return
 	}
+	if gpf.isModuleFile(path) {
+		gpf.rebuildModulesTxt()
+	}
 
 	if strings.HasSuffix(path, pathSeparator+".git") || strings.Contains(path, pathSeparator+".git"+pathSeparator) {
 		return
 	}
 
-	go nodeFs.Notify(filepath.Join(gpf.cfg.GoPkgPrefix, path))
+	gpf.attrCache.invalidate(filepath.Join(gpf.config().GoPkgPrefix, path))
+	gpf.docGos.forget(filepath.Join(gpf.config().GoPkgPrefix, path))
+	gpf.roots.forget(filepath.Join(gpf.config().GoPkgPrefix, path))
+	go nodeFs.Notify(filepath.Join(gpf.config().GoPkgPrefix, path))
 
 	isVendor := false
-	for _, vendor := range gpf.cfg.Vendors {
+	for _, vendor := range gpf.config().Vendors {
 		if strings.HasPrefix(path, vendor+pathSeparator) {
 			isVendor = true
+			gpf.attrCache.invalidate(path[len(vendor+pathSeparator):])
+			gpf.roots.forget(path[len(vendor+pathSeparator):])
 			nodeFs.FileNotify(path[len(vendor+pathSeparator):], 0, 0)
 			break
 		}
 	}
 
+	// Fall-through directories are also served at the top level.
+	isFallThrough := !isVendor && gpf.isFallThrough(path)
+	if isFallThrough {
+		gpf.attrCache.invalidate(path)
+		gpf.docGos.forget(path)
+		gpf.roots.forget(path)
+		nodeFs.FileNotify(path, 0, 0)
+	}
+
 	// If it's a proto file, run bazel build.
 	if strings.HasSuffix(path, ".proto") {
 		bzlPkg := filepath.Dir(path) + ":*"
-		exec.RunBazelBuild(gpf.dirs.Workspace, bzlPkg)
+		exec.RunBazelBuild(gpf.workspace(), bzlPkg)
 	}
 
 	// Run go install.
 	if strings.HasSuffix(path, ".proto") || strings.HasSuffix(path, ".go") {
 		goPkg := filepath.Dir(path)
-		if !isVendor {
-			goPkg = filepath.Join(gpf.cfg.GoPkgPrefix, goPkg)
+		if !isVendor && !isFallThrough {
+			goPkg = filepath.Join(gpf.config().GoPkgPrefix, goPkg)
 		}
-		exec.RunGoInstall(gpf.cfg, goPkg)
+		exec.RunGoInstall(gpf.config(), goPkg)
 	}
 }
 
+// normalizeName trims trailing slashes from mount paths, so that e.g.
+// "<prefix>/" is routed the same as "<prefix>".
+func normalizeName(name string) string {
+	return strings.TrimRight(name, pathSeparator)
+}
+
 func (gpf *GoPathFs) isIgnored(dir string) bool {
 	if strings.HasPrefix(dir, ".") {
 		return true
 	}
 
-	for _, re := range gpf.ignoreRegexes {
-		if re.MatchString(dir) {
-			return true
-		}
-	}
-	return false
+	re := gpf.loadSettings().ignoreMatcher
+	return re != nil && re.MatchString(dir)
 }
 
 func (gpf *GoPathFs) isVendorDir(dir string) bool {
-	for _, vendor := range gpf.cfg.Vendors {
+	for _, vendor := range gpf.config().Vendors {
 		if dir == vendor {
 			return true
 		}
@@ -125,40 +261,52 @@ func (gpf *GoPathFs) isVendorDir(dir string) bool {
 	return false
 }
 
-// NewGoPathFs returns a new GoPathFs.
+// NewGoPathFs returns a new GoPathFs. It exits if the config is invalid.
 func NewGoPathFs(debug bool, cfg *conf.GobazelConf, dirs *Dirs) *GoPathFs {
-	ignoreRegexes := make([]*regexp.Regexp, len(cfg.Ignores))
-	for i, ign := range cfg.Ignores {
-		ignoreRegexes[i] = regexp.MustCompile(ign)
+	gpfs, err := newGoPathFs(dirs, options{debug: debug, cfg: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return gpfs
+}
+
+func newGoPathFs(dirs *Dirs, o options) (*GoPathFs, error) {
+	cfg := o.withOptions(o.cfg)
+	st, err := newSettings(cfg, dirs.Workspace)
+	if err != nil {
+		return nil, err
 	}
 
 	gpfs := GoPathFs{
-		FileSystem:    pathfs.NewDefaultFileSystem(),
-		debug:         debug,
-		dirs:          dirs,
-		cfg:           cfg,
-		ignoreRegexes: ignoreRegexes,
-		notifyCh:      make(chan notify.EventInfo, 10),
-	}
-
-	// Find the go-sdk in bazel external folder. The debugger can use the same
-	// go-sdk source code for debugging.
-	found := false
-	if fi, err := os.Lstat("bazel-out"); err == nil {
-		if fi.Mode()&os.ModeSymlink != 0 {
-			if target, err := os.Readlink("bazel-out"); err == nil {
-				target = filepath.ToSlash(target)
-				suffix := filepath.Join("execroot", "__main__", "bazel-out")
-				if strings.HasSuffix(target, suffix) {
-					gpfs.dirs.GoSDKDir = filepath.Join(target[:len(target)-len(suffix)], "external", "go_sdk")
-					found = true
-				}
-			}
-		}
+		FileSystem:  pathfs.NewDefaultFileSystem(),
+		debug:       o.debug,
+		opts:        o,
+		dirs:        dirs,
+		notifyCh:    make(chan notify.EventInfo, 10),
+		attrCache:   newAttrCache(),
+		dirGrace:    newDirGrace(),
+		openFiles:   map[*loopbackFile]struct{}{},
+		auditLogger: o.auditLogger,
+		tracer:      o.tracer,
+		errorEvents: o.errorEvents,
 	}
-	if !found {
-		fmt.Println("Could not find symbolic link \"bazel-out\", debugger will not find Go SDK source codes.")
+	st.goSDKDir = findGoSDK(st.baseWorkspace, cfg.GoSDKAutoDetect)
+	st.scanSem = newScanSem(cfg.ScanConcurrencyLimit)
+	dirs.GoSDKDir = st.goSDKDir
+	gpfs.settings.Store(st)
+	gpfs.modulesTxtBuilt = make(chan struct{})
+
+	gpfs.checkVendors()
+	gpfs.checkGenDirs()
+
+	if cfg.GitRevision != "" {
+		snapshot, err := NewGitSnapshot(gpfs.workspace(), cfg.GitRevision)
+		if err != nil {
+			return nil, err
+		}
+		gpfs.snapshot = snapshot
+		fmt.Printf("Serving first-party files read-only from git revision %s (%s).\n", cfg.GitRevision, snapshot.Commit())
 	}
 
-	return &gpfs
+	return &gpfs, nil
 }
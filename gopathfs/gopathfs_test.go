@@ -0,0 +1,241 @@
+package gopathfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// testPrefix is the go-pkg-prefix of the test mounts.
+const testPrefix = "example.com/x"
+
+// testConfig returns a config as ParseConfig returns it for a config file
+// setting go-pkg-prefix and a "vendor" vendor-dirs only.
+func testConfig() *conf.GobazelConf {
+	return &conf.GobazelConf{
+		GoPkgPrefix:           testPrefix,
+		Vendors:               []string{"vendor"},
+		VendorSet:             map[string]struct{}{"vendor": {}},
+		GenDirs:               []string{conf.DefaultGenDir},
+		IgnoreSet:             map[string]struct{}{},
+		FallThroughSet:        map[string]struct{}{},
+		ConsistencyCheckCount: 16,
+	}
+}
+
+// newTestFs returns a GoPathFs serving a new, empty workspace with the given
+// config, or testConfig if nil, and the workspace.
+func newTestFs(t testing.TB, cfg *conf.GobazelConf, opts ...Option) (*GoPathFs, string) {
+	t.Helper()
+	if cfg == nil {
+		cfg = testConfig()
+	}
+	ws := t.TempDir()
+	if err := os.Mkdir(filepath.Join(ws, "vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := Dirs{
+		Workspace: ws,
+		SrcDir:    filepath.Join(t.TempDir(), "src"),
+	}
+	gpf, err := New(dirs, append([]Option{WithConfig(cfg)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gpf, ws
+}
+
+// writeFile writes the given file, creating its directory.
+func writeFile(t testing.TB, name, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readMountFile reads the given mount path through Open and Read.
+func readMountFile(t testing.TB, gpf *GoPathFs, name string) (string, fuse.Status) {
+	t.Helper()
+	f, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		return "", status
+	}
+	defer f.Release()
+
+	buf := make([]byte, 1<<16)
+	res, status := f.Read(buf, 0)
+	if status != fuse.OK {
+		return "", status
+	}
+	data, status := res.Bytes(buf)
+	return string(data), status
+}
+
+// listNames returns the names OpenDir lists for the given mount directory.
+func listNames(t testing.TB, gpf *GoPathFs, dir string) map[string]bool {
+	t.Helper()
+	entries, status := gpf.OpenDir(dir, nil)
+	if status != fuse.OK {
+		t.Fatalf("OpenDir(%q) = %v", dir, status)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name] = true
+	}
+	return names
+}
+
+// memSource is a ContentSource serving files from memory, which counts the
+// files opened.
+type memSource struct {
+	files map[string]string
+
+	mu    sync.Mutex
+	opens int
+}
+
+func (ms *memSource) Open(name string) (io.ReaderAt, int64, error) {
+	ms.mu.Lock()
+	ms.opens++
+	ms.mu.Unlock()
+
+	data, ok := ms.files[name]
+	if !ok {
+		return nil, 0, os.ErrNotExist
+	}
+	return bytes.NewReader([]byte(data)), int64(len(data)), nil
+}
+
+func (ms *memSource) openCount() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.opens
+}
+
+// sizedMemSource is a memSource which also implements sizer.
+type sizedMemSource struct {
+	*memSource
+}
+
+func (ss sizedMemSource) Size(name string) (int64, error) {
+	data, ok := ss.files[name]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(data)), nil
+}
+
+func TestOpenFirstPartyAndVendorFiles(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "b.go"), "package y\n")
+
+	for name, want := range map[string]string{
+		testPrefix + "/foo/a.go": "package foo\n",
+		"github.com/y/b.go":      "package y\n",
+	} {
+		got, status := readMountFile(t, gpf, name)
+		if status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+		}
+	}
+	if _, status := gpf.GetAttr(testPrefix+"/foo/missing.go", nil); status != fuse.ENOENT {
+		t.Errorf("GetAttr of a missing file = %v, want ENOENT", status)
+	}
+}
+
+func TestTrailingSlashes(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "b.go"), "package y\n")
+
+	for _, dir := range []string{testPrefix, testPrefix + "/foo", "github.com/y"} {
+		want, status := gpf.GetAttr(dir, nil)
+		if status != fuse.OK {
+			t.Fatalf("GetAttr(%q) = %v", dir, status)
+		}
+		for _, name := range []string{dir + "/", dir + "//"} {
+			got, status := gpf.GetAttr(name, nil)
+			if status != fuse.OK || got.Ino != want.Ino || got.Mode != want.Mode {
+				t.Errorf("GetAttr(%q) = %v, %v, want %v", name, got, status, want)
+			}
+			if names := listNames(t, gpf, name); !reflect.DeepEqual(names, listNames(t, gpf, dir)) {
+				t.Errorf("OpenDir(%q) = %v, want those of %q", name, names, dir)
+			}
+		}
+	}
+
+	if status := gpf.Mkdir(testPrefix+"/bar/", 0755, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	if fi, err := os.Stat(filepath.Join(ws, "bar")); err != nil || !fi.IsDir() {
+		t.Errorf("bar/ not created as bar in the workspace: %v", err)
+	}
+	if status := gpf.Rmdir(testPrefix+"/bar/", nil); status != fuse.OK {
+		t.Errorf("Rmdir = %v", status)
+	}
+}
+
+func TestFileChangeInvalidatesFallThroughPaths(t *testing.T) {
+	cfg := cachingConfig()
+	cfg.FallThrough = []string{"tools"}
+	cfg.FallThroughSet = map[string]struct{}{"tools": {}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "tools", "data.txt"), "a")
+	notifier := &recordingNotifier{}
+
+	for _, name := range []string{"tools/data.txt", testPrefix + "/tools/data.txt"} {
+		if _, status := gpf.GetAttr(name, nil); status != fuse.OK {
+			t.Fatalf("GetAttr(%s) = %v", name, status)
+		}
+	}
+	gpf.notifyFileChange(notifier, "tools/data.txt")
+	for _, name := range []string{"tools/data.txt", testPrefix + "/tools/data.txt"} {
+		if _, cached := gpf.attrCache.get(name); cached {
+			t.Errorf("attributes of %s are still cached after a change", name)
+		}
+	}
+	if !notifier.notified("tools/data.txt") {
+		t.Errorf("the kernel wasn't notified of the change of tools/data.txt")
+	}
+}
+
+// recordingNotifier is a changeNotifier which records the paths notified.
+type recordingNotifier struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (rn *recordingNotifier) Notify(path string) fuse.Status {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rn.paths = append(rn.paths, path)
+	return fuse.OK
+}
+
+func (rn *recordingNotifier) FileNotify(path string, off int64, length int64) fuse.Status {
+	return rn.Notify(path)
+}
+
+func (rn *recordingNotifier) notified(path string) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for _, p := range rn.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
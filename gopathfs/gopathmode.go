@@ -0,0 +1,38 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// hidesModFile returns true if the given mount path is a first-party go.mod
+// or go.sum hidden with gopath-mode, which would otherwise switch the go
+// command into module mode.
+func (gpf *GoPathFs) hidesModFile(name string) bool {
+	if !gpf.config().GopathMode {
+		return false
+	}
+	if base := filepath.Base(name); base != "go.mod" && base != "go.sum" {
+		return false
+	}
+	return strings.HasPrefix(name, gpf.config().GoPkgPrefix+pathSeparator) && !gpf.isGoRoot(name)
+}
+
+// withoutModFiles drops the hidden go.mod and go.sum from the listing of the
+// given mount directory.
+func (gpf *GoPathFs) withoutModFiles(dir string, entries []fuse.DirEntry) []fuse.DirEntry {
+	if !gpf.config().GopathMode {
+		return entries
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR == 0 && gpf.hidesModFile(filepath.Join(dir, e.Name)) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
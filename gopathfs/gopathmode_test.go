@@ -0,0 +1,37 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestGopathModeHidesModFiles(t *testing.T) {
+	for _, gopathMode := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.GopathMode = gopathMode
+		gpf, ws := newTestFs(t, cfg)
+		writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+		writeFile(t, filepath.Join(ws, "foo", "go.mod"), "module example.com/x/foo\n")
+		writeFile(t, filepath.Join(ws, "foo", "go.sum"), "")
+		writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "go.mod"), "module github.com/y\n")
+
+		names := listNames(t, gpf, testPrefix+"/foo")
+		for _, name := range []string{"go.mod", "go.sum"} {
+			_, status := gpf.GetAttr(testPrefix+"/foo/"+name, nil)
+			_, openStatus := readMountFile(t, gpf, testPrefix+"/foo/"+name)
+			if visible := names[name] && status == fuse.OK && openStatus == fuse.OK; visible == gopathMode {
+				t.Errorf("with gopath-mode %v, %s listed %v, GetAttr %v, Open %v", gopathMode, name, names[name], status, openStatus)
+			}
+		}
+		if !names["a.go"] {
+			t.Errorf("with gopath-mode %v, a.go isn't listed", gopathMode)
+		}
+
+		// Vendored modules are left alone.
+		if _, status := gpf.GetAttr("github.com/y/go.mod", nil); status != fuse.OK {
+			t.Errorf("with gopath-mode %v, GetAttr of a vendored go.mod = %v", gopathMode, status)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// detectGoRoot returns the GOROOT of the go command on PATH.
+func detectGoRoot() (string, error) {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run \"go env GOROOT\", %v", err)
+	}
+
+	goRoot := strings.TrimSpace(string(out))
+	if goRoot == "" {
+		return "", fmt.Errorf("\"go env GOROOT\" returned nothing")
+	}
+	if fi, err := os.Stat(filepath.Join(goRoot, "src", "runtime")); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s doesn't look like a Go SDK, src/runtime not found", goRoot)
+	}
+	return goRoot, nil
+}
+
+// findGoSDK returns the Go SDK GOROOT is served from for the given
+// workspace, i.e., the go-sdk in the bazel external folder, which the
+// debugger can use for its source code too, or with autoDetect, the Go SDK
+// on PATH. It returns an empty string if none is found.
+func findGoSDK(workspace string, autoDetect bool) string {
+	bazelOut := filepath.Join(workspace, "bazel-out")
+	if fi, err := os.Lstat(bazelOut); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(bazelOut); err == nil {
+			target = filepath.ToSlash(target)
+			suffix := filepath.Join("execroot", "__main__", "bazel-out")
+			if strings.HasSuffix(target, suffix) {
+				return filepath.Join(target[:len(target)-len(suffix)], "external", "go_sdk")
+			}
+		}
+	}
+	if autoDetect {
+		goRoot, err := detectGoRoot()
+		if err == nil {
+			fmt.Printf("Using Go SDK %s for GOROOT.\n", goRoot)
+			return goRoot
+		}
+		fmt.Printf("Failed to detect the Go SDK, %v.\n", err)
+	}
+	fmt.Println("Could not find symbolic link \"bazel-out\", debugger will not find Go SDK source codes.")
+	return ""
+}
+
+// goSDKDir returns the Go SDK GOROOT is served from, or an empty string if
+// none was found.
+func (gpf *GoPathFs) goSDKDir() string {
+	return gpf.loadSettings().goSDKDir
+}
+
+// goRootAvailable returns true if a Go SDK was found to serve GOROOT from.
+// Otherwise GOROOT paths don't exist, which is reported once.
+func (gpf *GoPathFs) goRootAvailable() bool {
+	if gpf.goSDKDir() != "" {
+		return true
+	}
+	gpf.noGoRootOnce.Do(func() {
+		fmt.Println("Warning, GOROOT was requested but no Go SDK was found, GOROOT passthrough is disabled. Set go-sdk-auto-detect to use the Go SDK on PATH.")
+	})
+	return false
+}
+
+// goRootAllowed returns true if the given path relative to GOROOT is served,
+// i.e., it's within one of the configured goroot-subtrees or one of their
+// parents. Everything is served if no subtrees are configured.
+func (gpf *GoPathFs) goRootAllowed(rel string) bool {
+	subtrees := gpf.config().GoRootSubtrees
+	rel = strings.Trim(rel, pathSeparator)
+	if len(subtrees) == 0 || rel == "" {
+		return true
+	}
+
+	for _, st := range subtrees {
+		st = strings.Trim(st, pathSeparator)
+		if rel == st || strings.HasPrefix(rel, st+pathSeparator) || strings.HasPrefix(st, rel+pathSeparator) {
+			return true
+		}
+	}
+	return false
+}
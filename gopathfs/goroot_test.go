@@ -0,0 +1,117 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// newGoRootFs returns a GoPathFs like newTestFs, serving GOROOT from the Go
+// SDK in the bazel external folder of its workspace, which it also returns.
+func newGoRootFs(t *testing.T, cfg *conf.GobazelConf) (*GoPathFs, string) {
+	t.Helper()
+	if cfg == nil {
+		cfg = testConfig()
+	}
+	ws, sdk := newBazelWorkspace(t)
+	writeFile(t, filepath.Join(sdk, "src", "fmt", "print.go"), "package fmt\n")
+
+	gpf, err := New(Dirs{Workspace: ws, SrcDir: filepath.Join(t.TempDir(), "src")}, WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gpf, sdk
+}
+
+func TestGoRootReadOnly(t *testing.T) {
+	gpf, sdk := newGoRootFs(t, nil)
+	goRoot := testPrefix + "/GOROOT"
+	file := goRoot + "/src/fmt/print.go"
+
+	for method, status := range map[string]fuse.Status{
+		"Create": func() fuse.Status {
+			_, status := gpf.Create(goRoot+"/src/fmt/new.go", uint32(os.O_WRONLY), 0644, nil)
+			return status
+		}(),
+		"Open for writing": func() fuse.Status {
+			_, status := gpf.Open(file, uint32(os.O_WRONLY), nil)
+			return status
+		}(),
+		"Unlink":          gpf.Unlink(file, nil),
+		"Rename":          gpf.Rename(file, goRoot+"/src/fmt/moved.go", nil),
+		"Rename into":     gpf.Rename(testPrefix+"/foo.go", goRoot+"/src/fmt/foo.go", nil),
+		"Truncate":        gpf.Truncate(file, 0, nil),
+		"Chmod":           gpf.Chmod(file, 0600, nil),
+		"Mkdir":           gpf.Mkdir(goRoot+"/src/newpkg", 0755, nil),
+		"Rmdir":           gpf.Rmdir(goRoot+"/src/fmt", nil),
+		"Mkdir of GOROOT": gpf.Mkdir(goRoot, 0755, nil),
+	} {
+		if status != fuse.EROFS {
+			t.Errorf("%s = %v, want EROFS", method, status)
+		}
+	}
+
+	// The Go SDK is untouched.
+	if got, status := readMountFile(t, gpf, file); status != fuse.OK || got != "package fmt\n" {
+		t.Errorf("reading %s = %q, %v", file, got, status)
+	}
+	for _, name := range []string{"new.go", "moved.go", "foo.go"} {
+		if _, err := os.Lstat(filepath.Join(sdk, "src", "fmt", name)); !os.IsNotExist(err) {
+			t.Errorf("%s created in the Go SDK", name)
+		}
+	}
+}
+
+func TestGoRootSubtrees(t *testing.T) {
+	cfg := testConfig()
+	cfg.GoRootSubtrees = []string{"src/runtime", "/src/fmt/"}
+	gpf, sdk := newGoRootFs(t, cfg)
+	writeFile(t, filepath.Join(sdk, "src", "runtime", "proc.go"), "package runtime\n")
+	writeFile(t, filepath.Join(sdk, "src", "net", "http", "server.go"), "package http\n")
+	writeFile(t, filepath.Join(sdk, "pkg", "tool", "compile"), "")
+	goRoot := testPrefix + "/GOROOT"
+
+	for _, name := range []string{"/src/runtime/proc.go", "/src/fmt/print.go"} {
+		if _, status := readMountFile(t, gpf, goRoot+name); status != fuse.OK {
+			t.Errorf("reading GOROOT%s = %v", name, status)
+		}
+	}
+	for _, name := range []string{"/src/net", "/src/net/http/server.go", "/pkg", "/pkg/tool/compile"} {
+		if _, status := gpf.GetAttr(goRoot+name, nil); status != fuse.ENOENT {
+			t.Errorf("GetAttr(GOROOT%s) = %v, want ENOENT", name, status)
+		}
+	}
+
+	// The parents of the subtrees are listed with only what leads to them.
+	if names := listNames(t, gpf, goRoot); !names["src"] || names["pkg"] {
+		t.Errorf("GOROOT lists %v, want src only", names)
+	}
+	if names := listNames(t, gpf, goRoot+"/src"); !names["runtime"] || !names["fmt"] || names["net"] {
+		t.Errorf("GOROOT/src lists %v, want runtime and fmt only", names)
+	}
+}
+
+func TestGoRootWithoutGoSDK(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	if sdk := gpf.goSDKDir(); sdk != "" {
+		t.Fatalf("Go SDK %s found without bazel-out", sdk)
+	}
+
+	out := captureStdout(t, func() {
+		for _, name := range []string{"/GOROOT", "/GOROOT/src", "/GOROOT/src/fmt/print.go"} {
+			if _, status := gpf.GetAttr(testPrefix+name, nil); status != fuse.ENOENT {
+				t.Errorf("GetAttr(%s) = %v, want ENOENT", name, status)
+			}
+		}
+		if _, status := readMountFile(t, gpf, testPrefix+"/GOROOT/src/fmt/print.go"); status != fuse.ENOENT {
+			t.Errorf("reading GOROOT/src/fmt/print.go = %v, want ENOENT", status)
+		}
+	})
+	if n := strings.Count(out, "GOROOT passthrough is disabled"); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, out)
+	}
+}
@@ -0,0 +1,86 @@
+package gopathfs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Entries beyond which expired directories are pruned from dirGrace.
+const maxGraceDirs = 10000
+
+// dirGrace remembers first-party directories seen recently, so that they
+// can be served as empty directories for a grace period if they disappear,
+// e.g., while a concurrent build recreates generated files.
+type dirGrace struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newDirGrace() *dirGrace {
+	return &dirGrace{
+		seen: map[string]time.Time{},
+	}
+}
+
+// rememberDir records that the given mount path is a first-party directory.
+func (gpf *GoPathFs) rememberDir(name string) {
+	grace := gpf.config().MissingDirGraceTimeout
+	if grace <= 0 || !strings.HasPrefix(name, gpf.config().GoPkgPrefix+pathSeparator) {
+		return
+	}
+
+	dg := gpf.dirGrace
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+
+	now := time.Now()
+	if len(dg.seen) > maxGraceDirs {
+		for n, t := range dg.seen {
+			if now.Sub(t) > grace {
+				delete(dg.seen, n)
+			}
+		}
+	}
+	dg.seen[name] = now
+}
+
+// forgetDir drops the given mount path and the directories below it, which
+// were removed or renamed through the mount, so that they aren't served as
+// empty directories afterwards.
+func (gpf *GoPathFs) forgetDir(name string) {
+	dg := gpf.dirGrace
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+
+	delete(dg.seen, name)
+	for n := range dg.seen {
+		if strings.HasPrefix(n, name+pathSeparator) {
+			delete(dg.seen, n)
+		}
+	}
+}
+
+// inGrace returns true if the given mount path was a first-party directory
+// within the grace period.
+func (gpf *GoPathFs) inGrace(name string) bool {
+	grace := gpf.config().MissingDirGraceTimeout
+	if grace <= 0 {
+		return false
+	}
+
+	dg := gpf.dirGrace
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+
+	t, ok := dg.seen[name]
+	return ok && time.Since(t) <= grace
+}
+
+func (gpf *GoPathFs) getGraceDirAttr() (*fuse.Attr, fuse.Status) {
+	return &fuse.Attr{
+		Mode: fuse.S_IFDIR | 0755,
+	}, fuse.OK
+}
@@ -0,0 +1,45 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestDirGrace(t *testing.T) {
+	cfg := testConfig()
+	cfg.MissingDirGraceTimeout = time.Minute
+	gpf, ws := newTestFs(t, cfg)
+	for _, dir := range []string{"gone", "removed", "renamed", "renamed/sub"} {
+		if err := os.MkdirAll(filepath.Join(ws, "foo", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, status := gpf.GetAttr(testPrefix+"/foo/"+dir, nil); status != fuse.OK {
+			t.Fatalf("GetAttr(%s) = %v", dir, status)
+		}
+	}
+
+	// Directories vanishing out-of-band are served for the grace period.
+	if err := os.Remove(filepath.Join(ws, "foo", "gone")); err != nil {
+		t.Fatal(err)
+	}
+	if attr, status := gpf.GetAttr(testPrefix+"/foo/gone", nil); status != fuse.OK || attr.Mode&fuse.S_IFDIR == 0 {
+		t.Errorf("GetAttr of a vanished directory = %+v, %v, want a directory", attr, status)
+	}
+
+	// Those removed or renamed through the mount aren't.
+	if status := gpf.Rmdir(testPrefix+"/foo/removed", nil); status != fuse.OK {
+		t.Fatalf("Rmdir = %v", status)
+	}
+	if status := gpf.Rename(testPrefix+"/foo/renamed", testPrefix+"/foo/moved", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	for _, dir := range []string{"removed", "renamed", "renamed/sub"} {
+		if _, status := gpf.GetAttr(testPrefix+"/foo/"+dir, nil); status != fuse.ENOENT {
+			t.Errorf("GetAttr(%s) = %v, want ENOENT", dir, status)
+		}
+	}
+}
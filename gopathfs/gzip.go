@@ -0,0 +1,202 @@
+package gopathfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+const gzipSuffix = ".gz"
+
+// decompresses returns true if the given mount path may be served from a
+// gzip compressed backing file, i.e., if its base name matches one of the
+// decompress-gz patterns.
+func (gpf *GoPathFs) decompresses(name string) bool {
+	base := filepath.Base(name)
+	for _, p := range gpf.config().DecompressGz {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withGzipped adds, after each local candidate of a mount path served
+// decompressed, its compressed counterpart, so that e.g. foo.go is served
+// from foo.go.gz unless foo.go exists.
+func (gpf *GoPathFs) withGzipped(name string, cands []candidate) []candidate {
+	if len(cands) == 0 || !gpf.decompresses(name) {
+		return cands
+	}
+
+	all := make([]candidate, 0, 2*len(cands))
+	for _, c := range cands {
+		all = append(all, c)
+		if c.src != nil {
+			continue
+		}
+		all = append(all, candidate{
+			path: c.path + gzipSuffix,
+			root: c.root,
+			rel:  c.rel,
+			kind: c.kind,
+			src:  gzipSource{root: c.root, sizes: &gpf.gzipSizes},
+		})
+	}
+	return all
+}
+
+// renameGzipped lists compressed files served decompressed under their
+// decompressed names, unless those exist too.
+func (gpf *GoPathFs) renameGzipped(entries []fuse.DirEntry) []fuse.DirEntry {
+	if len(gpf.config().DecompressGz) == 0 {
+		return entries
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		names[e.Name] = true
+	}
+
+	renamed := entries[:0]
+	for _, e := range entries {
+		if plain := strings.TrimSuffix(e.Name, gzipSuffix); plain != e.Name && e.Mode&fuse.S_IFREG != 0 && gpf.decompresses(plain) {
+			if names[plain] {
+				continue
+			}
+			e.Name = plain
+		}
+		renamed = append(renamed, e)
+	}
+	return renamed
+}
+
+// maxGunzipSize is the decompressed size beyond which compressed files
+// aren't served, as they are decompressed in memory.
+var maxGunzipSize int64 = 1 << 30
+
+// Entries beyond which the gzipSizes starts over.
+const maxGzipSizesCached = 10000
+
+// gzipSizes keeps the decompressed sizes of compressed files by path, until
+// they change.
+type gzipSizes struct {
+	mu      sync.Mutex
+	entries map[string]gzipSizeEntry
+}
+
+type gzipSizeEntry struct {
+	modTime time.Time
+	gzSize  int64
+	size    int64
+}
+
+func (gz *gzipSizes) load(path string, fi os.FileInfo) (int64, bool) {
+	gz.mu.Lock()
+	defer gz.mu.Unlock()
+	e, ok := gz.entries[path]
+	if !ok || !e.modTime.Equal(fi.ModTime()) || e.gzSize != fi.Size() {
+		return 0, false
+	}
+	return e.size, true
+}
+
+func (gz *gzipSizes) store(path string, fi os.FileInfo, size int64) {
+	gz.mu.Lock()
+	defer gz.mu.Unlock()
+	if gz.entries == nil || len(gz.entries) >= maxGzipSizesCached {
+		gz.entries = map[string]gzipSizeEntry{}
+	}
+	gz.entries[path] = gzipSizeEntry{modTime: fi.ModTime(), gzSize: fi.Size(), size: size}
+}
+
+func (gz *gzipSizes) clear() {
+	gz.mu.Lock()
+	defer gz.mu.Unlock()
+	gz.entries = nil
+}
+
+// gzipSource serves the decompressed content of the gzip compressed files in
+// root. It's read-only, like any content source.
+type gzipSource struct {
+	root  string
+	sizes *gzipSizes
+}
+
+// gunzip decompresses the given compressed file into w, and returns the
+// decompressed size. It fails with EFBIG beyond maxGunzipSize. The reader
+// checks the size and checksum of every member.
+func gunzip(f *os.File, w io.Writer) (int64, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, io.LimitReader(zr, maxGunzipSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if n > maxGunzipSize {
+		return 0, fmt.Errorf("%s decompresses to more than %d bytes, %w", f.Name(), maxGunzipSize, syscall.EFBIG)
+	}
+	return n, nil
+}
+
+// Open implements ContentSource.
+func (gs gzipSource) Open(name string) (io.ReaderAt, int64, error) {
+	path := filepath.Join(gs.root, name) + gzipSuffix
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buf := bytes.Buffer{}
+	if size, ok := gs.sizes.load(path, fi); ok {
+		buf.Grow(int(size))
+	}
+	size, err := gunzip(f, &buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	gs.sizes.store(path, fi, size)
+	return bytes.NewReader(buf.Bytes()), size, nil
+}
+
+// Size returns the decompressed size of the given file. The gzip trailer
+// has the size modulo 4GiB of the last member only, so the file is
+// decompressed once, and its size cached until it changes.
+func (gs gzipSource) Size(name string) (int64, error) {
+	path := filepath.Join(gs.root, name) + gzipSuffix
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if size, ok := gs.sizes.load(path, fi); ok {
+		return size, nil
+	}
+	size, err := gunzip(f, io.Discard)
+	if err != nil {
+		return 0, err
+	}
+	gs.sizes.store(path, fi, size)
+	return size, nil
+}
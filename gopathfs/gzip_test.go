@@ -0,0 +1,65 @@
+package gopathfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// writeGzip writes the given file with a gzip member per part.
+func writeGzip(t *testing.T, name string, parts ...string) {
+	t.Helper()
+	buf := bytes.Buffer{}
+	for _, p := range parts {
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, name, buf.String())
+}
+
+func TestGzipMultiMember(t *testing.T) {
+	cfg := testConfig()
+	cfg.DecompressGz = []string{"*.txt"}
+	gpf, ws := newTestFs(t, cfg)
+	writeGzip(t, filepath.Join(ws, "foo", "a.txt.gz"), "first member\n", "second\n")
+	name := testPrefix + "/foo/a.txt"
+	want := "first member\nsecond\n"
+
+	// The trailer has the size of the last member only.
+	attr, status := gpf.GetAttr(name, nil)
+	if status != fuse.OK || attr.Size != uint64(len(want)) {
+		t.Fatalf("GetAttr = %+v, %v, want size %d", attr, status, len(want))
+	}
+	if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != want {
+		t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+	}
+}
+
+func TestGzipMaxSize(t *testing.T) {
+	defer func(max int64) { maxGunzipSize = max }(maxGunzipSize)
+	maxGunzipSize = 1 << 10
+
+	cfg := testConfig()
+	cfg.DecompressGz = []string{"*.txt"}
+	gpf, ws := newTestFs(t, cfg)
+	writeGzip(t, filepath.Join(ws, "foo", "small.txt.gz"), strings.Repeat("x", 1<<10))
+	writeGzip(t, filepath.Join(ws, "foo", "big.txt.gz"), strings.Repeat("x", 1<<9), strings.Repeat("x", 1<<9+1))
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/small.txt"); status != fuse.OK || len(got) != 1<<10 {
+		t.Errorf("reading a file of the max size = %d bytes, %v", len(got), status)
+	}
+	if _, status := gpf.Open(testPrefix+"/foo/big.txt", uint32(os.O_RDONLY), nil); status != fuse.Status(syscall.EFBIG) {
+		t.Errorf("Open of a file over the max size = %v, want EFBIG", status)
+	}
+}
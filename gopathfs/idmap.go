@@ -0,0 +1,63 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Owners are translated with uid-map and gid-map, e.g., for rootless
+// containers whose user IDs differ from the host's: backing owners are
+// reported mapped, and owners set through the mount are mapped back. IDs not
+// in a map are passed on unchanged.
+
+// mapOwner replaces the backing owner of the given attributes with the
+// reported one.
+func (gpf *GoPathFs) mapOwner(attr *fuse.Attr) {
+	if id, ok := gpf.config().UIDMapping[attr.Uid]; ok {
+		attr.Uid = id
+	}
+	if id, ok := gpf.config().GIDMapping[attr.Gid]; ok {
+		attr.Gid = id
+	}
+}
+
+// backingOwner returns the backing owner of the given reported one.
+func (gpf *GoPathFs) backingOwner(uid, gid uint32) (uint32, uint32) {
+	return unmapID(gpf.config().UIDMapping, uid), unmapID(gpf.config().GIDMapping, gid)
+}
+
+func unmapID(mapping map[uint32]uint32, reported uint32) uint32 {
+	for backing, id := range mapping {
+		if id == reported {
+			return backing
+		}
+	}
+	return reported
+}
+
+// chownID returns the argument of os.Chown for the given ID, which is -1,
+// i.e., unchanged, for ^uint32(0).
+func chownID(id uint32) int {
+	if id == ^uint32(0) {
+		return -1
+	}
+	return int(id)
+}
+
+// chownCreated gives a file created through the mount the backing owner of
+// the caller, if the caller's owner is mapped, rather than gobazel's.
+func (gpf *GoPathFs) chownCreated(f *os.File, context *fuse.Context) {
+	if context == nil || len(gpf.config().UIDMapping) == 0 && len(gpf.config().GIDMapping) == 0 {
+		return
+	}
+
+	uid, gid := gpf.backingOwner(context.Owner.Uid, context.Owner.Gid)
+	if uid == context.Owner.Uid && gid == context.Owner.Gid {
+		return
+	}
+	if err := f.Chown(int(uid), int(gid)); err != nil && gpf.debug {
+		fmt.Printf("Failed to chown created file %s to %d:%d, %v.\n", f.Name(), uid, gid, err)
+	}
+}
@@ -0,0 +1,79 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func backingOwnerOf(t *testing.T, path string) (uint32, uint32) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
+
+func TestIDMapRoundTrip(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+
+	cfg := testConfig()
+	cfg.UIDMapping = map[uint32]uint32{1000: 0, 0: 2000}
+	cfg.GIDMapping = map[uint32]uint32{1001: 0, 0: 2001}
+	gpf, ws := newTestFs(t, cfg)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	if err := os.Chown(backing, 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	name := testPrefix + "/foo/a.go"
+
+	attr, status := gpf.GetAttr(name, nil)
+	if status != fuse.OK || attr.Uid != 0 || attr.Gid != 0 {
+		t.Fatalf("GetAttr = %+v, %v, want owner 0:0", attr, status)
+	}
+
+	// Reported owners are mapped back.
+	if status := gpf.Chown(name, 2000, 2001, nil); status != fuse.OK {
+		t.Fatalf("Chown = %v", status)
+	}
+	if uid, gid := backingOwnerOf(t, backing); uid != 0 || gid != 0 {
+		t.Errorf("backing owner = %d:%d, want 0:0", uid, gid)
+	}
+	attr, _ = gpf.GetAttr(name, nil)
+	if attr.Uid != 2000 || attr.Gid != 2001 {
+		t.Errorf("GetAttr owner = %d:%d, want 2000:2001", attr.Uid, attr.Gid)
+	}
+
+	// Unmapped owners are passed on, and -1 leaves the owner unchanged.
+	if status := gpf.Chown(name, 42, ^uint32(0), nil); status != fuse.OK {
+		t.Fatalf("Chown = %v", status)
+	}
+	if uid, gid := backingOwnerOf(t, backing); uid != 42 || gid != 0 {
+		t.Errorf("backing owner = %d:%d, want 42:0", uid, gid)
+	}
+
+	// Through a file handle too.
+	f, status := gpf.Open(name, uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	if status := f.Chown(0, 0); status != fuse.OK {
+		t.Fatalf("File.Chown = %v", status)
+	}
+	if uid, gid := backingOwnerOf(t, backing); uid != 1000 || gid != 1001 {
+		t.Errorf("backing owner = %d:%d, want 1000:1001", uid, gid)
+	}
+	out := fuse.Attr{}
+	if status := f.GetAttr(&out); status != fuse.OK || out.Uid != 0 || out.Gid != 0 {
+		t.Errorf("File.GetAttr = %+v, %v, want owner 0:0", out, status)
+	}
+}
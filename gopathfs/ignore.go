@@ -0,0 +1,73 @@
+package gopathfs
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ignoreMatcher matches names against all ignore-dirs patterns, compiled
+// once. Patterns which only look for a string, e.g., "bazel-.*", are
+// matched as strings. The others are matched in turn, since combining them
+// into one regexp makes every match slower than matching them one by one
+// (see BenchmarkIsIgnored).
+type ignoreMatcher struct {
+	literals []string
+	others   []*regexp.Regexp
+}
+
+// newIgnoreMatcher returns the matcher of the given patterns, or nil if
+// there are none.
+func newIgnoreMatcher(patterns []string) (*ignoreMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	m := &ignoreMatcher{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore-dirs pattern %s, %v", p, err)
+		}
+		if lit, ok := literalOf(p); ok {
+			m.literals = append(m.literals, lit)
+			continue
+		}
+		m.others = append(m.others, re)
+	}
+	return m, nil
+}
+
+// MatchString returns true if the given name matches any pattern.
+func (m *ignoreMatcher) MatchString(name string) bool {
+	for _, lit := range m.literals {
+		if strings.Contains(name, lit) {
+			return true
+		}
+	}
+	for _, re := range m.others {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// literalOf returns the string the given pattern looks for, if it only
+// looks for a string. A trailing ".*" doesn't change what matches.
+func literalOf(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	if re.Op == syntax.OpConcat && len(re.Sub) == 2 {
+		if last := re.Sub[1]; last.Op == syntax.OpStar && last.Sub[0].Op == syntax.OpAnyCharNotNL {
+			re = re.Sub[0]
+		}
+	}
+	if re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(re.Rune), true
+}
@@ -0,0 +1,100 @@
+package gopathfs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testIgnores are ignore-dirs patterns of both kinds the matcher tells
+// apart.
+var testIgnores = []string{
+	"bazel-.*", "third-party.*", "node_modules", `\.cache`,
+	"^out(/|$)", `\.git$`, "^docs/.*/testdata", "(?i)tmp",
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	m, err := newIgnoreMatcher(testIgnores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(m.literals, " "); got != "bazel- third-party node_modules .cache" {
+		t.Errorf("patterns matched as strings = %q", got)
+	}
+
+	for _, name := range []string{
+		"bazel-bin", "x/bazel-out", "third-party/y", "web/node_modules",
+		"a.cache", "out", "out/x", "outside", "x/out", "foo.git", "foo.git/x",
+		"docs/a/testdata", "x/docs/a/testdata", "TMP", "pkg",
+	} {
+		want := false
+		for _, p := range testIgnores {
+			if regexp.MustCompile(p).MatchString(name) {
+				want = true
+				break
+			}
+		}
+		if got := m.MatchString(name); got != want {
+			t.Errorf("MatchString(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := newIgnoreMatcher([]string{"("}); err == nil {
+		t.Errorf("newIgnoreMatcher accepted an invalid pattern")
+	}
+	if m, err := newIgnoreMatcher(nil); m != nil || err != nil {
+		t.Errorf("newIgnoreMatcher(nil) = %v, %v, want nil", m, err)
+	}
+}
+
+// BenchmarkIsIgnored compares matching the names of a large directory with
+// isIgnored against matching them with each pattern in turn, and with all
+// the patterns combined into one regexp.
+func BenchmarkIsIgnored(b *testing.B) {
+	cfg := testConfig()
+	cfg.Ignores = testIgnores
+	gpf, _ := newTestFs(b, cfg)
+	names := make([]string, 5000)
+	for i := range names {
+		names[i] = fmt.Sprintf("pkg%d", i)
+	}
+
+	b.Run("isIgnored", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, name := range names {
+				gpf.isIgnored(name)
+			}
+		}
+	})
+	b.Run("per-pattern", func(b *testing.B) {
+		res := make([]*regexp.Regexp, len(cfg.Ignores))
+		for i, p := range cfg.Ignores {
+			res[i] = regexp.MustCompile(p)
+		}
+		for i := 0; i < b.N; i++ {
+			for _, name := range names {
+				for _, re := range res {
+					if re.MatchString(name) {
+						break
+					}
+				}
+			}
+		}
+	})
+	b.Run("combined", func(b *testing.B) {
+		alts := ""
+		for i, p := range cfg.Ignores {
+			if i > 0 {
+				alts += "|"
+			}
+			alts += "(?:" + p + ")"
+		}
+		re := regexp.MustCompile(alts)
+		for i := 0; i < b.N; i++ {
+			for _, name := range names {
+				re.MatchString(name)
+			}
+		}
+	})
+}
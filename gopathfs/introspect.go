@@ -0,0 +1,100 @@
+package gopathfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// The virtual directory in the mount root with read-only views of the
+// running mount. It's only listed in debug mode, but always readable.
+const (
+	introspectDirName  = ".gobazel"
+	configJSONFileName = "config.json"
+)
+
+// effectiveConfig is the config in use, with the paths resolved.
+type effectiveConfig struct {
+	*conf.GobazelConf
+	Workspace     string
+	SrcDir        string
+	GoSDKDir      string
+	AbsGenDirs    []string
+	AbsVendorDirs []string // Existing vendor directories only.
+}
+
+// isIntrospectPath returns true if the given mount path is in the virtual
+// introspection directory.
+func isIntrospectPath(name string) bool {
+	return name == introspectDirName || strings.HasPrefix(name, introspectDirName+pathSeparator)
+}
+
+func (gpf *GoPathFs) configJSON() []byte {
+	ec := effectiveConfig{
+		GobazelConf: gpf.config(),
+		Workspace:   gpf.workspace(),
+		SrcDir:      gpf.dirs.SrcDir,
+		GoSDKDir:    gpf.goSDKDir(),
+	}
+	for _, gen := range gpf.config().GenDirs {
+		ec.AbsGenDirs = append(ec.AbsGenDirs, filepath.Join(gpf.workspace(), gen))
+	}
+	for _, v := range gpf.vendors() {
+		ec.AbsVendorDirs = append(ec.AbsVendorDirs, v.root)
+	}
+
+	data, err := json.MarshalIndent(ec, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode the config, %v.\n", err)
+		return []byte("{}\n")
+	}
+	return append(data, '\n')
+}
+
+func (gpf *GoPathFs) getIntrospectAttr(name string) (*fuse.Attr, fuse.Status) {
+	switch name {
+	case introspectDirName:
+		return &fuse.Attr{
+			Mode: fuse.S_IFDIR | 0555,
+		}, fuse.OK
+	case filepath.Join(introspectDirName, configJSONFileName):
+		return &fuse.Attr{
+			Mode: fuse.S_IFREG | 0444,
+			Size: uint64(len(gpf.configJSON())),
+		}, fuse.OK
+	}
+	return nil, fuse.ENOENT
+}
+
+func (gpf *GoPathFs) openIntrospectDir(name string) ([]fuse.DirEntry, fuse.Status) {
+	if name != introspectDirName {
+		return nil, fuse.ENOENT
+	}
+	return []fuse.DirEntry{{Name: configJSONFileName, Mode: fuse.S_IFREG}}, fuse.OK
+}
+
+func (gpf *GoPathFs) openIntrospectFile(name string, flags uint32) (nodefs.File, fuse.Status) {
+	if name != filepath.Join(introspectDirName, configJSONFileName) {
+		return nil, fuse.ENOENT
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+
+	// The content changes on reloads, bypass the kernel's page cache.
+	data := gpf.configJSON()
+	return &nodefs.WithFlags{
+		File: &contentSourceFile{
+			File: nodefs.NewDefaultFile(),
+			r:    bytes.NewReader(data),
+			size: int64(len(data)),
+		},
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, fuse.OK
+}
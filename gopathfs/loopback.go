@@ -0,0 +1,472 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"golang.org/x/sys/unix"
+)
+
+// loopbackFile wraps the go-fuse loopback file for files in the workspace.
+// It holds the backing file descriptor, not its path, so like on any Unix
+// filesystem an open file stays readable and writable after it's renamed
+// or unlinked, through the mount or not.
+type loopbackFile struct {
+	nodefs.File
+	gpf      *GoPathFs
+	name     string // The mount path, set by trackFile and updated on rename.
+	path     string // The backing path, updated on rename.
+	f        *os.File
+	writable bool
+	flags    uint32     // The open flags, set by trackFile.
+	pid      uint32     // The process which opened the file, set by trackFile.
+	owner    fuse.Owner // Of that process, set by trackFile.
+
+	// Held for reading by operations on f, so that Release doesn't close it
+	// under them.
+	mu       sync.RWMutex
+	released bool
+}
+
+func (gpf *GoPathFs) newLoopbackFile(f *os.File, writable bool) nodefs.File {
+	return &loopbackFile{
+		File:     nodefs.NewLoopbackFile(f),
+		gpf:      gpf,
+		path:     f.Name(),
+		f:        f,
+		writable: writable,
+	}
+}
+
+// trackFile associates a file opened or created through the mount with its
+// mount path, open flags and opening process, until it's released. The file
+// keeps the slot taken by checkOpenFiles.
+func (gpf *GoPathFs) trackFile(name string, file nodefs.File, flags uint32, context *fuse.Context) nodefs.File {
+	if lf, ok := file.(*loopbackFile); ok {
+		gpf.openFilesMu.Lock()
+		lf.name = name
+		lf.flags = flags
+		if context != nil {
+			lf.pid = context.Pid
+			lf.owner = context.Owner
+		}
+		gpf.openFiles[lf] = struct{}{}
+		gpf.openFilesMu.Unlock()
+	}
+	return file
+}
+
+// OpenFileInfo describes a workspace file open through the mount.
+type OpenFileInfo struct {
+	Name        string // The mount path.
+	BackingPath string
+	Flags       uint32
+	Pid         uint32 // The process which opened it, 0 if unknown.
+}
+
+// OpenFiles returns the workspace files currently open through the mount,
+// sorted by mount path, e.g., to find out what holds a file busy.
+func (gpf *GoPathFs) OpenFiles() []OpenFileInfo {
+	gpf.openFilesMu.Lock()
+	infos := make([]OpenFileInfo, 0, len(gpf.openFiles))
+	for lf := range gpf.openFiles {
+		infos = append(infos, OpenFileInfo{
+			Name:        lf.name,
+			BackingPath: lf.path,
+			Flags:       lf.flags,
+			Pid:         lf.pid,
+		})
+	}
+	gpf.openFilesMu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Pid < infos[j].Pid
+	})
+	return infos
+}
+
+// openForWriting returns true if the given backing file is open for
+// writing through the mount.
+func (gpf *GoPathFs) openForWriting(path string) bool {
+	gpf.openFilesMu.Lock()
+	defer gpf.openFilesMu.Unlock()
+	for lf := range gpf.openFiles {
+		if lf.writable && lf.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOpenFiles takes a slot for a file about to be opened. It warns once
+// the number of open files reaches max-open-files, before the process runs
+// out of file descriptors with EMFILE, and rejects new opens with ENFILE if
+// configured to. The slot is taken before the file is opened, so concurrent
+// opens can't all pass the check. Callers must pass the file opened, if
+// any, to releaseOpenFileSlot.
+func (gpf *GoPathFs) checkOpenFiles() fuse.Status {
+	n := atomic.AddInt64(&gpf.stats.openFiles, 1)
+	limit := gpf.config().MaxOpenFilesLimit
+	if limit <= 0 {
+		return fuse.OK
+	}
+
+	if n <= limit {
+		atomic.StoreInt32(&gpf.stats.openFilesWarned, 0)
+		return fuse.OK
+	}
+	if atomic.CompareAndSwapInt32(&gpf.stats.openFilesWarned, 0, 1) {
+		fmt.Printf("Warning, %d files open through the mount, max-open-files is %d.\n", n-1, limit)
+	}
+	if !gpf.config().MaxOpenFilesReject {
+		return fuse.OK
+	}
+	atomic.AddInt64(&gpf.stats.openFiles, -1)
+	atomic.AddInt64(&gpf.stats.openFilesRejected, 1)
+	return fuse.Status(unix.ENFILE)
+}
+
+// releaseOpenFileSlot gives back the slot taken by checkOpenFiles, unless
+// the given file was opened and tracked, in which case it keeps the slot
+// until released.
+func (gpf *GoPathFs) releaseOpenFileSlot(file *nodefs.File) {
+	if lf, ok := (*file).(*loopbackFile); ok {
+		gpf.openFilesMu.Lock()
+		_, tracked := gpf.openFiles[lf]
+		gpf.openFilesMu.Unlock()
+		if tracked {
+			return
+		}
+	}
+	atomic.AddInt64(&gpf.stats.openFiles, -1)
+}
+
+// renameOpenFiles updates the mount paths of the open files at or below
+// oldName, and their backing paths at or below oldPath, after a rename.
+// The backing paths are left as they are if oldPath and newPath are the
+// same, e.g., when the file was copied, not renamed, across filesystems.
+func (gpf *GoPathFs) renameOpenFiles(oldName, newName, oldPath, newPath string) {
+	gpf.openFilesMu.Lock()
+	defer gpf.openFilesMu.Unlock()
+
+	for lf := range gpf.openFiles {
+		lf.name = renamedPath(lf.name, oldName, newName, pathSeparator)
+		lf.path = renamedPath(lf.path, oldPath, newPath, string(os.PathSeparator))
+	}
+}
+
+// renamedPath returns the given path after renaming oldPath, which is the
+// path itself or one of its parents, to newPath.
+func renamedPath(path, oldPath, newPath, sep string) string {
+	if path == oldPath {
+		return newPath
+	}
+	if strings.HasPrefix(path, oldPath+sep) {
+		return newPath + path[len(oldPath):]
+	}
+	return path
+}
+
+// mountName returns the current mount path of the file.
+func (lf *loopbackFile) mountName() string {
+	lf.gpf.openFilesMu.Lock()
+	defer lf.gpf.openFilesMu.Unlock()
+	return lf.name
+}
+
+// backingPath returns the current backing path of the file.
+func (lf *loopbackFile) backingPath() string {
+	lf.gpf.openFilesMu.Lock()
+	defer lf.gpf.openFilesMu.Unlock()
+	return lf.path
+}
+
+// use runs the given operation unless the file was released or its backing
+// file closed, in which case it returns EBADF. Like operations by path, it's
+// counted as in-flight by Shutdown, fails once shutting down, and is traced.
+func (lf *loopbackFile) use(op string, fn func() fuse.Status) (code fuse.Status) {
+	if !lf.gpf.enterOp() {
+		return errShuttingDown
+	}
+	defer lf.gpf.exitOp(&code)
+	if lf.gpf.tracer != nil || lf.gpf.errorEvents != nil {
+		backingPath := lf.backingPath()
+		defer lf.gpf.watchOp(op, lf.mountName(), time.Now(), &code, &backingPath)
+	}
+
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+
+	if lf.released || lf.f.Fd() == ^uintptr(0) {
+		return fuse.EBADF
+	}
+	return fn()
+}
+
+func (lf *loopbackFile) InnerFile() nodefs.File {
+	return lf.File
+}
+
+func (lf *loopbackFile) String() string {
+	return fmt.Sprintf("gopathfs.loopbackFile(%s)", lf.f.Name())
+}
+
+// Flush overwrites the inner file's Flush method to make written data
+// durable before close returns, if configured to.
+func (lf *loopbackFile) Flush() fuse.Status {
+	return lf.use("flush", func() fuse.Status {
+		if lf.writable {
+			// The size and times have likely changed.
+			defer lf.gpf.attrCache.invalidateFile(lf.mountName())
+		}
+
+		if lf.writable && lf.gpf.config().FsyncOnClose {
+			if err := lf.f.Sync(); err != nil {
+				fmt.Printf("Failed to fsync file %s, %v.\n", lf.f.Name(), err)
+				return fuse.ToStatus(err)
+			}
+		}
+		return lf.File.Flush()
+	})
+}
+
+// Read overwrites the inner file's Read method, which hands the kernel a
+// single pread that may be cut short by a signal. It reads through the inner
+// file, under its lock, until dest is full or the end of the file, retrying
+// on EINTR. Writes go through pwrite, which is retried the same way.
+func (lf *loopbackFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	read := 0
+	status := lf.use("read", func() fuse.Status {
+		for read < len(dest) {
+			res, status := lf.File.Read(dest[read:], off+int64(read))
+			var data []byte
+			if status == fuse.OK {
+				data, status = res.Bytes(dest[read:])
+				res.Done()
+			}
+			if status == fuse.Status(unix.EINTR) {
+				continue
+			}
+			if status != fuse.OK {
+				return status
+			}
+			if len(data) == 0 {
+				break
+			}
+			read += copy(dest[read:], data)
+		}
+		return fuse.OK
+	})
+	if status != fuse.OK {
+		return nil, status
+	}
+	return fuse.ReadResultData(dest[:read]), fuse.OK
+}
+
+// Write overwrites the inner file's Write method, which goes through
+// os.File.WriteAt and fails on files opened with O_APPEND. It writes with
+// pwrite instead, which appends to such files at the live end of the backing
+// file, even if others wrote to it since the kernel last got its size. The
+// cached attributes are dropped, so that e.g. a seek from the end after an
+// append sees the new size.
+func (lf *loopbackFile) Write(data []byte, off int64) (written uint32, code fuse.Status) {
+	if status := lf.gpf.checkMaxSize(lf.mountName(), off+int64(len(data))); status != fuse.OK {
+		return 0, status
+	}
+	code = lf.use("write", func() fuse.Status {
+		fd := int(lf.f.Fd())
+		n := 0
+		for n < len(data) {
+			m, err := unix.Pwrite(fd, data[n:], off+int64(n))
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return fuse.ToStatus(err)
+			}
+			if m == 0 {
+				return fuse.EIO
+			}
+			n += m
+		}
+		written = uint32(n)
+		return fuse.OK
+	})
+	lf.gpf.attrCache.invalidateFile(lf.mountName())
+	return written, code
+}
+
+// Fsync, Truncate, Chmod, Chown and Utimens overwrite the inner file's
+// methods to fail with EBADF after Release, see use. Truncate, Chmod and
+// Chown are checked and audited like their counterparts by path.
+
+func (lf *loopbackFile) Fsync(flags int) fuse.Status {
+	return lf.use("fsync", func() fuse.Status {
+		return lf.File.Fsync(flags)
+	})
+}
+
+func (lf *loopbackFile) Truncate(size uint64) (code fuse.Status) {
+	defer lf.audit(AuditRecord{Op: "truncate", Size: size}, &code)
+
+	if lf.gpf.isReadOnly(lf.mountName()) {
+		return fuse.EROFS
+	}
+	if status := lf.gpf.checkMaxSize(lf.mountName(), int64(size)); status != fuse.OK {
+		return status
+	}
+	defer lf.gpf.attrCache.invalidateFile(lf.mountName())
+	return lf.use("ftruncate", func() fuse.Status {
+		return lf.File.Truncate(size)
+	})
+}
+
+func (lf *loopbackFile) Chmod(perms uint32) (code fuse.Status) {
+	defer lf.audit(AuditRecord{Op: "chmod", Mode: perms}, &code)
+
+	if lf.gpf.isReadOnly(lf.mountName()) {
+		return fuse.EROFS
+	}
+	defer lf.gpf.attrCache.invalidateFile(lf.mountName())
+	return lf.use("fchmod", func() fuse.Status {
+		return lf.File.Chmod(perms)
+	})
+}
+
+// Chown also maps the given owner back to the backing one, see uid-map.
+func (lf *loopbackFile) Chown(uid uint32, gid uint32) (code fuse.Status) {
+	defer lf.audit(AuditRecord{Op: "chown"}, &code)
+
+	if lf.gpf.isReadOnly(lf.mountName()) {
+		return fuse.EROFS
+	}
+	defer lf.gpf.attrCache.invalidateFile(lf.mountName())
+	return lf.use("fchown", func() fuse.Status {
+		return lf.File.Chown(lf.gpf.backingOwner(uid, gid))
+	})
+}
+
+func (lf *loopbackFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	return lf.use("futimens", func() fuse.Status {
+		return lf.File.Utimens(atime, mtime)
+	})
+}
+
+// Allocate overwrites the inner file's Allocate method to pass the mode,
+// e.g., FALLOC_FL_PUNCH_HOLE|FALLOC_FL_KEEP_SIZE to sparsify a file, on to
+// the backing file.
+func (lf *loopbackFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	if lf.gpf.isReadOnly(lf.mountName()) {
+		return fuse.EROFS
+	}
+	if mode&fallocKeepSize == 0 {
+		if status := lf.gpf.checkMaxSize(lf.mountName(), int64(off+size)); status != fuse.OK {
+			return status
+		}
+	}
+	return lf.use("fallocate", func() fuse.Status {
+		if err := fallocate(int(lf.f.Fd()), mode, int64(off), int64(size)); err != nil {
+			return fuse.ToStatus(err)
+		}
+		// The size or the allocated blocks have changed.
+		lf.gpf.attrCache.invalidateFile(lf.mountName())
+		return fuse.OK
+	})
+}
+
+// GetAttr overwrites the inner file's GetAttr method to report the same
+// inode numbers as GoPathFs.GetAttr.
+func (lf *loopbackFile) GetAttr(out *fuse.Attr) fuse.Status {
+	return lf.use("fgetattr", func() fuse.Status {
+		st := unix.Stat_t{}
+		if err := unix.Fstat(int(lf.f.Fd()), &st); err != nil {
+			return fuse.ToStatus(err)
+		}
+		*out = lf.gpf.statAttr(st)
+		lf.gpf.mapOwner(out)
+		return fuse.OK
+	})
+}
+
+// Release overwrites the inner file's Release method to count open files.
+// Operations arriving afterwards fail with EBADF.
+func (lf *loopbackFile) Release() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.released {
+		return
+	}
+	lf.released = true
+
+	lf.gpf.openFilesMu.Lock()
+	if _, ok := lf.gpf.openFiles[lf]; ok {
+		delete(lf.gpf.openFiles, lf)
+		atomic.AddInt64(&lf.gpf.stats.openFiles, -1)
+	}
+	lf.gpf.openFilesMu.Unlock()
+	lf.File.Release()
+}
+
+// SetLk overwrites the inner file's SetLk method to take BSD flock locks on
+// the backing file, which are separate from POSIX byte-range locks.
+func (lf *loopbackFile) SetLk(owner uint64, lk *fuse.FileLock, flags uint32) fuse.Status {
+	return lf.use("setlk", func() fuse.Status {
+		if flags&fuse.FUSE_LK_FLOCK != 0 {
+			return flock(lf.f, lk, false)
+		}
+		return lf.File.SetLk(owner, lk, flags)
+	})
+}
+
+// SetLkw overwrites the inner file's SetLkw method, see SetLk. It waits for
+// the lock on a duplicate of the backing file descriptor, which shares the
+// locks of the open file, so that neither Release nor Shutdown waits for
+// another process to unlock.
+func (lf *loopbackFile) SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) fuse.Status {
+	var dup *os.File
+	status := lf.use("setlkw", func() fuse.Status {
+		fd, err := unix.Dup(int(lf.f.Fd()))
+		if err != nil {
+			return fuse.ToStatus(err)
+		}
+		dup = os.NewFile(uintptr(fd), lf.f.Name())
+		return fuse.OK
+	})
+	if status != fuse.OK {
+		return status
+	}
+	defer dup.Close()
+
+	if flags&fuse.FUSE_LK_FLOCK != 0 {
+		return flock(dup, lk, true)
+	}
+	return nodefs.NewLoopbackFile(dup).SetLkw(owner, lk, flags)
+}
+
+func flock(f *os.File, lk *fuse.FileLock, block bool) fuse.Status {
+	var how int
+	switch lk.Typ {
+	case unix.F_RDLCK:
+		how = unix.LOCK_SH
+	case unix.F_WRLCK:
+		how = unix.LOCK_EX
+	case unix.F_UNLCK:
+		how = unix.LOCK_UN
+	default:
+		return fuse.EINVAL
+	}
+	if !block {
+		how |= unix.LOCK_NB
+	}
+	return fuse.ToStatus(unix.Flock(int(f.Fd()), how))
+}
@@ -0,0 +1,13 @@
+package gopathfs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// macOS has no fallocate, and FUSE for macOS doesn't forward it anyway.
+func fallocate(fd int, mode uint32, off, size int64) error {
+	return unix.ENOSYS
+}
+
+// Linux's FALLOC_FL_KEEP_SIZE, for the common code.
+const fallocKeepSize = 0x1
@@ -0,0 +1,11 @@
+package gopathfs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func fallocate(fd int, mode uint32, off, size int64) error {
+	return unix.Fallocate(fd, mode, off, size)
+}
+
+const fallocKeepSize = unix.FALLOC_FL_KEEP_SIZE
@@ -0,0 +1,74 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestAllocatePunchHole(t *testing.T) {
+	const size = 1 << 20
+	gpf, ws := newTestFs(t, nil)
+	backing := filepath.Join(ws, "foo", "data.bin")
+	writeFile(t, backing, strings.Repeat("x", size))
+
+	f, status := gpf.Open(testPrefix+"/foo/data.bin", uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	if status := f.Fsync(0); status != fuse.OK {
+		t.Fatalf("Fsync = %v", status)
+	}
+	before := fuse.Attr{}
+	if status := f.GetAttr(&before); status != fuse.OK {
+		t.Fatalf("GetAttr = %v", status)
+	}
+
+	status = f.Allocate(0, size, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE)
+	if status == fuse.Status(unix.EOPNOTSUPP) || status == fuse.ENOSYS {
+		t.Skip("the workspace filesystem cannot punch holes")
+	}
+	if status != fuse.OK {
+		t.Fatalf("Allocate = %v", status)
+	}
+	after := fuse.Attr{}
+	if status := f.GetAttr(&after); status != fuse.OK {
+		t.Fatalf("GetAttr = %v", status)
+	}
+	if after.Size != size {
+		t.Errorf("Size = %d after punching a hole, want %d", after.Size, size)
+	}
+	if after.Blocks >= before.Blocks {
+		t.Errorf("Blocks = %d after punching a hole, want fewer than %d", after.Blocks, before.Blocks)
+	}
+
+	// The attributes through the mount see the hole too.
+	attr, status := gpf.GetAttr(testPrefix+"/foo/data.bin", nil)
+	if status != fuse.OK {
+		t.Fatalf("GetAttr = %v", status)
+	}
+	if attr.Blocks != after.Blocks {
+		t.Errorf("Blocks = %d through the mount, want %d", attr.Blocks, after.Blocks)
+	}
+}
+
+func TestAllocateReadOnly(t *testing.T) {
+	gpf, ws := newTestFs(t, nil, WithReadOnly())
+	writeFile(t, filepath.Join(ws, "foo", "data.bin"), "data")
+
+	f, status := gpf.Open(testPrefix+"/foo/data.bin", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	for _, mode := range []uint32{0, unix.FALLOC_FL_KEEP_SIZE, unix.FALLOC_FL_PUNCH_HOLE | unix.FALLOC_FL_KEEP_SIZE} {
+		if status := f.Allocate(0, 4096, mode); status != fuse.EROFS {
+			t.Errorf("Allocate(mode %#x) = %v, want EROFS", mode, status)
+		}
+	}
+}
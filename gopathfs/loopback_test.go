@@ -0,0 +1,537 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/linuxerwang/gobazel/conf"
+	"golang.org/x/sys/unix"
+)
+
+func TestFlock(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	open := func() *loopbackFile {
+		f, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+		if status != fuse.OK {
+			t.Fatalf("Open = %v", status)
+		}
+		t.Cleanup(f.Release)
+		return f.(*loopbackFile)
+	}
+	first, second := open(), open()
+
+	lock := &fuse.FileLock{Typ: unix.F_WRLCK}
+	if status := first.SetLk(0, lock, fuse.FUSE_LK_FLOCK); status != fuse.OK {
+		t.Fatalf("SetLk = %v", status)
+	}
+	if status := second.SetLk(0, lock, fuse.FUSE_LK_FLOCK); status != fuse.Status(unix.EWOULDBLOCK) {
+		t.Errorf("second SetLk = %v, want EWOULDBLOCK", status)
+	}
+
+	// The lock is taken on the backing file.
+	direct, err := os.Open(backing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer direct.Close()
+	if err := unix.Flock(int(direct.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != unix.EWOULDBLOCK {
+		t.Errorf("direct flock = %v, want EWOULDBLOCK", err)
+	}
+
+	// A blocking attempt waits for the lock to be released.
+	done := make(chan fuse.Status)
+	go func() {
+		done <- second.SetLkw(0, lock, fuse.FUSE_LK_FLOCK)
+	}()
+	select {
+	case status := <-done:
+		t.Fatalf("second SetLkw = %v before the lock was released", status)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if status := first.SetLk(0, &fuse.FileLock{Typ: unix.F_UNLCK}, fuse.FUSE_LK_FLOCK); status != fuse.OK {
+		t.Fatalf("unlocking SetLk = %v", status)
+	}
+	select {
+	case status := <-done:
+		if status != fuse.OK {
+			t.Errorf("second SetLkw = %v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second SetLkw still blocked after the lock was released")
+	}
+}
+
+func TestReleaseWhileWaitingForLock(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	holder, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer holder.Release()
+	waiter, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+
+	lock := &fuse.FileLock{Typ: unix.F_WRLCK}
+	if status := holder.SetLk(0, lock, fuse.FUSE_LK_FLOCK); status != fuse.OK {
+		t.Fatalf("SetLk = %v", status)
+	}
+	done := make(chan fuse.Status, 1)
+	go func() {
+		done <- waiter.SetLkw(0, lock, fuse.FUSE_LK_FLOCK)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Neither Release nor other operations wait for the lock.
+	released := make(chan struct{})
+	go func() {
+		waiter.Release()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Release blocked by a waiting SetLkw")
+	}
+	if _, status := gpf.GetAttr(name, nil); status != fuse.OK {
+		t.Errorf("GetAttr = %v", status)
+	}
+
+	if status := holder.SetLk(0, &fuse.FileLock{Typ: unix.F_UNLCK}, fuse.FUSE_LK_FLOCK); status != fuse.OK {
+		t.Fatalf("unlocking SetLk = %v", status)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetLkw still blocked after the lock was released")
+	}
+}
+
+// cachingConfig returns testConfig with first-party attributes and entries
+// cached for a minute by gobazel.
+func cachingConfig() *conf.GobazelConf {
+	cfg := testConfig()
+	cfg.Timeouts = &conf.TimeoutsConf{
+		FirstParty: &conf.TimeoutConf{AttrTimeout: time.Minute, EntryTimeout: time.Minute},
+		Vendor:     &conf.TimeoutConf{},
+		GoRoot:     &conf.TimeoutConf{},
+	}
+	return cfg
+}
+
+func TestWriteInvalidatesCachedAttr(t *testing.T) {
+	gpf, ws := newTestFs(t, cachingConfig())
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "foo", "b.go"), "package foo\n")
+	name, sibling := testPrefix+"/foo/a.go", testPrefix+"/foo/b.go"
+
+	for _, n := range []string{name, sibling} {
+		if attr, status := gpf.GetAttr(n, nil); status != fuse.OK || attr.Size != 12 {
+			t.Fatalf("GetAttr(%s) = %+v, %v", n, attr, status)
+		}
+	}
+
+	f, status := gpf.Open(name, uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	if _, status := f.Write([]byte("// more\n"), 12); status != fuse.OK {
+		t.Fatalf("Write = %v", status)
+	}
+
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != 20 {
+		t.Errorf("GetAttr after Write = %+v, %v, want size 20", attr, status)
+	}
+	if _, ok := gpf.attrCache.get(sibling); !ok {
+		t.Errorf("Write dropped the cached attributes of %s", sibling)
+	}
+}
+
+// TestSeekEndAfterAppend checks the size the kernel seeks from for SEEK_END,
+// which it gets from GetAttr of the open file, while the file is appended to
+// through the mount and directly.
+func TestSeekEndAfterAppend(t *testing.T) {
+	gpf, ws := newTestFs(t, cachingConfig())
+	backing := filepath.Join(ws, "foo", "a.log")
+	writeFile(t, backing, "start\n")
+	name := testPrefix + "/foo/a.log"
+	if _, status := gpf.GetAttr(name, nil); status != fuse.OK {
+		t.Fatalf("GetAttr = %v", status)
+	}
+
+	open := func() *loopbackFile {
+		f, status := gpf.Open(name, uint32(os.O_WRONLY|os.O_APPEND), nil)
+		if status != fuse.OK {
+			t.Fatalf("Open = %v", status)
+		}
+		t.Cleanup(f.Release)
+		return f.(*loopbackFile)
+	}
+	checkEnd := func(f *loopbackFile, want int64) {
+		t.Helper()
+		out := fuse.Attr{}
+		if status := f.GetAttr(&out); status != fuse.OK || int64(out.Size) != want {
+			t.Errorf("File.GetAttr = %+v, %v, want size %d", out, status, want)
+		}
+		if end, err := f.f.Seek(0, io.SeekEnd); err != nil || end != want {
+			t.Errorf("Seek(0, SeekEnd) = %d, %v, want %d", end, err, want)
+		}
+	}
+
+	// Appends go to the end whatever the offset written at.
+	f := open()
+	if _, status := f.Write([]byte("a\n"), 0); status != fuse.OK {
+		t.Fatalf("Write = %v", status)
+	}
+	checkEnd(f, 8)
+
+	// Another writer through the mount and one writing the backing file
+	// directly append concurrently, while the size is polled.
+	const appends = 100
+	other := open()
+	direct, err := os.OpenFile(backing, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer direct.Close()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < appends; i++ {
+			other.Write([]byte("b\n"), 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < appends; i++ {
+			direct.Write([]byte("c\n"))
+		}
+	}()
+	last := uint64(0)
+	for i := 0; i < appends; i++ {
+		out := fuse.Attr{}
+		if status := f.GetAttr(&out); status != fuse.OK || out.Size < last {
+			t.Fatalf("File.GetAttr while appending = %+v, %v, want size of at least %d", out, status, last)
+		}
+		last = out.Size
+	}
+	wg.Wait()
+
+	want := int64(8 + 2*2*appends)
+	checkEnd(f, want)
+	checkEnd(other, want)
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || int64(attr.Size) != want {
+		t.Errorf("GetAttr after the appends = %+v, %v, want size %d", attr, status, want)
+	}
+}
+
+func BenchmarkWriteLargeAttrCache(b *testing.B) {
+	gpf, ws := newTestFs(b, cachingConfig())
+	writeFile(b, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	for i := 0; i < 100000; i++ {
+		gpf.attrCache.put(fmt.Sprintf("%s/bar/%d.go", testPrefix, i), &fuse.Attr{}, time.Minute)
+	}
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		b.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	data := []byte("x")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, status := f.Write(data, 0); status != fuse.OK {
+			b.Fatalf("Write = %v", status)
+		}
+	}
+}
+
+func TestOpenFilesAfterRename(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+
+	check := func(name, backing string) {
+		t.Helper()
+		infos := gpf.OpenFiles()
+		if len(infos) != 1 || infos[0].Name != name || infos[0].BackingPath != backing {
+			t.Errorf("OpenFiles = %+v, want %s backed by %s", infos, name, backing)
+		}
+		if !gpf.openForWriting(backing) {
+			t.Errorf("%s isn't open for writing", backing)
+		}
+	}
+	check(testPrefix+"/foo/a.go", filepath.Join(ws, "foo", "a.go"))
+
+	// Renaming the directory moves the files below it.
+	if status := gpf.Rename(testPrefix+"/foo", testPrefix+"/bar", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	check(testPrefix+"/bar/a.go", filepath.Join(ws, "bar", "a.go"))
+
+	if status := gpf.Rename(testPrefix+"/bar/a.go", testPrefix+"/bar/b.go", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	check(testPrefix+"/bar/b.go", filepath.Join(ws, "bar", "b.go"))
+
+	// Renaming a sibling with a common name prefix leaves the file alone.
+	if err := os.Mkdir(filepath.Join(ws, "ba"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if status := gpf.Rename(testPrefix+"/ba", testPrefix+"/baz", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	check(testPrefix+"/bar/b.go", filepath.Join(ws, "bar", "b.go"))
+}
+
+// faultyFile is a nodefs.File failing reads with EINTR every other time and
+// serving them a few bytes at a time, like reads interrupted by signals.
+type faultyFile struct {
+	nodefs.File
+	reads int
+}
+
+func (ff *faultyFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	ff.reads++
+	if ff.reads%2 == 1 {
+		return nil, fuse.Status(unix.EINTR)
+	}
+	if len(buf) > 3 {
+		buf = buf[:3]
+	}
+	return ff.File.Read(buf, off)
+}
+
+func TestReadRetriesInterruptedReads(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	data := "package foo\n\n// Read a few bytes at a time.\n"
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), data)
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	lf := f.(*loopbackFile)
+	ff := &faultyFile{File: lf.File}
+	lf.File = ff
+
+	buf := make([]byte, 1<<10)
+	res, status := f.Read(buf, 0)
+	if status != fuse.OK {
+		t.Fatalf("Read = %v", status)
+	}
+	got, _ := res.Bytes(buf)
+	if string(got) != data {
+		t.Errorf("Read = %q, want %q", got, data)
+	}
+	if ff.reads < 2*len(data)/3 {
+		t.Errorf("%d reads of the backing file, want the faults injected", ff.reads)
+	}
+
+	// From an offset, up to the size asked for.
+	res, status = f.Read(buf[:4], 8)
+	if got, _ := res.Bytes(buf[:4]); status != fuse.OK || string(got) != "foo\n" {
+		t.Errorf("Read at 8 = %q, %v, want %q", got, status, "foo\n")
+	}
+}
+
+func TestMaxOpenFilesConcurrent(t *testing.T) {
+	const limit, opens = 4, 32
+	cfg := testConfig()
+	cfg.MaxOpenFilesLimit = limit
+	cfg.MaxOpenFilesReject = true
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	// Creates checked against the limit wait for the path locks, held here,
+	// before they open their backing files.
+	for i := range gpf.pathLocks.shards {
+		gpf.pathLocks.shards[i].Lock()
+	}
+	files := make([]nodefs.File, opens)
+	statuses := make([]fuse.Status, opens)
+	var wg sync.WaitGroup
+	for i := 0; i < opens; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("%s/foo/%d.go", testPrefix, i)
+			files[i], statuses[i] = gpf.Create(name, uint32(os.O_WRONLY), 0644, nil)
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := range gpf.pathLocks.shards {
+		gpf.pathLocks.shards[i].Unlock()
+	}
+	wg.Wait()
+
+	ok, rejected := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case fuse.OK:
+			ok++
+		case fuse.Status(unix.ENFILE):
+			rejected++
+		default:
+			t.Errorf("open = %v, want OK or ENFILE", status)
+		}
+	}
+	if ok != limit || rejected != opens-limit {
+		t.Errorf("%d opens succeeded and %d were rejected, want %d and %d", ok, rejected, limit, opens-limit)
+	}
+	if st := gpf.Stats(); st.OpenFiles != limit || st.OpenFilesRejected != opens-limit {
+		t.Errorf("stats = %d open, %d rejected, want %d and %d", st.OpenFiles, st.OpenFilesRejected, limit, opens-limit)
+	}
+
+	for _, f := range files {
+		if f != nil {
+			f.Release()
+		}
+	}
+	if st := gpf.Stats(); st.OpenFiles != 0 {
+		t.Errorf("%d files open after releasing all, want 0", st.OpenFiles)
+	}
+	// Opens which don't take a backing file give their slot back.
+	gpf.SetGenfilesSource(&memSource{files: map[string]string{"foo/gen.pb.go": "package foo\n"}})
+	for i := 0; i < 2*limit; i++ {
+		if _, status := readMountFile(t, gpf, testPrefix+"/foo/gen.pb.go"); status != fuse.OK {
+			t.Fatalf("reading a generated file = %v", status)
+		}
+		if _, status := gpf.Open(testPrefix+"/foo/missing.go", uint32(os.O_RDONLY), nil); status != fuse.ENOENT {
+			t.Fatalf("Open of a missing file = %v", status)
+		}
+	}
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open after releasing all = %v", status)
+	}
+	f.Release()
+}
+
+func TestOpsOnClosedBackingFile(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	lf := f.(*loopbackFile)
+
+	ops := map[string]func() fuse.Status{
+		"read": func() fuse.Status {
+			_, status := lf.Read(make([]byte, 4), 0)
+			return status
+		},
+		"write": func() fuse.Status {
+			_, status := lf.Write([]byte("x"), 0)
+			return status
+		},
+		"getattr":  func() fuse.Status { return lf.GetAttr(&fuse.Attr{}) },
+		"flush":    func() fuse.Status { return lf.Flush() },
+		"fsync":    func() fuse.Status { return lf.Fsync(0) },
+		"truncate": func() fuse.Status { return lf.Truncate(0) },
+		"chmod":    func() fuse.Status { return lf.Chmod(0644) },
+		"utimens": func() fuse.Status {
+			now := time.Now()
+			return lf.Utimens(&now, &now)
+		},
+		"allocate": func() fuse.Status { return lf.Allocate(0, 1, 0) },
+		"setlk": func() fuse.Status {
+			return lf.SetLk(1, &fuse.FileLock{Typ: unix.F_RDLCK}, 0)
+		},
+	}
+	run := func(when string) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for op, fn := range ops {
+				if status := fn(); status != fuse.EBADF {
+					t.Errorf("%s %s = %v, want EBADF", op, when, status)
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("operations %s hang", when)
+		}
+	}
+
+	// The backing file closed underneath the open handle, e.g., on teardown.
+	if err := lf.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	run("on a closed backing file")
+	f.Release()
+	run("after Release")
+	f.Release()
+}
+
+func TestFsyncOnClose(t *testing.T) {
+	cfg := testConfig()
+	cfg.FsyncOnClose = true
+	gpf, ws := newTestFs(t, cfg)
+	if status := gpf.Mkdir(testPrefix+"/foo", 0755, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	f, status := gpf.Create(testPrefix+"/foo/a.go", uint32(os.O_WRONLY), 0644, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create = %v", status)
+	}
+	if _, status := f.Write([]byte("package foo\n"), 0); status != fuse.OK {
+		t.Fatalf("Write = %v", status)
+	}
+	if status := f.Flush(); status != fuse.OK {
+		t.Fatalf("Flush = %v", status)
+	}
+	f.Release()
+	if got, err := os.ReadFile(filepath.Join(ws, "foo", "a.go")); err != nil || string(got) != "package foo\n" {
+		t.Errorf("backing file = %q, %v", got, err)
+	}
+
+	// A pipe can't be fsynced, so its Flush fails if and only if close
+	// syncs.
+	for _, fsync := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.FsyncOnClose = fsync
+		gpf, _ := newTestFs(t, cfg)
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		lf := gpf.newLoopbackFile(w, true)
+		want := fuse.OK
+		if fsync {
+			want = fuse.EINVAL
+		}
+		if status := lf.Flush(); status != want {
+			t.Errorf("with fsync-on-close %v, Flush = %v, want %v", fsync, status, want)
+		}
+		lf.Release()
+	}
+}
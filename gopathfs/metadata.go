@@ -0,0 +1,115 @@
+package gopathfs
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// MetadataProvider synthesizes read-only virtual files in the served
+// directories, e.g., a ".bazel-info" listing the BUILD targets of each
+// package, so that build wrappers can find metadata next to the code.
+type MetadataProvider interface {
+	// Names returns the names of the virtual files the provider may serve.
+	Names() []string
+
+	// Exists returns true if there is a virtual file with the given name in
+	// the given mount directory. Listings and lookups which don't read the
+	// file ask this only, so it should be cheaper than Metadata.
+	Exists(dir, name string) bool
+
+	// Metadata returns the content of the virtual file with the given name
+	// in the given mount directory, or false if there is none there.
+	Metadata(dir, name string) ([]byte, bool)
+}
+
+// SetMetadataProviders sets the providers of virtual metadata files, which
+// are consulted, in order, before the backing files. It must be called
+// before mounting.
+func (gpf *GoPathFs) SetMetadataProviders(providers ...MetadataProvider) {
+	gpf.metadataProviders = providers
+}
+
+// metadata returns the content of the virtual metadata file at the given
+// mount path, if any.
+func (gpf *GoPathFs) metadata(name string) ([]byte, bool) {
+	if len(gpf.metadataProviders) == 0 || name == "" {
+		return nil, false
+	}
+
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	if dir == "." {
+		dir = ""
+	}
+	for _, p := range gpf.metadataProviders {
+		for _, n := range p.Names() {
+			if n != base {
+				continue
+			}
+			if data, ok := p.Metadata(dir, base); ok {
+				return data, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// servesMetadata returns true if the given mount path is a virtual metadata
+// file, without computing its content.
+func (gpf *GoPathFs) servesMetadata(name string) bool {
+	if len(gpf.metadataProviders) == 0 || name == "" {
+		return false
+	}
+
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	if dir == "." {
+		dir = ""
+	}
+	for _, p := range gpf.metadataProviders {
+		for _, n := range p.Names() {
+			if n == base && p.Exists(dir, base) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metadataEntries returns the listing entries of the virtual metadata files
+// in the given mount directory.
+func (gpf *GoPathFs) metadataEntries(dir string) []fuse.DirEntry {
+	entries := []fuse.DirEntry{}
+	for _, p := range gpf.metadataProviders {
+		for _, n := range p.Names() {
+			if p.Exists(dir, n) {
+				entries = append(entries, fuse.DirEntry{Name: n, Mode: fuse.S_IFREG})
+			}
+		}
+	}
+	return entries
+}
+
+func (gpf *GoPathFs) getMetadataAttr(data []byte) (*fuse.Attr, fuse.Status) {
+	return &fuse.Attr{
+		Mode: fuse.S_IFREG | 0444,
+		Size: uint64(len(data)),
+	}, fuse.OK
+}
+
+func (gpf *GoPathFs) openMetadataFile(data []byte, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+
+	// The content is computed on every open, bypass the kernel's page cache.
+	return &nodefs.WithFlags{
+		File: &contentSourceFile{
+			File: nodefs.NewDefaultFile(),
+			r:    bytes.NewReader(data),
+			size: int64(len(data)),
+		},
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, fuse.OK
+}
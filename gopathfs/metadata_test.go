@@ -0,0 +1,86 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// infoProvider serves a .bazel-info in the given mount directories, and
+// counts the contents computed.
+type infoProvider struct {
+	dirs map[string]string
+
+	mu    sync.Mutex
+	reads int
+}
+
+func (ip *infoProvider) Names() []string {
+	return []string{".bazel-info"}
+}
+
+func (ip *infoProvider) Exists(dir, name string) bool {
+	_, ok := ip.dirs[dir]
+	return ok
+}
+
+func (ip *infoProvider) Metadata(dir, name string) ([]byte, bool) {
+	ip.mu.Lock()
+	ip.reads++
+	ip.mu.Unlock()
+
+	data, ok := ip.dirs[dir]
+	return []byte(data), ok
+}
+
+func (ip *infoProvider) readCount() int {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	return ip.reads
+}
+
+func TestMetadataProvider(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "bar", ".bazel-info"), "backing\n")
+	ip := &infoProvider{dirs: map[string]string{
+		testPrefix + "/foo": "//foo:foo\n",
+		testPrefix + "/bar": "//bar:bar\n",
+	}}
+	gpf.SetMetadataProviders(ip)
+
+	// Listings and write checks don't compute the content.
+	if !listNames(t, gpf, testPrefix+"/foo")[".bazel-info"] {
+		t.Error(".bazel-info not listed")
+	}
+	if listNames(t, gpf, testPrefix)[".bazel-info"] {
+		t.Error(".bazel-info listed where the provider serves none")
+	}
+	if !gpf.isReadOnly(testPrefix + "/foo/.bazel-info") {
+		t.Error(".bazel-info writable")
+	}
+	if n := ip.readCount(); n != 0 {
+		t.Errorf("%d contents computed by listings, want 0", n)
+	}
+
+	// Virtual files are consulted before the backing files, and listed
+	// once.
+	if got, status := readMountFile(t, gpf, testPrefix+"/bar/.bazel-info"); status != fuse.OK || got != "//bar:bar\n" {
+		t.Errorf("reading bar/.bazel-info = %q, %v", got, status)
+	}
+	entries, _ := gpf.OpenDir(testPrefix+"/bar", nil)
+	if len(entries) != 1 {
+		t.Errorf("bar listed %v, want .bazel-info once", entries)
+	}
+
+	attr, status := gpf.GetAttr(testPrefix+"/foo/.bazel-info", nil)
+	if status != fuse.OK || attr.Size != uint64(len("//foo:foo\n")) {
+		t.Errorf("GetAttr = %+v, %v", attr, status)
+	}
+	if _, status := gpf.Open(testPrefix+"/foo/.bazel-info", uint32(os.O_WRONLY), nil); status != fuse.EROFS {
+		t.Errorf("Open for writing = %v, want EROFS", status)
+	}
+}
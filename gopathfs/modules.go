@@ -0,0 +1,229 @@
+package gopathfs
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ModuleSource identifies where the mount serves a module from.
+type ModuleSource int
+
+// Sources of modules.
+const (
+	ModuleFirstParty ModuleSource = iota
+	ModuleVendor
+	ModuleReplace
+)
+
+var moduleSourceNames = map[ModuleSource]string{
+	ModuleFirstParty: "first-party",
+	ModuleVendor:     "vendor",
+	ModuleReplace:    "replace",
+}
+
+func (s ModuleSource) String() string {
+	return moduleSourceNames[s]
+}
+
+// Module is a module of the workspace's dependency graph.
+type Module struct {
+	Path    string
+	Version string // Empty for first-party modules and local replacements.
+	Source  ModuleSource
+	Replace string // The replacement path or module, for ModuleReplace.
+}
+
+// ModuleGraph returns the first-party modules, i.e., the go.mod files in the
+// workspace outside of vendor and generated directories, and the modules
+// listed in the vendor directories' modules.txt files, sorted by path. A
+// module replaced in a first-party go.mod is reported as ModuleReplace. The
+// workspace is walked under the cap of scan-concurrency, like other
+// background walks.
+func (gpf *GoPathFs) ModuleGraph() ([]Module, error) {
+	ws := gpf.workspace()
+
+	var (
+		mu       sync.Mutex
+		goMods   []string
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	gpf.walkTree([]string{ws}, func(dir string) (subdirs []string) {
+		entries, err := gpf.scanBackingDir(dir)
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() {
+				rel, _ := filepath.Rel(ws, path)
+				if !gpf.isIgnored(rel) && !gpf.isVendorDir(rel) && !gpf.isGenDir(rel) {
+					subdirs = append(subdirs, path)
+				}
+				continue
+			}
+			if e.Name() == "go.mod" {
+				mu.Lock()
+				goMods = append(goMods, path)
+				mu.Unlock()
+			}
+		}
+		return subdirs
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// Sorted, so that which replace of a module wins doesn't depend on the
+	// order the directories were read in.
+	sort.Strings(goMods)
+	mods := map[string]Module{}
+	replaces := map[string]Module{}
+	for _, path := range goMods {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		modPath, reps := parseGoMod(data)
+		if modPath != "" {
+			mods[modPath] = Module{Path: modPath, Source: ModuleFirstParty}
+		}
+		for _, r := range reps {
+			replaces[r.Path] = r
+		}
+	}
+
+	for _, v := range gpf.vendors() {
+		data, err := os.ReadFile(filepath.Join(v.root, "modules.txt"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, m := range parseModulesTxt(data) {
+			if _, ok := mods[m.Path]; !ok {
+				mods[m.Path] = m
+			}
+		}
+	}
+
+	for p, r := range replaces {
+		if m, ok := mods[p]; !ok || m.Source != ModuleFirstParty {
+			mods[p] = r
+		}
+	}
+
+	graph := make([]Module, 0, len(mods))
+	for _, m := range mods {
+		graph = append(graph, m)
+	}
+	sort.Slice(graph, func(i, j int) bool {
+		return graph[i].Path < graph[j].Path
+	})
+	return graph, nil
+}
+
+// isGenDir returns true if the given directory, relative to the workspace,
+// is a genfiles directory.
+func (gpf *GoPathFs) isGenDir(dir string) bool {
+	for _, gen := range gpf.config().GenDirs {
+		if dir == gen || strings.HasPrefix(dir, gen+pathSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGoMod returns the module path and the replace directives of a go.mod
+// file. Malformed lines are skipped.
+func parseGoMod(data []byte) (modPath string, replaces []Module) {
+	inReplace := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case inReplace && fields[0] == ")":
+			inReplace = false
+		case inReplace:
+			if r, ok := parseReplace(fields); ok {
+				replaces = append(replaces, r)
+			}
+		case fields[0] == "module" && len(fields) == 2:
+			modPath = strings.Trim(fields[1], `"`)
+		case fields[0] == "replace" && len(fields) == 2 && fields[1] == "(":
+			inReplace = true
+		case fields[0] == "replace":
+			if r, ok := parseReplace(fields[1:]); ok {
+				replaces = append(replaces, r)
+			}
+		}
+	}
+	return modPath, replaces
+}
+
+// parseReplace parses "old [version] => new [version]".
+func parseReplace(fields []string) (Module, bool) {
+	for i, f := range fields {
+		if f != "=>" || i == 0 || i == len(fields)-1 {
+			continue
+		}
+		m := Module{
+			Path:    fields[0],
+			Source:  ModuleReplace,
+			Replace: strings.Join(fields[i+1:], " "),
+		}
+		if i == 2 {
+			m.Version = fields[1]
+		}
+		return m, true
+	}
+	return Module{}, false
+}
+
+// parseModulesTxt returns the modules listed in a vendor/modules.txt file,
+// i.e., its "# path version [=> replacement]" lines.
+func parseModulesTxt(data []byte) []Module {
+	mods := []Module{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		fields := strings.Fields(line[2:])
+		if len(fields) == 0 {
+			continue
+		}
+		if r, ok := parseReplace(fields); ok {
+			mods = append(mods, r)
+			continue
+		}
+		m := Module{Path: fields[0], Source: ModuleVendor}
+		if len(fields) > 1 {
+			m.Version = fields[1]
+		}
+		mods = append(mods, m)
+	}
+	return mods
+}
@@ -0,0 +1,51 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestModuleGraph(t *testing.T) {
+	cfg := testConfig()
+	cfg.ScanConcurrencyLimit = 1
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "go.mod"), "module example.com/x\n\nreplace github.com/y => ./third_party/y\n")
+	writeFile(t, filepath.Join(ws, "tools", "go.mod"), "module example.com/x/tools\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "z", "go.mod"), "module github.com/z\n")
+	writeFile(t, filepath.Join(ws, "vendor", "modules.txt"), "# github.com/y v1.0.0\ngithub.com/y\n")
+	writeFile(t, filepath.Join(ws, "bazel-genfiles", "gen", "go.mod"), "module example.com/gen\n")
+
+	// The walk waits for the slot held by another walk.
+	sem := gpf.scanSem()
+	sem <- struct{}{}
+	type result struct {
+		graph []Module
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		graph, err := gpf.ModuleGraph()
+		done <- result{graph, err}
+	}()
+	select {
+	case <-done:
+		t.Fatal("ModuleGraph walked beyond scan-concurrency")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-sem
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	want := []Module{
+		{Path: "example.com/x", Source: ModuleFirstParty},
+		{Path: "example.com/x/tools", Source: ModuleFirstParty},
+		{Path: "github.com/y", Source: ModuleReplace, Replace: "./third_party/y"},
+	}
+	if !reflect.DeepEqual(res.graph, want) {
+		t.Errorf("ModuleGraph = %+v, want %+v", res.graph, want)
+	}
+}
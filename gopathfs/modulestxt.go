@@ -0,0 +1,179 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// modulesTxtName is the virtual file in the vendor root of the first-party
+// module, i.e., <go-pkg-prefix>/vendor where Go tools in module mode look
+// for it, listing the served vendored modules and their packages like
+// vendor/modules.txt does.
+const modulesTxtName = "modules.txt"
+
+// modulesTxtDir is the vendor root below the prefix, which Go fixes.
+const modulesTxtDir = "vendor"
+
+// modulesTxtPath returns the mount path of the synthesized modules.txt.
+func (gpf *GoPathFs) modulesTxtPath() string {
+	return filepath.Join(gpf.config().GoPkgPrefix, modulesTxtDir, modulesTxtName)
+}
+
+// servesModulesTxt returns true if the given mount path is the synthesized
+// modules.txt.
+func (gpf *GoPathFs) servesModulesTxt(name string) bool {
+	return gpf.config().SynthesizeModulesTxt && name == gpf.modulesTxtPath()
+}
+
+// servesModulesTxtDir returns true if the given mount path is the vendor
+// root holding the synthesized modules.txt, which is served even without a
+// backing directory.
+func (gpf *GoPathFs) servesModulesTxtDir(name string) bool {
+	return gpf.config().SynthesizeModulesTxt && name == filepath.Dir(gpf.modulesTxtPath())
+}
+
+func (gpf *GoPathFs) getModulesTxtAttr() (*fuse.Attr, fuse.Status) {
+	return &fuse.Attr{
+		Mode: fuse.S_IFREG | 0444,
+		Size: uint64(len(gpf.modulesTxt())),
+	}, fuse.OK
+}
+
+func (gpf *GoPathFs) openModulesTxt(flags uint32) (nodefs.File, fuse.Status) {
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EROFS
+	}
+	data := gpf.modulesTxt()
+	return &contentSourceFile{
+		File: nodefs.NewDefaultFile(),
+		r:    bytes.NewReader(data),
+		size: int64(len(data)),
+	}, fuse.OK
+}
+
+// modulesTxt returns the content of the synthesized modules.txt as last
+// built. Only the first use waits for it to be built; afterwards it's
+// rebuilt in the background, see rebuildModulesTxt, and the previous
+// content is served until then.
+func (gpf *GoPathFs) modulesTxt() []byte {
+	gpf.modulesTxtMu.Lock()
+	data, built := gpf.modulesTxtData, gpf.modulesTxtBuilt
+	gpf.modulesTxtMu.Unlock()
+	if data != nil {
+		return data
+	}
+
+	gpf.rebuildModulesTxt()
+	<-built
+	gpf.modulesTxtMu.Lock()
+	defer gpf.modulesTxtMu.Unlock()
+	return gpf.modulesTxtData
+}
+
+// rebuildModulesTxt builds the synthesized modules.txt in the background,
+// unless it isn't served. If a build is already running, another one
+// follows it, so that the changes it may have missed are picked up.
+func (gpf *GoPathFs) rebuildModulesTxt() {
+	if !gpf.config().SynthesizeModulesTxt {
+		return
+	}
+
+	gpf.modulesTxtMu.Lock()
+	defer gpf.modulesTxtMu.Unlock()
+	if gpf.modulesTxtBuilding {
+		gpf.modulesTxtStale = true
+		return
+	}
+	gpf.modulesTxtBuilding = true
+
+	go func() {
+		for {
+			data := gpf.buildModulesTxt()
+
+			gpf.modulesTxtMu.Lock()
+			gpf.modulesTxtData = data
+			select {
+			case <-gpf.modulesTxtBuilt:
+			default:
+				close(gpf.modulesTxtBuilt)
+			}
+			if !gpf.modulesTxtStale {
+				gpf.modulesTxtBuilding = false
+				gpf.modulesTxtMu.Unlock()
+				return
+			}
+			gpf.modulesTxtStale = false
+			gpf.modulesTxtMu.Unlock()
+		}
+	}()
+}
+
+// isModuleFile returns true if a change of the given file, relative to the
+// workspace, may change the synthesized modules.txt: a first-party go.mod,
+// or anything in a vendor directory, which adds or removes packages.
+func (gpf *GoPathFs) isModuleFile(path string) bool {
+	return filepath.Base(path) == "go.mod" || gpf.isVendorDir(path)
+}
+
+// buildModulesTxt lists the vendored modules of the module graph, each
+// followed by the served vendor packages it provides.
+func (gpf *GoPathFs) buildModulesTxt() []byte {
+	graph, err := gpf.ModuleGraph()
+	if err != nil {
+		fmt.Printf("Failed to build the module graph for %s, %v.\n", modulesTxtName, err)
+	}
+	mods := []Module{}
+	for _, m := range graph {
+		if m.Source != ModuleFirstParty {
+			mods = append(mods, m)
+		}
+	}
+
+	// Packages are sorted, so are the packages of each module.
+	pkgs := map[string][]string{}
+	for _, p := range gpf.ListPackages() {
+		if p.Kind != KindVendor && p.Kind != KindVendorGenfiles {
+			continue
+		}
+		if m, ok := moduleOf(mods, p.ImportPath); ok {
+			pkgs[m.Path] = append(pkgs[m.Path], p.ImportPath)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, m := range mods {
+		fmt.Fprintf(&buf, "# %s", m.Path)
+		if m.Version != "" {
+			fmt.Fprintf(&buf, " %s", m.Version)
+		}
+		if m.Replace != "" {
+			fmt.Fprintf(&buf, " => %s", m.Replace)
+		}
+		buf.WriteString("\n## explicit\n")
+		for _, p := range pkgs[m.Path] {
+			buf.WriteString(p + "\n")
+		}
+	}
+	// Not nil, which modulesTxt takes as not built yet.
+	return append([]byte{}, buf.Bytes()...)
+}
+
+// moduleOf returns the module with the longest path the given package is
+// in.
+func moduleOf(mods []Module, pkg string) (Module, bool) {
+	best, found := Module{}, false
+	for _, m := range mods {
+		if pkg != m.Path && !strings.HasPrefix(pkg, m.Path+"/") {
+			continue
+		}
+		if !found || len(m.Path) > len(best.Path) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,70 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestSynthesizedModulesTxt(t *testing.T) {
+	cfg := testConfig()
+	cfg.SynthesizeModulesTxt = true
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "go.mod"), "module "+testPrefix+"\n")
+	writeFile(t, filepath.Join(ws, "vendor", "modules.txt"), "# github.com/y v1.0.0\n## explicit\ngithub.com/y\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "b.go"), "package y\n")
+	name := testPrefix + "/vendor/modules.txt"
+
+	got, status := readMountFile(t, gpf, name)
+	if want := "# github.com/y v1.0.0\n## explicit\ngithub.com/y\n"; status != fuse.OK || got != want {
+		t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+	}
+	if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != uint64(len(got)) {
+		t.Errorf("GetAttr(%s) = %+v, %v", name, attr, status)
+	}
+	if !listNames(t, gpf, testPrefix+"/vendor")[modulesTxtName] {
+		t.Errorf("%s/vendor doesn't list %s", testPrefix, modulesTxtName)
+	}
+
+	// A change of the vendor tree rebuilds it in the background.
+	writeFile(t, filepath.Join(ws, "vendor", "modules.txt"), "# github.com/y v1.0.0\n# github.com/z v2.0.0\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "z", "c.go"), "package z\n")
+	if !gpf.isModuleFile("vendor/github.com/z/c.go") {
+		t.Fatal("a vendored file isn't a module file")
+	}
+	gpf.rebuildModulesTxt()
+	want := "# github.com/y v1.0.0\n## explicit\ngithub.com/y\n# github.com/z v2.0.0\n## explicit\ngithub.com/z\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ = readMountFile(t, gpf, name)
+		if got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reading %s after a change = %q, want %q", name, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSynthesizedModulesTxtWithoutVendorDir(t *testing.T) {
+	cfg := testConfig()
+	cfg.SynthesizeModulesTxt = true
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "go.mod"), "module "+testPrefix+"\n\nreplace github.com/y => ../y\n")
+	if err := os.Remove(filepath.Join(ws, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+
+	if attr, status := gpf.GetAttr(testPrefix+"/vendor", nil); status != fuse.OK || attr.Mode&fuse.S_IFDIR == 0 {
+		t.Fatalf("GetAttr of the vendor root = %+v, %v", attr, status)
+	}
+	got, status := readMountFile(t, gpf, testPrefix+"/vendor/modules.txt")
+	if !strings.HasPrefix(got, "# github.com/y => ../y\n") || status != fuse.OK {
+		t.Errorf("reading modules.txt = %q, %v", got, status)
+	}
+}
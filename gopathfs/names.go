@@ -0,0 +1,58 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// Longest file name, i.e., path element, of the backing file systems.
+const maxNameLen = 255
+
+// checkName returns ENAMETOOLONG if the given mount path has a path element
+// longer than the backing file systems allow, or if joined with any of the
+// backing roots it would exceed the system's path length limit, instead of
+// failing obscurely in the backing call.
+func (gpf *GoPathFs) checkName(name string) fuse.Status {
+	for _, e := range strings.Split(name, pathSeparator) {
+		if len(e) > maxNameLen {
+			return fuse.Status(unix.ENAMETOOLONG)
+		}
+	}
+	if gpf.maxRootLen()+len(pathSeparator)+len(name) >= maxPathLen {
+		return fuse.Status(unix.ENAMETOOLONG)
+	}
+	return fuse.OK
+}
+
+// maxRootLen returns the length of the longest backing root a mount path may
+// be joined with.
+func (gpf *GoPathFs) maxRootLen() int {
+	roots := []string{gpf.workspace(), gpf.goSDKDir()}
+	for _, gen := range gpf.config().GenDirs {
+		roots = append(roots, filepath.Join(gpf.workspace(), gen))
+	}
+	for _, v := range gpf.vendors() {
+		roots = append(roots, v.root)
+	}
+	for _, o := range gpf.config().OverlayDirs {
+		roots = append(roots, o.Dir)
+	}
+
+	n := 0
+	for _, r := range roots {
+		if len(r) > n {
+			n = len(r)
+		}
+	}
+	return n
+}
+
+// isVirtualDir returns true if the given mount path is the mount root or the
+// prefix directory, which have no backing directory. All methods but
+// GetAttr, OpenDir, Access and the xattr ones fail on them with EPERM.
+func (gpf *GoPathFs) isVirtualDir(name string) bool {
+	return name == "" || name == gpf.config().GoPkgPrefix
+}
@@ -0,0 +1,4 @@
+package gopathfs
+
+// Longest path of the system, including the terminating NUL.
+const maxPathLen = 4096
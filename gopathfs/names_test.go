@@ -0,0 +1,84 @@
+package gopathfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestNameTooLong(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	longElem := testPrefix + "/foo/" + strings.Repeat("x", maxNameLen+1) + ".go"
+	// Each element fits, but not joined with the workspace.
+	longPath := testPrefix + strings.Repeat("/"+strings.Repeat("y", 200), maxPathLen/200+1)
+
+	for _, name := range []string{longElem, longPath} {
+		if _, status := gpf.GetAttr(name, nil); status != fuse.Status(unix.ENAMETOOLONG) {
+			t.Errorf("GetAttr = %v, want ENAMETOOLONG", status)
+		}
+		if _, status := gpf.Open(name, uint32(os.O_RDONLY), nil); status != fuse.Status(unix.ENAMETOOLONG) {
+			t.Errorf("Open = %v, want ENAMETOOLONG", status)
+		}
+		if _, status := gpf.Create(name, uint32(os.O_WRONLY), 0644, nil); status != fuse.Status(unix.ENAMETOOLONG) {
+			t.Errorf("Create = %v, want ENAMETOOLONG", status)
+		}
+		if status := gpf.Mkdir(name, 0755, nil); status != fuse.Status(unix.ENAMETOOLONG) {
+			t.Errorf("Mkdir = %v, want ENAMETOOLONG", status)
+		}
+		if status := gpf.Rename(testPrefix+"/foo/a.go", name, nil); status != fuse.Status(unix.ENAMETOOLONG) {
+			t.Errorf("Rename = %v, want ENAMETOOLONG", status)
+		}
+	}
+
+	// The longest element allowed still works.
+	name := testPrefix + "/" + strings.Repeat("z", maxNameLen)
+	if status := gpf.Mkdir(name, 0755, nil); status != fuse.OK {
+		t.Errorf("Mkdir with a %d byte name = %v", maxNameLen, status)
+	}
+}
+
+// TestEmptyName checks that the mount root, i.e., the empty name, and the
+// prefix directory can be read but not changed.
+func TestEmptyName(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	for _, name := range []string{"", testPrefix} {
+		if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Mode&fuse.S_IFDIR == 0 {
+			t.Errorf("GetAttr(%q) = %v, %v, want a directory", name, attr, status)
+		}
+		if _, status := gpf.OpenDir(name, nil); status != fuse.OK {
+			t.Errorf("OpenDir(%q) = %v", name, status)
+		}
+		if status := gpf.Access(name, unix.R_OK, nil); status != fuse.OK {
+			t.Errorf("Access(%q) = %v", name, status)
+		}
+		if _, status := gpf.ListXAttr(name, nil); status != fuse.OK {
+			t.Errorf("ListXAttr(%q) = %v", name, status)
+		}
+
+		for method, status := range map[string]fuse.Status{
+			"Open": func() fuse.Status {
+				_, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+				return status
+			}(),
+			"Create": func() fuse.Status {
+				_, status := gpf.Create(name, uint32(os.O_WRONLY), 0644, nil)
+				return status
+			}(),
+			"Mkdir":     gpf.Mkdir(name, 0755, nil),
+			"Rmdir":     gpf.Rmdir(name, nil),
+			"Unlink":    gpf.Unlink(name, nil),
+			"Rename":    gpf.Rename(name, testPrefix+"/moved", nil),
+			"Rename to": gpf.Rename(testPrefix+"/foo", name, nil),
+			"Truncate":  gpf.Truncate(name, 0, nil),
+			"Chmod":     gpf.Chmod(name, 0700, nil),
+			"Chown":     gpf.Chown(name, 0, 0, nil),
+		} {
+			if status != fuse.EPERM {
+				t.Errorf("%s(%q) = %v, want EPERM", method, name, status)
+			}
+		}
+	}
+}
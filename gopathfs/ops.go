@@ -0,0 +1,49 @@
+package gopathfs
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of operations kept by opLog.
+const maxRecentOps = 100
+
+// Op is a filesystem operation served by a GoPathFs.
+type Op struct {
+	Time time.Time
+	Op   string
+	Name string
+}
+
+// opLog keeps the most recent operations in a ring buffer.
+type opLog struct {
+	mu   sync.Mutex
+	ops  [maxRecentOps]Op
+	next int
+	full bool
+}
+
+func (ol *opLog) record(op, name string) {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	ol.ops[ol.next] = Op{Time: time.Now(), Op: op, Name: name}
+	ol.next++
+	if ol.next == maxRecentOps {
+		ol.next = 0
+		ol.full = true
+	}
+}
+
+// RecentOps returns the most recent operations, oldest first.
+func (gpf *GoPathFs) RecentOps() []Op {
+	ol := &gpf.ops
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	ops := []Op{}
+	if ol.full {
+		ops = append(ops, ol.ops[ol.next:]...)
+	}
+	return append(ops, ol.ops[:ol.next]...)
+}
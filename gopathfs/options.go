@@ -0,0 +1,120 @@
+package gopathfs
+
+import (
+	"fmt"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// Option configures a GoPathFs created by New.
+type Option func(*options)
+
+type options struct {
+	debug       bool
+	cfg         *conf.GobazelConf
+	readOnly    bool
+	vendors     []string
+	genDirs     []string
+	auditLogger AuditLogger
+	tracer      Tracer
+	errorEvents chan<- ErrorEvent
+}
+
+// WithDebug prints debug output and serves the .gobazel directory.
+func WithDebug() Option {
+	return func(o *options) {
+		o.debug = true
+	}
+}
+
+// WithConfig uses the given config instead of the one in Dirs.GobzlConf.
+func WithConfig(cfg *conf.GobazelConf) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithReadOnly rejects all changes through the mount with EROFS.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithVendors serves the given vendor directories instead of the configured
+// vendor-dirs, also after the config is reloaded.
+func WithVendors(vendors ...string) Option {
+	return func(o *options) {
+		o.vendors = vendors
+	}
+}
+
+// WithGenDirs searches the given genfiles directories instead of the
+// configured gen-dirs, also after the config is reloaded.
+func WithGenDirs(genDirs ...string) Option {
+	return func(o *options) {
+		o.genDirs = genDirs
+	}
+}
+
+// WithLogger sets the logger of mutating operations, see SetAuditLogger.
+func WithLogger(l AuditLogger) Option {
+	return func(o *options) {
+		o.auditLogger = l
+	}
+}
+
+// WithTracer sets the tracer of operations, see SetTracer.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithErrorEvents sets the channel error events are sent to, see
+// SetErrorEvents. Unlike SetErrorEvents, it also gets the events of the
+// checks done by New, e.g., of missing vendor-dirs.
+func WithErrorEvents(ch chan<- ErrorEvent) Option {
+	return func(o *options) {
+		o.errorEvents = ch
+	}
+}
+
+// New returns a new GoPathFs serving the given directories, configured by
+// the given options. Unless WithConfig is given, the config is read from
+// dirs.GobzlConf.
+func New(dirs Dirs, opts ...Option) (*GoPathFs, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cfg == nil {
+		cfg, err := conf.ParseConfig(dirs.GobzlConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gobazel config file %s, %v", dirs.GobzlConf, err)
+		}
+		o.cfg = cfg
+	}
+	return newGoPathFs(&dirs, o)
+}
+
+// withOptions returns the given config with the settings of the options
+// applied, leaving the given one unchanged.
+func (o *options) withOptions(cfg *conf.GobazelConf) *conf.GobazelConf {
+	if o.vendors == nil && o.genDirs == nil {
+		return cfg
+	}
+
+	c := *cfg
+	if o.vendors != nil {
+		c.Vendors = o.vendors
+		c.VendorSet = map[string]struct{}{}
+		for _, v := range o.vendors {
+			c.VendorSet[v] = struct{}{}
+		}
+	}
+	if o.genDirs != nil {
+		c.GenDirs = o.genDirs
+	}
+	return &c
+}
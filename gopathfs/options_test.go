@@ -0,0 +1,65 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestNewDefaults(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	if gpf.debug || gpf.isReadOnly(testPrefix+"/foo") || gpf.auditLogger != nil {
+		t.Errorf("New without options: debug %v, read-only %v, logger %v", gpf.debug, gpf.isReadOnly(testPrefix+"/foo"), gpf.auditLogger)
+	}
+	if got := gpf.config().Vendors; !reflect.DeepEqual(got, []string{"vendor"}) {
+		t.Errorf("vendor-dirs = %v, want those of the config", got)
+	}
+
+	// The config is read from the config file unless given.
+	dirs := Dirs{Workspace: t.TempDir(), GobzlConf: filepath.Join(t.TempDir(), "missing")}
+	if _, err := New(dirs); err == nil {
+		t.Errorf("New without a config file succeeded")
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	logger := &auditRecorder{}
+	gpf, ws := newTestFs(t, nil, WithDebug(), WithReadOnly(), WithVendors("v1", "v2"),
+		WithGenDirs("bazel-bin"), WithLogger(logger))
+
+	if !gpf.debug || gpf.auditLogger != logger {
+		t.Errorf("debug %v, logger %v, want the options'", gpf.debug, gpf.auditLogger)
+	}
+	if _, status := gpf.Create(testPrefix+"/a.go", uint32(os.O_WRONLY), 0644, nil); status != fuse.EROFS {
+		t.Errorf("Create on a read-only mount = %v, want EROFS", status)
+	}
+
+	// Vendor and gen dir options are applied over the config, also when
+	// it's reloaded.
+	checkDirs := func(when string) {
+		t.Helper()
+		if got := gpf.config().Vendors; !reflect.DeepEqual(got, []string{"v1", "v2"}) {
+			t.Errorf("vendor-dirs %s = %v, want [v1 v2]", when, got)
+		}
+		if _, ok := gpf.config().VendorSet["v2"]; !ok || len(gpf.config().VendorSet) != 2 {
+			t.Errorf("vendor set %s = %v, want v1 and v2", when, gpf.config().VendorSet)
+		}
+		if got := gpf.config().GenDirs; !reflect.DeepEqual(got, []string{"bazel-bin"}) {
+			t.Errorf("gen-dirs %s = %v, want [bazel-bin]", when, got)
+		}
+	}
+	checkDirs("on mount")
+	if err := gpf.Reload(testConfig()); err != nil {
+		t.Fatal(err)
+	}
+	checkDirs("after a reload")
+
+	writeFile(t, filepath.Join(ws, "v2", "github.com", "y", "b.go"), "package y\n")
+	gpf.FlushCaches()
+	if got, status := readMountFile(t, gpf, "github.com/y/b.go"); status != fuse.OK || got != "package y\n" {
+		t.Errorf("reading from the vendor option's directory = %q, %v", got, status)
+	}
+}
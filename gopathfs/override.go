@@ -0,0 +1,125 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// Name of the per-directory files overriding the global config for their
+// subtree of the first-party tree.
+const dirOverrideFileName = ".gobazel"
+
+// Directories beyond which the dirOverrides cache starts over.
+const maxOverrideDirs = 100000
+
+// dirOverrides caches the override in effect in each directory, relative to
+// the workspace, i.e., that of the nearest .gobazel file in it or above, or
+// nil if none. Lookups don't lock, as they happen for most operations.
+type dirOverrides struct {
+	entries atomic.Value // Of *sync.Map.
+	count   int64        // Accessed atomically.
+}
+
+func (do *dirOverrides) clear() {
+	do.entries.Store(&sync.Map{})
+	atomic.StoreInt64(&do.count, 0)
+}
+
+func (do *dirOverrides) load(dir string) (*conf.DirOverride, bool) {
+	m, ok := do.entries.Load().(*sync.Map)
+	if !ok {
+		return nil, false
+	}
+	v, ok := m.Load(dir)
+	if !ok {
+		return nil, false
+	}
+	return v.(*conf.DirOverride), true
+}
+
+func (do *dirOverrides) store(dir string, o *conf.DirOverride) {
+	m, ok := do.entries.Load().(*sync.Map)
+	if !ok || atomic.LoadInt64(&do.count) >= maxOverrideDirs {
+		do.clear()
+		m = do.entries.Load().(*sync.Map)
+	}
+	if _, loaded := m.LoadOrStore(dir, o); !loaded {
+		atomic.AddInt64(&do.count, 1)
+	}
+}
+
+// dirOverride returns the override of the nearest .gobazel file in the
+// directory of the given mount path and above, or nil if none or the path
+// isn't backed by the workspace.
+func (gpf *GoPathFs) dirOverride(name string) *conf.DirOverride {
+	rel, ok := gpf.workspaceRel(name)
+	if !ok {
+		return nil
+	}
+	return gpf.nearestDirOverride(filepath.Dir(rel))
+}
+
+// nearestDirOverride returns the override in effect in the given directory,
+// relative to the workspace.
+func (gpf *GoPathFs) nearestDirOverride(dir string) *conf.DirOverride {
+	if o, ok := gpf.overrides.load(dir); ok {
+		return o
+	}
+
+	o := gpf.loadDirOverride(dir)
+	if o == nil && dir != "." && dir != pathSeparator {
+		o = gpf.nearestDirOverride(filepath.Dir(dir))
+	}
+	gpf.overrides.store(dir, o)
+	return o
+}
+
+// workspaceRel returns the path relative to the workspace of the given
+// first-party or fall-through mount path.
+func (gpf *GoPathFs) workspaceRel(name string) (string, bool) {
+	if gpf.isGoRoot(name) {
+		return "", false
+	}
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if strings.HasPrefix(name, prefix) {
+		return name[len(prefix):], true
+	}
+	if gpf.isFallThrough(name) {
+		return name, true
+	}
+	return "", false
+}
+
+// loadDirOverride parses the .gobazel file in the given directory, relative
+// to the workspace, if any.
+func (gpf *GoPathFs) loadDirOverride(dir string) *conf.DirOverride {
+	path := filepath.Join(gpf.workspace(), dir, dirOverrideFileName)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	o, err := conf.ParseDirOverride(path)
+	if err != nil {
+		fmt.Printf("Warning, failed to parse %s, ignored, %v.\n", path, err)
+		return nil
+	}
+	return o
+}
+
+// genDirs returns the genfiles directories for the given mount path, i.e.,
+// those of the nearest .gobazel file setting them, or those of the
+// target-arch output roots followed by gen-dirs.
+func (gpf *GoPathFs) genDirs(name string) []string {
+	if o := gpf.dirOverride(name); o != nil && len(o.GenDirs) > 0 {
+		return o.GenDirs
+	}
+	if archDirs := gpf.archGenDirs(); len(archDirs) > 0 {
+		return append(archDirs[:len(archDirs):len(archDirs)], gpf.config().GenDirs...)
+	}
+	return gpf.config().GenDirs
+}
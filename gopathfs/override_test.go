@@ -0,0 +1,39 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+func TestDirOverrideLookup(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "bar", "a.go"), "package bar\n")
+	// As if parsed from foo/.gobazel.
+	gpf.overrides.store("foo", &conf.DirOverride{ReadOnly: true})
+
+	for name, want := range map[string]bool{
+		testPrefix + "/foo/a.go":         true,
+		testPrefix + "/foo/bar":          true,
+		testPrefix + "/foo/bar/a.go":     true,
+		testPrefix + "/foo/bar/baz/b.go": true,
+		testPrefix + "/foo":              false,
+		testPrefix + "/qux/a.go":         false,
+	} {
+		if got := gpf.dirOverride(name) != nil; got != want {
+			t.Errorf("dirOverride(%s) found = %v, want %v", name, got, want)
+		}
+	}
+
+	// Directories are cached, not files.
+	gpf.overrides.clear()
+	for i := 0; i < 100; i++ {
+		gpf.dirOverride(fmt.Sprintf("%s/foo/bar/%d.go", testPrefix, i))
+	}
+	if n := atomic.LoadInt64(&gpf.overrides.count); n != 3 {
+		t.Errorf("%d directories cached, want 3", n)
+	}
+}
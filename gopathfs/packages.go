@@ -0,0 +1,121 @@
+package gopathfs
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// PackageInfo describes a Go package served by the mount.
+type PackageInfo struct {
+	ImportPath  string
+	Kind        PathKind
+	GoFiles     int // Not counting test files.
+	TestGoFiles int
+	IsMain      bool
+}
+
+// ListPackages walks the first-party and vendor trees as they are served,
+// i.e., respecting ignored and excluded folders, and returns the packages
+// found, sorted by import path. GOROOT is not walked.
+func (gpf *GoPathFs) ListPackages() []PackageInfo {
+	pl := packageLister{gpf: gpf}
+
+	gpf.walkTree(gpf.walkRoots(), pl.walk)
+
+	sort.Slice(pl.pkgs, func(i, j int) bool {
+		return pl.pkgs[i].ImportPath < pl.pkgs[j].ImportPath
+	})
+	return pl.pkgs
+}
+
+type packageLister struct {
+	gpf    *GoPathFs
+	visits walkVisits
+
+	mu   sync.Mutex
+	pkgs []PackageInfo
+}
+
+// walk lists the package in the given directory, if any, and returns its
+// subdirectories.
+func (pl *packageLister) walk(dir string) (subdirs []string) {
+	if pl.gpf.isGoRoot(dir) || !pl.visits.enter(pl.gpf, dir) {
+		return nil
+	}
+
+	entries, status := pl.gpf.scanDir(dir)
+	if status != fuse.OK {
+		return nil
+	}
+
+	pkg := PackageInfo{ImportPath: dir}
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR != 0 {
+			subdirs = append(subdirs, filepath.Join(dir, e.Name))
+			continue
+		}
+		if !strings.HasSuffix(e.Name, ".go") {
+			continue
+		}
+		if strings.HasSuffix(e.Name, "_test.go") {
+			pkg.TestGoFiles++
+			continue
+		}
+		if pkg.GoFiles == 0 {
+			pkg.IsMain = pl.gpf.packageName(filepath.Join(dir, e.Name)) == "main"
+		}
+		pkg.GoFiles++
+	}
+	if pkg.GoFiles == 0 && pkg.TestGoFiles == 0 {
+		return subdirs
+	}
+
+	if c, _, ok := pl.gpf.resolve(dir); ok {
+		pkg.Kind = c.kind
+	} else if dir == pl.gpf.config().GoPkgPrefix {
+		pkg.Kind = KindFirstParty
+	}
+
+	pl.mu.Lock()
+	pl.pkgs = append(pl.pkgs, pkg)
+	pl.mu.Unlock()
+	return subdirs
+}
+
+// walkRoots returns the top-level mount directories walks start from, i.e.,
+// all but the fall-through ones.
+func (gpf *GoPathFs) walkRoots() []string {
+	roots := []string{}
+	top, _ := gpf.listDir("")
+	for _, e := range top {
+		if e.Mode&fuse.S_IFDIR == 0 {
+			continue
+		}
+		if _, ok := gpf.config().FallThroughSet[e.Name]; ok {
+			continue
+		}
+		roots = append(roots, e.Name)
+	}
+	return roots
+}
+
+// packageName returns the package name declared by the given Go file, or an
+// empty string if it can't be read.
+func (gpf *GoPathFs) packageName(name string) string {
+	c, _, ok := gpf.resolve(name)
+	if !ok || c.src != nil {
+		return ""
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), c.path, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return f.Name.Name
+}
@@ -0,0 +1,119 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWalkTreeBounded(t *testing.T) {
+	cfg := testConfig()
+	cfg.ScanConcurrencyLimit = 2
+	gpf, _ := newTestFs(t, cfg)
+
+	var (
+		mu              sync.Mutex
+		visited         = map[string]bool{}
+		active, maxSeen int
+	)
+	gpf.walkTree([]string{"a", "b"}, func(dir string) []string {
+		mu.Lock()
+		visited[dir] = true
+		active++
+		if active > maxSeen {
+			maxSeen = active
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if strings.Count(dir, "/") == 2 {
+			return nil
+		}
+		return []string{dir + "/0", dir + "/1", dir + "/2"}
+	})
+
+	// Two roots, each with 3+9 subdirectories.
+	if len(visited) != 26 {
+		t.Errorf("visited %d directories, want 26", len(visited))
+	}
+	if maxSeen > 2 {
+		t.Errorf("%d directories visited at once, want at most 2", maxSeen)
+	}
+}
+
+func TestListPackages(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	for i := 0; i < 20; i++ {
+		writeFile(t, filepath.Join(ws, fmt.Sprintf("p%d", i), "sub", "a.go"), "package sub\n")
+	}
+	writeFile(t, filepath.Join(ws, "cmd", "main.go"), "package main\n")
+	writeFile(t, filepath.Join(ws, "vendor", "github.com", "y", "y_test.go"), "package y\n")
+
+	pkgs := gpf.ListPackages()
+	byPath := map[string]PackageInfo{}
+	for _, p := range pkgs {
+		byPath[p.ImportPath] = p
+	}
+	if len(pkgs) != 22 {
+		t.Errorf("ListPackages = %d packages, want 22", len(pkgs))
+	}
+	if p := byPath[testPrefix+"/cmd"]; !p.IsMain || p.Kind != KindFirstParty {
+		t.Errorf("cmd = %+v, want a first-party main package", p)
+	}
+	if p := byPath["github.com/y"]; p.TestGoFiles != 1 || p.Kind != KindVendor {
+		t.Errorf("github.com/y = %+v, want a vendor package with a test file", p)
+	}
+}
+
+func TestScanConcurrencyReload(t *testing.T) {
+	cfg := testConfig()
+	cfg.ScanConcurrencyLimit = 2
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	// A walk holding every slot while the config is reloaded.
+	old := gpf.scanSem()
+	old <- struct{}{}
+	old <- struct{}{}
+
+	more := *cfg
+	more.ScanConcurrencyLimit = 4
+	if err := gpf.Reload(&more); err != nil {
+		t.Fatal(err)
+	}
+	if n := cap(gpf.scanSem()); n != 4 {
+		t.Errorf("scan-concurrency after reload = %d, want 4", n)
+	}
+
+	// Walks started after the reload use the new slots.
+	done := make(chan []PackageInfo)
+	go func() {
+		done <- gpf.ListPackages()
+	}()
+	select {
+	case pkgs := <-done:
+		if len(pkgs) != 1 {
+			t.Errorf("ListPackages = %d packages, want 1", len(pkgs))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListPackages blocked by the slots before the reload")
+	}
+	<-old
+	<-old
+
+	// An unchanged limit keeps the slots.
+	sem := gpf.scanSem()
+	if err := gpf.Reload(&more); err != nil {
+		t.Fatal(err)
+	}
+	if gpf.scanSem() != sem {
+		t.Error("reload without changes replaced the scan slots")
+	}
+}
@@ -0,0 +1,21 @@
+package gopathfs
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Number of mutexes of pathLocks.
+const pathLockShards = 64
+
+// pathLocks serializes operations on the same backing path, with a fixed set
+// of mutexes shared by hash of the path.
+type pathLocks struct {
+	shards [pathLockShards]sync.Mutex
+}
+
+func (pl *pathLocks) get(path string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return &pl.shards[h.Sum32()%pathLockShards]
+}
@@ -0,0 +1,87 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// TestConcurrentCreate is meant to be run with -race too.
+func TestConcurrentCreate(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	if err := os.Mkdir(filepath.Join(ws, "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const creators = 16
+
+	// With O_EXCL, exactly one of the creates succeeds.
+	name := testPrefix + "/foo/excl.go"
+	statuses := make([]fuse.Status, creators)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, status := gpf.Create(name, uint32(os.O_WRONLY|os.O_EXCL), 0644, nil)
+			if status == fuse.OK {
+				f.Release()
+			}
+			statuses[i] = status
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, status := range statuses {
+		switch status {
+		case fuse.OK:
+			created++
+		case fuse.Status(unix.EEXIST):
+		default:
+			t.Errorf("Create with O_EXCL = %v, want OK or EEXIST", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d creates with O_EXCL succeeded, want 1", created)
+	}
+
+	// Without O_TRUNC, no create truncates what another has written, so the
+	// file holds one whole write.
+	name = testPrefix + "/foo/shared.go"
+	for i := 0; i < creators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, status := gpf.Create(name, uint32(os.O_WRONLY), 0644, nil)
+			if status != fuse.OK {
+				t.Errorf("Create = %v", status)
+				return
+			}
+			defer f.Release()
+			if _, status := f.Write([]byte(fmt.Sprintf("writer %02d\n", i)), 0); status != fuse.OK {
+				t.Errorf("Write = %v", status)
+			}
+		}(i)
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(filepath.Join(ws, "foo", "shared.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var i int
+	if n, err := fmt.Sscanf(string(data), "writer %02d\n", &i); n != 1 || err != nil || len(data) != len("writer 00\n") {
+		t.Errorf("concurrently created file holds %q, want one whole write", data)
+	}
+	fi, err := os.Stat(filepath.Join(ws, "foo", "shared.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("concurrently created file has mode %v, want 0644", fi.Mode())
+	}
+}
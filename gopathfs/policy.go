@@ -0,0 +1,42 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// checkCreatePolicy returns EPERM if the create-policy forbids creating a
+// file with the given mount path, e.g., by creating or renaming it.
+func (gpf *GoPathFs) checkCreatePolicy(name string) fuse.Status {
+	cp := gpf.config().CreatePolicy
+	if cp == nil {
+		return fuse.OK
+	}
+	if status, ok := gpf.checkQuarantine(name); ok {
+		return status
+	}
+
+	base := filepath.Base(name)
+	for _, p := range cp.Deny {
+		if ok, _ := filepath.Match(p, base); ok {
+			return gpf.reject(name, fuse.EPERM, "Rejected creating %s, denied by create-policy pattern %s.\n", name, p)
+		}
+	}
+	return fuse.OK
+}
+
+// checkMaxSize returns EFBIG if a file written through the mount would grow
+// beyond the create-policy max-size. Unlike denied names, the path isn't
+// quarantined, as the next write may well be smaller, and the quarantine
+// would reject creating the path again.
+func (gpf *GoPathFs) checkMaxSize(name string, size int64) fuse.Status {
+	cp := gpf.config().CreatePolicy
+	if cp == nil || cp.MaxBytes <= 0 || size <= cp.MaxBytes {
+		return fuse.OK
+	}
+	fmt.Printf("Rejected growing %s to %d bytes, over create-policy max-size %d.\n", name, size, cp.MaxBytes)
+	return fuse.Status(unix.EFBIG)
+}
@@ -0,0 +1,111 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+	"golang.org/x/sys/unix"
+)
+
+func TestQuarantine(t *testing.T) {
+	cfg := testConfig()
+	cfg.CreatePolicy = &conf.CreatePolicyConf{Deny: []string{"*.orig"}, MaxBytes: 16}
+	cfg.QuarantineDuration = time.Minute
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	// Writing too much doesn't keep the file from being created again.
+	f, status := gpf.Open(name, uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	if _, status := f.Write([]byte(strings.Repeat("x", 17)), 0); status != fuse.Status(unix.EFBIG) {
+		t.Errorf("Write over max-size = %v, want EFBIG", status)
+	}
+	f.Release()
+	if status := gpf.Unlink(name, nil); status != fuse.OK {
+		t.Fatalf("Unlink = %v", status)
+	}
+	f, status = gpf.Create(name, uint32(os.O_RDWR), 0644, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create after a write over max-size = %v", status)
+	}
+	f.Release()
+
+	// Denied names are quarantined until the caches are flushed.
+	orig := testPrefix + "/foo/a.go.orig"
+	for i := 0; i < 2; i++ {
+		if _, status := gpf.Create(orig, uint32(os.O_RDWR), 0644, nil); status != fuse.EPERM {
+			t.Fatalf("Create of a denied name = %v, want EPERM", status)
+		}
+	}
+	if paths, hits := gpf.quarantineStats(); paths != 1 || hits != 1 {
+		t.Errorf("quarantine stats = %d paths, %d hits, want 1, 1", paths, hits)
+	}
+	gpf.FlushCaches()
+	if paths, _ := gpf.quarantineStats(); paths != 0 {
+		t.Errorf("%d paths quarantined after FlushCaches, want 0", paths)
+	}
+}
+
+func TestCreatePolicy(t *testing.T) {
+	cfg := testConfig()
+	cfg.CreatePolicy = &conf.CreatePolicyConf{Deny: []string{"*.so", "*.exe"}, MaxBytes: 16}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "lib.c"), "")
+
+	for _, name := range []string{"a.go", "lib.so.go", "notes.txt", "exe"} {
+		f, status := gpf.Create(testPrefix+"/foo/"+name, uint32(os.O_WRONLY), 0644, nil)
+		if status != fuse.OK {
+			t.Errorf("Create(%s) = %v", name, status)
+			continue
+		}
+		f.Release()
+	}
+	for _, name := range []string{"lib.so", "tool.exe"} {
+		if _, status := gpf.Create(testPrefix+"/foo/"+name, uint32(os.O_WRONLY), 0644, nil); status != fuse.EPERM {
+			t.Errorf("Create(%s) = %v, want EPERM", name, status)
+		}
+		if _, err := os.Lstat(filepath.Join(ws, "foo", name)); !os.IsNotExist(err) {
+			t.Errorf("%s created, %v", name, err)
+		}
+	}
+	if status := gpf.Rename(testPrefix+"/foo/lib.c", testPrefix+"/foo/lib.so", nil); status != fuse.EPERM {
+		t.Errorf("Rename to lib.so = %v, want EPERM", status)
+	}
+
+	// Up to max-size bytes may be written, truncated or allocated.
+	name := testPrefix + "/foo/a.go"
+	f, status := gpf.Open(name, uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	if _, status := f.Write([]byte(strings.Repeat("x", 16)), 0); status != fuse.OK {
+		t.Errorf("Write of max-size bytes = %v", status)
+	}
+	if _, status := f.Write([]byte("x"), 16); status != fuse.Status(unix.EFBIG) {
+		t.Errorf("Write past max-size = %v, want EFBIG", status)
+	}
+	for op, status := range map[string]fuse.Status{
+		"Truncate":      gpf.Truncate(name, 17, nil),
+		"File.Truncate": f.Truncate(17),
+		"File.Allocate": f.Allocate(0, 17, 0),
+	} {
+		if status != fuse.Status(unix.EFBIG) {
+			t.Errorf("%s past max-size = %v, want EFBIG", op, status)
+		}
+	}
+	if status := gpf.Truncate(name, 16, nil); status != fuse.OK {
+		t.Errorf("Truncate to max-size = %v", status)
+	}
+	if fi, err := os.Stat(filepath.Join(ws, "foo", "a.go")); err != nil || fi.Size() != 16 {
+		t.Errorf("a.go = %v, %v, want 16 bytes", fi, err)
+	}
+}
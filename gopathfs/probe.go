@@ -0,0 +1,49 @@
+package gopathfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// ProbeResult reports how an import path resolves in the virtual GOPATH.
+type ProbeResult struct {
+	ImportPath string
+	Resolved   bool
+	Kind       PathKind
+	Location   string
+	Candidates int
+	Files      int
+}
+
+// Probe resolves the given import path the same way the mount does, without
+// going through the kernel. It's meant as a smoke test to run right after
+// mounting.
+func (gpf *GoPathFs) Probe(importPath string) (ProbeResult, error) {
+	name := strings.Trim(importPath, pathSeparator)
+	result := ProbeResult{ImportPath: importPath}
+	if name == "" {
+		return result, fmt.Errorf("empty import path")
+	}
+
+	c, tried, ok := gpf.resolve(name)
+	result.Candidates = tried
+	if !ok {
+		return result, nil
+	}
+	result.Resolved = true
+	result.Kind = c.kind
+	result.Location = c.path
+
+	entries, status := gpf.OpenDir(name, nil)
+	if status != fuse.OK {
+		return result, fmt.Errorf("failed to list package %s: %v", importPath, status)
+	}
+	for _, e := range entries {
+		if e.Mode&fuse.S_IFDIR == 0 {
+			result.Files++
+		}
+	}
+	return result, nil
+}
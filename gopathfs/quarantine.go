@@ -0,0 +1,107 @@
+package gopathfs
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Entries beyond which expired paths are pruned from the quarantine.
+const maxQuarantined = 10000
+
+// quarantine remembers recently rejected mount paths, which are rejected
+// again with the same status without rerunning the checks or logging each
+// time, e.g., while a misbehaving tool retries in a tight loop.
+type quarantine struct {
+	mu      sync.Mutex
+	entries map[string]*quarantineEntry
+
+	hits int64
+}
+
+type quarantineEntry struct {
+	status  fuse.Status
+	expires time.Time
+	count   int // Rejections since the last log.
+}
+
+// checkQuarantine returns the status the given mount path was recently rejected with,
+// if it's still quarantined.
+func (gpf *GoPathFs) checkQuarantine(name string) (fuse.Status, bool) {
+	if gpf.config().QuarantineDuration <= 0 {
+		return fuse.OK, false
+	}
+
+	q := &gpf.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[name]
+	if !ok || time.Now().After(e.expires) {
+		return fuse.OK, false
+	}
+	e.count++
+	atomic.AddInt64(&q.hits, 1)
+	return e.status, true
+}
+
+// reject logs the rejection of the given mount path, unless it was already
+// logged within the quarantine period, and quarantines it. It returns the
+// given status.
+func (gpf *GoPathFs) reject(name string, status fuse.Status, format string, args ...interface{}) fuse.Status {
+	d := gpf.config().QuarantineDuration
+	if d <= 0 {
+		fmt.Printf(format, args...)
+		return status
+	}
+
+	q := &gpf.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if q.entries == nil {
+		q.entries = map[string]*quarantineEntry{}
+	}
+	if len(q.entries) > maxQuarantined {
+		for n, e := range q.entries {
+			if now.After(e.expires) {
+				delete(q.entries, n)
+			}
+		}
+	}
+
+	fmt.Printf(format, args...)
+	if e, ok := q.entries[name]; ok && e.count > 0 {
+		fmt.Printf("%s was also rejected %d more times while quarantined.\n", name, e.count)
+	}
+	q.entries[name] = &quarantineEntry{status: status, expires: now.Add(d)}
+	return status
+}
+
+// clear releases all quarantined paths, e.g., after the create-policy
+// changed.
+func (q *quarantine) clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = nil
+}
+
+// quarantineStats returns the number of quarantined paths and the
+// rejections short-circuited by the quarantine.
+func (gpf *GoPathFs) quarantineStats() (paths int, hits int64) {
+	q := &gpf.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range q.entries {
+		if !now.After(e.expires) {
+			paths++
+		}
+	}
+	return paths, atomic.LoadInt64(&q.hits)
+}
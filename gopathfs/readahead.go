@@ -0,0 +1,24 @@
+package gopathfs
+
+import (
+	"fmt"
+
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// adviseReadahead applies the readahead hint configured for the given
+// resolution kind to the backing file, e.g., to read large generated files
+// sequentially.
+func (gpf *GoPathFs) adviseReadahead(file nodefs.File, kind PathKind) {
+	hint, ok := gpf.config().ReadaheadHints[kind.String()]
+	if !ok {
+		return
+	}
+	lf, ok := file.(*loopbackFile)
+	if !ok {
+		return
+	}
+	if err := fadvise(int(lf.f.Fd()), hint); err != nil {
+		fmt.Printf("Warning, failed to set readahead of %s to %s, %v.\n", lf.f.Name(), hint, err)
+	}
+}
@@ -0,0 +1,15 @@
+package gopathfs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// macOS has no posix_fadvise, only a switch for readahead.
+func fadvise(fd int, hint string) error {
+	on := 1
+	if hint == "random" {
+		on = 0
+	}
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_RDAHEAD, on)
+	return err
+}
@@ -0,0 +1,15 @@
+package gopathfs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+var fadviseAdvice = map[string]int{
+	"normal":     unix.FADV_NORMAL,
+	"sequential": unix.FADV_SEQUENTIAL,
+	"random":     unix.FADV_RANDOM,
+}
+
+func fadvise(fd int, hint string) error {
+	return unix.Fadvise(fd, 0, 0, fadviseAdvice[hint])
+}
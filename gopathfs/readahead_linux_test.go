@@ -0,0 +1,65 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// BenchmarkSequentialRead reads a large generated file through the mount
+// from a cold page cache, with the kernel's default readahead and with the
+// sequential readahead hint for genfiles.
+func BenchmarkSequentialRead(b *testing.B) {
+	const size = 64 << 20
+	for _, hint := range []string{"", "sequential"} {
+		name := hint
+		if name == "" {
+			name = "default"
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg := testConfig()
+			if hint != "" {
+				cfg.ReadaheadHints = map[string]string{KindGenfiles.String(): hint}
+			}
+			gpf, ws := newTestFs(b, cfg)
+			backing := filepath.Join(ws, "bazel-genfiles", "foo", "large.pb.go")
+			writeFile(b, backing, strings.Repeat("x", size))
+
+			buf := make([]byte, 128<<10)
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dropPageCache(b, backing)
+				b.StartTimer()
+
+				f, status := gpf.Open(testPrefix+"/foo/large.pb.go", uint32(os.O_RDONLY), nil)
+				if status != fuse.OK {
+					b.Fatal(status)
+				}
+				for off := int64(0); off < size; off += int64(len(buf)) {
+					if _, status := f.Read(buf, off); status != fuse.OK {
+						b.Fatal(status)
+					}
+				}
+				f.Release()
+			}
+		})
+	}
+}
+
+// dropPageCache evicts the given file from the page cache.
+func dropPageCache(b *testing.B, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+		b.Fatal(err)
+	}
+}
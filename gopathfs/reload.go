@@ -0,0 +1,185 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/linuxerwang/gobazel/conf"
+	"github.com/rjeczalik/notify"
+)
+
+// settings holds the config and everything derived from it, which are
+// replaced together on reload.
+type settings struct {
+	cfg           *conf.GobazelConf
+	ignoreMatcher *ignoreMatcher // All ignore-dirs patterns, nil if none.
+	workspace     string         // The worktree served, if git-worktree is set.
+	baseWorkspace string         // The bazel workspace, set by Dirs or Remount.
+	goSDKDir      string         // Found for baseWorkspace, see findGoSDK.
+
+	// Limits the directories read by background walks, replaced when
+	// scan-concurrency changes. Walks running keep the one they started
+	// with.
+	scanSem chan struct{}
+}
+
+func newSettings(cfg *conf.GobazelConf, workspace string) (*settings, error) {
+	ignoreMatcher, err := newIgnoreMatcher(cfg.Ignores)
+	if err != nil {
+		return nil, err
+	}
+
+	baseWorkspace := workspace
+	if cfg.GitWorktree != "" {
+		if dir, err := worktreeDir(workspace, cfg.GitWorktree); err == nil {
+			workspace = dir
+		} else {
+			fmt.Printf("Failed to find git worktree %s, serving %s instead, %v.\n", cfg.GitWorktree, workspace, err)
+		}
+	}
+
+	return &settings{
+		cfg:           cfg,
+		ignoreMatcher: ignoreMatcher,
+		workspace:     workspace,
+		baseWorkspace: baseWorkspace,
+	}, nil
+}
+
+func (gpf *GoPathFs) loadSettings() *settings {
+	return gpf.settings.Load().(*settings)
+}
+
+// config returns the current config.
+func (gpf *GoPathFs) config() *conf.GobazelConf {
+	return gpf.loadSettings().cfg
+}
+
+// workspace returns the folder first-party and vendor files are served from,
+// which is the bazel workspace or one of its git worktrees.
+func (gpf *GoPathFs) workspace() string {
+	return gpf.loadSettings().workspace
+}
+
+// Reload applies the given config to the running file system. The mount
+// related settings (go-path and go-pkg-prefix) can't be changed without
+// remounting; the previous config is kept if the new one is invalid.
+func (gpf *GoPathFs) Reload(cfg *conf.GobazelConf) error {
+	old := gpf.config()
+	if cfg.GoPath != old.GoPath || cfg.GoPkgPrefix != old.GoPkgPrefix {
+		return fmt.Errorf("go-path and go-pkg-prefix can't be changed without remounting")
+	}
+
+	return gpf.swapSettings(cfg, gpf.loadSettings().baseWorkspace)
+}
+
+// Remount serves the given config and workspace behind the existing mount,
+// for changes Reload can't apply in place, e.g., go-pkg-prefix or the
+// workspace. Unlike unmounting and mounting again, processes keep their
+// working directories in the mount, though ones below the old prefix become
+// stale. Only the workspace of dirs is applied, and the Go SDK found for it;
+// both are updated in the Dirs given to New. Changing go-path, i.e., the
+// mount point, needs a real remount and returns an error, as do configs
+// serving first-party files from a git revision, which is read on startup.
+func (gpf *GoPathFs) Remount(cfg *conf.GobazelConf, dirs Dirs) error {
+	old := gpf.config()
+	if cfg.GoPath != old.GoPath || dirs.SrcDir != gpf.dirs.SrcDir {
+		return fmt.Errorf("go-path can't be changed without unmounting")
+	}
+	if cfg.GitRevision != old.GitRevision {
+		return fmt.Errorf("git-revision can't be changed without unmounting")
+	}
+
+	if err := gpf.swapSettings(cfg, dirs.Workspace); err != nil {
+		return err
+	}
+	gpf.dirs.Workspace = dirs.Workspace
+	gpf.dirs.GoSDKDir = gpf.goSDKDir()
+	if cfg.GoPkgPrefix != old.GoPkgPrefix && gpf.nodeFs != nil {
+		// FlushCaches invalidated the new top level entries only.
+		gpf.nodeFs.Notify(old.GoPkgPrefix)
+	}
+	fmt.Println("Remounted with the new config.")
+	return nil
+}
+
+// swapSettings serves the given config and workspace, and drops everything
+// cached about the previous ones.
+func (gpf *GoPathFs) swapSettings(cfg *conf.GobazelConf, workspace string) error {
+	st, err := newSettings(gpf.opts.withOptions(cfg), workspace)
+	if err != nil {
+		return err
+	}
+	old := gpf.loadSettings()
+	if st.baseWorkspace == old.baseWorkspace && cfg.GoSDKAutoDetect == old.cfg.GoSDKAutoDetect {
+		st.goSDKDir = old.goSDKDir
+	} else {
+		st.goSDKDir = findGoSDK(st.baseWorkspace, cfg.GoSDKAutoDetect)
+	}
+	if st.cfg.ScanConcurrencyLimit == old.cfg.ScanConcurrencyLimit {
+		st.scanSem = old.scanSem
+	} else {
+		st.scanSem = newScanSem(st.cfg.ScanConcurrencyLimit)
+	}
+	oldWorkspace := old.workspace
+	gpf.settings.Store(st)
+
+	if st.workspace != oldWorkspace {
+		fmt.Printf("Switched to workspace %s.\n", st.workspace)
+		if gpf.nodeFs != nil {
+			notify.Stop(gpf.notifyCh)
+			if err := notify.Watch(filepath.Join(st.workspace, "..."), gpf.notifyCh, notify.All); err != nil {
+				fmt.Printf("Failed to watch %s, %v.\n", st.workspace, err)
+			}
+		}
+	}
+
+	gpf.FlushCaches()
+	if st.cfg.ConsistencyCheckDuration != old.cfg.ConsistencyCheckDuration {
+		gpf.reloadConsistencyCheck()
+	}
+	return nil
+}
+
+// WatchConfig reloads the given config file whenever it changes.
+func (gpf *GoPathFs) WatchConfig(cfgPath string) error {
+	ch := make(chan notify.EventInfo, 10)
+	if err := notify.Watch(filepath.Dir(cfgPath), ch, notify.Create, notify.Write, notify.Rename); err != nil {
+		return err
+	}
+	gpf.cfgNotifyCh = ch
+
+	go func() {
+		for ei := range ch {
+			if filepath.Base(ei.Path()) != filepath.Base(cfgPath) {
+				continue
+			}
+
+			// Editors often write files in several steps.
+			time.Sleep(100 * time.Millisecond)
+			drain(ch)
+
+			cfg, err := conf.ParseConfig(cfgPath)
+			if err == nil {
+				err = gpf.Reload(cfg)
+			}
+			if err != nil {
+				fmt.Printf("Failed to reload gobazel config file %s, keeping the previous config, %v.\n", cfgPath, err)
+				continue
+			}
+			fmt.Printf("Reloaded gobazel config file %s.\n", cfgPath)
+		}
+	}()
+	return nil
+}
+
+func drain(ch chan notify.EventInfo) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// newBazelWorkspace returns a new workspace whose bazel-out links into an
+// output base with a go_sdk, and the go_sdk.
+func newBazelWorkspace(t *testing.T) (string, string) {
+	t.Helper()
+	ws, base := t.TempDir(), t.TempDir()
+	out := filepath.Join(base, "execroot", "__main__", "bazel-out")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(out, filepath.Join(ws, "bazel-out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(ws, "vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ws, filepath.Join(base, "external", "go_sdk")
+}
+
+func TestRemountSwitchesWorkspaceAndGoSDK(t *testing.T) {
+	gpf, _ := newTestFs(t, nil)
+	if sdk := gpf.goSDKDir(); sdk != "" {
+		t.Fatalf("Go SDK %s found without bazel-out", sdk)
+	}
+
+	ws, sdk := newBazelWorkspace(t)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(sdk, "src", "fmt", "print.go"), "package fmt\n")
+
+	dirs := *gpf.dirs
+	dirs.Workspace = ws
+	if err := gpf.Remount(testConfig(), dirs); err != nil {
+		t.Fatalf("Remount = %v", err)
+	}
+	if gpf.goSDKDir() != sdk || gpf.dirs.GoSDKDir != sdk {
+		t.Errorf("Go SDK = %q, Dirs.GoSDKDir = %q, want %s", gpf.goSDKDir(), gpf.dirs.GoSDKDir, sdk)
+	}
+	if gpf.dirs.Workspace != ws {
+		t.Errorf("Dirs.Workspace = %s, want %s", gpf.dirs.Workspace, ws)
+	}
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading foo/a.go = %q, %v", got, status)
+	}
+	if got, status := readMountFile(t, gpf, testPrefix+"/GOROOT/src/fmt/print.go"); status != fuse.OK || got != "package fmt\n" {
+		t.Errorf("reading GOROOT/src/fmt/print.go = %q, %v", got, status)
+	}
+
+	// Reloads keep the Go SDK found for the workspace.
+	if err := gpf.Reload(testConfig()); err != nil {
+		t.Fatalf("Reload = %v", err)
+	}
+	if gpf.goSDKDir() != sdk {
+		t.Errorf("Go SDK after Reload = %q, want %s", gpf.goSDKDir(), sdk)
+	}
+}
@@ -0,0 +1,424 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+	"golang.org/x/sys/unix"
+)
+
+// PathKind identifies the backing location a mount path resolves to.
+type PathKind int
+
+// Kinds of backing locations, in the order they are usually consulted.
+const (
+	KindUnknown PathKind = iota
+	KindTopDir
+	KindPrefixDir
+	KindFirstParty
+	KindOverlay
+	KindGenfiles
+	KindGoRoot
+	KindFallThrough
+	KindVendor
+	KindVendorGenfiles
+	KindPinned
+)
+
+var pathKindNames = map[PathKind]string{
+	KindUnknown:        "unknown",
+	KindTopDir:         "top-dir",
+	KindPrefixDir:      "prefix-dir",
+	KindFirstParty:     "first-party",
+	KindGenfiles:       "genfiles",
+	KindGoRoot:         "goroot",
+	KindFallThrough:    "fall-through",
+	KindVendor:         "vendor",
+	KindVendorGenfiles: "vendor-genfiles",
+	KindOverlay:        "overlay",
+	KindPinned:         "pinned",
+}
+
+func (k PathKind) String() string {
+	if s, ok := pathKindNames[k]; ok {
+		return s
+	}
+	return pathKindNames[KindUnknown]
+}
+
+// Resolver maps mount paths to backing paths, for layouts which don't fit
+// the built-in first-party, vendor and fall-through directories.
+type Resolver interface {
+	// Resolve returns the backing path for the given mount path, or false
+	// if the resolver doesn't handle it.
+	Resolve(name string) (backingPath string, kind PathKind, ok bool)
+}
+
+// SetResolvers sets the chain of resolvers consulted, in order, before the
+// default resolver. The first resolver handling a mount path serves it, for
+// lookups as well as changes, e.g., files created at the mount path are
+// created at the backing path it returns.
+func (gpf *GoPathFs) SetResolvers(resolvers ...Resolver) {
+	gpf.resolvers = resolvers
+}
+
+// DefaultResolver returns the resolver for the built-in layout, which is
+// always the last one in the chain.
+func (gpf *GoPathFs) DefaultResolver() Resolver {
+	return defaultResolver{gpf}
+}
+
+// resolverChain returns the resolvers set with SetResolvers followed by the
+// default resolver.
+func (gpf *GoPathFs) resolverChain() []Resolver {
+	return append(gpf.resolvers[:len(gpf.resolvers):len(gpf.resolvers)], gpf.DefaultResolver())
+}
+
+// customResolve resolves the given mount path with the links of the chain
+// before the default resolver, if one handles it. The default resolver's
+// layout has several candidates per mount path, see candidates.
+func (gpf *GoPathFs) customResolve(name string) (string, PathKind, bool) {
+	for _, r := range gpf.resolverChain() {
+		if _, ok := r.(defaultResolver); ok {
+			break
+		}
+		if backingPath, kind, ok := r.Resolve(name); ok {
+			return backingPath, kind, true
+		}
+	}
+	return "", KindUnknown, false
+}
+
+type defaultResolver struct {
+	gpf *GoPathFs
+}
+
+func (dr defaultResolver) Resolve(name string) (string, PathKind, bool) {
+	for _, c := range dr.gpf.builtinCandidates(name) {
+		if dr.gpf.exists(c) {
+			return c.path, c.kind, true
+		}
+	}
+	return "", KindUnknown, false
+}
+
+// candidate is a backing path a mount path may resolve to.
+type candidate struct {
+	path string // Full backing path, i.e., root joined with rel.
+	root string
+	rel  string
+	kind PathKind
+	src  ContentSource // Serves the candidate at rel if not nil.
+}
+
+func newCandidate(root, rel string, kind PathKind) candidate {
+	return candidate{
+		path: filepath.Join(root, rel),
+		root: root,
+		rel:  rel,
+		kind: kind,
+	}
+}
+
+// candidates returns the backing paths the given mount path may resolve to,
+// in lookup order. Virtual directories (the mount root and the prefix dir)
+// have no backing path and return nil.
+func (gpf *GoPathFs) candidates(name string) []candidate {
+	if name == "" || name == gpf.config().GoPkgPrefix {
+		return nil
+	}
+
+	// Custom resolvers take precedence over the built-in layout.
+	if backingPath, kind, ok := gpf.customResolve(name); ok {
+		return []candidate{newCandidate(backingPath, "", kind)}
+	}
+
+	return gpf.skipToRoot(name, gpf.withGzipped(name, gpf.builtinCandidates(name)))
+}
+
+// builtinCandidates returns the candidates of the built-in layout.
+func (gpf *GoPathFs) builtinCandidates(name string) []candidate {
+	if name == "" || name == gpf.config().GoPkgPrefix {
+		return nil
+	}
+
+	// Pinned packages are served from their directory only.
+	if pin, ok := gpf.pathPin(name); ok {
+		return []candidate{newCandidate(pin.Dir, name[len(pin.Prefix):], KindPinned)}
+	}
+
+	cands := []candidate{}
+
+	// Children of the virtual Golang prefix package.
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if strings.HasPrefix(name, prefix) {
+		rel := name[len(prefix):]
+
+		// Search in GOROOT (for debugger).
+		if gpf.isGoRoot(name) {
+			if !gpf.goRootAvailable() || !gpf.goRootAllowed(rel[len("GOROOT"):]) {
+				return nil
+			}
+			return append(cands, newCandidate(gpf.goSDKDir(), rel[len("GOROOT"):], KindGoRoot))
+		}
+
+		if gpf.snapshot != nil {
+			cands = append(cands, candidate{rel: rel, kind: KindFirstParty, src: gpf.snapshot})
+		} else {
+			cands = append(cands, newCandidate(gpf.workspace(), rel, KindFirstParty))
+		}
+
+		// Then in the overlay directories covering this path.
+		for _, o := range gpf.config().OverlayDirs {
+			if name == o.ImportPrefix || strings.HasPrefix(name, o.ImportPrefix+pathSeparator) {
+				cands = append(cands, newCandidate(o.Dir, name[len(o.ImportPrefix):], KindOverlay))
+			}
+		}
+		if gpf.config().DisableGenfiles {
+			return cands
+		}
+
+		// Also search in genfiles directories, or the content source
+		// replacing them.
+		if !gpf.isLocalGenfiles() {
+			cands = append(cands, candidate{rel: rel, kind: KindGenfiles, src: gpf.genSource})
+		} else {
+			for _, gen := range gpf.genDirs(name) {
+				cands = append(cands, newCandidate(filepath.Join(gpf.workspace(), gen), rel, KindGenfiles))
+			}
+		}
+
+		// Then in the extra generated output roots covering this path.
+		for _, gr := range gpf.config().GenRootScopes {
+			if name == gr.ImportPrefix || strings.HasPrefix(name, gr.ImportPrefix+pathSeparator) {
+				cands = append(cands, newCandidate(filepath.Join(gpf.workspace(), gr.Dir), rel, KindGenfiles))
+			}
+		}
+		cands = orderByKind(cands, gpf.config().FirstPartyOrder)
+		if gpf.config().PreferNewer {
+			cands = preferNewest(cands)
+		}
+		return cands
+	}
+
+	// Search in fall-through directories.
+	if gpf.isFallThrough(name) {
+		return append(cands, newCandidate(gpf.workspace(), name, KindFallThrough))
+	}
+
+	// Search in vendor directories, and their genfiles counterparts.
+	for _, v := range gpf.vendors() {
+		cands = append(cands, newCandidate(v.root, name, KindVendor))
+		if gpf.config().DisableGenfiles {
+			continue
+		}
+		for _, gen := range gpf.config().GenDirs {
+			cands = append(cands, newCandidate(filepath.Join(gpf.workspace(), gen, v.name), name, KindVendorGenfiles))
+		}
+	}
+
+	return orderByKind(cands, gpf.config().VendorOrder)
+}
+
+// orderByKind reorders the given candidates by the kinds in the given search
+// order, keeping the order of the candidates of the same kind, e.g., of the
+// vendor directories, and dropping the kinds it doesn't list. An empty
+// order keeps the candidates as they are.
+func orderByKind(cands []candidate, order []string) []candidate {
+	if len(order) == 0 {
+		return cands
+	}
+
+	ordered := make([]candidate, 0, len(cands))
+	for _, kind := range order {
+		for _, c := range cands {
+			if c.kind.String() == kind {
+				ordered = append(ordered, c)
+			}
+		}
+	}
+	return ordered
+}
+
+// preferNewest moves the most recently modified regular file among the
+// local candidates to the front, so that, e.g., a hand-written source file
+// isn't shadowed by a stale generated one, or the other way around. Since
+// the same name is listed once by OpenDir, listings are unaffected.
+func preferNewest(cands []candidate) []candidate {
+	newest := -1
+	var newestTime time.Time
+	for i, c := range cands {
+		if c.src != nil {
+			continue
+		}
+		fi, err := os.Stat(c.path)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if newest < 0 || fi.ModTime().After(newestTime) {
+			newest, newestTime = i, fi.ModTime()
+		}
+	}
+	if newest <= 0 {
+		return cands
+	}
+
+	sorted := make([]candidate, 0, len(cands))
+	sorted = append(sorted, cands[newest])
+	sorted = append(sorted, cands[:newest]...)
+	return append(sorted, cands[newest+1:]...)
+}
+
+// pathPin returns the pin covering the given mount path, if any. Its first
+// use is logged.
+func (gpf *GoPathFs) pathPin(name string) (conf.PathPin, bool) {
+	for _, p := range gpf.config().PathPins {
+		if name != p.Prefix && !strings.HasPrefix(name, p.Prefix+pathSeparator) {
+			continue
+		}
+		if _, logged := gpf.pinsUsed.LoadOrStore(p.Prefix, true); !logged {
+			fmt.Printf("Serving %s pinned to %s.\n", p.Prefix, p.Dir)
+		}
+		return p, true
+	}
+	return conf.PathPin{}, false
+}
+
+// overlayChildren returns the names of the entries the given mount path
+// gets from overlay directories deeper in the tree, i.e., the next path
+// element of each overlay's import prefix below it.
+func (gpf *GoPathFs) overlayChildren(name string) []string {
+	children := []string{}
+	for _, o := range gpf.config().OverlayDirs {
+		if !strings.HasPrefix(o.ImportPrefix, name+pathSeparator) {
+			continue
+		}
+		rest := o.ImportPrefix[len(name+pathSeparator):]
+		if i := strings.Index(rest, pathSeparator); i >= 0 {
+			rest = rest[:i]
+		}
+		children = append(children, rest)
+	}
+	return children
+}
+
+// isGoRoot returns true if the given mount path is in the virtual GOROOT,
+// which is read-only.
+func (gpf *GoPathFs) isGoRoot(name string) bool {
+	prefix := gpf.config().GoPkgPrefix + pathSeparator
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	rel := name[len(prefix):]
+	return rel == "GOROOT" || strings.HasPrefix(rel, "GOROOT"+pathSeparator)
+}
+
+// isFallThrough returns true if the given mount path is in one of the
+// fall-through directories, which are served from the workspace as they are.
+func (gpf *GoPathFs) isFallThrough(name string) bool {
+	for _, v := range gpf.config().FallThrough {
+		if name == v || strings.HasPrefix(name, v+pathSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnly returns true if the given mount path can't be changed, i.e.,
+// the whole mount is read-only, or it's in GOROOT, in the first-party tree
+// served from a git revision, in a fall-through directory with
+// fall-through-read-only, below a .gobazel file setting read-only, pinned,
+// or a virtual file.
+func (gpf *GoPathFs) isReadOnly(name string) bool {
+	if gpf.opts.readOnly || gpf.isGoRoot(name) {
+		return true
+	}
+	if _, ok := gpf.pathPin(name); ok {
+		return true
+	}
+	if gpf.servesModulesTxt(name) || isIntrospectPath(name) || gpf.servesBazelMeta(name) {
+		return true
+	}
+	if gpf.servesMetadata(name) {
+		return true
+	}
+	if o := gpf.dirOverride(name); o != nil && o.ReadOnly {
+		return true
+	}
+	if gpf.config().FallThroughReadOnly && gpf.isFallThrough(name) {
+		return true
+	}
+	return gpf.snapshot != nil && (name == gpf.config().GoPkgPrefix || strings.HasPrefix(name, gpf.config().GoPkgPrefix+pathSeparator))
+}
+
+// pathClass returns the class of the given mount path, which is one of
+// KindFirstParty (including fall-through directories), KindGoRoot and
+// KindVendor.
+func (gpf *GoPathFs) pathClass(name string) PathKind {
+	if gpf.isGoRoot(name) {
+		return KindGoRoot
+	}
+	if name == "" || name == gpf.config().GoPkgPrefix || strings.HasPrefix(name, gpf.config().GoPkgPrefix+pathSeparator) {
+		return KindFirstParty
+	}
+	if gpf.isFallThrough(name) {
+		return KindFirstParty
+	}
+	return KindVendor
+}
+
+// resolve returns the first existing backing path for the given mount path,
+// together with the number of candidates tried.
+func (gpf *GoPathFs) resolve(name string) (c candidate, tried int, ok bool) {
+	cands := gpf.candidates(name)
+	for i, c := range cands {
+		tried++
+		if !gpf.exists(c) {
+			continue
+		}
+
+		if gpf.debug {
+			gpf.logShadowed(c, cands[i+1:])
+		}
+		gpf.rememberRoot(name, cands, i)
+		gpf.stats.recordCandidates(tried)
+		return c, tried, true
+	}
+	return candidate{}, tried, false
+}
+
+func (gpf *GoPathFs) exists(c candidate) bool {
+	if c.src != nil {
+		_, status := gpf.getContentSourceAttr(c.src, c.rel)
+		return status == fuse.OK
+	}
+	return unix.Access(c.path, unix.F_OK) == nil
+}
+
+// logShadowed prints the genfiles candidates of the same kind which are
+// shadowed by the given, resolved one.
+func (gpf *GoPathFs) logShadowed(c candidate, rest []candidate) {
+	for _, r := range rest {
+		if isShadowing(c, r) && gpf.exists(r) {
+			logShadowing(c, r)
+		}
+	}
+}
+
+// isShadowing returns true if c and r are generated files in different
+// genfiles directories of the same kind, so that c shadows r.
+func isShadowing(c, r candidate) bool {
+	if c.kind != KindGenfiles && c.kind != KindVendorGenfiles {
+		return false
+	}
+	return r.kind == c.kind
+}
+
+func logShadowing(c, r candidate) {
+	fmt.Printf("Generated file %s shadows %s.\n", c.path, r.path)
+}
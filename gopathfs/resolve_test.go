@@ -0,0 +1,297 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+func TestCreateWithSearchOrder(t *testing.T) {
+	cfg := testConfig()
+	cfg.FirstPartyOrder = []string{"genfiles", "first-party"}
+	cfg.VendorOrder = []string{"vendor-genfiles", "vendor"}
+	gpf, _ := newTestFs(t, cfg)
+
+	for _, name := range []string{testPrefix + "/foo/a.go", "github.com/y/b.go"} {
+		if status := gpf.Mkdir(filepath.Dir(name), 0755, nil); status != fuse.OK {
+			t.Fatalf("Mkdir(%s) = %v", filepath.Dir(name), status)
+		}
+		f, status := gpf.Create(name, uint32(os.O_WRONLY), 0644, nil)
+		if status != fuse.OK {
+			t.Fatalf("Create(%s) = %v", name, status)
+		}
+		f.Write([]byte("package x\n"), 0)
+		f.Release()
+
+		if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != "package x\n" {
+			t.Errorf("reading %s = %q, %v", name, got, status)
+		}
+	}
+}
+
+func TestIsFallThrough(t *testing.T) {
+	cfg := testConfig()
+	cfg.FallThrough = []string{"tools"}
+	cfg.FallThroughSet = map[string]struct{}{"tools": {}}
+	gpf, _ := newTestFs(t, cfg)
+
+	for name, want := range map[string]bool{
+		"tools":          true,
+		"tools/lint":     true,
+		"toolsmith":      false,
+		"toolsmith/x.go": false,
+		"github.com/y":   false,
+	} {
+		if got := gpf.isFallThrough(name); got != want {
+			t.Errorf("isFallThrough(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// dirResolver resolves the mount paths under its prefix to its directory.
+type dirResolver struct {
+	prefix, dir string
+}
+
+func (dr dirResolver) Resolve(name string) (string, PathKind, bool) {
+	if name != dr.prefix && !strings.HasPrefix(name, dr.prefix+"/") {
+		return "", KindUnknown, false
+	}
+	return filepath.Join(dr.dir, strings.TrimPrefix(name, dr.prefix)), KindVendor, true
+}
+
+func TestCustomResolverChanges(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	corp := t.TempDir()
+	gpf.SetResolvers(dirResolver{prefix: "corp.example", dir: corp})
+
+	if status := gpf.Mkdir("corp.example/lib", 0755, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	f, status := gpf.Create("corp.example/lib/a.go", uint32(os.O_WRONLY), 0644, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create = %v", status)
+	}
+	f.Write([]byte("package lib\n"), 0)
+	f.Release()
+	if _, err := os.Stat(filepath.Join(corp, "lib", "a.go")); err != nil {
+		t.Fatalf("created file isn't where the resolver resolves it to: %v", err)
+	}
+	if got, status := readMountFile(t, gpf, "corp.example/lib/a.go"); status != fuse.OK || got != "package lib\n" {
+		t.Errorf("reading the created file = %q, %v", got, status)
+	}
+
+	if status := gpf.Rename("corp.example/lib/a.go", "corp.example/lib/b.go", nil); status != fuse.OK {
+		t.Fatalf("Rename = %v", status)
+	}
+	if _, err := os.Stat(filepath.Join(corp, "lib", "b.go")); err != nil {
+		t.Errorf("renamed file isn't where the resolver resolves it to: %v", err)
+	}
+	if status := gpf.Unlink("corp.example/lib/b.go", nil); status != fuse.OK {
+		t.Fatalf("Unlink = %v", status)
+	}
+	if status := gpf.Rmdir("corp.example/lib", nil); status != fuse.OK {
+		t.Fatalf("Rmdir = %v", status)
+	}
+	if _, err := os.Stat(filepath.Join(corp, "lib")); !os.IsNotExist(err) {
+		t.Errorf("removed directory still exists: %v", err)
+	}
+
+	// Nothing was changed in the built-in layout.
+	if _, err := os.Stat(filepath.Join(ws, "vendor", "corp.example")); !os.IsNotExist(err) {
+		t.Errorf("changes leaked into the vendor directory: %v", err)
+	}
+}
+
+func TestPreferNewer(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	for _, tc := range []struct {
+		newer, want string
+	}{
+		{newer: "foo", want: "source"},
+		{newer: filepath.Join(conf.DefaultGenDir, "foo"), want: "generated"},
+	} {
+		cfg := testConfig()
+		cfg.PreferNewer = true
+		gpf, ws := newTestFs(t, cfg)
+		src := filepath.Join(ws, "foo", "a.go")
+		gen := filepath.Join(ws, conf.DefaultGenDir, "foo", "a.go")
+		writeFile(t, src, "source")
+		writeFile(t, gen, "generated")
+		// Age the other one of the two.
+		for _, name := range []string{src, gen} {
+			if filepath.Dir(name) != filepath.Join(ws, tc.newer) {
+				if err := os.Chtimes(name, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != tc.want {
+			t.Errorf("newer in %s: reading = %q, %v, want %q", tc.newer, got, status, tc.want)
+		}
+	}
+}
+
+func TestPathPin(t *testing.T) {
+	pinned := t.TempDir()
+	cfg := testConfig()
+	cfg.PathPins = []conf.PathPin{{Prefix: testPrefix + "/foo", Dir: pinned}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "workspace")
+	writeFile(t, filepath.Join(ws, "foo", "b.go"), "workspace")
+	writeFile(t, filepath.Join(pinned, "a.go"), "pinned")
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != "pinned" {
+		t.Errorf("reading a.go = %q, %v, want %q", got, status, "pinned")
+	}
+	// The workspace copy isn't consulted for the pinned package at all.
+	if _, status := gpf.GetAttr(testPrefix+"/foo/b.go", nil); status != fuse.ENOENT {
+		t.Errorf("GetAttr(b.go) = %v, want ENOENT", status)
+	}
+	if _, status := gpf.Create(testPrefix+"/foo/c.go", uint32(os.O_WRONLY), 0644, nil); status != fuse.EROFS {
+		t.Errorf("Create(c.go) = %v, want EROFS", status)
+	}
+	// A sibling sharing the prefix as a string isn't pinned.
+	writeFile(t, filepath.Join(ws, "foobar", "a.go"), "workspace")
+	if got, status := readMountFile(t, gpf, testPrefix+"/foobar/a.go"); status != fuse.OK || got != "workspace" {
+		t.Errorf("reading foobar/a.go = %q, %v, want %q", got, status, "workspace")
+	}
+}
+
+func TestGenRootScopes(t *testing.T) {
+	cfg := testConfig()
+	cfg.GenRootScopes = []conf.GenRoot{
+		{ImportPrefix: testPrefix + "/protos", Dir: "bazel-bin"},
+		{ImportPrefix: testPrefix + "/api", Dir: "out/api-gen"},
+	}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "bazel-bin", "protos", "a.pb.go"), "bin")
+	writeFile(t, filepath.Join(ws, "bazel-bin", "protos", "b.pb.go"), "bin")
+	writeFile(t, filepath.Join(ws, conf.DefaultGenDir, "protos", "b.pb.go"), "genfiles")
+	writeFile(t, filepath.Join(ws, "bazel-bin", "api", "c.pb.go"), "bin")
+	writeFile(t, filepath.Join(ws, "out", "api-gen", "api", "c.pb.go"), "api-gen")
+	writeFile(t, filepath.Join(ws, "out", "api-gen", "protos", "d.pb.go"), "api-gen")
+
+	for name, want := range map[string]string{
+		"/protos/a.pb.go": "bin",
+		// gen-dirs come first.
+		"/protos/b.pb.go": "genfiles",
+		// Each root serves its own prefix only.
+		"/api/c.pb.go": "api-gen",
+	} {
+		if got, status := readMountFile(t, gpf, testPrefix+name); status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+		}
+	}
+	if _, status := gpf.GetAttr(testPrefix+"/protos/d.pb.go", nil); status != fuse.ENOENT {
+		t.Errorf("GetAttr(protos/d.pb.go) = %v, want ENOENT", status)
+	}
+}
+
+func TestDisableGenfiles(t *testing.T) {
+	cfg := testConfig()
+	cfg.DisableGenfiles = true
+	cfg.GenRootScopes = []conf.GenRoot{{ImportPrefix: testPrefix, Dir: "bazel-bin"}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, conf.DefaultGenDir, "foo", "b.pb.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "bazel-bin", "foo", "c.pb.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, conf.DefaultGenDir, "vendor", "github.com", "y", "d.pb.go"), "package y\n")
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading foo/a.go = %q, %v", got, status)
+	}
+	for _, name := range []string{testPrefix + "/foo/b.pb.go", testPrefix + "/foo/c.pb.go", "github.com/y/d.pb.go"} {
+		if _, status := gpf.GetAttr(name, nil); status != fuse.ENOENT {
+			t.Errorf("GetAttr(%s) = %v, want ENOENT", name, status)
+		}
+	}
+	if names := listNames(t, gpf, testPrefix+"/foo"); len(names) != 1 || !names["a.go"] {
+		t.Errorf("foo lists %v, want a.go only", names)
+	}
+
+	// Nor is the content source replacing genfiles consulted.
+	src := &memSource{files: map[string]string{"foo/e.pb.go": "package foo\n"}}
+	gpf.SetGenfilesSource(src)
+	if _, status := readMountFile(t, gpf, testPrefix+"/foo/e.pb.go"); status != fuse.ENOENT {
+		t.Errorf("reading foo/e.pb.go = %v, want ENOENT", status)
+	}
+	listNames(t, gpf, testPrefix+"/foo")
+	if n := src.openCount(); n != 0 {
+		t.Errorf("genfiles source opened %d times, want 0", n)
+	}
+}
+
+func TestOverlayDirs(t *testing.T) {
+	overlay := t.TempDir()
+	cfg := testConfig()
+	cfg.OverlayDirs = []conf.OverlayDir{{ImportPrefix: testPrefix + "/labs/exp", Dir: overlay}}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(overlay, "foo", "a.go"), "overlay")
+	writeFile(t, filepath.Join(overlay, "foo", "b.go"), "overlay")
+	writeFile(t, filepath.Join(ws, "labs", "exp", "foo", "b.go"), "workspace")
+	writeFile(t, filepath.Join(ws, conf.DefaultGenDir, "labs", "exp", "foo", "a.go"), "genfiles")
+
+	for name, want := range map[string]string{
+		// The overlay comes before generated files.
+		"/labs/exp/foo/a.go": "overlay",
+		// The workspace comes before the overlay.
+		"/labs/exp/foo/b.go": "workspace",
+	} {
+		if got, status := readMountFile(t, gpf, testPrefix+name); status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v, want %q", name, got, status, want)
+		}
+	}
+	if names := listNames(t, gpf, testPrefix+"/labs/exp/foo"); !names["a.go"] || !names["b.go"] {
+		t.Errorf("labs/exp/foo lists %v, want a.go and b.go", names)
+	}
+}
+
+func TestOverlayDirParentsListed(t *testing.T) {
+	overlay := t.TempDir()
+	cfg := testConfig()
+	cfg.OverlayDirs = []conf.OverlayDir{{ImportPrefix: testPrefix + "/labs/exp", Dir: overlay}}
+	gpf, _ := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(overlay, "foo", "a.go"), "package foo\n")
+
+	// The parents of the overlay aren't in the workspace.
+	if names := listNames(t, gpf, testPrefix); !names["labs"] {
+		t.Errorf("%s lists %v, want labs", testPrefix, names)
+	}
+	if names := listNames(t, gpf, testPrefix+"/labs"); !names["exp"] {
+		t.Errorf("labs lists %v, want exp", names)
+	}
+	if got, status := readMountFile(t, gpf, testPrefix+"/labs/exp/foo/a.go"); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading labs/exp/foo/a.go = %q, %v", got, status)
+	}
+}
+
+func TestBazelConfigsPrecedence(t *testing.T) {
+	for _, configs := range [][]string{{"k8-fastbuild", "k8-dbg"}, {"k8-dbg", "k8-fastbuild"}} {
+		first := configs[0]
+		cfg := testConfig()
+		// The gen-dirs of bazel-configs as ParseConfig expands them.
+		cfg.GenDirs = nil
+		for _, c := range configs {
+			cfg.GenDirs = append(cfg.GenDirs, filepath.Join("bazel-out", c, "bin"), filepath.Join("bazel-out", c, "genfiles"))
+		}
+		gpf, ws := newTestFs(t, cfg)
+		writeFile(t, filepath.Join(ws, "bazel-out", "k8-fastbuild", "bin", "foo", "a.pb.go"), "k8-fastbuild")
+		writeFile(t, filepath.Join(ws, "bazel-out", "k8-dbg", "genfiles", "foo", "a.pb.go"), "k8-dbg")
+		writeFile(t, filepath.Join(ws, "bazel-out", "k8-dbg", "bin", "foo", "b.pb.go"), "k8-dbg")
+
+		if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.pb.go"); status != fuse.OK || got != first {
+			t.Errorf("%s first: reading a.pb.go = %q, %v, want %q", first, got, status, first)
+		}
+		// Files generated by one configuration only are served either way.
+		if got, status := readMountFile(t, gpf, testPrefix+"/foo/b.pb.go"); status != fuse.OK || got != "k8-dbg" {
+			t.Errorf("%s first: reading b.pb.go = %q, %v", first, got, status)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linuxerwang/gobazel/conf"
+)
+
+// Entries beyond which expired directories are pruned from rootCache.
+const maxRootCacheDirs = 10000
+
+// rootCache remembers, per directory, the first backing root which has the
+// directory, found once a lookup of one of its entries resolved past the
+// first candidate. Later lookups in the directory start at that root,
+// saving a stat per skipped root, and still resolve to the same backing
+// file as a full search would.
+type rootCache struct {
+	mu      sync.Mutex
+	entries map[string]rootCacheEntry
+}
+
+type rootCacheEntry struct {
+	root    string
+	expires time.Time
+}
+
+func (rc *rootCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries = nil
+}
+
+// forget drops the given mount path and the directories below it, e.g.,
+// after it was created in a root before the remembered ones.
+func (rc *rootCache) forget(name string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for dir := range rc.entries {
+		if dir == name || strings.HasPrefix(dir, name+pathSeparator) {
+			delete(rc.entries, dir)
+		}
+	}
+}
+
+// skipToRoot drops the candidates of the given mount path before the root
+// remembered for its directory, if any.
+func (gpf *GoPathFs) skipToRoot(name string, cands []candidate) []candidate {
+	if len(cands) < 2 || gpf.config().PreferNewer {
+		return cands
+	}
+
+	rc := &gpf.roots
+	rc.mu.Lock()
+	e, ok := rc.entries[filepath.Dir(name)]
+	rc.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return cands
+	}
+
+	for i, c := range cands {
+		if c.src == nil && c.root == e.root {
+			return cands[i:]
+		}
+	}
+	return cands
+}
+
+// rememberRoot records the first root which has the directory of the given
+// mount path, which resolved to the candidate at index i, unless it's
+// remembered already. Only the parents of the candidates before i are
+// stat'ed, once per directory until the entry expires, however many
+// lookups in the directory resolve past the first candidate.
+func (gpf *GoPathFs) rememberRoot(name string, cands []candidate, i int) {
+	if i == 0 || cands[i].src != nil || gpf.config().PreferNewer {
+		return
+	}
+	dir := filepath.Dir(name)
+
+	rc := &gpf.roots
+	rc.mu.Lock()
+	e, ok := rc.entries[dir]
+	rc.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		// The candidates started at the remembered root already.
+		return
+	}
+
+	root := cands[i].root
+	for _, c := range cands[:i] {
+		if c.src != nil {
+			return
+		}
+		if _, err := os.Stat(filepath.Dir(c.path)); err == nil {
+			// Remembered too, so that later lookups don't stat again.
+			root = c.root
+			break
+		}
+	}
+
+	ttl := conf.DefaultTimeout
+	if t := gpf.config().Timeouts; t != nil {
+		switch gpf.pathClass(name) {
+		case KindVendor:
+			ttl = t.Vendor.EntryTimeout
+		case KindGoRoot:
+			ttl = t.GoRoot.EntryTimeout
+		default:
+			ttl = t.FirstParty.EntryTimeout
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	if rc.entries == nil {
+		rc.entries = map[string]rootCacheEntry{}
+	}
+	if len(rc.entries) > maxRootCacheDirs {
+		for d, e := range rc.entries {
+			if now.After(e.expires) {
+				delete(rc.entries, d)
+			}
+		}
+	}
+	rc.entries[dir] = rootCacheEntry{root: root, expires: now.Add(ttl)}
+}
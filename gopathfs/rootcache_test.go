@@ -0,0 +1,64 @@
+package gopathfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestRememberRoot(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	// Generated next to sources, and in a package of its own.
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "bazel-genfiles", "foo", "a.pb.go"), "package foo\n")
+	writeFile(t, filepath.Join(ws, "bazel-genfiles", "bar", "b.pb.go"), "package bar\n")
+
+	for _, tc := range []struct {
+		name, root string
+	}{
+		{testPrefix + "/foo/a.pb.go", ws},
+		{testPrefix + "/bar/b.pb.go", filepath.Join(ws, "bazel-genfiles")},
+	} {
+		if _, status := gpf.GetAttr(tc.name, nil); status != fuse.OK {
+			t.Fatalf("GetAttr(%s) = %v", tc.name, status)
+		}
+		gpf.roots.mu.Lock()
+		e, ok := gpf.roots.entries[filepath.Dir(tc.name)]
+		gpf.roots.mu.Unlock()
+		if !ok || e.root != tc.root {
+			t.Errorf("root remembered for %s = %q, %v, want %s", filepath.Dir(tc.name), e.root, ok, tc.root)
+		}
+	}
+
+	// Both resolve as a full search does.
+	for name, want := range map[string]string{
+		testPrefix + "/foo/a.go":    "package foo\n",
+		testPrefix + "/foo/a.pb.go": "package foo\n",
+		testPrefix + "/bar/b.pb.go": "package bar\n",
+	} {
+		if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v", name, got, status)
+		}
+	}
+}
+
+func BenchmarkLookupsInGenfilesPackage(b *testing.B) {
+	gpf, ws := newTestFs(b, nil)
+	// A package with sources, whose generated files are all found past the
+	// first candidate.
+	writeFile(b, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	names := make([]string, 100)
+	for i := range names {
+		writeFile(b, filepath.Join(ws, "bazel-genfiles", "foo", fmt.Sprintf("a%d.pb.go", i)), "package foo\n")
+		names[i] = fmt.Sprintf("%s/foo/a%d.pb.go", testPrefix, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, status := gpf.GetAttr(names[i%len(names)], nil); status != fuse.OK {
+			b.Fatalf("GetAttr = %v", status)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package gopathfs
+
+import (
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func newScanSem(n int) chan struct{} {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return make(chan struct{}, n)
+}
+
+// scanSem returns the semaphore background walks share.
+func (gpf *GoPathFs) scanSem() chan struct{} {
+	return gpf.loadSettings().scanSem
+}
+
+// scanDir lists the given directory for a background walk. Walks share a cap
+// on the directories read at once, so that they don't starve the requests
+// served to the IDE of file descriptors or backing store bandwidth.
+func (gpf *GoPathFs) scanDir(dir string) ([]fuse.DirEntry, fuse.Status) {
+	sem := gpf.scanSem()
+	sem <- struct{}{}
+	defer func() { <-sem }()
+	return gpf.listDir(dir)
+}
+
+// scanBackingDir is scanDir for walks of backing directories.
+func (gpf *GoPathFs) scanBackingDir(dir string) ([]os.DirEntry, error) {
+	sem := gpf.scanSem()
+	sem <- struct{}{}
+	defer func() { <-sem }()
+	return os.ReadDir(dir)
+}
+
+// walkTree calls visit for the given mount directories and, recursively,
+// for the subdirectories it returns, on as many workers as directories are
+// read at once by walks, rather than on a goroutine per directory.
+func (gpf *GoPathFs) walkTree(dirs []string, visit func(dir string) []string) {
+	var (
+		mu      sync.Mutex
+		ready   = sync.NewCond(&mu)
+		queue   = append([]string(nil), dirs...)
+		pending = len(queue) // Queued or being visited.
+		wg      sync.WaitGroup
+	)
+
+	worker := func() {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			for len(queue) == 0 && pending > 0 {
+				ready.Wait()
+			}
+			if pending == 0 {
+				return
+			}
+			dir := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+
+			mu.Unlock()
+			subdirs := visit(dir)
+			mu.Lock()
+
+			queue = append(queue, subdirs...)
+			pending += len(subdirs) - 1
+			ready.Broadcast()
+		}
+	}
+
+	for i := 0; i < cap(gpf.scanSem()); i++ {
+		wg.Add(1)
+		go worker()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// selfTestPayload is written and read back by SelfTest.
+var selfTestPayload = []byte("gobazel self-test\n")
+
+// SelfTest checks that the mount works end to end, going through the kernel
+// like any other client: it lists the first-party tree and, unless it's
+// read-only, creates, writes, reads back and deletes a temporary file in it.
+// It must not be called from a FUSE operation of the same mount.
+func (gpf *GoPathFs) SelfTest() error {
+	dir := filepath.Join(gpf.dirs.SrcDir, gpf.config().GoPkgPrefix)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s, %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return fmt.Errorf("failed to list %s, %v", dir, err)
+	}
+
+	if gpf.isReadOnly(gpf.config().GoPkgPrefix + pathSeparator + ".gobazel-selftest") {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(dir, ".gobazel-selftest-")
+	if err != nil {
+		return fmt.Errorf("failed to create a file in %s, %v", dir, err)
+	}
+	name := f.Name()
+	defer os.Remove(name)
+
+	if _, err := f.Write(selfTestPayload); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s, %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s, %v", name, err)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s, %v", name, err)
+	}
+	if !bytes.Equal(data, selfTestPayload) {
+		return fmt.Errorf("read back %q from %s, want %q", data, name, selfTestPayload)
+	}
+
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to delete %s, %v", name, err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		return fmt.Errorf("%s still exists after deleting it", name)
+	}
+	return nil
+}
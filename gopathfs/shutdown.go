@@ -0,0 +1,79 @@
+package gopathfs
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// errShuttingDown is returned by operations arriving after Shutdown was
+// called.
+const errShuttingDown = fuse.Status(unix.ENOTCONN)
+
+// enterOp registers an in-flight operation, which has to call exitOp when
+// done. It returns false if the file system is shutting down.
+func (gpf *GoPathFs) enterOp() bool {
+	gpf.shutdownMu.RLock()
+	defer gpf.shutdownMu.RUnlock()
+	if gpf.shuttingDown {
+		return false
+	}
+	atomic.AddInt64(&gpf.inFlight, 1)
+	return true
+}
+
+// exitOp ends an operation registered by enterOp, given a pointer to its
+// result status so that it can be deferred.
+func (gpf *GoPathFs) exitOp(code *fuse.Status) {
+	gpf.stats.recordOp(code)
+	if atomic.AddInt64(&gpf.inFlight, -1) != 0 {
+		return
+	}
+
+	gpf.shutdownMu.RLock()
+	waited := gpf.drained != nil
+	gpf.shutdownMu.RUnlock()
+	if !waited {
+		return
+	}
+	gpf.shutdownMu.Lock()
+	if gpf.drained != nil && atomic.LoadInt64(&gpf.inFlight) == 0 {
+		close(gpf.drained)
+		gpf.drained = nil
+	}
+	gpf.shutdownMu.Unlock()
+}
+
+// Shutdown rejects new operations, waits for the in-flight ones to finish,
+// including I/O on open files, and unmounts the file system. If ctx is done
+// first, it returns ctx's error without unmounting, and operations are
+// served again, so that Shutdown can be retried.
+func (gpf *GoPathFs) Shutdown(ctx context.Context) error {
+	gpf.shutdownMu.Lock()
+	gpf.shuttingDown = true
+	// No operation enters while the lock is held.
+	if gpf.drained == nil && atomic.LoadInt64(&gpf.inFlight) != 0 {
+		gpf.drained = make(chan struct{})
+	}
+	drained := gpf.drained
+	gpf.shutdownMu.Unlock()
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			gpf.shutdownMu.Lock()
+			gpf.shuttingDown = false
+			gpf.shutdownMu.Unlock()
+			return ctx.Err()
+		}
+	}
+
+	if gpf.nodeFs == nil {
+		return fmt.Errorf("not mounted")
+	}
+	return gpf.nodeFs.Connector().Server().Unmount()
+}
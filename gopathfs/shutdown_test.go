@@ -0,0 +1,109 @@
+package gopathfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// blockingSource is a ContentSource whose Open blocks until release is
+// closed, after signaling entered.
+type blockingSource struct {
+	*memSource
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingSource(files map[string]string) *blockingSource {
+	return &blockingSource{
+		memSource: &memSource{files: files},
+		entered:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+}
+
+func (bs *blockingSource) Open(name string) (io.ReaderAt, int64, error) {
+	select {
+	case bs.entered <- struct{}{}:
+	default:
+	}
+	<-bs.release
+	return bs.memSource.Open(name)
+}
+
+func TestShutdownWaitsForInFlightOps(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	src := newBlockingSource(map[string]string{"foo/gen.pb.go": "package foo\n"})
+	gpf.SetGenfilesSource(src)
+
+	f, status := gpf.Open(testPrefix+"/foo/a.go", uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+
+	go gpf.GetAttr(testPrefix+"/foo/gen.pb.go", nil)
+	<-src.entered
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- gpf.Shutdown(context.Background()) }()
+
+	// New operations fail while draining, including I/O on open files.
+	deadline := time.Now().Add(5 * time.Second)
+	for gpf.Access(testPrefix+"/foo/a.go", 0, nil) != errShuttingDown {
+		if time.Now().After(deadline) {
+			t.Fatal("Access didn't fail while shutting down")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, status := f.Read(make([]byte, 16), 0); status != errShuttingDown {
+		t.Errorf("Read while shutting down = %v, want %v", status, errShuttingDown)
+	}
+	if _, status := gpf.ListXAttr(testPrefix+"/foo/a.go", nil); status != errShuttingDown {
+		t.Errorf("ListXAttr while shutting down = %v, want %v", status, errShuttingDown)
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the in-flight GetAttr finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(src.release)
+	select {
+	case err := <-shutdown:
+		// The test file system isn't mounted.
+		if err == nil || err == context.DeadlineExceeded {
+			t.Errorf("Shutdown = %v, want the not mounted error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return after the in-flight GetAttr finished")
+	}
+}
+
+func TestShutdownTimeoutServesAgain(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	src := newBlockingSource(map[string]string{"foo/gen.pb.go": "package foo\n"})
+	gpf.SetGenfilesSource(src)
+
+	go gpf.GetAttr(testPrefix+"/foo/gen.pb.go", nil)
+	<-src.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := gpf.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(src.release)
+
+	if got, status := readMountFile(t, gpf, testPrefix+"/foo/a.go"); status != fuse.OK || got != "package foo\n" {
+		t.Errorf("reading after a timed out Shutdown = %q, %v", got, status)
+	}
+}
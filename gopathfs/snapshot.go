@@ -0,0 +1,292 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// GitSnapshot is a read-only DirSource serving the files of a git revision,
+// with paths relative to the repository root. The tree is listed once; file
+// contents are read from git on open. Executable files are served with the
+// exec bit, and symbolic links as what they lead to within the revision,
+// like the links of the working tree are served; those leading out of it or
+// nowhere aren't served.
+type GitSnapshot struct {
+	repo   string
+	commit string
+
+	entries  map[string]gitEntry
+	children map[string][]string // Entry names by directory, "" is the root.
+}
+
+type gitEntry struct {
+	object string
+	isDir  bool
+	mode   uint32 // The git file mode, e.g., 0100755.
+	size   int64
+	target string // Of symbolic links.
+}
+
+// Git file modes of executable files and symbolic links.
+const (
+	gitModeExec    = 0100755
+	gitModeSymlink = 0120000
+)
+
+// maxLinkHops is the number of symbolic links followed to resolve a path,
+// like the kernel's limit.
+const maxLinkHops = 40
+
+// NewGitSnapshot returns a snapshot of the given revision of the git
+// repository in repo.
+func NewGitSnapshot(repo, rev string) (*GitSnapshot, error) {
+	out, err := runGit(repo, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	gs := &GitSnapshot{
+		repo:     repo,
+		commit:   strings.TrimSpace(string(out)),
+		entries:  map[string]gitEntry{},
+		children: map[string][]string{},
+	}
+
+	out, err = runGit(repo, "ls-tree", "-r", "-t", "-l", "-z", gs.commit)
+	if err != nil {
+		return nil, err
+	}
+	links := []string{}
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+
+		// "<mode> <type> <object> <size>\t<path>", size is "-" for trees.
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("unexpected git ls-tree output %q", line)
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git ls-tree output %q", line)
+		}
+		path := line[tab+1:]
+		e := gitEntry{object: fields[2], isDir: fields[1] == "tree"}
+		mode, err := strconv.ParseUint(fields[0], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git ls-tree output %q", line)
+		}
+		e.mode = uint32(mode)
+		if !e.isDir {
+			// Submodules ("commit") have no content, serve them empty.
+			e.size, _ = strconv.ParseInt(fields[3], 10, 64)
+		}
+		gs.entries[path] = e
+		if e.mode == gitModeSymlink {
+			links = append(links, path)
+		}
+
+		dir, name := "", path
+		if i := strings.LastIndexByte(path, '/'); i >= 0 {
+			dir, name = path[:i], path[i+1:]
+		}
+		gs.children[dir] = append(gs.children[dir], name)
+	}
+	if err := gs.readLinks(links); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// readLinks reads the targets of the given symbolic links, all at once.
+func (gs *GitSnapshot) readLinks(links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	objects := make([]string, len(links))
+	for i, l := range links {
+		objects[i] = gs.entries[l].object
+	}
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = gs.repo
+	cmd.Stdin = strings.NewReader(strings.Join(objects, "\n") + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git cat-file --batch failed, %v", err)
+	}
+
+	// Each object is "<object> blob <size>\n<content>\n".
+	for _, l := range links {
+		nl := bytes.IndexByte(out, '\n')
+		if nl < 0 {
+			return fmt.Errorf("unexpected git cat-file output for %s", l)
+		}
+		fields := strings.Fields(string(out[:nl]))
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected git cat-file output %q", out[:nl])
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || nl+1+size > len(out) {
+			return fmt.Errorf("unexpected git cat-file output %q", out[:nl])
+		}
+		e := gs.entries[l]
+		e.target = string(out[nl+1 : nl+1+size])
+		gs.entries[l] = e
+		out = out[nl+1+size:]
+		if len(out) > 0 && out[0] == '\n' {
+			out = out[1:]
+		}
+	}
+	return nil
+}
+
+// resolve returns the path the given one leads to, following symbolic
+// links, and its entry, or false if it doesn't exist in the revision or
+// leads out of it. The root is "", with an empty entry.
+func (gs *GitSnapshot) resolve(name string) (string, gitEntry, bool) {
+	resolved := ""
+	rest := strings.Split(name, "/")
+	hops := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "" {
+				return "", gitEntry{}, false
+			}
+			resolved = gitDir(resolved)
+			continue
+		}
+
+		next := elem
+		if resolved != "" {
+			next = resolved + "/" + elem
+		}
+		e, ok := gs.entries[next]
+		if !ok {
+			return "", gitEntry{}, false
+		}
+		if e.mode == gitModeSymlink {
+			hops++
+			if hops > maxLinkHops || strings.HasPrefix(e.target, "/") {
+				return "", gitEntry{}, false
+			}
+			rest = append(strings.Split(e.target, "/"), rest...)
+			continue
+		}
+		if !e.isDir && len(rest) > 0 {
+			return "", gitEntry{}, false
+		}
+		resolved = next
+	}
+	if resolved == "" {
+		return "", gitEntry{isDir: true}, true
+	}
+	return resolved, gs.entries[resolved], true
+}
+
+// gitDir returns the directory of the given path, "" for the root.
+func gitDir(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// Commit returns the hash of the commit served.
+func (gs *GitSnapshot) Commit() string {
+	return gs.commit
+}
+
+// Open implements ContentSource.
+func (gs *GitSnapshot) Open(name string) (io.ReaderAt, int64, error) {
+	_, e, ok := gs.resolve(name)
+	if !ok || e.isDir {
+		return nil, 0, os.ErrNotExist
+	}
+	if e.size == 0 {
+		return bytes.NewReader(nil), 0, nil
+	}
+
+	data, err := runGit(gs.repo, "cat-file", "blob", e.object)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// Size implements sizer, with the sizes listed by git.
+func (gs *GitSnapshot) Size(name string) (int64, error) {
+	_, e, ok := gs.resolve(name)
+	if !ok || e.isDir {
+		return 0, os.ErrNotExist
+	}
+	return e.size, nil
+}
+
+// Mode implements moder.
+func (gs *GitSnapshot) Mode(name string) (uint32, error) {
+	_, e, ok := gs.resolve(name)
+	switch {
+	case !ok:
+		return 0, os.ErrNotExist
+	case e.isDir:
+		return fuse.S_IFDIR | 0555, nil
+	case e.mode == gitModeExec:
+		return fuse.S_IFREG | 0555, nil
+	}
+	return fuse.S_IFREG | 0444, nil
+}
+
+// ReadDir implements DirSource. Symbolic links are listed as what they lead
+// to, and skipped if they lead nowhere.
+func (gs *GitSnapshot) ReadDir(name string) ([]fuse.DirEntry, error) {
+	dir, e, ok := gs.resolve(name)
+	if !ok || !e.isDir {
+		return nil, os.ErrNotExist
+	}
+
+	entries := []fuse.DirEntry{}
+	for _, child := range gs.children[dir] {
+		path := child
+		if dir != "" {
+			path = dir + "/" + child
+		}
+		_, ce, ok := gs.resolve(path)
+		if !ok {
+			continue
+		}
+		entry := fuse.DirEntry{
+			Name: child,
+			Mode: fuse.S_IFREG,
+		}
+		if ce.isDir {
+			entry.Mode = fuse.S_IFDIR
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func runGit(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed, %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,168 @@
+package gopathfs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// newTestRepo returns a git repository with one commit of the given files,
+// and symbolic links by name and target.
+func newTestRepo(t *testing.T, files map[string]string, modes map[string]os.FileMode, links map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	repo := t.TempDir()
+	for name, data := range files {
+		writeFile(t, filepath.Join(repo, name), data)
+		if mode, ok := modes[name]; ok {
+			if err := os.Chmod(filepath.Join(repo, name), mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for name, target := range links {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(repo, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "test"},
+	} {
+		if _, err := runGit(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestGitSnapshotModesAndLinks(t *testing.T) {
+	repo := newTestRepo(t,
+		map[string]string{
+			"a.go":     "package a\n",
+			"run.sh":   "#!/bin/sh\n",
+			"sub/b.go": "package sub\n",
+		},
+		map[string]os.FileMode{"run.sh": 0755},
+		map[string]string{
+			"link.go":     "a.go",
+			"sublink":     "sub",
+			"sub/up.go":   "../a.go",
+			"dangling.go": "missing.go",
+			"out.go":      "/etc/passwd",
+			"loop/a":      "b",
+			"loop/b":      "a",
+		})
+	gs, err := NewGitSnapshot(repo, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]uint32{
+		"a.go":         fuse.S_IFREG | 0444,
+		"run.sh":       fuse.S_IFREG | 0555,
+		"link.go":      fuse.S_IFREG | 0444,
+		"sublink":      fuse.S_IFDIR | 0555,
+		"sublink/b.go": fuse.S_IFREG | 0444,
+		"sub/up.go":    fuse.S_IFREG | 0444,
+		"sub":          fuse.S_IFDIR | 0555,
+	} {
+		if mode, err := gs.Mode(name); err != nil || mode != want {
+			t.Errorf("Mode(%s) = %o, %v, want %o", name, mode, err, want)
+		}
+	}
+	for _, name := range []string{"dangling.go", "out.go", "loop/a", "missing.go", "a.go/x"} {
+		if _, err := gs.Mode(name); !os.IsNotExist(err) {
+			t.Errorf("Mode(%s) = %v, want not exist", name, err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"link.go":      "package a\n",
+		"sublink/b.go": "package sub\n",
+		"sub/up.go":    "package a\n",
+	} {
+		size, err := gs.Size(name)
+		if err != nil || size != int64(len(want)) {
+			t.Errorf("Size(%s) = %d, %v, want %d", name, size, err, len(want))
+		}
+		r, n, err := gs.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s) = %v", name, err)
+		}
+		data := make([]byte, n)
+		if _, err := r.ReadAt(data, 0); err != nil || string(data) != want {
+			t.Errorf("Open(%s) read %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	entries, err := gs.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]uint32{}
+	for _, e := range entries {
+		got[e.Name] = e.Mode
+	}
+	for name, want := range map[string]uint32{
+		"a.go":    fuse.S_IFREG,
+		"link.go": fuse.S_IFREG,
+		"sublink": fuse.S_IFDIR,
+		"sub":     fuse.S_IFDIR,
+	} {
+		if got[name] != want {
+			t.Errorf("ReadDir listed %s with mode %o, want %o", name, got[name], want)
+		}
+	}
+	for _, name := range []string{"dangling.go", "out.go"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("ReadDir listed %s", name)
+		}
+	}
+	if entries, err := gs.ReadDir("sublink"); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir(sublink) = %v, %v, want b.go and up.go", entries, err)
+	}
+}
+
+func TestGitSnapshotAttrs(t *testing.T) {
+	repo := newTestRepo(t,
+		map[string]string{"foo/run.sh": "#!/bin/sh\n"},
+		map[string]os.FileMode{"foo/run.sh": 0755},
+		nil)
+	cfg := testConfig()
+	cfg.GitRevision = "HEAD"
+	gpf, err := New(Dirs{Workspace: repo}, WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := testPrefix + "/foo/run.sh"
+	attr, status := gpf.GetAttr(name, nil)
+	if status != fuse.OK || attr.Mode != fuse.S_IFREG|0555 || attr.Size != 10 {
+		t.Fatalf("GetAttr = %+v, %v, want mode %o and size 10", attr, status, fuse.S_IFREG|0555)
+	}
+	f, status := gpf.Open(name, uint32(os.O_RDONLY), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	defer f.Release()
+	out := fuse.Attr{}
+	if status := f.GetAttr(&out); status != fuse.OK || out.Mode != attr.Mode || out.Size != attr.Size {
+		t.Errorf("File.GetAttr = %+v, %v, want %+v", out, status, attr)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(repo, "foo", "run.sh"))
+	if got, _ := readMountFile(t, gpf, name); got != string(data) {
+		t.Errorf("read %q, want %q", got, data)
+	}
+}
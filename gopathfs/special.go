@@ -0,0 +1,45 @@
+package gopathfs
+
+import (
+	"os"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// Special files, i.e., FIFOs, sockets and device nodes, are reported with
+// their own type, so that the kernel handles opening them rather than
+// gobazel, or hidden altogether with hide-special-files.
+
+// isSpecialFile returns true if the given file mode is that of a special
+// file.
+func isSpecialFile(m os.FileMode) bool {
+	return m&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice) != 0
+}
+
+// isSpecialAttr returns true if the given attribute mode is that of a
+// special file.
+func isSpecialAttr(mode uint32) bool {
+	switch mode & unix.S_IFMT {
+	case unix.S_IFIFO, unix.S_IFSOCK, unix.S_IFCHR, unix.S_IFBLK:
+		return true
+	}
+	return false
+}
+
+// direntMode returns the listing mode of a file with the given mode.
+func direntMode(m os.FileMode) uint32 {
+	switch {
+	case m.IsDir():
+		return fuse.S_IFDIR
+	case m&os.ModeNamedPipe != 0:
+		return unix.S_IFIFO
+	case m&os.ModeSocket != 0:
+		return unix.S_IFSOCK
+	case m&os.ModeCharDevice != 0:
+		return unix.S_IFCHR
+	case m&os.ModeDevice != 0:
+		return unix.S_IFBLK
+	}
+	return fuse.S_IFREG
+}
@@ -0,0 +1,54 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestFIFOInServedDirectory(t *testing.T) {
+	for _, hide := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.HideSpecialFiles = hide
+		gpf, ws := newTestFs(t, cfg)
+		writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+		if err := unix.Mkfifo(filepath.Join(ws, "foo", "pipe"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		name := testPrefix + "/foo/pipe"
+
+		entries, status := gpf.OpenDir(testPrefix+"/foo", nil)
+		if status != fuse.OK {
+			t.Fatalf("OpenDir = %v", status)
+		}
+		listed := map[string]uint32{}
+		for _, e := range entries {
+			listed[e.Name] = e.Mode
+		}
+		attr, attrStatus := gpf.GetAttr(name, nil)
+		// Opening it must not block waiting for a writer.
+		_, openStatus := gpf.Open(name, uint32(os.O_RDONLY), nil)
+
+		if hide {
+			if _, ok := listed["pipe"]; ok {
+				t.Errorf("hidden FIFO listed")
+			}
+			if attrStatus != fuse.ENOENT || openStatus != fuse.ENOENT {
+				t.Errorf("GetAttr, Open of a hidden FIFO = %v, %v, want ENOENT", attrStatus, openStatus)
+			}
+			continue
+		}
+		if mode, ok := listed["pipe"]; !ok || mode != unix.S_IFIFO {
+			t.Errorf("FIFO listed as %o, %v, want S_IFIFO", mode, ok)
+		}
+		if attrStatus != fuse.OK || attr.Mode&unix.S_IFMT != unix.S_IFIFO {
+			t.Errorf("GetAttr of a FIFO = %v, %v, want S_IFIFO", attr, attrStatus)
+		}
+		if openStatus != fuse.Status(unix.ENXIO) {
+			t.Errorf("Open of a FIFO = %v, want ENXIO", openStatus)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Number of buckets in the candidates histogram, the last one counts
+// resolutions which took this many candidates or more.
+const candidateBuckets = 8
+
+// Stats holds counters of a GoPathFs.
+type Stats struct {
+	// CandidatesTried is the histogram of how many candidate paths were
+	// tried before a mount path resolved: CandidatesTried[i] counts the
+	// resolutions which took i+1 candidates.
+	CandidatesTried [candidateBuckets]int64
+
+	// Ops counts the operations served, and Errors those which failed for
+	// another reason than a missing entry.
+	Ops    int64
+	Errors int64
+
+	AttrCacheHits   int64
+	AttrCacheMisses int64
+
+	// OpenFiles is the number of workspace files currently open, and
+	// OpenFilesRejected counts the opens rejected over max-open-files.
+	OpenFiles         int64
+	OpenFilesRejected int64
+
+	// QuarantinedPaths is the number of recently rejected paths, and
+	// QuarantineHits counts the rejections repeated from the quarantine.
+	QuarantinedPaths int
+	QuarantineHits   int64
+
+	// ConsistencyChecked counts the cached entries compared with the
+	// backing files by the consistency check, and ConsistencyMismatches
+	// those found stale.
+	ConsistencyChecked    int64
+	ConsistencyMismatches int64
+}
+
+type stats struct {
+	candidatesTried [candidateBuckets]int64
+	ops             int64
+	errors          int64
+	openFiles       int64
+
+	openFilesRejected int64
+	openFilesWarned   int32 // Set while at or above max-open-files.
+
+	consistencyChecked    int64
+	consistencyMismatches int64
+}
+
+// recordOp counts an operation, given a pointer to its result status so
+// that it can be deferred.
+func (s *stats) recordOp(code *fuse.Status) {
+	atomic.AddInt64(&s.ops, 1)
+	if *code != fuse.OK && *code != fuse.ENOENT {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+func (s *stats) recordCandidates(tried int) {
+	if tried < 1 {
+		return
+	}
+	if tried > candidateBuckets {
+		tried = candidateBuckets
+	}
+	atomic.AddInt64(&s.candidatesTried[tried-1], 1)
+}
+
+// Stats returns a snapshot of the counters.
+func (gpf *GoPathFs) Stats() Stats {
+	st := Stats{}
+	for i := range gpf.stats.candidatesTried {
+		st.CandidatesTried[i] = atomic.LoadInt64(&gpf.stats.candidatesTried[i])
+	}
+	st.Ops = atomic.LoadInt64(&gpf.stats.ops)
+	st.Errors = atomic.LoadInt64(&gpf.stats.errors)
+	st.AttrCacheHits = atomic.LoadInt64(&gpf.attrCache.hits)
+	st.AttrCacheMisses = atomic.LoadInt64(&gpf.attrCache.misses)
+	st.OpenFiles = atomic.LoadInt64(&gpf.stats.openFiles)
+	st.OpenFilesRejected = atomic.LoadInt64(&gpf.stats.openFilesRejected)
+	st.QuarantinedPaths, st.QuarantineHits = gpf.quarantineStats()
+	st.ConsistencyChecked = atomic.LoadInt64(&gpf.stats.consistencyChecked)
+	st.ConsistencyMismatches = atomic.LoadInt64(&gpf.stats.consistencyMismatches)
+	return st
+}
+
+// String returns a human readable summary of the counters.
+func (st Stats) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Candidates tried per resolved path:")
+	for i, n := range st.CandidatesTried {
+		if i == candidateBuckets-1 {
+			fmt.Fprintf(&buf, " %d+: %d", i+1, n)
+		} else {
+			fmt.Fprintf(&buf, " %d: %d,", i+1, n)
+		}
+	}
+	return buf.String()
+}
+
+// logStats prints a summary of the activity every interval, until stop is
+// closed.
+func (gpf *GoPathFs) logStats(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prev := gpf.Stats()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		st := gpf.Stats()
+		ratio := 0.0
+		if lookups := st.AttrCacheHits + st.AttrCacheMisses - prev.AttrCacheHits - prev.AttrCacheMisses; lookups > 0 {
+			ratio = float64(st.AttrCacheHits-prev.AttrCacheHits) / float64(lookups) * 100
+		}
+		fmt.Printf("Stats: %d ops (%d errors) in the last %v, attribute cache hit ratio %.0f%%, %d open files.\n",
+			st.Ops-prev.Ops, st.Errors-prev.Errors, interval, ratio, st.OpenFiles)
+		prev = st
+	}
+}
@@ -0,0 +1,139 @@
+package gopathfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// SymlinkLoop is a symbolic link in a served tree which leads back to a
+// directory it's in, directly or through other links, so that walks
+// following links would never end.
+type SymlinkLoop struct {
+	Link   string // Backing path of the link.
+	Target string // Real path of the directory it leads back to, if known.
+}
+
+// ScanSymlinkLoops walks the workspace and the vendor directories outside
+// of it, following symbolic links to directories within them, and warns
+// about links leading back into a directory being walked.
+func (gpf *GoPathFs) ScanSymlinkLoops() []SymlinkLoop {
+	loops := []SymlinkLoop{}
+	roots := []string{gpf.workspace()}
+	for _, v := range gpf.vendors() {
+		roots = append(roots, v.root)
+	}
+
+	// Directories are colored by real path: absent if not walked yet, false
+	// while being walked, true once done.
+	done := map[string]bool{}
+	for _, root := range roots {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if _, ok := done[real]; !ok {
+			scanSymlinkLoops(real, real, done, &loops)
+		}
+	}
+
+	for _, l := range loops {
+		if l.Target == "" {
+			fmt.Printf("Warning: symbolic link %s is part of a loop.\n", l.Link)
+			continue
+		}
+		fmt.Printf("Warning: symbolic link %s loops back to %s.\n", l.Link, l.Target)
+	}
+	return loops
+}
+
+func scanSymlinkLoops(root, dir string, done map[string]bool, loops *[]SymlinkLoop) {
+	done[dir] = false
+	defer func() { done[dir] = true }()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			scanSymlinkLoops(root, path, done, loops)
+			continue
+		}
+		if e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// Links leading only to links, e.g., a -> b -> a, fail to
+			// resolve with ELOOP.
+			if _, err := os.Stat(path); errors.Is(err, unix.ELOOP) {
+				*loops = append(*loops, SymlinkLoop{Link: path})
+			}
+			continue
+		}
+		if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+			continue
+		}
+		// Links out of the tree (e.g., bazel-out) aren't followed.
+		if target != root && !strings.HasPrefix(target, root+pathSeparator) {
+			continue
+		}
+
+		walked, seen := done[target]
+		if seen && !walked {
+			*loops = append(*loops, SymlinkLoop{Link: path, Target: target})
+		} else if !seen {
+			scanSymlinkLoops(root, target, done, loops)
+		}
+	}
+}
+
+// walkVisits tracks the backing directories a walk through the mount has
+// entered, so that walks terminate despite symbolic link loops.
+type walkVisits struct {
+	mu   sync.Mutex
+	real map[string]string // Real backing path by mount directory entered.
+}
+
+// enter returns true if the given mount directory is to be walked, i.e., its
+// backing directory isn't that of one of its parents, which would make a
+// loop. Directories reached through several paths, e.g., symbolic links to
+// a shared directory, are walked at each. Virtual directories are always
+// walked. The parents must have been entered before.
+func (v *walkVisits) enter(gpf *GoPathFs, dir string) bool {
+	c, _, ok := gpf.resolve(dir)
+	if !ok || c.src != nil {
+		return true
+	}
+	real, err := filepath.EvalSymlinks(c.path)
+	if err != nil {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.real == nil {
+		v.real = map[string]string{}
+	}
+	for p := dir; p != ""; {
+		if p = filepath.Dir(p); p == "." {
+			p = ""
+		}
+		if v.real[p] == real {
+			if gpf.debug {
+				fmt.Printf("Skipped %s in walk, it loops back to %s.\n", dir, p)
+			}
+			return false
+		}
+	}
+	v.real[dir] = real
+	return true
+}
@@ -0,0 +1,94 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestWalkFollowsSharedDirsButNotLoops(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "shared", "a.go"), "package shared\n")
+	writeFile(t, filepath.Join(ws, "foo", "b.go"), "package foo\n")
+	for link, target := range map[string]string{
+		"foo/s1":     "../shared",
+		"foo/s2":     "../shared",
+		"foo/sub/up": "..",
+	} {
+		if err := os.MkdirAll(filepath.Join(ws, filepath.Dir(link)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(ws, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string]bool{}
+	for _, p := range gpf.ListPackages() {
+		got[p.ImportPath] = true
+	}
+	for _, dir := range []string{"shared", "foo", "foo/s1", "foo/s2"} {
+		if !got[testPrefix+"/"+dir] {
+			t.Errorf("package %s not listed", dir)
+		}
+	}
+	if got[testPrefix+"/foo/sub/up"] {
+		t.Error("package foo/sub/up listed, which loops back to foo")
+	}
+	if len(got) != 4 {
+		t.Errorf("ListPackages = %v, want 4 packages", got)
+	}
+}
+
+func TestSymlinkedPackageDir(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	elsewhere := t.TempDir()
+	writeFile(t, filepath.Join(elsewhere, "bar", "a.go"), "package bar\n")
+	writeFile(t, filepath.Join(ws, "foo", "b.go"), "package foo\n")
+	for link, target := range map[string]string{
+		filepath.Join(ws, "foo", "bar"):      filepath.Join(elsewhere, "bar"),
+		filepath.Join(ws, "linked"):          filepath.Join(elsewhere, "bar"),
+		filepath.Join(ws, "foo", "c.go"):     filepath.Join(ws, "foo", "b.go"),
+		filepath.Join(ws, "foo", "dangling"): filepath.Join(elsewhere, "missing"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modes := map[string]uint32{}
+	for _, dir := range []string{testPrefix, testPrefix + "/foo"} {
+		entries, status := gpf.OpenDir(dir, nil)
+		if status != fuse.OK {
+			t.Fatalf("OpenDir(%s) = %v", dir, status)
+		}
+		for _, e := range entries {
+			modes[dir+"/"+e.Name] = e.Mode
+		}
+	}
+	for name, want := range map[string]uint32{
+		testPrefix + "/linked":   fuse.S_IFDIR,
+		testPrefix + "/foo/bar":  fuse.S_IFDIR,
+		testPrefix + "/foo/c.go": fuse.S_IFREG,
+	} {
+		if modes[name] != want {
+			t.Errorf("%s listed with mode %o, want %o", name, modes[name], want)
+		}
+		attr, status := gpf.GetAttr(name, nil)
+		if status != fuse.OK || attr.Mode&unix.S_IFMT != want {
+			t.Errorf("GetAttr(%s) = %v, %v, want mode %o", name, attr, status, want)
+		}
+	}
+	if _, ok := modes[testPrefix+"/foo/dangling"]; ok {
+		t.Errorf("dangling link listed")
+	}
+
+	for _, name := range []string{testPrefix + "/foo/bar/a.go", testPrefix + "/linked/a.go"} {
+		if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != "package bar\n" {
+			t.Errorf("reading %s = %q, %v", name, got, status)
+		}
+	}
+}
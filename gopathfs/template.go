@@ -0,0 +1,140 @@
+package gopathfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// Files and bytes of expanded content beyond which the templateCache starts
+// over.
+const (
+	maxTemplateFiles = 1000
+	maxTemplateBytes = 64 << 20
+)
+
+// templateCache keeps the expanded content of template files by backing
+// path, until they or the config change.
+type templateCache struct {
+	mu      sync.Mutex
+	entries map[string]templateCacheEntry
+	bytes   int64 // Of the expanded content cached.
+}
+
+type templateCacheEntry struct {
+	mtime time.Time
+	size  int64
+	data  []byte
+}
+
+func (tc *templateCache) clear() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.entries = nil
+	tc.bytes = 0
+}
+
+// expandsTemplate returns true if files at the given mount path are read
+// expanded as Go templates, i.e., if its base name matches one of the
+// template-files patterns.
+func (gpf *GoPathFs) expandsTemplate(name string) bool {
+	base := filepath.Base(name)
+	for _, p := range gpf.config().TemplateFiles {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateVars returns the variables templates are expanded with: the
+// template-vars, plus Workspace, SrcDir and GoPkgPrefix unless set there.
+func (gpf *GoPathFs) templateVars() map[string]string {
+	vars := map[string]string{
+		"Workspace":   gpf.workspace(),
+		"SrcDir":      gpf.dirs.SrcDir,
+		"GoPkgPrefix": gpf.config().GoPkgPrefix,
+	}
+	for k, v := range gpf.config().TemplateVarMap {
+		vars[k] = v
+	}
+	return vars
+}
+
+// readExpanded returns the expanded content of the given backing template
+// file. It returns false if the file can't be read or isn't a valid
+// template, in which case it's served as is.
+func (gpf *GoPathFs) readExpanded(path string) ([]byte, bool) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, false
+	}
+
+	tc := &gpf.templates
+	tc.mu.Lock()
+	e, ok := tc.entries[path]
+	tc.mu.Unlock()
+	if ok && e.mtime.Equal(fi.ModTime()) && e.size == fi.Size() {
+		return e.data, true
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		fmt.Printf("Warning, failed to parse template %s, served as is, %v.\n", path, err)
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, gpf.templateVars()); err != nil {
+		fmt.Printf("Warning, failed to expand template %s, served as is, %v.\n", path, err)
+		return nil, false
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if old, ok := tc.entries[path]; ok {
+		tc.bytes -= int64(len(old.data))
+	}
+	if tc.entries == nil || len(tc.entries) >= maxTemplateFiles || tc.bytes+int64(buf.Len()) > maxTemplateBytes {
+		tc.entries = map[string]templateCacheEntry{}
+		tc.bytes = 0
+	}
+	tc.entries[path] = templateCacheEntry{mtime: fi.ModTime(), size: fi.Size(), data: buf.Bytes()}
+	tc.bytes += int64(buf.Len())
+	return buf.Bytes(), true
+}
+
+// openExpandedFile opens the given backing template file read-only with its
+// content expanded, see readExpanded.
+func (gpf *GoPathFs) openExpandedFile(path string) (nodefs.File, bool) {
+	data, ok := gpf.readExpanded(path)
+	if !ok {
+		return nil, false
+	}
+	return &contentSourceFile{
+		File: nodefs.NewDefaultFile(),
+		r:    bytes.NewReader(data),
+		size: int64(len(data)),
+	}, true
+}
+
+// expandAttr sets the size of the given attributes of a backing template
+// file to the size of its expanded content.
+func (gpf *GoPathFs) expandAttr(name, path string, attr *fuse.Attr) {
+	if attr.Mode&fuse.S_IFREG == 0 || !gpf.expandsTemplate(name) {
+		return
+	}
+	if data, ok := gpf.readExpanded(path); ok {
+		attr.Size = uint64(len(data))
+	}
+}
@@ -0,0 +1,68 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestTemplateExpansion(t *testing.T) {
+	cfg := testConfig()
+	cfg.TemplateFiles = []string{"*.tmpl"}
+	cfg.TemplateVarMap = map[string]string{"version": "1.2.3"}
+	gpf, ws := newTestFs(t, cfg)
+	writeFile(t, filepath.Join(ws, "foo", "v.tmpl"), "v{{.version}}\n")
+	writeFile(t, filepath.Join(ws, "foo", "prefix.tmpl"), "{{.GoPkgPrefix}}")
+	writeFile(t, filepath.Join(ws, "foo", "bad.tmpl"), "{{.missing}}")
+	writeFile(t, filepath.Join(ws, "foo", "v.txt"), "v{{.version}}\n")
+
+	for file, want := range map[string]string{
+		"v.tmpl":      "v1.2.3\n",
+		"prefix.tmpl": testPrefix,
+		"bad.tmpl":    "{{.missing}}", // Served as is.
+		"v.txt":       "v{{.version}}\n",
+	} {
+		name := testPrefix + "/foo/" + file
+		if got, status := readMountFile(t, gpf, name); status != fuse.OK || got != want {
+			t.Errorf("reading %s = %q, %v, want %q", file, got, status, want)
+		}
+		if attr, status := gpf.GetAttr(name, nil); status != fuse.OK || attr.Size != uint64(len(want)) {
+			t.Errorf("GetAttr(%s) = %v, %v, want size %d", file, attr, status, len(want))
+		}
+	}
+
+	// Template files can't be opened for writing, other files can.
+	if _, status := gpf.Open(testPrefix+"/foo/v.tmpl", uint32(os.O_RDWR), nil); status != fuse.EROFS {
+		t.Errorf("Open of a template file for writing = %v, want EROFS", status)
+	}
+	f, status := gpf.Open(testPrefix+"/foo/v.txt", uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open of another file for writing = %v", status)
+	}
+	f.Release()
+
+	// Changes are expanded on the next read.
+	writeFile(t, filepath.Join(ws, "foo", "v.tmpl"), "version {{.version}}\n")
+	if got, _ := readMountFile(t, gpf, testPrefix+"/foo/v.tmpl"); got != "version 1.2.3\n" {
+		t.Errorf("reading v.tmpl after a change = %q", got)
+	}
+}
+
+func TestTemplateCacheBounded(t *testing.T) {
+	cfg := testConfig()
+	cfg.TemplateFiles = []string{"*.tmpl"}
+	gpf, ws := newTestFs(t, cfg)
+	for i := 0; i < maxTemplateFiles+10; i++ {
+		path := filepath.Join(ws, "foo", fmt.Sprintf("%d.tmpl", i))
+		writeFile(t, path, "{{.GoPkgPrefix}}")
+		if _, ok := gpf.readExpanded(path); !ok {
+			t.Fatalf("expanding %s failed", path)
+		}
+	}
+	if n := len(gpf.templates.entries); n > maxTemplateFiles {
+		t.Errorf("%d expanded files cached, want at most %d", n, maxTemplateFiles)
+	}
+}
@@ -0,0 +1,44 @@
+package gopathfs
+
+import (
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Span describes an operation served by a GoPathFs, for tracing.
+type Span struct {
+	Op          string // E.g., getattr, open or rename.
+	Name        string // The mount path, "old -> new" for rename.
+	BackingPath string // The backing path the operation resolved Name to, if any.
+	Start       time.Time
+	End         time.Time
+	Status      fuse.Status
+}
+
+// Tracer receives a span for every operation, e.g., to export it with
+// OpenTelemetry so that the operations of the mount are correlated with
+// the build traces. gobazel doesn't depend on a tracing library; a Tracer
+// records the span with its own start and end times.
+type Tracer interface {
+	Span(s Span)
+}
+
+// SetTracer sets the tracer of operations. It must be called before
+// mounting. Without a tracer, no spans are built.
+func (gpf *GoPathFs) SetTracer(t Tracer) {
+	gpf.tracer = t
+}
+
+// trace passes the span of the given operation to the tracer, with the
+// backing path the operation resolved, if any.
+func (gpf *GoPathFs) trace(op, name, backingPath string, start time.Time, code fuse.Status) {
+	gpf.tracer.Span(Span{
+		Op:          op,
+		Name:        name,
+		BackingPath: backingPath,
+		Start:       start,
+		End:         time.Now(),
+		Status:      code,
+	})
+}
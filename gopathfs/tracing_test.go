@@ -0,0 +1,98 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// spanRecorder is a Tracer keeping the spans in memory.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (sr *spanRecorder) Span(s Span) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.spans = append(sr.spans, s)
+}
+
+// take returns the spans recorded since the last call.
+func (sr *spanRecorder) take() []Span {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	spans := sr.spans
+	sr.spans = nil
+	return spans
+}
+
+func TestTracerSpans(t *testing.T) {
+	sr := &spanRecorder{}
+	gpf, ws := newTestFs(t, nil, WithTracer(sr))
+	backing := filepath.Join(ws, "foo", "a.go")
+	writeFile(t, backing, "package foo\n")
+	name := testPrefix + "/foo/a.go"
+
+	expect := func(what string, want ...Span) {
+		t.Helper()
+		got := sr.take()
+		if len(got) != len(want) {
+			t.Fatalf("%s: spans %+v, want %+v", what, got, want)
+		}
+		for i, w := range want {
+			g := got[i]
+			if g.Op != w.Op || g.Name != w.Name || g.BackingPath != w.BackingPath || g.Status != w.Status {
+				t.Errorf("%s: span %+v, want %+v", what, g, w)
+			}
+			if g.End.Before(g.Start) {
+				t.Errorf("%s: span %s ends before it starts", what, g.Op)
+			}
+		}
+	}
+
+	// The backing path comes from the operation, not from resolving the
+	// name again.
+	before := gpf.Stats().CandidatesTried
+	gpf.GetAttr(name, nil)
+	expect("getattr", Span{Op: "getattr", Name: name, BackingPath: backing})
+	resolutions := int64(0)
+	after := gpf.Stats().CandidatesTried
+	for i := range after {
+		resolutions += after[i] - before[i]
+	}
+	if resolutions != 1 {
+		t.Errorf("%d resolutions for a traced getattr, want 1", resolutions)
+	}
+
+	gpf.Access(name, 4, nil)
+	expect("access", Span{Op: "access", Name: name})
+
+	gpf.GetXAttr(name, hashXAttr, nil)
+	expect("getxattr", Span{Op: "getxattr", Name: name, BackingPath: backing})
+
+	f, status := gpf.Open(name, uint32(os.O_RDWR), nil)
+	if status != fuse.OK {
+		t.Fatalf("Open = %v", status)
+	}
+	expect("open", Span{Op: "open", Name: name, BackingPath: backing})
+	f.Read(make([]byte, 64), 0)
+	f.Write([]byte("package bar\n"), 0)
+	f.Flush()
+	f.Release()
+	expect("file I/O",
+		Span{Op: "read", Name: name, BackingPath: backing},
+		Span{Op: "write", Name: name, BackingPath: backing},
+		Span{Op: "flush", Name: name, BackingPath: backing},
+	)
+
+	if status := gpf.Unlink(name, nil); status != fuse.OK {
+		t.Fatalf("Unlink = %v", status)
+	}
+	expect("unlink", Span{Op: "unlink", Name: name, BackingPath: backing})
+	gpf.GetAttr(name, nil)
+	expect("missing", Span{Op: "getattr", Name: name, Status: fuse.ENOENT})
+}
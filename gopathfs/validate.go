@@ -0,0 +1,248 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// ValidationProblem is a mount path which is listed but can't be served.
+type ValidationProblem struct {
+	Path        string
+	BackingPath string // Empty if the path doesn't resolve.
+	Reason      string
+}
+
+// ValidationReport is the result of Validate.
+type ValidationReport struct {
+	Paths    int                 // Mount paths checked.
+	Problems []ValidationProblem // Sorted by mount path.
+}
+
+// OK returns true if every mount path checked can be served.
+func (r ValidationReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Validate walks the first-party and vendor trees as they are served, like
+// ListPackages, and checks that every file and directory listed resolves to
+// a readable backing file, e.g., to catch unreadable files, dangling
+// symbolic links and missing genfiles before a build. The attributes of the
+// paths checked are cached along the way, which warms the mount. The walk
+// shares the cap of scan-concurrency with the other background walks, and
+// isn't accounted as operations through the mount.
+func (gpf *GoPathFs) Validate() ValidationReport {
+	v := validator{gpf: gpf}
+
+	gpf.walkTree(gpf.walkRoots(), v.walk)
+
+	sort.Slice(v.report.Problems, func(i, j int) bool {
+		return v.report.Problems[i].Path < v.report.Problems[j].Path
+	})
+	for _, p := range v.report.Problems {
+		fmt.Printf("Warning, %s can't be served: %s.\n", p.Path, p.Reason)
+	}
+	return v.report
+}
+
+type validator struct {
+	gpf    *GoPathFs
+	visits walkVisits
+
+	mu     sync.Mutex
+	report ValidationReport
+}
+
+// walk checks the given directory and its files, and returns its
+// subdirectories.
+func (v *validator) walk(dir string) (subdirs []string) {
+	if v.gpf.isGoRoot(dir) {
+		return nil
+	}
+	if !v.check(dir, true) || !v.visits.enter(v.gpf, dir) {
+		return nil
+	}
+
+	entries, status := v.gpf.scanDir(dir)
+	if status != fuse.OK {
+		v.problem(dir, "", fmt.Sprintf("listing failed, %v", status))
+		return nil
+	}
+
+	// The files are checked under the same cap as the listings.
+	sem := v.gpf.scanSem()
+	sem <- struct{}{}
+	v.checkDangling(dir, entries)
+	for _, e := range entries {
+		name := filepath.Join(dir, e.Name)
+		if e.Mode&fuse.S_IFDIR != 0 {
+			subdirs = append(subdirs, name)
+			continue
+		}
+		v.check(name, false)
+	}
+	<-sem
+	return subdirs
+}
+
+// check checks that the given mount path can be served, and returns false
+// if it can't.
+func (v *validator) check(name string, isDir bool) bool {
+	v.mu.Lock()
+	v.report.Paths++
+	v.mu.Unlock()
+
+	if v.isVirtual(name) {
+		return true
+	}
+
+	// Caches the attributes as a lookup would.
+	if _, _, status := v.gpf.lookupAttr(name); status != fuse.OK && status != fuse.ENOENT {
+		v.problem(name, "", fmt.Sprintf("getattr failed, %v", status))
+		return false
+	}
+
+	c, _, ok := v.gpf.resolve(name)
+	if !ok {
+		// Parents of overlay directories have no backing directory.
+		if isDir && len(v.gpf.overlayChildren(name)) > 0 {
+			return true
+		}
+		v.problem(name, "", "no backing file, e.g., missing genfiles")
+		return false
+	}
+
+	if c.src != nil {
+		if isDir {
+			return true
+		}
+		r, size, err := c.src.Open(c.rel)
+		if err == nil && size > 0 {
+			_, err = r.ReadAt(make([]byte, 1), 0)
+		}
+		if err != nil && err != io.EOF {
+			v.problem(name, c.rel, err.Error())
+			return false
+		}
+		return true
+	}
+
+	f, err := os.Open(c.path)
+	if err == nil {
+		if isDir {
+			_, err = f.Readdirnames(1)
+		} else {
+			_, err = f.Read(make([]byte, 1))
+		}
+		f.Close()
+	}
+	if err != nil && err != io.EOF {
+		v.problem(name, c.path, err.Error())
+		return false
+	}
+	return true
+}
+
+// checkDangling reports the symbolic links in the backing directories of
+// the given mount directory which lead nowhere, and which listings skip,
+// unless the name is served from another backing directory.
+func (v *validator) checkDangling(dir string, entries []fuse.DirEntry) {
+	listed := listedNames(entries)
+	reported := map[string]bool{}
+	for _, c := range v.gpf.candidates(dir) {
+		if c.src != nil {
+			continue
+		}
+		fis, err := ioutil.ReadDir(c.path)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if fi.Mode()&os.ModeSymlink == 0 || reported[fi.Name()] {
+				continue
+			}
+			if _, ok := listed[fi.Name()]; ok {
+				continue
+			}
+			path := filepath.Join(c.path, fi.Name())
+			if _, err := os.Stat(path); err != nil {
+				reported[fi.Name()] = true
+				v.problem(filepath.Join(dir, fi.Name()), path, "dangling symbolic link")
+			}
+		}
+	}
+}
+
+// isVirtual returns true if the given mount path is served by gobazel
+// itself, without a backing file.
+func (v *validator) isVirtual(name string) bool {
+	gpf := v.gpf
+	if name == ctlFileName || name == gpf.config().GoPkgPrefix {
+		return true
+	}
+	if gpf.servesModulesTxt(name) || isIntrospectPath(name) || gpf.servesBazelMeta(name) {
+		return true
+	}
+	if gpf.servesMetadata(name) {
+		return true
+	}
+	return gpf.servesDocGo(name)
+}
+
+func (v *validator) problem(name, backingPath, reason string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.report.Problems = append(v.report.Problems, ValidationProblem{
+		Path:        name,
+		BackingPath: backingPath,
+		Reason:      reason,
+	})
+}
+
+// validation tracks the validation run in the background for the control
+// file, and the report of the last one.
+type validation struct {
+	mu      sync.Mutex
+	running bool
+	last    *ValidationReport
+}
+
+// ValidationStatus is the answer to the validate control command.
+type ValidationStatus struct {
+	Running bool              // A validation runs in the background.
+	Last    *ValidationReport // Of the last validation finished, if any.
+}
+
+// startValidation starts a Validate in the background, unless one runs, and
+// returns the status of the validations.
+func (gpf *GoPathFs) startValidation() ValidationStatus {
+	vs := &gpf.validation
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if !vs.running {
+		vs.running = true
+		go func() {
+			report := gpf.Validate()
+			vs.mu.Lock()
+			defer vs.mu.Unlock()
+			vs.running = false
+			vs.last = &report
+		}()
+	}
+	return ValidationStatus{Running: true, Last: vs.last}
+}
+
+// validationStatus returns the status of the validations started through
+// the control file.
+func (gpf *GoPathFs) validationStatus() ValidationStatus {
+	vs := &gpf.validation
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return ValidationStatus{Running: vs.running, Last: vs.last}
+}
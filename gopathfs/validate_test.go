@@ -0,0 +1,77 @@
+package gopathfs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateReportsBrokenBackingFiles(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+	if err := os.Symlink("missing.go", filepath.Join(ws, "foo", "dangling.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop.go", filepath.Join(ws, "foo", "loop.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	report := gpf.Validate()
+	problems := map[string]string{}
+	for _, p := range report.Problems {
+		problems[p.Path] = p.BackingPath
+	}
+	for _, name := range []string{"dangling.go", "loop.go"} {
+		want := filepath.Join(ws, "foo", name)
+		if got, ok := problems[testPrefix+"/foo/"+name]; !ok || got != want {
+			t.Errorf("problem of %s = %q, %v, want backing path %s", name, got, ok, want)
+		}
+	}
+	if _, ok := problems[testPrefix+"/foo/a.go"]; ok {
+		t.Error("a.go reported as a problem")
+	}
+	if report.OK() {
+		t.Error("report OK with broken backing files")
+	}
+
+	// The walk isn't accounted as operations through the mount.
+	if ops := gpf.Stats().Ops; ops != 0 {
+		t.Errorf("Stats().Ops = %d after Validate, want 0", ops)
+	}
+}
+
+func TestValidateControlCommandIsAsync(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	f, _ := gpf.openCtlFile()
+	ctl := func(cmd string) ValidationStatus {
+		t.Helper()
+		if _, status := f.Write([]byte(cmd+"\n"), 0); !status.Ok() {
+			t.Fatalf("writing %s = %v", cmd, status)
+		}
+		buf := make([]byte, 1<<16)
+		res, _ := f.Read(buf, 0)
+		data, _ := res.Bytes(buf)
+		st := ValidationStatus{}
+		if err := json.Unmarshal(data, &st); err != nil {
+			t.Fatalf("answer to %s = %q, %v", cmd, data, err)
+		}
+		return st
+	}
+
+	if st := ctl("validate"); !st.Running {
+		t.Errorf("validate = %+v, want running", st)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	st := ctl("report")
+	for st.Running && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		st = ctl("report")
+	}
+	if st.Running || st.Last == nil || st.Last.Paths == 0 || !st.Last.OK() {
+		t.Errorf("report = %+v, want a finished report without problems", st)
+	}
+}
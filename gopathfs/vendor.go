@@ -0,0 +1,38 @@
+package gopathfs
+
+import (
+	"path"
+	"strings"
+)
+
+// ResolveImport returns the mount path the Go tool resolves importPath to
+// when imported from the package importer, following Go's vendor rules:
+//
+//  1. <dir>/vendor/<importPath>, for dir being importer itself and then each
+//     of its parents, deepest first. This is how packages vendored with
+//     their own nested vendor folder find their dependencies.
+//  2. <importPath> itself, which covers first-party packages and the
+//     workspace's vendor dirs (they are mapped to the top of the mount).
+//
+// It returns false if none of them exists.
+func (gpf *GoPathFs) ResolveImport(importer, importPath string) (string, bool) {
+	importer = strings.Trim(importer, "/")
+	importPath = strings.Trim(importPath, "/")
+
+	for dir := importer; dir != "." && dir != ""; dir = path.Dir(dir) {
+		if path.Base(dir) == "vendor" {
+			// A vendor folder doesn't have a nested vendor of its own.
+			continue
+		}
+
+		name := path.Join(dir, "vendor", importPath)
+		if _, _, ok := gpf.resolve(name); ok {
+			return name, true
+		}
+	}
+
+	if _, _, ok := gpf.resolve(importPath); ok {
+		return importPath, true
+	}
+	return "", false
+}
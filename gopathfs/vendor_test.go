@@ -0,0 +1,53 @@
+package gopathfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestResolveImportNestedVendor(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	vendor := filepath.Join(ws, "vendor")
+	writeFile(t, filepath.Join(vendor, "github.com", "a", "b", "b.go"), "package b\n")
+	writeFile(t, filepath.Join(vendor, "github.com", "a", "b", "sub", "sub.go"), "package sub\n")
+	writeFile(t, filepath.Join(vendor, "github.com", "a", "b", "vendor", "github.com", "c", "d", "d.go"), "package nested\n")
+	writeFile(t, filepath.Join(vendor, "github.com", "a", "b", "sub", "vendor", "github.com", "c", "d", "d.go"), "package deeper\n")
+	writeFile(t, filepath.Join(vendor, "github.com", "c", "d", "d.go"), "package top\n")
+	writeFile(t, filepath.Join(vendor, "github.com", "e", "f", "f.go"), "package f\n")
+	writeFile(t, filepath.Join(ws, "foo", "vendor", "github.com", "c", "d", "d.go"), "package firstparty\n")
+
+	for _, tc := range []struct {
+		importer, importPath, want, content string
+	}{
+		// The importer's nested vendor folder first.
+		{"github.com/a/b", "github.com/c/d", "github.com/a/b/vendor/github.com/c/d", "package nested\n"},
+		// The deepest one of a package below it.
+		{"github.com/a/b/sub", "github.com/c/d", "github.com/a/b/sub/vendor/github.com/c/d", "package deeper\n"},
+		// Packages in a nested vendor folder use their vendoring package's.
+		{"github.com/a/b/vendor/github.com/x", "github.com/c/d", "github.com/a/b/vendor/github.com/c/d", "package nested\n"},
+		// The top level otherwise.
+		{"github.com/e/f", "github.com/c/d", "github.com/c/d", "package top\n"},
+		{"github.com/a/b", "github.com/e/f", "github.com/e/f", "package f\n"},
+		// First-party packages have nested vendor folders too.
+		{testPrefix + "/foo/bar", "github.com/c/d", testPrefix + "/foo/vendor/github.com/c/d", "package firstparty\n"},
+	} {
+		got, ok := gpf.ResolveImport(tc.importer, tc.importPath)
+		if !ok || got != tc.want {
+			t.Errorf("ResolveImport(%s, %s) = %s, %v, want %s", tc.importer, tc.importPath, got, ok, tc.want)
+			continue
+		}
+		file := "d.go"
+		if tc.importPath == "github.com/e/f" {
+			file = "f.go"
+		}
+		if data, status := readMountFile(t, gpf, got+"/"+file); status != fuse.OK || data != tc.content {
+			t.Errorf("reading %s/%s = %q, %v, want %q", got, file, data, status, tc.content)
+		}
+	}
+
+	if got, ok := gpf.ResolveImport("github.com/a/b", "github.com/missing"); ok {
+		t.Errorf("ResolveImport of a missing package = %s, want false", got)
+	}
+}
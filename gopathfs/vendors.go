@@ -0,0 +1,115 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// The number of vendor directories beyond which a warning is printed, since
+// each lookup of a vendor path missing from the first ones tries them all.
+const manyVendors = 16
+
+// vendorDir is an existing vendor directory.
+type vendorDir struct {
+	name string // As configured, relative to the workspace.
+	root string // The real path, with symbolic links resolved.
+}
+
+// checkVendors finds the configured vendor directories which exist. Missing
+// ones are skipped by lookups, until checked again. Vendor directories
+// which are symbolic links (e.g., into a shared cache) are resolved here
+// once, or skipped with skip-vendor-symlinks. Problems are reported when the
+// vendor directories served change only, not on every flush.
+func (gpf *GoPathFs) checkVendors() {
+	vendors := []vendorDir{}
+	warnings := []string{}
+	events := []ErrorEvent{}
+	for _, v := range gpf.config().Vendors {
+		path := filepath.Join(gpf.workspace(), v)
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 && gpf.config().SkipVendorSymlinks {
+			warnings = append(warnings, fmt.Sprintf("Warning, vendor directory %s is a symbolic link, skipped.\n", v))
+			continue
+		}
+
+		root, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Warning, vendor directory %s doesn't exist, skipped.\n", v))
+			events = append(events, ErrorEvent{Op: "check-vendors", Name: v, Status: fuse.ENOENT})
+			continue
+		}
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			warnings = append(warnings, fmt.Sprintf("Warning, vendor directory %s doesn't exist, skipped.\n", v))
+			events = append(events, ErrorEvent{Op: "check-vendors", Name: v, Status: fuse.ENOTDIR})
+			continue
+		}
+		if gpf.debug && root != path {
+			fmt.Printf("Vendor directory %s resolved to %s.\n", v, root)
+		}
+		vendors = append(vendors, vendorDir{name: v, root: root})
+	}
+
+	if limit := gpf.config().MaxVendorsLimit; limit > 0 && len(vendors) > limit {
+		warnings = append(warnings, fmt.Sprintf("Warning, %d vendor directories exist, only the first %d (max-vendors) are served.\n", len(vendors), limit))
+		vendors = vendors[:limit]
+	} else if len(vendors) > manyVendors {
+		warnings = append(warnings, fmt.Sprintf("Warning, %d vendor directories are served, lookups of missing vendor paths try each of them.\n", len(vendors)))
+	}
+
+	gpf.vendorsMu.Lock()
+	// Nil until checked first.
+	changed := gpf.existingVendors == nil || !equalVendors(gpf.existingVendors, vendors)
+	gpf.existingVendors = vendors
+	gpf.vendorsMu.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, w := range warnings {
+		fmt.Print(w)
+	}
+	for _, e := range events {
+		gpf.reportError(e)
+	}
+}
+
+func equalVendors(a, b []vendorDir) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// vendorRootFor returns the vendor directory a new entry at the given mount
+// path is created in, i.e., the first one its parent directory exists in,
+// so that related files aren't scattered across vendor directories, or the
+// first existing one.
+func (gpf *GoPathFs) vendorRootFor(name string) (string, bool) {
+	vendors := gpf.vendors()
+	if parent := filepath.Dir(name); parent != "." {
+		for _, v := range vendors {
+			if fi, err := os.Stat(filepath.Join(v.root, parent)); err == nil && fi.IsDir() {
+				return v.root, true
+			}
+		}
+	}
+
+	if len(vendors) == 0 {
+		return "", false
+	}
+	return vendors[0].root, true
+}
+
+// vendors returns the existing vendor directories, in the configured order.
+func (gpf *GoPathFs) vendors() []vendorDir {
+	gpf.vendorsMu.RLock()
+	defer gpf.vendorsMu.RUnlock()
+	return gpf.existingVendors
+}
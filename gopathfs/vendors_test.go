@@ -0,0 +1,173 @@
+package gopathfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestMkdirInFirstExistingVendor(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vendors = []string{"missing", "vendor"}
+	cfg.VendorSet = map[string]struct{}{"missing": {}, "vendor": {}}
+	gpf, ws := newTestFs(t, cfg)
+
+	if status := gpf.Mkdir("github.com/z", 0755, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	if fi, err := os.Stat(filepath.Join(ws, "vendor", "github.com", "z")); err != nil || !fi.IsDir() {
+		t.Errorf("vendor/github.com/z not created, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing vendor directory created, %v", err)
+	}
+
+	if status := gpf.Rmdir("github.com/z", nil); status != fuse.OK {
+		t.Fatalf("Rmdir = %v", status)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "vendor", "github.com", "z")); !os.IsNotExist(err) {
+		t.Errorf("vendor/github.com/z not removed, %v", err)
+	}
+}
+
+func TestCheckVendorsReportsChangesOnly(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vendors = append(cfg.Vendors, "missing")
+	cfg.VendorSet["missing"] = struct{}{}
+	ch := make(chan ErrorEvent, 16)
+	gpf, ws := newTestFs(t, cfg, WithErrorEvents(ch))
+	if n := len(ch); n != 1 {
+		t.Fatalf("%d events on mount, want 1", n)
+	}
+	<-ch
+
+	gpf.FlushCaches()
+	gpf.FlushCaches()
+	if n := len(ch); n != 0 {
+		t.Errorf("%d events on flushes with the same vendor directories, want 0", n)
+	}
+
+	// Reported again once the vendor directories change.
+	if err := os.Remove(filepath.Join(ws, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+	gpf.FlushCaches()
+	if n := len(ch); n != 2 {
+		t.Errorf("%d events after a vendor directory went away, want 2", n)
+	}
+}
+
+// newManyVendorsFs returns a GoPathFs serving n vendor directories, each
+// with a package of its own, and the workspace.
+func newManyVendorsFs(b *testing.B, n int) (*GoPathFs, string) {
+	cfg := testConfig()
+	cfg.Vendors = nil
+	cfg.VendorSet = map[string]struct{}{}
+	for i := 0; i < n; i++ {
+		v := fmt.Sprintf("vendor%d", i)
+		cfg.Vendors = append(cfg.Vendors, v)
+		cfg.VendorSet[v] = struct{}{}
+	}
+	gpf, ws := newTestFs(b, cfg)
+	for i, v := range cfg.Vendors {
+		writeFile(b, filepath.Join(ws, v, "github.com", fmt.Sprintf("y%d", i), "a.go"), "package y\n")
+	}
+	gpf.FlushCaches()
+	return gpf, ws
+}
+
+func BenchmarkManyVendors(b *testing.B) {
+	gpf, _ := newManyVendorsFs(b, 50)
+
+	b.Run("last vendor", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, status := gpf.GetAttr("github.com/y49/a.go", nil); status != fuse.OK {
+				b.Fatalf("GetAttr = %v", status)
+			}
+		}
+	})
+	b.Run("missing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, status := gpf.GetAttr("github.com/z/a.go", nil); status != fuse.ENOENT {
+				b.Fatalf("GetAttr = %v", status)
+			}
+		}
+	})
+	b.Run("top listing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, status := gpf.OpenDir("", nil); status != fuse.OK {
+				b.Fatalf("OpenDir = %v", status)
+			}
+		}
+	})
+}
+
+func TestCreateInParentsVendor(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vendors = []string{"vendor", "vendor2"}
+	cfg.VendorSet = map[string]struct{}{"vendor": {}, "vendor2": {}}
+	gpf, ws := newTestFs(t, cfg)
+	if err := os.MkdirAll(filepath.Join(ws, "vendor2", "github.com", "z"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gpf.FlushCaches()
+
+	f, status := gpf.Create("github.com/z/a.go", uint32(os.O_WRONLY), 0644, nil)
+	if status != fuse.OK {
+		t.Fatalf("Create = %v", status)
+	}
+	f.Release()
+	if status := gpf.Mkdir("github.com/z/sub", 0755, nil); status != fuse.OK {
+		t.Fatalf("Mkdir = %v", status)
+	}
+
+	for _, rel := range []string{"a.go", "sub"} {
+		if _, err := os.Stat(filepath.Join(ws, "vendor2", "github.com", "z", rel)); err != nil {
+			t.Errorf("%s not created in the parent's vendor directory, %v", rel, err)
+		}
+		if _, err := os.Stat(filepath.Join(ws, "vendor", "github.com", "z", rel)); !os.IsNotExist(err) {
+			t.Errorf("%s created in the first vendor directory, %v", rel, err)
+		}
+	}
+}
+
+func TestSymlinkedVendor(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.Vendors = []string{"vendor", "shared"}
+		cfg.VendorSet = map[string]struct{}{"vendor": {}, "shared": {}}
+		cfg.SkipVendorSymlinks = skip
+		gpf, ws := newTestFs(t, cfg)
+		cache := t.TempDir()
+		writeFile(t, filepath.Join(cache, "github.com", "z", "b.go"), "package z\n")
+		if err := os.Symlink(cache, filepath.Join(ws, "shared")); err != nil {
+			t.Fatal(err)
+		}
+		gpf.FlushCaches()
+
+		got, status := readMountFile(t, gpf, "github.com/z/b.go")
+		if skip {
+			if status != fuse.ENOENT {
+				t.Errorf("reading from a skipped vendor symlink = %q, %v, want ENOENT", got, status)
+			}
+			continue
+		}
+		if status != fuse.OK || got != "package z\n" {
+			t.Errorf("reading github.com/z/b.go = %q, %v", got, status)
+		}
+		if names := listNames(t, gpf, "github.com/z"); !names["b.go"] {
+			t.Errorf("github.com/z lists %v, want b.go", names)
+		}
+		f, status := gpf.Create("github.com/z/c.go", uint32(os.O_WRONLY), 0644, nil)
+		if status != fuse.OK {
+			t.Fatalf("Create = %v", status)
+		}
+		f.Release()
+		if _, err := os.Stat(filepath.Join(cache, "github.com", "z", "c.go")); err != nil {
+			t.Errorf("c.go not created in the symlink's target, %v", err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gopathfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// worktreeDir returns the folder of the given git worktree of the given
+// workspace, as recorded in the workspace's git metadata. The worktree is
+// either named by its folder under .git/worktrees, usually the base name of
+// its path, or by its path as listed by "git worktree list".
+func worktreeDir(workspace, name string) (string, error) {
+	if !strings.Contains(name, string(filepath.Separator)) {
+		return worktreeDirOf(workspace, name)
+	}
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspace, path)
+	}
+	path = filepath.Clean(path)
+	fis, err := ioutil.ReadDir(filepath.Join(workspace, ".git", "worktrees"))
+	if err != nil {
+		return "", err
+	}
+	for _, fi := range fis {
+		if dir, err := worktreeDirOf(workspace, fi.Name()); err == nil && dir == path {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no git worktree at %s", path)
+}
+
+// worktreeDirOf returns the folder of the git worktree with the given folder
+// under .git/worktrees.
+func worktreeDirOf(workspace, name string) (string, error) {
+	// Each worktree has a "gitdir" file pointing to its ".git" file.
+	data, err := ioutil.ReadFile(filepath.Join(workspace, ".git", "worktrees", name, "gitdir"))
+	if err != nil {
+		return "", err
+	}
+
+	gitFile := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(gitFile) {
+		gitFile = filepath.Join(workspace, ".git", "worktrees", name, gitFile)
+	}
+	dir := filepath.Dir(gitFile)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("worktree folder %s doesn't exist", dir)
+	}
+	return dir, nil
+}
@@ -0,0 +1,30 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorktreeDir(t *testing.T) {
+	ws := t.TempDir()
+	wt := filepath.Join(t.TempDir(), "feature")
+	if err := os.Mkdir(wt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// As "git worktree add" records it, with the folder under
+	// .git/worktrees named after the worktree's base name.
+	writeFile(t, filepath.Join(ws, ".git", "worktrees", "feature", "gitdir"), filepath.Join(wt, ".git")+"\n")
+
+	for _, name := range []string{"feature", wt, wt + "/"} {
+		dir, err := worktreeDir(ws, name)
+		if err != nil || dir != wt {
+			t.Errorf("worktreeDir(%q) = %q, %v, want %q", name, dir, err, wt)
+		}
+	}
+	for _, name := range []string{"other", filepath.Join(filepath.Dir(wt), "other")} {
+		if dir, err := worktreeDir(ws, name); err == nil {
+			t.Errorf("worktreeDir(%q) = %q, want an error", name, dir)
+		}
+	}
+}
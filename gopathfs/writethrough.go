@@ -0,0 +1,33 @@
+package gopathfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeThroughPath returns the source file writes to the given mount path
+// are redirected to, if it resolves to a first-party generated file matching
+// one of the write-through rules, and the source file exists.
+func (gpf *GoPathFs) writeThroughPath(name string) (string, bool) {
+	rules := gpf.config().WriteThroughRules
+	if len(rules) == 0 {
+		return "", false
+	}
+
+	c, _, ok := gpf.resolve(name)
+	if !ok || c.kind != KindGenfiles {
+		return "", false
+	}
+
+	for _, r := range rules {
+		if !strings.HasSuffix(c.rel, r.GenSuffix) {
+			continue
+		}
+		src := filepath.Join(gpf.workspace(), strings.TrimSuffix(c.rel, r.GenSuffix)+r.SrcSuffix)
+		if fi, err := os.Stat(src); err == nil && fi.Mode().IsRegular() {
+			return src, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,172 @@
+package gopathfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// hashXAttr is the synthesized extended attribute holding the hex encoded
+// SHA-256 of a file's content, e.g., for build tools skipping unchanged
+// inputs without reading them.
+const hashXAttr = "user.gobazel.sha256"
+
+// Defaults of the hash-xattr-max-size and hash-xattr-timeout settings.
+const (
+	defaultHashXAttrMaxSize = 64 << 20
+	defaultHashXAttrTimeout = 10 * time.Second
+)
+
+// Size of the chunks hashed between checks of the timeout.
+const hashChunkSize = 1 << 20
+
+// GetXAttr overwrites the parent's GetXAttr method.
+func (gpf *GoPathFs) GetXAttr(name string, attribute string, context *fuse.Context) (data []byte, code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("getxattr", name, time.Now(), &code, &backingPath)
+
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
+	}
+
+	if attribute != hashXAttr {
+		return nil, fuse.ENOATTR
+	}
+
+	c, _, ok := gpf.resolve(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	if c.src == nil {
+		backingPath = c.path
+	}
+
+	var r io.ReaderAt
+	var size int64
+	if c.src != nil {
+		// Sources which know the size aren't read if it's over the cap.
+		if sz, ok := c.src.(sizer); ok {
+			n, err := sz.Size(c.rel)
+			if err != nil && err != errNotSupported {
+				return nil, fuse.ENOENT
+			}
+			if err == nil && gpf.overHashMaxSize(name, n) {
+				return nil, fuse.Status(unix.ENOTSUP)
+			}
+		}
+		sr, n, err := c.src.Open(c.rel)
+		if err != nil {
+			return nil, fuse.ENOENT
+		}
+		if cl, ok := sr.(io.Closer); ok {
+			defer cl.Close()
+		}
+		r, size = sr, n
+	} else {
+		f, err := os.Open(c.path)
+		if err != nil {
+			return nil, fuse.ToStatus(err)
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, fuse.ToStatus(err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, fuse.ENOATTR
+		}
+		r, size = f, fi.Size()
+	}
+
+	if gpf.overHashMaxSize(name, size) {
+		return nil, fuse.Status(unix.ENOTSUP)
+	}
+
+	sum, status := hashContent(r, size, time.Now().Add(gpf.hashXAttrTimeout()))
+	if status != fuse.OK {
+		fmt.Printf("Warning, failed to hash file %s, %v.\n", name, status)
+		return nil, status
+	}
+	return []byte(sum), fuse.OK
+}
+
+// ListXAttr overwrites the parent's ListXAttr method.
+func (gpf *GoPathFs) ListXAttr(name string, context *fuse.Context) (attrs []string, code fuse.Status) {
+	name = normalizeName(name)
+	if !gpf.enterOp() {
+		return nil, errShuttingDown
+	}
+	defer gpf.exitOp(&code)
+	backingPath := ""
+	defer gpf.watchOp("listxattr", name, time.Now(), &code, &backingPath)
+
+	if status := gpf.checkName(name); status != fuse.OK {
+		return nil, status
+	}
+	attr, backingPath, code := gpf.lookupAttr(name)
+	if code != fuse.OK {
+		return nil, code
+	}
+	if attr.Mode&fuse.S_IFREG == 0 {
+		return []string{}, fuse.OK
+	}
+	return []string{hashXAttr}, fuse.OK
+}
+
+func (gpf *GoPathFs) hashXAttrMaxSize() int64 {
+	if n := gpf.config().HashXAttrMaxBytes; n > 0 {
+		return n
+	}
+	return defaultHashXAttrMaxSize
+}
+
+// overHashMaxSize returns true if the given file of the given size isn't
+// hashed, being over hash-xattr-max-size.
+func (gpf *GoPathFs) overHashMaxSize(name string, size int64) bool {
+	limit := gpf.hashXAttrMaxSize()
+	if size <= limit {
+		return false
+	}
+	if gpf.debug {
+		fmt.Printf("Not hashing file %s of %d bytes, over %d.\n", name, size, limit)
+	}
+	return true
+}
+
+func (gpf *GoPathFs) hashXAttrTimeout() time.Duration {
+	if d := gpf.config().HashXAttrTimeoutDuration; d > 0 {
+		return d
+	}
+	return defaultHashXAttrTimeout
+}
+
+// hashContent returns the hex encoded SHA-256 of the first size bytes of r,
+// or ETIMEDOUT if it takes past the deadline, or EIO if r fails.
+func hashContent(r io.ReaderAt, size int64, deadline time.Time) (string, fuse.Status) {
+	h := sha256.New()
+	sr := io.NewSectionReader(r, 0, size)
+	for {
+		if time.Now().After(deadline) {
+			return "", fuse.Status(unix.ETIMEDOUT)
+		}
+		n, err := io.CopyN(h, sr, hashChunkSize)
+		if err != nil && err != io.EOF {
+			return "", fuse.EIO
+		}
+		if err == io.EOF || n < hashChunkSize {
+			break
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), fuse.OK
+}
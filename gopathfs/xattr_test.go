@@ -0,0 +1,69 @@
+package gopathfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// failingReaderAt fails reads at or past off.
+type failingReaderAt struct {
+	data []byte
+	off  int64
+}
+
+func (r failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.off {
+		return 0, errors.New("injected read error")
+	}
+	return copy(p, r.data[off:]), nil
+}
+
+func TestHashContentReadError(t *testing.T) {
+	data := make([]byte, 3*hashChunkSize)
+	r := failingReaderAt{data: data, off: 2 * hashChunkSize}
+	if sum, status := hashContent(r, int64(len(data)), time.Now().Add(time.Minute)); status != fuse.EIO {
+		t.Errorf("hashContent = %q, %v, want EIO", sum, status)
+	}
+
+	want := sha256.Sum256(data[:hashChunkSize+42])
+	sum, status := hashContent(failingReaderAt{data: data, off: int64(len(data))}, hashChunkSize+42, time.Now().Add(time.Minute))
+	if status != fuse.OK || sum != hex.EncodeToString(want[:]) {
+		t.Errorf("hashContent = %q, %v, want %x", sum, status, want)
+	}
+}
+
+func TestHashXAttr(t *testing.T) {
+	gpf, ws := newTestFs(t, nil)
+	writeFile(t, filepath.Join(ws, "foo", "a.go"), "package foo\n")
+
+	data, status := gpf.GetXAttr(testPrefix+"/foo/a.go", hashXAttr, nil)
+	want := sha256.Sum256([]byte("package foo\n"))
+	if status != fuse.OK || string(data) != hex.EncodeToString(want[:]) {
+		t.Errorf("GetXAttr = %q, %v, want %x", data, status, want)
+	}
+}
+
+func TestHashXAttrSizerBeforeOpen(t *testing.T) {
+	cfg := testConfig()
+	cfg.HashXAttrMaxBytes = 16
+	gpf, _ := newTestFs(t, cfg)
+	src := sizedMemSource{&memSource{files: map[string]string{
+		"foo/big.pb.go": strings.Repeat("x", 17),
+	}}}
+	gpf.SetGenfilesSource(src)
+
+	if _, status := gpf.GetXAttr(testPrefix+"/foo/big.pb.go", hashXAttr, nil); status != fuse.Status(unix.ENOTSUP) {
+		t.Errorf("GetXAttr = %v, want ENOTSUP", status)
+	}
+	if n := src.openCount(); n != 0 {
+		t.Errorf("content source opened %d times, want 0", n)
+	}
+}
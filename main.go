@@ -14,6 +14,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/linuxerwang/gobazel/conf"
@@ -42,6 +43,11 @@ const (
         "third-party-go/vendor",
     ]
 
+    # Folders with generated files, in order of precedence.
+    gen-dirs: [
+        "bazel-genfiles",
+    ]
+
     ignore-dirs: [
         "bazel-.*",
         "third-party.*",
@@ -93,6 +99,8 @@ Usage:
 	gobazel [options]
 	OR to show its version:
 	gobazel version
+	OR to check that import paths resolve through the virtual GOPATH:
+	gobazel probe [-from <importer>] <import-path> ...
 
 Note:
 	This command has to be executed in a bazel workspace (where your WORKSPACE file reside).
@@ -128,7 +136,15 @@ func main() {
 		}
 	}
 
+	// Probing only reads the config, the mount isn't set up.
+	if strings.ToLower(flag.Arg(0)) == "probe" {
+		probe(loadConfig(), flag.Args()[1:])
+		return
+	}
+
+	createConfig()
 	cfg := loadConfig()
+	setupGoPath(cfg)
 
 	if _, err := os.Stat(filepath.Join(dirs.Workspace, gobzlPidFile)); !os.IsNotExist(err) {
 		fmt.Println("File .gobazelpid for another gobazel process exists. Start IDE")
@@ -136,6 +152,10 @@ func main() {
 		return
 	}
 
+	checkMountOverlap()
+	checkStaleMount(cfg)
+	checkCleanTree(cfg)
+
 	if *daemon && !*detached {
 		pid, err := detach()
 		if err != nil {
@@ -147,9 +167,29 @@ func main() {
 	}
 
 
+	srcDirCreated := prepareMountpoint(cfg)
+
 	// Create a FUSE virtual file system on dirs.SrcDir.
-	nfs := pathfs.NewPathNodeFs(gopathfs.NewGoPathFs(*debug, cfg, &dirs), nil)
-	server, _, err := nodefs.MountRoot(dirs.SrcDir, nfs.Root(), nil)
+	gpfs := gopathfs.NewGoPathFs(*debug, cfg, &dirs)
+	// Hard links in the workspace are served as hard links.
+	nfs := pathfs.NewPathNodeFs(gpfs, &pathfs.PathNodeFsOptions{ClientInodes: true})
+	opts := nodefs.NewOptions()
+	// The owners are those of the backing files, mapped with uid-map and
+	// gid-map, not gobazel's.
+	opts.Owner = nil
+	if cfg.Timeouts != nil {
+		// The kernel timeouts apply to the whole mount, path classes with
+		// longer timeouts are further cached by gobazel.
+		opts.AttrTimeout, opts.EntryTimeout = cfg.Timeouts.Shortest()
+	}
+	// Reads are sent up to max-read bytes at a time, like writes. Lock
+	// requests are only sent by the kernel with EnableLocks, see
+	// loopbackFile.SetLk.
+	mountOpts := &fuse.MountOptions{
+		MaxWrite:    cfg.MaxReadBytes,
+		EnableLocks: true,
+	}
+	server, _, err := nodefs.Mount(dirs.SrcDir, nfs.Root(), mountOpts, opts)
 	if err != nil {
 		fmt.Printf("Mount fail: %v\n", err)
 		os.Exit(2)
@@ -172,10 +212,37 @@ func main() {
 				fmt.Println("Error to unmount,", err)
 				continue
 			}
+			if srcDirCreated {
+				// Only removes the mount point if it's still empty.
+				os.Remove(dirs.SrcDir)
+			}
 			os.Exit(0)
 		}
 	}()
 
+	if cfg.WatchConfig {
+		if err := gpfs.WatchConfig(dirs.GobzlConf); err != nil {
+			fmt.Printf("Failed to watch %s, %v.\n", dirs.GobzlConf, err)
+		}
+	}
+
+	if cfg.CaseCollisionScan {
+		go gpfs.ScanCaseCollisions()
+	}
+	if cfg.SymlinkLoopScan {
+		go gpfs.ScanSymlinkLoops()
+	}
+
+	// Flush caches on SIGUSR1, e.g., after the workspace changed out-of-band.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			fmt.Println("\nFlushing caches.")
+			gpfs.FlushCaches()
+		}
+	}()
+
 	go func() {
 		time.Sleep(time.Second)
 
@@ -202,20 +269,27 @@ func main() {
 	server.Serve()
 }
 
-func loadConfig() *conf.GobazelConf {
+// createConfig creates the gobazel config file with the initial config, and
+// exits for it to be customized, if it doesn't exist.
+func createConfig() {
 	// File gobazel.cfg holds configurations for gobazel.
-	if _, err := os.Stat(dirs.GobzlConf); err != nil {
-		if os.IsNotExist(err) {
-			if err = ioutil.WriteFile(dirs.GobzlConf, []byte(initialConf), 0644); err != nil {
-				fmt.Printf("Failed to create file %s, %+v.\n", dirs.GobzlConf, err)
-				os.Exit(2)
-			}
-
-			fmt.Printf("Created gobazel config file %s, please customize it and run the command again.\n", dirs.GobzlConf)
-			os.Exit(0)
-		} else {
-			fmt.Println(err)
+	if _, err := os.Stat(dirs.GobzlConf); os.IsNotExist(err) {
+		if err = ioutil.WriteFile(dirs.GobzlConf, []byte(initialConf), 0644); err != nil {
+			fmt.Printf("Failed to create file %s, %+v.\n", dirs.GobzlConf, err)
+			os.Exit(2)
 		}
+
+		fmt.Printf("Created gobazel config file %s, please customize it and run the command again.\n", dirs.GobzlConf)
+		os.Exit(0)
+	}
+}
+
+// loadConfig parses and checks the gobazel config file, and sets the GOPATH
+// directories of dirs, without creating anything.
+func loadConfig() *conf.GobazelConf {
+	if _, err := os.Stat(dirs.GobzlConf); err != nil {
+		fmt.Println("Error, failed to read the gobazel config file,", err)
+		os.Exit(2)
 	}
 
 	cfg := conf.LoadConfig(dirs.GobzlConf)
@@ -229,15 +303,70 @@ func loadConfig() *conf.GobazelConf {
 	}
 
 	dirs.BinDir = filepath.Join(cfg.GoPath, "bin")
-	os.Mkdir(dirs.BinDir, 0755)
 	dirs.PkgDir = filepath.Join(cfg.GoPath, "pkg")
-	os.Mkdir(dirs.PkgDir, 0755)
 	dirs.SrcDir = filepath.Join(cfg.GoPath, "src")
-	os.Mkdir(dirs.SrcDir, 0755)
-
 	return cfg
 }
 
+// setupGoPath creates the GOPATH bin and pkg directories, linking the bin
+// directory to bin-dir if set. The src directory is the mount point, see
+// prepareMountpoint.
+func setupGoPath(cfg *conf.GobazelConf) {
+	mkdir := os.Mkdir
+	if cfg.CreateMountpoint {
+		mkdir = os.MkdirAll
+	}
+
+	if cfg.BinDir != "" {
+		linkBinDir(cfg)
+	}
+	mkdir(dirs.BinDir, 0755)
+	mkdir(dirs.PkgDir, 0755)
+}
+
+// linkBinDir makes $GOPATH/bin a symbolic link to the configured bin-dir,
+// so that installed binaries end up there.
+func linkBinDir(cfg *conf.GobazelConf) {
+	if err := os.MkdirAll(cfg.BinDir, 0755); err != nil {
+		fmt.Printf("Failed to create bin-dir %s, %v.\n", cfg.BinDir, err)
+		os.Exit(2)
+	}
+
+	if target, err := os.Readlink(dirs.BinDir); err == nil && target == cfg.BinDir {
+		return
+	}
+	if _, err := os.Lstat(dirs.BinDir); err == nil {
+		fmt.Printf("Warning, %s already exists, binaries are installed to bin-dir %s only by gobazel.\n", dirs.BinDir, cfg.BinDir)
+		return
+	}
+	if err := os.Symlink(cfg.BinDir, dirs.BinDir); err != nil {
+		fmt.Printf("Failed to link %s to bin-dir %s, %v.\n", dirs.BinDir, cfg.BinDir, err)
+		os.Exit(2)
+	}
+}
+
+// prepareMountpoint creates the mount point if needed, and returns true if it
+// should be removed on unmount.
+func prepareMountpoint(cfg *conf.GobazelConf) bool {
+	if _, err := os.Stat(dirs.SrcDir); os.IsNotExist(err) {
+		mkdir := os.Mkdir
+		if cfg.CreateMountpoint {
+			mkdir = os.MkdirAll
+		}
+		if err := mkdir(dirs.SrcDir, 0755); err != nil {
+			fmt.Printf("Failed to create mount point %s, %v.\n", dirs.SrcDir, err)
+			os.Exit(2)
+		}
+		return cfg.CreateMountpoint
+	}
+
+	// FUSE hides the existing content of the mount point.
+	if fis, err := ioutil.ReadDir(dirs.SrcDir); err == nil && len(fis) > 0 {
+		fmt.Printf("Warning, mount point %s is not empty, its content will be hidden while mounted.\n", dirs.SrcDir)
+	}
+	return false
+}
+
 func bazelBuild(cfg *conf.GobazelConf, dirs *gopathfs.Dirs) {
 	ignoreRegexes := make([]*regexp.Regexp, len(cfg.Build.Ignores))
 	for i, ign := range cfg.Build.Ignores {
@@ -348,16 +477,181 @@ func stopExistingProcess() {
 
 	// Check if the mount point is still mounted (only works on linux).
 	time.Sleep(time.Second)
-	if b, err := ioutil.ReadFile("/proc/mounts"); err == nil {
-		if idx := strings.Index(string(b), dirs.SrcDir); idx > -1 {
-			osexec.Command("fusermount", "-u", dirs.SrcDir).CombinedOutput()
-		}
+	if isMounted(dirs.SrcDir) {
+		osexec.Command("fusermount", "-u", dirs.SrcDir).CombinedOutput()
 	}
 	os.Remove(pidFile)
 }
 
+// checkStaleMount exits with an error if the mount point is still mounted.
+// A FUSE mount whose process is gone, e.g., a gobazel process which crashed,
+// is unmounted if configured to. A live mount, e.g., of another gobazel
+// process, never is.
+func checkStaleMount(cfg *conf.GobazelConf) {
+	if !isStaleMount(dirs.SrcDir) {
+		if isMounted(dirs.SrcDir) {
+			fmt.Printf("Error, %s is already mounted, maybe by another gobazel process. Stop it, or unmount %s, and try again.\n", dirs.SrcDir, dirs.SrcDir)
+			os.Exit(2)
+		}
+		return
+	}
+
+	if !cfg.ForceUnmountStale {
+		fmt.Printf("Error, %s is already mounted, probably by a gobazel process which didn't exit cleanly. Run \"fusermount -u %s\" and try again.\n", dirs.SrcDir, dirs.SrcDir)
+		os.Exit(2)
+	}
+
+	fmt.Printf("Unmounting stale mount %s.\n", dirs.SrcDir)
+	if out, err := osexec.Command("fusermount", "-u", dirs.SrcDir).CombinedOutput(); err != nil {
+		fmt.Printf("Failed to unmount %s, %v: %s\n", dirs.SrcDir, err, strings.TrimSpace(string(out)))
+		os.Exit(2)
+	}
+}
+
+// checkCleanTree warns, or exits, if the workspace has uncommitted changes,
+// as configured by require-clean-tree.
+func checkCleanTree(cfg *conf.GobazelConf) {
+	if cfg.RequireCleanTree == "" {
+		return
+	}
+
+	files, err := gopathfs.DirtyFiles(cfg, dirs.Workspace)
+	if err != nil {
+		fmt.Printf("Failed to check the workspace for uncommitted changes, %v.\n", err)
+		if cfg.RequireCleanTree == conf.CleanTreeFail {
+			os.Exit(2)
+		}
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	const maxListed = 10
+	listed := files
+	if len(listed) > maxListed {
+		listed = listed[:maxListed]
+	}
+	if cfg.RequireCleanTree == conf.CleanTreeFail {
+		fmt.Printf("Error, the workspace has %d uncommitted changes (%s). Commit or stash them and try again.\n", len(files), strings.Join(listed, ", "))
+		os.Exit(2)
+	}
+	fmt.Printf("Warning, the workspace has %d uncommitted changes (%s), served files may not match any commit.\n", len(files), strings.Join(listed, ", "))
+}
+
+// checkMountOverlap exits if the mount point and the workspace overlap, see
+// mountOverlap.
+func checkMountOverlap() {
+	if err := mountOverlap(dirs.SrcDir, dirs.Workspace); err != nil {
+		fmt.Printf("Error, %v. Set go-path to a folder outside of the workspace.\n", err)
+		os.Exit(2)
+	}
+}
+
+// mountOverlap returns an error if the mount point is in the workspace,
+// which the mount would then serve to itself, or the workspace is in the
+// mount point, which the mount would hide.
+func mountOverlap(srcDir, workspace string) error {
+	realSrcDir, realWs := realPath(srcDir), realPath(workspace)
+	if isWithin(realSrcDir, realWs) {
+		return fmt.Errorf("the mount point %s is inside the workspace %s", srcDir, workspace)
+	}
+	if isWithin(realWs, realSrcDir) {
+		return fmt.Errorf("the workspace %s is inside the mount point %s, and would be hidden by the mount", workspace, srcDir)
+	}
+	return nil
+}
+
+// realPath returns the absolute path with symbolic links resolved, for as
+// much of the path as exists.
+func realPath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(realPath(parent), filepath.Base(path))
+}
+
+// isWithin returns true if path is dir or below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isMounted returns true if dir is a mount point (only works on linux), or a
+// FUSE mount whose process is gone.
+func isMounted(dir string) bool {
+	if isStaleMount(dir) {
+		return true
+	}
+
+	b, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// isStaleMount returns true if dir is a FUSE mount whose process is gone,
+// which fails every access with ENOTCONN.
+func isStaleMount(dir string) bool {
+	_, err := os.Stat(dir)
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == syscall.ENOTCONN
+}
+
 func startIDE(cfg *conf.GobazelConf) {
 	if err := exec.RunCommand(cfg, cfg.GoIdeCmd+" "+dirs.SrcDir); err != nil {
 		fmt.Println("Error to run IDE, ", err)
 	}
 }
+
+func probe(cfg *conf.GobazelConf, args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	from := fs.String("from", "", "Resolve the import paths as imported from this package, following Go's vendor rules.")
+	fs.Parse(args)
+	gpfs := gopathfs.NewGoPathFs(*debug, cfg, &dirs)
+
+	failed := false
+	for _, importPath := range fs.Args() {
+		name := importPath
+		if *from != "" {
+			// A missing package is reported by Probe.
+			if resolved, ok := gpfs.ResolveImport(*from, importPath); ok {
+				name = resolved
+			}
+		}
+		res, err := gpfs.Probe(name)
+		if err != nil {
+			fmt.Printf("%s: error, %v.\n", importPath, err)
+			failed = true
+			continue
+		}
+		if !res.Resolved {
+			fmt.Printf("%s: not found (%d candidates tried).\n", importPath, res.Candidates)
+			failed = true
+			continue
+		}
+		if name != importPath {
+			fmt.Printf("%s: as %s, %s %s (%d candidates tried, %d files).\n", importPath, name, res.Kind, res.Location, res.Candidates, res.Files)
+			continue
+		}
+		fmt.Printf("%s: %s %s (%d candidates tried, %d files).\n", importPath, res.Kind, res.Location, res.Candidates, res.Files)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
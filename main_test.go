@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountOverlap(t *testing.T) {
+	ws := t.TempDir()
+	outside := t.TempDir()
+	link := filepath.Join(outside, "link")
+	if err := os.Symlink(ws, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		srcDir, workspace string
+		overlap           bool
+	}{
+		{filepath.Join(outside, "gopath", "src"), ws, false},
+		{filepath.Join(ws, "gopath", "src"), ws, true}, // Inside.
+		{ws, ws, true},                         // Equal.
+		{outside, ws, false},                   // Siblings.
+		{filepath.Dir(ws), ws, true},           // Containing.
+		{filepath.Join(link, "src"), ws, true}, // Inside, through a link.
+		{ws + "-gopath", ws, false},            // Sharing a prefix only.
+	} {
+		err := mountOverlap(tc.srcDir, tc.workspace)
+		if (err != nil) != tc.overlap {
+			t.Errorf("mountOverlap(%s, %s) = %v, want overlap %v", tc.srcDir, tc.workspace, err, tc.overlap)
+		}
+	}
+}